@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"os"
 	"time"
 	"wallet-service/controllers"
 	"wallet-service/pkg/config"
 	"wallet-service/pkg/logger"
 	"wallet-service/pkg/postgresx"
 	"wallet-service/pkg/redisx"
+	"wallet-service/pkg/worker"
 	"wallet-service/services"
 )
 
@@ -18,18 +21,116 @@ func main() {
 	postgresx.InitDB()
 	redisx.InitRedis()
 	l := logger.NewLogger()
+	// 命令行对账, 执行完退出
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		os.Exit(reconcileCommand(l, os.Args[2:]))
+	}
 	walletService := services.NewWalletService(l, postgresx.GetDB(), redisx.GetRedisClient())
 	walletController := controllers.NewWalletController(l, redisx.GetRedisClient(), walletService)
+	fxService := services.NewFXService(l, postgresx.GetDB())
+	fxController := controllers.NewFXController(l, fxService)
+	scheduleService := services.NewScheduleService(l, postgresx.GetDB())
+	scheduleController := controllers.NewScheduleController(l, scheduleService)
+	sharedWalletService := services.NewSharedWalletService(l, postgresx.GetDB(), redisx.GetRedisClient())
+	sharedWalletController := controllers.NewSharedWalletController(l, sharedWalletService)
+	escrowService := services.NewEscrowService(l, postgresx.GetDB(), redisx.GetRedisClient())
+	escrowController := controllers.NewEscrowController(l, escrowService)
+	reconciliationService := services.NewReconciliationService(l, postgresx.GetDB(), redisx.GetRedisClient())
+	reconciliationController := controllers.NewReconciliationController(l, reconciliationService)
+
+	// 后台任务
+	ctx := context.Background()
+	idempotencyJanitor := services.NewIdempotencyJanitor(l, postgresx.GetDB())
+	go worker.RunPeriodic(ctx, "idempotency-janitor", time.Hour, idempotencyJanitor.PurgeExpired)
+	holdExpirer := services.NewHoldExpirer(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "hold-expirer", time.Minute, holdExpirer.ExpireHolds)
+	reversalWorker := services.NewReversalWorker(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "reversal-worker", time.Minute, reversalWorker.ProcessPending)
+	scheduleRunner := services.NewScheduleRunner(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "schedule-runner", time.Minute, scheduleRunner.RunDue)
+	interestAccruer := services.NewInterestAccruer(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "interest-accruer", time.Hour, interestAccruer.Run)
+	approvalWorker := services.NewApprovalWorker(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "approval-worker", time.Minute, approvalWorker.Run)
+	balanceSnapshotter := services.NewBalanceSnapshotter(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "balance-snapshotter", time.Hour, balanceSnapshotter.Run)
+	statementGenerator := services.NewStatementGenerator(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "statement-generator", time.Hour, statementGenerator.Run)
+	escrowExpirer := services.NewEscrowExpirer(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "escrow-expirer", time.Minute, escrowExpirer.ExpireEscrows)
+	feeSweeper := services.NewFeeSweeper(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "fee-sweeper", time.Hour, feeSweeper.Run)
+	reconciler := services.NewReconciler(l, postgresx.GetDB(), redisx.GetRedisClient())
+	go worker.RunPeriodic(ctx, "reconciler", time.Hour, reconciler.Run)
+	eventSink, err := services.NewEventSink(redisx.GetRedisClient())
+	if err != nil {
+		panic(err)
+	}
+	outboxRelay := services.NewOutboxRelay(l, postgresx.GetDB(), redisx.GetRedisClient(), eventSink)
+	go worker.RunPeriodic(ctx, "outbox-relay", time.Second, outboxRelay.Run)
+	go worker.RunPeriodic(ctx, "outbox-janitor", time.Hour, outboxRelay.PurgePublished)
 
 	router := gin.New()
 	router.Use(GinLogger(l.GetZapLogger()), gin.Recovery())
+	router.POST("/wallets", walletController.CreateWallet)
 	router.POST("/wallet/:user_id/deposit", walletController.Deposit)
 	router.POST("/wallet/:user_id/withdraw", walletController.Withdraw)
 	router.POST("/wallet/transfer/:sender_id/to/:receiver_id", walletController.Transfer)
+	router.POST("/wallet/:user_id/transfers/batch", walletController.BatchTransfer)
 	router.GET("/wallet/:user_id/balance", walletController.GetBalance)
 	router.GET("/wallet/:user_id/transactions", walletController.GetTransactionHistory)
+	router.GET("/wallet/:user_id/statements", walletController.GetStatement)
+	router.GET("/wallet/:user_id/limits", walletController.GetWalletLimits)
+	router.GET("/wallet/:user_id/interest", walletController.GetInterest)
+	router.POST("/wallet/:user_id/holds", walletController.Reserve)
+	router.GET("/wallet/:user_id/holds", walletController.ListHolds)
+	router.POST("/holds/:hold_id/capture", walletController.Capture)
+	router.POST("/holds/:hold_id/void", walletController.Void)
+	router.POST("/wallet/:user_id/payouts", walletController.RequestPayout)
+	router.GET("/wallet/:user_id/balance/aggregate", walletController.GetAggregateBalance)
+	router.POST("/wallet/:user_id/pockets", walletController.CreatePocket)
+	router.GET("/wallet/:user_id/pockets", walletController.ListPockets)
+	router.POST("/wallet/:user_id/pockets/transfer", walletController.MovePocketFunds)
+	router.POST("/pockets/:pocket_id/close", walletController.ClosePocket)
+	router.GET("/transactions/:id", walletController.GetTransaction)
+	router.POST("/transactions/:id/reverse", walletController.ReverseTransaction)
+	router.POST("/transactions/:id/complete", walletController.CompleteTransaction)
+	router.POST("/transactions/:id/fail", walletController.FailTransaction)
+	router.POST("/wallet/:user_id/schedules", scheduleController.CreateSchedule)
+	router.GET("/wallet/:user_id/schedules", scheduleController.ListSchedules)
+	router.POST("/schedules/:schedule_id/cancel", scheduleController.CancelSchedule)
+	router.GET("/schedules/:schedule_id/executions", scheduleController.ListExecutions)
+	router.POST("/shared-wallets", sharedWalletController.CreateSharedWallet)
+	router.GET("/shared-wallets/:user_id/members", sharedWalletController.ListMembers)
+	router.POST("/shared-wallets/:user_id/members", sharedWalletController.SetMember)
+	router.POST("/shared-wallets/:user_id/members/remove", sharedWalletController.RemoveMember)
+	router.POST("/shared-wallets/:user_id/transfers", sharedWalletController.RequestTransfer)
+	router.GET("/shared-wallets/:user_id/approvals", sharedWalletController.ListApprovals)
+	router.GET("/approvals/:approval_id", sharedWalletController.GetApproval)
+	router.POST("/approvals/:approval_id/approve", sharedWalletController.Approve)
+	router.POST("/approvals/:approval_id/reject", sharedWalletController.Reject)
+	router.POST("/escrows", escrowController.CreateEscrow)
+	router.GET("/escrows/:escrow_id", escrowController.GetEscrow)
+	router.POST("/escrows/:escrow_id/release", escrowController.ReleaseEscrow)
+	router.POST("/escrows/:escrow_id/refund", escrowController.RefundEscrow)
+	router.POST("/escrows/:escrow_id/split", escrowController.SplitEscrow)
+	router.GET("/wallet/:user_id/escrows", escrowController.ListEscrows)
+	router.POST("/fx/quotes", fxController.CreateQuote)
+
+	// 管理接口
+	router.POST("/admin/fx/rates", fxController.PublishRate)
+	router.GET("/admin/fx/rates", fxController.ListRates)
+	router.POST("/admin/wallets/:user_id/freeze", walletController.FreezeWallet)
+	router.POST("/admin/wallets/:user_id/unfreeze", walletController.UnfreezeWallet)
+	router.POST("/admin/wallets/:user_id/close", walletController.CloseWallet)
+	router.GET("/admin/wallets/:user_id/status-changes", walletController.ListWalletStatusChanges)
+	router.POST("/admin/wallets/:user_id/limits", walletController.SetWalletLimits)
+	router.POST("/admin/wallets/:user_id/credit-limit", walletController.SetCreditLimit)
+	router.POST("/admin/reconciliations", reconciliationController.StartReconciliation)
+	router.GET("/admin/reconciliations", reconciliationController.ListReconciliations)
+	router.GET("/admin/reconciliations/:run_id", reconciliationController.GetReconciliation)
 
-	err := router.Run(":8080") // 启动服务在8080端口(暂时不用配置文件里的端口)
+	err = router.Run(":8080") // 启动服务在8080端口(暂时不用配置文件里的端口)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+	"wallet-service/pkg/postgresx"
+	"wallet-service/pkg/redisx"
+	"wallet-service/services"
+)
+
+// reconcileCommand 命令行对账: wallet-service reconcile [-repair-cache], 对账报告以 JSON 输出;
+// 退出码 0 表示没有不一致, 1 表示对账失败, 2 表示发现不一致
+func reconcileCommand(l *logger.Logger, args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repairCache := flags.Bool("repair-cache", false, "delete cached balances that do not match the database")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	service := services.NewReconciliationService(l, postgresx.GetDB(), redisx.GetRedisClient())
+	run, err := service.Reconcile(context.Background(), models.ReconciliationCLI, *repairCache)
+	if run != nil {
+		report, _ := json.MarshalIndent(run, "", "  ")
+		fmt.Println(string(report))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reconcile failed:", err)
+		return 1
+	}
+	if run.DriftCount > 0 {
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"strconv"
+	"wallet-service/models"
+)
+
+// BatchTransfer 批量付款, 返回每笔明细的执行结果
+func (wc *WalletController) BatchTransfer(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency string
+		Mode     models.BatchMode // all_or_nothing 全部成功或全部失败 / best_effort 尽量执行
+		Items    []models.BatchTransferItem
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController BatchTransfer BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	result, err := wc.walletService.BatchTransfer(ctx, userID, parseCurrency(request.Currency), request.Items, request.Mode)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController BatchTransfer walletService",
+			zap.Int("userID", userID), zap.Int("items", len(request.Items)), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	if result.Replayed {
+		c.Header(idempotentReplayedHeader, "true")
+	}
+	handleSuccess(c, result.Batch)
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_BatchTransfer(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	items := []models.BatchTransferItem{
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(50), Reference: "inv-1"},
+		{ReceiverUserID: 3, Amount: decimal.NewFromInt(500)},
+	}
+	batch := &models.BatchTransferResult{
+		BatchID: 9, Mode: models.BatchBestEffort, Currency: models.USD, TotalAmount: decimal.NewFromInt(50), Succeeded: 1, Failed: 1,
+		Items: []models.BatchItemResult{
+			{Index: 0, ReceiverUserID: 2, Amount: decimal.NewFromInt(50), Reference: "inv-1", Status: models.BatchItemSucceeded, TransactionID: 21},
+			{Index: 1, ReceiverUserID: 3, Amount: decimal.NewFromInt(500), Status: models.BatchItemFailed, Error: services.ErrInsufficientFunds.Error()},
+		},
+	}
+	// 设置 mock WalletService 的期望行为
+	mockService.On("BatchTransfer", mock.Anything, 1, models.USD, items, models.BatchBestEffort).
+		Return(&models.TransactionResult{Batch: batch}, nil)
+
+	router := gin.Default()
+	router.POST("/wallet/:user_id/transfers/batch", controller.BatchTransfer)
+
+	body := `{"currency": "usd", "mode": "best_effort", "items": [{"receiver_id": 2, "amount": "50", "reference": "inv-1"}, {"receiver_id": 3, "amount": "500"}]}`
+	req := httptest.NewRequest("POST", "/wallet/1/transfers/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"batch_id":9`)
+	assert.Contains(t, w.Body.String(), `"succeeded":1`)
+	assert.Contains(t, w.Body.String(), `"transaction_id":21`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_BatchTransfer_AllOrNothingRejected(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("BatchTransfer", mock.Anything, 1, models.USD, mock.Anything, models.BatchAllOrNothing).
+		Return(nil, fmt.Errorf("item 1: %w", services.ErrInsufficientFunds))
+
+	router := gin.Default()
+	router.POST("/wallet/:user_id/transfers/batch", controller.BatchTransfer)
+
+	body := `{"currency": "USD", "mode": "all_or_nothing", "items": [{"receiver_id": 2, "amount": "50"}, {"receiver_id": 3, "amount": "500"}]}`
+	req := httptest.NewRequest("POST", "/wallet/1/transfers/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), fmt.Sprintf(`"error_code":%d`, CODE_INSUFFICIENT_FUNDS))
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// SetCreditLimit 设置钱包的授信额度(管理接口)
+func (wc *WalletController) SetCreditLimit(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency    string
+		CreditLimit decimal.Decimal `json:"credit_limit"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController SetCreditLimit BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	wallet, err := wc.walletService.SetCreditLimit(ctx, userID, parseCurrency(request.Currency), request.CreditLimit)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController SetCreditLimit walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, wallet)
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_SetCreditLimit(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	creditLimit := decimal.RequireFromString("500")
+	mockService.On("SetCreditLimit", mock.Anything, 1, models.USD, creditLimit).
+		Return(&models.Wallet{UserID: 1, Currency: models.USD, CreditLimit: creditLimit, Status: models.WalletActive}, nil)
+
+	router := gin.Default()
+	router.POST("/admin/wallets/:user_id/credit-limit", controller.SetCreditLimit)
+
+	req := httptest.NewRequest("POST", "/admin/wallets/1/credit-limit", strings.NewReader(`{"currency": "USD", "credit_limit": "500"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"credit_limit":"500"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_SetCreditLimit_InUse(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("SetCreditLimit", mock.Anything, 1, models.USD, decimal.RequireFromString("100")).
+		Return(nil, fmt.Errorf("%w: 150 in use", services.ErrCreditLimitInUse))
+
+	router := gin.Default()
+	router.POST("/admin/wallets/:user_id/credit-limit", controller.SetCreditLimit)
+
+	req := httptest.NewRequest("POST", "/admin/wallets/1/credit-limit", strings.NewReader(`{"currency": "USD", "credit_limit": "100"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301016`)
+	mockService.AssertExpectations(t)
+}
@@ -8,12 +8,14 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"log"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"wallet-service/models"
 	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
 )
 
 func TestWalletController_Deposit_Success(t *testing.T) {
@@ -39,14 +41,14 @@ func TestWalletController_Deposit_Success(t *testing.T) {
 	amount := decimal.NewFromFloat(100.0).Round(0)
 
 	// 设置 mock WalletService 的期望行为
-	mockService.On("Deposit", ctx, userID, userID, amount, models.DepositTransactionType).Return(nil)
+	mockService.On("Deposit", ctx, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{}).Return(&models.TransactionResult{TransactionID: 1}, nil)
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.POST("/wallet/:user_id/deposit", controller.Deposit)
 
 	// 模拟请求，传递有效的 user_id 和存款金额
-	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s"}`, amount.String())))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -82,14 +84,14 @@ func TestWalletController_Deposit_Failed(t *testing.T) {
 	amount := decimal.NewFromFloat(100.0).Round(0)
 
 	// 设置 mock WalletService 的期望行为，模拟存款失败
-	mockService.On("Deposit", ctx, userID, userID, amount, models.DepositTransactionType).Return(errors.New("internal server error"))
+	mockService.On("Deposit", ctx, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{}).Return(nil, errors.New("internal server error"))
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.POST("/wallet/:user_id/deposit", controller.Deposit)
 
 	// 模拟请求，传递有效的 user_id 和存款金额
-	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s"}`, amount.String())))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -137,3 +139,137 @@ func TestWalletController_Deposit_InvalidParams(t *testing.T) {
 	// 验证方法调用
 	mockService.AssertExpectations(t)
 }
+
+func TestWalletController_Deposit_IdempotentReplay(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 准备测试数据
+	userID := 1
+	amount := decimal.NewFromFloat(100.0).Round(0)
+
+	// 设置 mock WalletService 的期望行为，模拟重放已保存的结果
+	mockService.On("Deposit", mock.Anything, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{}).
+		Return(&models.TransactionResult{TransactionID: 7, Replayed: true}, nil)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.POST("/wallet/:user_id/deposit", controller.Deposit)
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "deposit-key-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Contains(t, w.Body.String(), `"transaction_id":7`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_Deposit_IdempotencyConflict(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 准备测试数据
+	userID := 1
+	amount := decimal.NewFromFloat(100.0).Round(0)
+
+	// 设置 mock WalletService 的期望行为，模拟同一个幂等键被不同请求使用
+	mockService.On("Deposit", mock.Anything, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{}).
+		Return(nil, services.ErrIdempotencyKeyConflict)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.POST("/wallet/:user_id/deposit", controller.Deposit)
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "deposit-key-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301001`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_Deposit_WithDetails(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 准备测试数据
+	userID := 1
+	amount := decimal.NewFromInt(100)
+	details := models.TransactionDetails{Memo: "top up", ExternalReference: "psp-991", Metadata: models.Metadata(`{"channel": "card"}`)}
+
+	// 设置 mock WalletService 的期望行为, 附加信息原样传给 service
+	mockService.On("Deposit", mock.Anything, userID, userID, amount, models.USD, models.DepositTransactionType, details).
+		Return(&models.TransactionResult{TransactionID: 1}, nil)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.POST("/wallet/:user_id/deposit", controller.Deposit)
+
+	body := `{"amount": "100", "currency": "USD", "memo": "top up", "external_reference": "psp-991", "metadata": {"channel": "card"}}`
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"Deposit successful"`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
@@ -9,6 +9,31 @@ const (
 	CODE_INVALID_PARAMS      = 100004 // 请求参数错误
 	CODE_DATA_LEN_ERROR      = 100005 // 数据格式错误
 	CODE_REQUEST_TOO_QUICKLY = 100006
+	// 交易
+	CODE_IDEMPOTENCY_CONFLICT      = 301001 // 幂等键已被不同的请求使用
+	CODE_INSUFFICIENT_FUNDS        = 301002 // 余额不足
+	CODE_CURRENCY_MISMATCH         = 301003 // 币种不一致
+	CODE_FX_QUOTE_UNAVAILABLE      = 301004 // 换汇报价不存在、已过期或已使用
+	CODE_HOLD_NOT_ACTIVE           = 301005 // 冻结已扣款、已撤销或已过期
+	CODE_REFUND_EXCEEDS            = 301006 // 退款金额超过可退金额
+	CODE_NOT_REVERSIBLE            = 301007 // 该类型的交易不能冲正
+	CODE_SCHEDULE_NOT_ACTIVE       = 301008 // 定时转账已取消或已结束
+	CODE_INVALID_STATUS_TRANSITION = 301009 // 交易或钱包当前状态不允许该操作
+	CODE_DUPLICATE_EXTERNAL_REF    = 301010 // 调用方单号已被使用
+	CODE_WALLET_FROZEN             = 301011 // 钱包已冻结, 不能付款
+	CODE_WALLET_CLOSED             = 301012 // 钱包已注销
+	CODE_WALLET_EXISTS             = 301013 // 钱包已存在
+	CODE_WALLET_NOT_EMPTY          = 301014 // 注销前余额必须为零
+	CODE_LIMIT_EXCEEDED            = 301015 // 超出钱包限额
+	CODE_CREDIT_LIMIT_IN_USE       = 301016 // 授信额度低于已使用的额度
+	CODE_POCKET_EXISTS             = 301017 // 同名的 pocket 已存在
+	CODE_POCKET_CLOSED             = 301018 // pocket 已关闭
+	CODE_SHARED_WALLET_EXISTS      = 301019 // 钱包已是共享钱包
+	CODE_NOT_PERMITTED             = 301020 // 不是共享钱包成员或角色不允许该操作
+	CODE_APPROVAL_NOT_PENDING      = 301021 // 审批请求已结束或已过期
+	CODE_ALREADY_DECIDED           = 301022 // 成员已对该审批请求作出决定
+	CODE_ESCROW_SETTLED            = 301023 // 托管已结算
+	CODE_APPROVAL_REQUIRED         = 301024 // 共享钱包超过审批阈值的付款需经过审批
 	// 用户
 	CODE_USER_ROLE_NOT_EXISTS = 201001 // 用户不存在
 
@@ -24,6 +49,32 @@ const (
 	ERRMSG_INVALID_PARAMS      string = "invalid params" // 请求参数错误
 	ERRMSG_DATA_LEN_ERROR      string = "data_len_error" // 数据格式错误
 
+	// 交易
+	ERRMSG_IDEMPOTENCY_CONFLICT      string = "idempotency_key_conflict"     // 幂等键已被不同的请求使用
+	ERRMSG_INSUFFICIENT_FUNDS        string = "insufficient_funds"           // 余额不足
+	ERRMSG_CURRENCY_MISMATCH         string = "currency_mismatch"            // 币种不一致
+	ERRMSG_FX_QUOTE_UNAVAILABLE      string = "fx_quote_unavailable"         // 换汇报价不存在、已过期或已使用
+	ERRMSG_HOLD_NOT_ACTIVE           string = "hold_not_active"              // 冻结已扣款、已撤销或已过期
+	ERRMSG_REFUND_EXCEEDS            string = "refund_exceeds_remaining"     // 退款金额超过可退金额
+	ERRMSG_NOT_REVERSIBLE            string = "transaction_not_reversible"   // 该类型的交易不能冲正
+	ERRMSG_SCHEDULE_NOT_ACTIVE       string = "schedule_not_active"          // 定时转账已取消或已结束
+	ERRMSG_INVALID_STATUS_TRANSITION string = "invalid_status_transition"    // 交易或钱包当前状态不允许该操作
+	ERRMSG_DUPLICATE_EXTERNAL_REF    string = "duplicate_external_reference" // 调用方单号已被使用
+	ERRMSG_WALLET_FROZEN             string = "wallet_frozen"                // 钱包已冻结, 不能付款
+	ERRMSG_WALLET_CLOSED             string = "wallet_closed"                // 钱包已注销
+	ERRMSG_WALLET_EXISTS             string = "wallet_already_exists"        // 钱包已存在
+	ERRMSG_WALLET_NOT_EMPTY          string = "wallet_not_empty"             // 注销前余额必须为零
+	ERRMSG_LIMIT_EXCEEDED            string = "limit_exceeded"               // 超出钱包限额
+	ERRMSG_CREDIT_LIMIT_IN_USE       string = "credit_limit_in_use"          // 授信额度低于已使用的额度
+	ERRMSG_POCKET_EXISTS             string = "pocket_exists"                // 同名的 pocket 已存在
+	ERRMSG_POCKET_CLOSED             string = "pocket_closed"                // pocket 已关闭
+	ERRMSG_SHARED_WALLET_EXISTS      string = "shared_wallet_exists"         // 钱包已是共享钱包
+	ERRMSG_NOT_PERMITTED             string = "not_permitted"                // 不是共享钱包成员或角色不允许该操作
+	ERRMSG_APPROVAL_NOT_PENDING      string = "approval_not_pending"         // 审批请求已结束或已过期
+	ERRMSG_ALREADY_DECIDED           string = "already_decided"              // 成员已对该审批请求作出决定
+	ERRMSG_ESCROW_SETTLED            string = "escrow_settled"               // 托管已结算
+	ERRMSG_APPROVAL_REQUIRED         string = "approval_required"            // 共享钱包超过审批阈值的付款需经过审批
+
 	// 用户
 	ERRMSG_USER_ROLE_NOT_EXISTS string = "user role not exists" // 角色不存在
 )
@@ -37,6 +88,32 @@ var codeToERRMsgMap = map[int]string{
 	CODE_INVALID_PARAMS:      ERRMSG_INVALID_PARAMS, // 请求参数错误
 	CODE_DATA_LEN_ERROR:      ERRMSG_DATA_LEN_ERROR, // 数据格式错误
 
+	// 交易
+	CODE_IDEMPOTENCY_CONFLICT:      ERRMSG_IDEMPOTENCY_CONFLICT,      // 幂等键已被不同的请求使用
+	CODE_INSUFFICIENT_FUNDS:        ERRMSG_INSUFFICIENT_FUNDS,        // 余额不足
+	CODE_CURRENCY_MISMATCH:         ERRMSG_CURRENCY_MISMATCH,         // 币种不一致
+	CODE_FX_QUOTE_UNAVAILABLE:      ERRMSG_FX_QUOTE_UNAVAILABLE,      // 换汇报价不存在、已过期或已使用
+	CODE_HOLD_NOT_ACTIVE:           ERRMSG_HOLD_NOT_ACTIVE,           // 冻结已扣款、已撤销或已过期
+	CODE_REFUND_EXCEEDS:            ERRMSG_REFUND_EXCEEDS,            // 退款金额超过可退金额
+	CODE_NOT_REVERSIBLE:            ERRMSG_NOT_REVERSIBLE,            // 该类型的交易不能冲正
+	CODE_SCHEDULE_NOT_ACTIVE:       ERRMSG_SCHEDULE_NOT_ACTIVE,       // 定时转账已取消或已结束
+	CODE_INVALID_STATUS_TRANSITION: ERRMSG_INVALID_STATUS_TRANSITION, // 交易或钱包当前状态不允许该操作
+	CODE_DUPLICATE_EXTERNAL_REF:    ERRMSG_DUPLICATE_EXTERNAL_REF,    // 调用方单号已被使用
+	CODE_WALLET_FROZEN:             ERRMSG_WALLET_FROZEN,             // 钱包已冻结, 不能付款
+	CODE_WALLET_CLOSED:             ERRMSG_WALLET_CLOSED,             // 钱包已注销
+	CODE_WALLET_EXISTS:             ERRMSG_WALLET_EXISTS,             // 钱包已存在
+	CODE_WALLET_NOT_EMPTY:          ERRMSG_WALLET_NOT_EMPTY,          // 注销前余额必须为零
+	CODE_LIMIT_EXCEEDED:            ERRMSG_LIMIT_EXCEEDED,            // 超出钱包限额
+	CODE_CREDIT_LIMIT_IN_USE:       ERRMSG_CREDIT_LIMIT_IN_USE,       // 授信额度低于已使用的额度
+	CODE_POCKET_EXISTS:             ERRMSG_POCKET_EXISTS,             // 同名的 pocket 已存在
+	CODE_POCKET_CLOSED:             ERRMSG_POCKET_CLOSED,             // pocket 已关闭
+	CODE_SHARED_WALLET_EXISTS:      ERRMSG_SHARED_WALLET_EXISTS,      // 钱包已是共享钱包
+	CODE_NOT_PERMITTED:             ERRMSG_NOT_PERMITTED,             // 不是共享钱包成员或角色不允许该操作
+	CODE_APPROVAL_NOT_PENDING:      ERRMSG_APPROVAL_NOT_PENDING,      // 审批请求已结束或已过期
+	CODE_ALREADY_DECIDED:           ERRMSG_ALREADY_DECIDED,           // 成员已对该审批请求作出决定
+	CODE_ESCROW_SETTLED:            ERRMSG_ESCROW_SETTLED,            // 托管已结算
+	CODE_APPROVAL_REQUIRED:         ERRMSG_APPROVAL_REQUIRED,         // 共享钱包超过审批阈值的付款需经过审批
+
 	// 用户
 	CODE_USER_ROLE_NOT_EXISTS: ERRMSG_USER_ROLE_NOT_EXISTS, // 角色不存在
 
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type EscrowController struct {
+	escrowService services.EscrowService
+	logger        *wallet_logger.Logger
+}
+
+// NewEscrowController new escrow controller
+func NewEscrowController(logger *wallet_logger.Logger, service services.EscrowService) *EscrowController {
+	return &EscrowController{
+		escrowService: service,
+		logger:        logger,
+	}
+}
+
+// CreateEscrow 创建托管, 买方资金转入托管账户
+func (ec *EscrowController) CreateEscrow(c *gin.Context) {
+	ctx, err := withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	var request struct {
+		BuyerID   int             `json:"buyer_id"`
+		SellerID  int             `json:"seller_id"`
+		Amount    decimal.Decimal `json:"amount"`
+		Currency  string          `json:"currency"`
+		ExpiresAt *time.Time      `json:"expires_at"` // 为空表示 14 天后到期
+		OnExpiry  string          `json:"on_expiry"`  // release/refund, 默认 refund
+	}
+	if err := c.BindJSON(&request); err != nil {
+		ec.logger.Error(ctx, "EscrowController CreateEscrow BindJSON", zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	escrow := models.Escrow{
+		BuyerUserID:  request.BuyerID,
+		SellerUserID: request.SellerID,
+		Amount:       request.Amount,
+		Currency:     parseCurrency(request.Currency),
+		OnExpiry:     models.EscrowAction(request.OnExpiry),
+	}
+	if request.ExpiresAt != nil {
+		escrow.ExpiresAt = *request.ExpiresAt
+	}
+	created, err := ec.escrowService.CreateEscrow(ctx, escrow)
+	if err != nil {
+		ec.logger.Error(ctx, "EscrowController CreateEscrow escrowService",
+			zap.Int("buyerID", request.BuyerID), zap.Int("sellerID", request.SellerID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, created)
+}
+
+// GetEscrow 查询托管及其结算记录
+func (ec *EscrowController) GetEscrow(c *gin.Context) {
+	escrowID, err := strconv.Atoi(c.Param("escrow_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	escrow, err := ec.escrowService.GetEscrow(ctx, escrowID)
+	if err != nil {
+		ec.logger.Error(ctx, "EscrowController GetEscrow escrowService",
+			zap.Int("escrowID", escrowID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, escrow)
+}
+
+// ListEscrows 查询用户作为买方或卖方的托管
+func (ec *EscrowController) ListEscrows(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	escrows, err := ec.escrowService.ListEscrows(ctx, userID)
+	if err != nil {
+		ec.logger.Error(ctx, "EscrowController ListEscrows escrowService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{"escrows": escrows})
+}
+
+// ReleaseEscrow 买方放款给卖方, amount 为空时放款全部剩余金额
+func (ec *EscrowController) ReleaseEscrow(c *gin.Context) {
+	ec.settle(c, "ReleaseEscrow", ec.escrowService.ReleaseEscrow)
+}
+
+// RefundEscrow 卖方退款给买方, amount 为空时退还全部剩余金额
+func (ec *EscrowController) RefundEscrow(c *gin.Context) {
+	ec.settle(c, "RefundEscrow", ec.escrowService.RefundEscrow)
+}
+
+// SplitEscrow 提议或确认拆分, seller_amount 放款给卖方, 其余退款给买方; 双方提议相同金额时执行
+func (ec *EscrowController) SplitEscrow(c *gin.Context) {
+	ec.settle(c, "SplitEscrow", ec.escrowService.SplitEscrow)
+}
+
+type escrowSettleFunc func(ctx context.Context, escrowID, actorID int, amount decimal.Decimal) (*models.Escrow, error)
+
+func (ec *EscrowController) settle(c *gin.Context, name string, fn escrowSettleFunc) {
+	escrowID, err := strconv.Atoi(c.Param("escrow_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx, err := withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	var request struct {
+		ActorID      int             `json:"actor_id"`
+		Amount       decimal.Decimal `json:"amount"`        // release/refund
+		SellerAmount decimal.Decimal `json:"seller_amount"` // split
+	}
+	if err := c.BindJSON(&request); err != nil {
+		ec.logger.Error(ctx, "EscrowController "+name+" BindJSON",
+			zap.Int("escrowID", escrowID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	amount := request.Amount
+	if name == "SplitEscrow" {
+		amount = request.SellerAmount
+	}
+
+	escrow, err := fn(ctx, escrowID, request.ActorID, amount)
+	if err != nil {
+		ec.logger.Error(ctx, "EscrowController "+name+" escrowService",
+			zap.Int("escrowID", escrowID), zap.Int("actorID", request.ActorID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, escrow)
+}
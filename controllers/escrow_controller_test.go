@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type MockEscrowService struct {
+	mock.Mock
+}
+
+func (m *MockEscrowService) CreateEscrow(ctx context.Context, escrow models.Escrow) (*models.Escrow, error) {
+	args := m.Called(ctx, escrow)
+	result, _ := args.Get(0).(*models.Escrow)
+	return result, args.Error(1)
+}
+
+func (m *MockEscrowService) GetEscrow(ctx context.Context, escrowID int) (*models.Escrow, error) {
+	args := m.Called(ctx, escrowID)
+	result, _ := args.Get(0).(*models.Escrow)
+	return result, args.Error(1)
+}
+
+func (m *MockEscrowService) ListEscrows(ctx context.Context, userID int) ([]models.Escrow, error) {
+	args := m.Called(ctx, userID)
+	escrows, _ := args.Get(0).([]models.Escrow)
+	return escrows, args.Error(1)
+}
+
+func (m *MockEscrowService) ReleaseEscrow(ctx context.Context, escrowID, actorID int, amount decimal.Decimal) (*models.Escrow, error) {
+	args := m.Called(ctx, escrowID, actorID, amount)
+	result, _ := args.Get(0).(*models.Escrow)
+	return result, args.Error(1)
+}
+
+func (m *MockEscrowService) RefundEscrow(ctx context.Context, escrowID, actorID int, amount decimal.Decimal) (*models.Escrow, error) {
+	args := m.Called(ctx, escrowID, actorID, amount)
+	result, _ := args.Get(0).(*models.Escrow)
+	return result, args.Error(1)
+}
+
+func (m *MockEscrowService) SplitEscrow(ctx context.Context, escrowID, actorID int, sellerAmount decimal.Decimal) (*models.Escrow, error) {
+	args := m.Called(ctx, escrowID, actorID, sellerAmount)
+	result, _ := args.Get(0).(*models.Escrow)
+	return result, args.Error(1)
+}
+
+func TestEscrowController_CreateEscrow(t *testing.T) {
+	mockService := new(MockEscrowService)
+	controller := NewEscrowController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("CreateEscrow", mock.Anything, models.Escrow{
+		BuyerUserID: 1, SellerUserID: 2, Amount: decimal.RequireFromString("100"), Currency: models.USD, OnExpiry: models.EscrowRelease,
+	}).Return(&models.Escrow{ID: 5, BuyerUserID: 1, SellerUserID: 2, Status: models.EscrowFunded}, nil)
+
+	router := gin.Default()
+	router.POST("/escrows", controller.CreateEscrow)
+
+	req := httptest.NewRequest("POST", "/escrows",
+		strings.NewReader(`{"buyer_id": 1, "seller_id": 2, "amount": "100", "currency": "usd", "on_expiry": "release"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":5`)
+	assert.Contains(t, w.Body.String(), `"status":"funded"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestEscrowController_SplitEscrow(t *testing.T) {
+	mockService := new(MockEscrowService)
+	controller := NewEscrowController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("SplitEscrow", mock.Anything, 5, 2, decimal.RequireFromString("30")).
+		Return(&models.Escrow{ID: 5, Status: models.EscrowSplit}, nil)
+
+	router := gin.Default()
+	router.POST("/escrows/:escrow_id/split", controller.SplitEscrow)
+
+	req := httptest.NewRequest("POST", "/escrows/5/split", strings.NewReader(`{"actor_id": 2, "seller_amount": "30"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"split"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestEscrowController_RefundEscrow_Settled(t *testing.T) {
+	mockService := new(MockEscrowService)
+	controller := NewEscrowController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("RefundEscrow", mock.Anything, 5, 2, decimal.Decimal{}).
+		Return(nil, services.ErrEscrowSettled)
+
+	router := gin.Default()
+	router.POST("/escrows/:escrow_id/refund", controller.RefundEscrow)
+
+	req := httptest.NewRequest("POST", "/escrows/5/refund", strings.NewReader(`{"actor_id": 2}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301023`)
+	mockService.AssertExpectations(t)
+}
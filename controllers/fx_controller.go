@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type FXController struct {
+	fxService services.FXService
+	logger    *wallet_logger.Logger
+}
+
+// NewFXController new fx controller
+func NewFXController(logger *wallet_logger.Logger, service services.FXService) *FXController {
+	return &FXController{
+		fxService: service,
+		logger:    logger,
+	}
+}
+
+// PublishRate 发布汇率(管理接口)
+func (fc *FXController) PublishRate(c *gin.Context) {
+	ctx := c.Request.Context()
+	var request struct {
+		BaseCurrency  string          `json:"base_currency"`
+		QuoteCurrency string          `json:"quote_currency"`
+		Rate          decimal.Decimal `json:"rate"`
+		Spread        decimal.Decimal `json:"spread"`
+		ValidFrom     *time.Time      `json:"valid_from"` // 为空表示立即生效
+		ValidTo       *time.Time      `json:"valid_to"`   // 为空表示长期有效
+	}
+	if err := c.BindJSON(&request); err != nil {
+		fc.logger.Error(ctx, "FXController PublishRate BindJSON", zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	rate := models.FXRate{
+		BaseCurrency:  parseCurrency(request.BaseCurrency),
+		QuoteCurrency: parseCurrency(request.QuoteCurrency),
+		Rate:          request.Rate,
+		Spread:        request.Spread,
+		ValidTo:       request.ValidTo,
+	}
+	if request.ValidFrom != nil {
+		rate.ValidFrom = *request.ValidFrom
+	}
+	published, err := fc.fxService.PublishRate(ctx, rate)
+	if err != nil {
+		fc.logger.Error(ctx, "FXController PublishRate fxService", zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, published)
+}
+
+// ListRates 查询币种对的汇率(管理接口)
+func (fc *FXController) ListRates(c *gin.Context) {
+	ctx := c.Request.Context()
+	rates, err := fc.fxService.ListRates(ctx, parseCurrency(c.Query("base")), parseCurrency(c.Query("quote")))
+	if err != nil {
+		fc.logger.Error(ctx, "FXController ListRates fxService", zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, rates)
+}
+
+// CreateQuote 获取锁定汇率的报价, 在有效期内用于换汇转账
+func (fc *FXController) CreateQuote(c *gin.Context) {
+	ctx := c.Request.Context()
+	var request struct {
+		BaseCurrency  string `json:"base_currency"`
+		QuoteCurrency string `json:"quote_currency"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		fc.logger.Error(ctx, "FXController CreateQuote BindJSON", zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	quote, err := fc.fxService.CreateQuote(ctx, parseCurrency(request.BaseCurrency), parseCurrency(request.QuoteCurrency))
+	if err != nil {
+		fc.logger.Error(ctx, "FXController CreateQuote fxService", zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{
+		"quote_id":       quote.ID,
+		"base_currency":  quote.BaseCurrency,
+		"quote_currency": quote.QuoteCurrency,
+		"rate":           quote.ClientRate(),
+		"expires_at":     quote.ExpiresAt,
+	})
+}
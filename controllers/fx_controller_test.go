@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type MockFXService struct {
+	mock.Mock
+}
+
+func (m *MockFXService) PublishRate(ctx context.Context, rate models.FXRate) (*models.FXRate, error) {
+	args := m.Called(ctx, rate)
+	result, _ := args.Get(0).(*models.FXRate)
+	return result, args.Error(1)
+}
+
+func (m *MockFXService) ListRates(ctx context.Context, base, quote models.Currency) ([]models.FXRate, error) {
+	args := m.Called(ctx, base, quote)
+	rates, _ := args.Get(0).([]models.FXRate)
+	return rates, args.Error(1)
+}
+
+func (m *MockFXService) CreateQuote(ctx context.Context, base, quote models.Currency) (*models.FXQuote, error) {
+	args := m.Called(ctx, base, quote)
+	result, _ := args.Get(0).(*models.FXQuote)
+	return result, args.Error(1)
+}
+
+func TestFXController_CreateQuote_Success(t *testing.T) {
+	mockService := new(MockFXService)
+	controller := NewFXController(wallet_logger.NewLogger(), mockService)
+
+	// 设置 mock FXService 的期望行为
+	quote := &models.FXQuote{
+		ID:            "q-1",
+		BaseCurrency:  models.EUR,
+		QuoteCurrency: models.USD,
+		Rate:          decimal.RequireFromString("1.1"),
+		Spread:        decimal.RequireFromString("0.01"),
+		ExpiresAt:     time.Now().Add(30 * time.Second),
+	}
+	mockService.On("CreateQuote", mock.Anything, models.EUR, models.USD).Return(quote, nil)
+
+	router := gin.Default()
+	router.POST("/fx/quotes", controller.CreateQuote)
+
+	req := httptest.NewRequest("POST", "/fx/quotes", strings.NewReader(`{"base_currency": "eur", "quote_currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 返回扣除点差后的成交汇率
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"quote_id":"q-1"`)
+	assert.Contains(t, w.Body.String(), `"rate":"1.089"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestFXController_CreateQuote_NoRate(t *testing.T) {
+	mockService := new(MockFXService)
+	controller := NewFXController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("CreateQuote", mock.Anything, models.EUR, models.JPY).Return(nil, services.ErrFXRateNotFound)
+
+	router := gin.Default()
+	router.POST("/fx/quotes", controller.CreateQuote)
+
+	req := httptest.NewRequest("POST", "/fx/quotes", strings.NewReader(`{"base_currency": "EUR", "quote_currency": "JPY"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), `"error_code":100001`)
+	mockService.AssertExpectations(t)
+}
+
+func TestFXController_PublishRate_InvalidRate(t *testing.T) {
+	mockService := new(MockFXService)
+	controller := NewFXController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("PublishRate", mock.Anything, mock.AnythingOfType("models.FXRate")).Return(nil, services.ErrInvalidFXRate)
+
+	router := gin.Default()
+	router.POST("/admin/fx/rates", controller.PublishRate)
+
+	req := httptest.NewRequest("POST", "/admin/fx/rates", strings.NewReader(`{"base_currency": "EUR", "quote_currency": "USD", "rate": "0"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":100004`)
+	mockService.AssertExpectations(t)
+}
@@ -11,6 +11,7 @@ import (
 	"log"
 	"net/http/httptest"
 	"testing"
+	"wallet-service/models"
 	wallet_logger "wallet-service/pkg/logger"
 )
 
@@ -37,20 +38,26 @@ func TestWalletController_GetBalance_Success(t *testing.T) {
 	expectedBalance := decimal.NewFromFloat(100.0)
 
 	// 设置 mock WalletService 的期望行为
-	mockService.On("GetBalance", ctx, userID).Return(expectedBalance, nil)
+	mockService.On("GetBalance", ctx, userID, models.USD).Return(&models.Balance{
+		Currency:  models.USD,
+		Ledger:    expectedBalance,
+		Held:      decimal.NewFromFloat(30.0),
+		Available: decimal.NewFromFloat(70.0),
+	}, nil)
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.GET("/wallet/:user_id/balance", controller.GetBalance)
 
 	// 模拟请求，传递有效的 user_id
-	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/balance", userID), nil)
+	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/balance?currency=USD", userID), nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
 	// 断言返回结果
 	assert.Equal(t, 200, w.Code)
 	assert.Contains(t, w.Body.String(), `"balance":"100"`)
+	assert.Contains(t, w.Body.String(), `"available_balance":"70"`)
 
 	// 验证方法调用
 	mockService.AssertExpectations(t)
@@ -78,14 +85,14 @@ func TestWalletController_GetBalance_Failed(t *testing.T) {
 	userID := 1
 
 	// 设置 mock WalletService 的期望行为，模拟查询余额失败
-	mockService.On("GetBalance", ctx, userID).Return(decimal.Zero, errors.New("internal server error"))
+	mockService.On("GetBalance", ctx, userID, models.USD).Return(nil, errors.New("internal server error"))
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.GET("/wallet/:user_id/balance", controller.GetBalance)
 
 	// 模拟请求，传递有效的 user_id
-	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/balance", userID), nil)
+	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/balance?currency=USD", userID), nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -131,3 +138,48 @@ func TestWalletController_GetBalance_InvalidParams(t *testing.T) {
 	// 验证方法调用
 	mockService.AssertExpectations(t)
 }
+
+func TestWalletController_GetBalance_AllCurrencies(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 准备测试数据
+	userID := 1
+	wallets := []models.Wallet{
+		{UserID: userID, Currency: models.CNY, Balance: decimal.NewFromFloat(80.5)},
+		{UserID: userID, Currency: models.USD, Balance: decimal.NewFromFloat(100.0)},
+	}
+
+	// 设置 mock WalletService 的期望行为: 未指定币种时返回所有币种
+	mockService.On("GetBalances", ctx, userID).Return(wallets, nil)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.GET("/wallet/:user_id/balance", controller.GetBalance)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/balance", userID), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `{"available_balance":"80.5","balance":"80.5","currency":"CNY","held_balance":"0"}`)
+	assert.Contains(t, w.Body.String(), `{"available_balance":"100","balance":"100","currency":"USD","held_balance":"0"}`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
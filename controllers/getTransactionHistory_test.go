@@ -7,9 +7,11 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"log"
 	"net/http/httptest"
 	"testing"
+	"time"
 	"wallet-service/models"
 	wallet_logger "wallet-service/pkg/logger"
 )
@@ -40,7 +42,8 @@ func TestWalletController_GetTransactionHistory_Success(t *testing.T) {
 	}
 
 	// 设置 mock WalletService 的期望行为，返回交易历史
-	mockService.On("GetTransactionHistory", userID).Return(transactions, nil)
+	mockService.On("GetTransactionHistory", mock.Anything, userID, models.TransactionFilter{}).
+		Return(&models.TransactionPage{Transactions: transactions}, nil)
 
 	// 创建 HTTP 请求
 	router := gin.Default()
@@ -83,8 +86,9 @@ func TestWalletController_GetTransactionHistory_NoTransactions(t *testing.T) {
 	userID := 1
 	transactions := []models.Transaction{}
 
-	// 设置 mock WalletService 的期望行为，返回空的交易历史
-	mockService.On("GetTransactionHistory", userID).Return(transactions, nil)
+	// 设置 mock WalletService 的期望行为，返回空的交易历史, 空列表不再视为未找到
+	mockService.On("GetTransactionHistory", mock.Anything, userID, models.TransactionFilter{}).
+		Return(&models.TransactionPage{Transactions: transactions}, nil)
 
 	// 创建 HTTP 请求
 	router := gin.Default()
@@ -97,7 +101,63 @@ func TestWalletController_GetTransactionHistory_NoTransactions(t *testing.T) {
 
 	// 断言返回结果
 	assert.Equal(t, 200, w.Code)
-	assert.Contains(t, w.Body.String(), `"error_code":100001`) // 未找到交易记录
+	assert.Contains(t, w.Body.String(), `"error_code":0`)
+	assert.Contains(t, w.Body.String(), `"transactions":[]`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_GetTransactionHistory_Filtered(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 准备测试数据
+	userID := 1
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	minAmount := decimal.NewFromInt(10)
+	filter := models.TransactionFilter{
+		Types:     []models.TransactionType{models.TransferTransactionType, models.ReversalTransactionType},
+		Direction: models.DirectionIn,
+		From:      &from,
+		MinAmount: &minAmount,
+		PageToken: "abc",
+		Limit:     20,
+	}
+	transactions := []models.Transaction{
+		{ID: 7, SenderUserID: 2, ReceiverUserID: userID, Amount: decimal.NewFromInt(30), TransactionType: models.TransferTransactionType},
+	}
+
+	// 设置 mock WalletService 的期望行为，返回一页交易历史和下一页游标
+	mockService.On("GetTransactionHistory", mock.Anything, userID, filter).
+		Return(&models.TransactionPage{Transactions: transactions, NextPageToken: "next"}, nil)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.GET("/wallet/:user_id/transactions", controller.GetTransactionHistory)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/transactions?type=transfer,reversal&direction=in&from=2026-01-01T00:00:00Z&min_amount=10&page_token=abc&limit=20", userID), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"ID":7`)
+	assert.Contains(t, w.Body.String(), `"next_page_token":"next"`)
 
 	// 验证方法调用
 	mockService.AssertExpectations(t)
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"wallet-service/services"
 )
 
 // handleError 通用错误处理函数
@@ -13,10 +15,17 @@ func handleError(c *gin.Context, errorCode int, err error) {
 		switch errorCode {
 		case CODE_NOT_FOUND, CODE_USER_ROLE_NOT_EXISTS:
 			statusCode = http.StatusOK
-		case CODE_INVALID_PARAMS, CODE_DATA_LEN_ERROR:
+		case CODE_INVALID_PARAMS, CODE_DATA_LEN_ERROR, CODE_INSUFFICIENT_FUNDS, CODE_CURRENCY_MISMATCH, CODE_FX_QUOTE_UNAVAILABLE,
+			CODE_HOLD_NOT_ACTIVE, CODE_REFUND_EXCEEDS, CODE_NOT_REVERSIBLE, CODE_SCHEDULE_NOT_ACTIVE, CODE_INVALID_STATUS_TRANSITION,
+			CODE_WALLET_NOT_EMPTY, CODE_LIMIT_EXCEEDED, CODE_CREDIT_LIMIT_IN_USE, CODE_POCKET_CLOSED, CODE_APPROVAL_NOT_PENDING, CODE_ESCROW_SETTLED:
 			statusCode = http.StatusBadRequest
 		case CODE_TIMEOUT:
 			statusCode = http.StatusGatewayTimeout
+		case CODE_WALLET_FROZEN, CODE_WALLET_CLOSED, CODE_NOT_PERMITTED, CODE_APPROVAL_REQUIRED:
+			statusCode = http.StatusForbidden
+		case CODE_IDEMPOTENCY_CONFLICT, CODE_DUPLICATE_EXTERNAL_REF, CODE_WALLET_EXISTS, CODE_POCKET_EXISTS,
+			CODE_SHARED_WALLET_EXISTS, CODE_ALREADY_DECIDED:
+			statusCode = http.StatusConflict
 		default:
 			statusCode = http.StatusInternalServerError
 		}
@@ -27,10 +36,84 @@ func handleError(c *gin.Context, errorCode int, err error) {
 		}
 
 		responseData.Detail = err.Error()
+		// 超出限额时返回具体的限额项和重置时间
+		var limitErr *services.LimitExceededError
+		if errors.As(err, &limitErr) {
+			responseData.Data = limitErr
+		}
 		c.JSON(statusCode, responseData)
 	}
 }
 
+// serviceErrorCode 把 service 层返回的错误映射为错误码
+func serviceErrorCode(err error) int {
+	switch {
+	case errors.Is(err, services.ErrIdempotencyKeyConflict):
+		return CODE_IDEMPOTENCY_CONFLICT
+	case errors.Is(err, services.ErrInsufficientFunds):
+		return CODE_INSUFFICIENT_FUNDS
+	case errors.Is(err, services.ErrWalletNotFound), errors.Is(err, services.ErrFXRateNotFound), errors.Is(err, services.ErrHoldNotFound),
+		errors.Is(err, services.ErrTransactionNotFound), errors.Is(err, services.ErrScheduleNotFound), errors.Is(err, services.ErrPocketNotFound),
+		errors.Is(err, services.ErrSharedWalletNotFound), errors.Is(err, services.ErrMemberNotFound), errors.Is(err, services.ErrApprovalNotFound),
+		errors.Is(err, services.ErrEscrowNotFound), errors.Is(err, services.ErrReconciliationNotFound):
+		return CODE_NOT_FOUND
+	case errors.Is(err, services.ErrCurrencyMismatch):
+		return CODE_CURRENCY_MISMATCH
+	case errors.Is(err, services.ErrFXQuoteUnavailable):
+		return CODE_FX_QUOTE_UNAVAILABLE
+	case errors.Is(err, services.ErrHoldNotActive), errors.Is(err, services.ErrHoldExpired):
+		return CODE_HOLD_NOT_ACTIVE
+	case errors.Is(err, services.ErrRefundExceedsRemaining):
+		return CODE_REFUND_EXCEEDS
+	case errors.Is(err, services.ErrNotReversible):
+		return CODE_NOT_REVERSIBLE
+	case errors.Is(err, services.ErrScheduleNotActive):
+		return CODE_SCHEDULE_NOT_ACTIVE
+	case errors.Is(err, services.ErrInvalidStatusTransition), errors.Is(err, services.ErrInvalidWalletStatusTransition):
+		return CODE_INVALID_STATUS_TRANSITION
+	case errors.Is(err, services.ErrDuplicateExternalReference):
+		return CODE_DUPLICATE_EXTERNAL_REF
+	case errors.Is(err, services.ErrWalletFrozen):
+		return CODE_WALLET_FROZEN
+	case errors.Is(err, services.ErrWalletClosed):
+		return CODE_WALLET_CLOSED
+	case errors.Is(err, services.ErrWalletAlreadyExists):
+		return CODE_WALLET_EXISTS
+	case errors.Is(err, services.ErrWalletNotEmpty):
+		return CODE_WALLET_NOT_EMPTY
+	case errors.Is(err, services.ErrLimitExceeded):
+		return CODE_LIMIT_EXCEEDED
+	case errors.Is(err, services.ErrCreditLimitInUse):
+		return CODE_CREDIT_LIMIT_IN_USE
+	case errors.Is(err, services.ErrPocketExists):
+		return CODE_POCKET_EXISTS
+	case errors.Is(err, services.ErrPocketClosed):
+		return CODE_POCKET_CLOSED
+	case errors.Is(err, services.ErrSharedWalletExists):
+		return CODE_SHARED_WALLET_EXISTS
+	case errors.Is(err, services.ErrNotPermitted):
+		return CODE_NOT_PERMITTED
+	case errors.Is(err, services.ErrApprovalNotPending):
+		return CODE_APPROVAL_NOT_PENDING
+	case errors.Is(err, services.ErrAlreadyDecided):
+		return CODE_ALREADY_DECIDED
+	case errors.Is(err, services.ErrEscrowSettled):
+		return CODE_ESCROW_SETTLED
+	case errors.Is(err, services.ErrApprovalRequired):
+		return CODE_APPROVAL_REQUIRED
+	case errors.Is(err, services.ErrUnsupportedCurrency), errors.Is(err, services.ErrInvalidAmountPrecision),
+		errors.Is(err, services.ErrInvalidFXRate), errors.Is(err, services.ErrExchangeTooSmall), errors.Is(err, services.ErrCaptureExceedsHold),
+		errors.Is(err, services.ErrInvalidReversalPolicy), errors.Is(err, services.ErrInvalidSchedule), errors.Is(err, services.ErrInvalidBatch),
+		errors.Is(err, services.ErrInvalidHistoryFilter), errors.Is(err, services.ErrInvalidPageToken), errors.Is(err, services.ErrInvalidTransactionDetails),
+		errors.Is(err, services.ErrInvalidLimits), errors.Is(err, services.ErrInvalidCreditLimit),
+		errors.Is(err, services.ErrInvalidPocket), errors.Is(err, services.ErrInvalidSharedWallet), errors.Is(err, services.ErrInvalidEscrow),
+		errors.Is(err, services.ErrInvalidBalanceTime), errors.Is(err, services.ErrInvalidStatement):
+		return CODE_INVALID_PARAMS
+	default:
+		return CODE_INTERNALSERVER
+	}
+}
+
 // handleSuccess
 func handleSuccess(c *gin.Context, data interface{}) {
 	var responseData ResponseData
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"errors"
+	"fmt"
 	"github.com/spf13/cast"
 	"net/http"
 	"testing"
@@ -10,6 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"net/http/httptest"
+	"wallet-service/models"
+	"wallet-service/services"
 )
 
 func TestHandleError(t *testing.T) {
@@ -53,6 +56,28 @@ func TestHandleError(t *testing.T) {
 				"detail":     "invalid params",
 			},
 		},
+		{
+			name:           "Test CODE_IDEMPOTENCY_CONFLICT",
+			errorCode:      CODE_IDEMPOTENCY_CONFLICT,
+			err:            errors.New("idempotency key conflict"),
+			expectedStatus: http.StatusConflict,
+			expectedBody: map[string]interface{}{
+				"error_msg":  "idempotency_key_conflict",
+				"error_code": CODE_IDEMPOTENCY_CONFLICT,
+				"detail":     "idempotency key conflict",
+			},
+		},
+		{
+			name:           "Test CODE_INSUFFICIENT_FUNDS",
+			errorCode:      CODE_INSUFFICIENT_FUNDS,
+			err:            errors.New("insufficient funds balance"),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"error_msg":  "insufficient_funds",
+				"error_code": CODE_INSUFFICIENT_FUNDS,
+				"detail":     "insufficient funds balance",
+			},
+		},
 	}
 
 	// Setup Gin router for testing
@@ -140,6 +165,62 @@ func TestHandleSuccess(t *testing.T) {
 	}
 }
 
+func TestServiceErrorCode(t *testing.T) {
+	assert.Equal(t, CODE_IDEMPOTENCY_CONFLICT, serviceErrorCode(services.ErrIdempotencyKeyConflict))
+	assert.Equal(t, CODE_IDEMPOTENCY_CONFLICT, serviceErrorCode(fmt.Errorf("wrapped: %w", services.ErrIdempotencyKeyConflict)))
+	assert.Equal(t, CODE_INSUFFICIENT_FUNDS, serviceErrorCode(services.ErrInsufficientFunds))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(services.ErrWalletNotFound))
+	assert.Equal(t, CODE_CURRENCY_MISMATCH, serviceErrorCode(services.ErrCurrencyMismatch))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(fmt.Errorf("%w: %q", services.ErrUnsupportedCurrency, "XYZ")))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidAmountPrecision))
+	assert.Equal(t, CODE_FX_QUOTE_UNAVAILABLE, serviceErrorCode(services.ErrFXQuoteUnavailable))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(fmt.Errorf("%w: EUR/USD", services.ErrFXRateNotFound)))
+	assert.Equal(t, CODE_HOLD_NOT_ACTIVE, serviceErrorCode(fmt.Errorf("%w: hold 1 is voided", services.ErrHoldNotActive)))
+	assert.Equal(t, CODE_HOLD_NOT_ACTIVE, serviceErrorCode(services.ErrHoldExpired))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrCaptureExceedsHold))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(services.ErrTransactionNotFound))
+	assert.Equal(t, CODE_REFUND_EXCEEDS, serviceErrorCode(fmt.Errorf("%w: 10 USD remaining", services.ErrRefundExceedsRemaining)))
+	assert.Equal(t, CODE_NOT_REVERSIBLE, serviceErrorCode(fmt.Errorf("%w: exchange_in", services.ErrNotReversible)))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidReversalPolicy))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(services.ErrScheduleNotFound))
+	assert.Equal(t, CODE_SCHEDULE_NOT_ACTIVE, serviceErrorCode(fmt.Errorf("%w: schedule 1 is cancelled", services.ErrScheduleNotActive)))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidSchedule))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidBatch))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidHistoryFilter))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidPageToken))
+	assert.Equal(t, CODE_INVALID_STATUS_TRANSITION, serviceErrorCode(fmt.Errorf("%w: failed -> completed", services.ErrInvalidStatusTransition)))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidTransactionDetails))
+	assert.Equal(t, CODE_DUPLICATE_EXTERNAL_REF, serviceErrorCode(fmt.Errorf("%w: inv-1", services.ErrDuplicateExternalReference)))
+	assert.Equal(t, CODE_WALLET_FROZEN, serviceErrorCode(fmt.Errorf("item 0: %w", services.ErrWalletFrozen)))
+	assert.Equal(t, CODE_WALLET_CLOSED, serviceErrorCode(services.ErrWalletClosed))
+	assert.Equal(t, CODE_WALLET_EXISTS, serviceErrorCode(services.ErrWalletAlreadyExists))
+	assert.Equal(t, CODE_WALLET_NOT_EMPTY, serviceErrorCode(services.ErrWalletNotEmpty))
+	assert.Equal(t, CODE_INVALID_STATUS_TRANSITION, serviceErrorCode(services.ErrInvalidWalletStatusTransition))
+	assert.Equal(t, CODE_LIMIT_EXCEEDED, serviceErrorCode(&services.LimitExceededError{Limit: models.LimitDailyOutflow, Max: "500"}))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidLimits))
+	assert.Equal(t, CODE_CREDIT_LIMIT_IN_USE, serviceErrorCode(fmt.Errorf("%w: 50 in use", services.ErrCreditLimitInUse)))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidCreditLimit))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(fmt.Errorf("%w: 3", services.ErrPocketNotFound)))
+	assert.Equal(t, CODE_POCKET_EXISTS, serviceErrorCode(services.ErrPocketExists))
+	assert.Equal(t, CODE_POCKET_CLOSED, serviceErrorCode(services.ErrPocketClosed))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidPocket))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(services.ErrSharedWalletNotFound))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(fmt.Errorf("%w: 3", services.ErrApprovalNotFound)))
+	assert.Equal(t, CODE_SHARED_WALLET_EXISTS, serviceErrorCode(services.ErrSharedWalletExists))
+	assert.Equal(t, CODE_NOT_PERMITTED, serviceErrorCode(fmt.Errorf("%w: viewer cannot initiate transfers", services.ErrNotPermitted)))
+	assert.Equal(t, CODE_APPROVAL_NOT_PENDING, serviceErrorCode(services.ErrApprovalNotPending))
+	assert.Equal(t, CODE_ALREADY_DECIDED, serviceErrorCode(services.ErrAlreadyDecided))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidSharedWallet))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(fmt.Errorf("%w: 4", services.ErrEscrowNotFound)))
+	assert.Equal(t, CODE_ESCROW_SETTLED, serviceErrorCode(fmt.Errorf("%w: escrow 4 is released", services.ErrEscrowSettled)))
+	assert.Equal(t, CODE_APPROVAL_REQUIRED, serviceErrorCode(fmt.Errorf("%w: amount 800 exceeds the approval threshold 500", services.ErrApprovalRequired)))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidEscrow))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidBalanceTime))
+	assert.Equal(t, CODE_INVALID_PARAMS, serviceErrorCode(services.ErrInvalidStatement))
+	assert.Equal(t, CODE_NOT_FOUND, serviceErrorCode(fmt.Errorf("%w: 5", services.ErrReconciliationNotFound)))
+	assert.Equal(t, CODE_INTERNALSERVER, serviceErrorCode(errors.New("unknown error")))
+}
+
 // Helper function to perform HTTP requests in tests
 func performRequest(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
 	// Create a new request
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+	"wallet-service/pkg/rdsLimit"
+)
+
+// Reserve 预授权冻结
+func (wc *WalletController) Reserve(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+	if !rdsLimit.NewRdsLimit(wc.redis, fmt.Sprintf("Reserve:%d", userID), 1).AllowN(ctx, limitCount) { // 限频
+		handleError(c, CODE_REQUEST_TOO_QUICKLY, errors.New("trigger limit exceeded"))
+		return
+	}
+
+	var request struct {
+		Amount     decimal.Decimal
+		Currency   string
+		TTLSeconds int `json:"ttl_seconds"` // 为 0 时使用默认有效期
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController Reserve BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	if request.TTLSeconds < 0 {
+		handleError(c, CODE_INVALID_PARAMS, errors.New("ttl_seconds must not be negative"))
+		return
+	}
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	hold, err := wc.walletService.Reserve(ctx, userID, request.Amount, parseCurrency(request.Currency),
+		time.Duration(request.TTLSeconds)*time.Second)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController Reserve walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, hold)
+}
+
+// ListHolds 查询钱包的冻结记录
+func (wc *WalletController) ListHolds(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+	if !rdsLimit.NewRdsLimit(wc.redis, fmt.Sprintf("ListHolds:%d", userID), 1).AllowN(ctx, limitCount) { // 限频
+		handleError(c, CODE_REQUEST_TOO_QUICKLY, errors.New("trigger limit exceeded"))
+		return
+	}
+
+	holds, err := wc.walletService.ListHolds(ctx, userID, parseCurrency(c.Query("currency")))
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController ListHolds walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, holds)
+}
+
+// Capture 对冻结扣款, 不传金额时全额扣款
+func (wc *WalletController) Capture(c *gin.Context) {
+	holdID, err := strconv.Atoi(c.Param("hold_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Amount decimal.Decimal
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&request); err != nil {
+			wc.logger.Error(ctx, "WalletController Capture BindJSON",
+				zap.Int("holdID", holdID), zap.Error(err))
+			handleError(c, CODE_INVALID_PARAMS, err)
+			return
+		}
+	}
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	result, err := wc.walletService.Capture(ctx, holdID, request.Amount)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController Capture walletService",
+			zap.Int("holdID", holdID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleTransactionResult(c, "Capture successful", result)
+}
+
+// Void 撤销冻结
+func (wc *WalletController) Void(c *gin.Context) {
+	holdID, err := strconv.Atoi(c.Param("hold_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	hold, err := wc.walletService.Void(ctx, holdID)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController Void walletService",
+			zap.Int("holdID", holdID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, hold)
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_Reserve_Success(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 设置 mock WalletService 的期望行为
+	amount := decimal.NewFromInt(40)
+	mockService.On("Reserve", ctx, 1, amount, models.USD, 10*time.Minute).
+		Return(&models.Hold{ID: 5, UserID: 1, Currency: models.USD, Amount: amount, Status: models.HoldActive}, nil)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.POST("/wallet/:user_id/holds", controller.Reserve)
+
+	req := httptest.NewRequest("POST", "/wallet/1/holds", strings.NewReader(`{"amount": "40", "currency": "USD", "ttl_seconds": 600}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"active"`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_Capture_Partial(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	// 设置 mock WalletService 的期望行为
+	mockService.On("Capture", mock.Anything, 5, decimal.NewFromInt(25)).
+		Return(&models.TransactionResult{TransactionID: 9, HoldID: 5}, nil)
+
+	router := gin.Default()
+	router.POST("/holds/:hold_id/capture", controller.Capture)
+
+	req := httptest.NewRequest("POST", "/holds/5/capture", strings.NewReader(`{"amount": "25"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"transaction_id":9`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_Capture_FullWithoutBody(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	// 不传金额时全额扣款
+	mockService.On("Capture", mock.Anything, 5, decimal.Decimal{}).
+		Return(&models.TransactionResult{TransactionID: 9, HoldID: 5}, nil)
+
+	router := gin.Default()
+	router.POST("/holds/:hold_id/capture", controller.Capture)
+
+	req := httptest.NewRequest("POST", "/holds/5/capture", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_Void_NotActive(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("Void", mock.Anything, 5).Return(nil, fmt.Errorf("%w: hold 5 is captured", services.ErrHoldNotActive))
+
+	router := gin.Default()
+	router.POST("/holds/:hold_id/void", controller.Void)
+
+	req := httptest.NewRequest("POST", "/holds/5/void", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301005`)
+	mockService.AssertExpectations(t)
+}
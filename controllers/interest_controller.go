@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// GetInterest 查询钱包已计提未派发的利息和最近的派息记录
+func (wc *WalletController) GetInterest(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	summary, err := wc.walletService.GetInterest(ctx, userID, parseCurrency(c.Query("currency")))
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController GetInterest walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, summary)
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+func TestWalletController_GetInterest(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("GetInterest", mock.Anything, 1, models.USD).
+		Return(&models.InterestSummary{UserID: 1, Currency: models.USD, Accrued: decimal.RequireFromString("0.054794520547945205"), Payouts: []models.InterestPayout{}}, nil)
+
+	router := gin.Default()
+	router.GET("/wallet/:user_id/interest", controller.GetInterest)
+
+	req := httptest.NewRequest("GET", "/wallet/1/interest?currency=usd", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"accrued":"0.054794520547945205"`)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"strconv"
+	"wallet-service/models"
+)
+
+// GetWalletLimits 查询钱包生效的限额、当前用量和重置时间
+func (wc *WalletController) GetWalletLimits(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	limits, err := wc.walletService.GetWalletLimits(ctx, userID, parseCurrency(c.Query("currency")))
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController GetWalletLimits walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, limits)
+}
+
+// SetWalletLimits 设置钱包的限额等级和单独的限额项(管理接口)
+func (wc *WalletController) SetWalletLimits(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency string
+		models.SpendingLimits
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController SetWalletLimits BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	limits, err := wc.walletService.SetWalletLimits(ctx, userID, parseCurrency(request.Currency), request.SpendingLimits)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController SetWalletLimits walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, limits)
+}
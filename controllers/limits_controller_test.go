@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_SetWalletLimits(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	daily := decimal.NewFromInt(500)
+	transfers := 10
+	limits := models.SpendingLimits{Tier: "premium", DailyOutflow: &daily, MaxDailyTransfers: &transfers}
+	mockService.On("SetWalletLimits", mock.Anything, 1, models.USD, limits).
+		Return(&models.WalletLimits{UserID: 1, Currency: models.USD, Limits: limits}, nil)
+
+	router := gin.Default()
+	router.POST("/admin/wallets/:user_id/limits", controller.SetWalletLimits)
+
+	body := `{"currency": "USD", "tier": "premium", "daily_outflow": "500", "max_daily_transfers": 10}`
+	req := httptest.NewRequest("POST", "/admin/wallets/1/limits", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"daily_outflow":"500"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_GetWalletLimits(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("GetWalletLimits", mock.Anything, 1, models.USD).
+		Return(&models.WalletLimits{UserID: 1, Currency: models.USD, Usage: models.LimitUsage{DailyTransfers: 3}}, nil)
+
+	router := gin.Default()
+	router.GET("/wallet/:user_id/limits", controller.GetWalletLimits)
+
+	req := httptest.NewRequest("GET", "/wallet/1/limits?currency=USD", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"daily_transfers":3`)
+	mockService.AssertExpectations(t)
+}
+
+func TestHandleError_LimitExceeded(t *testing.T) {
+	resetsAt := time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)
+	err := &services.LimitExceededError{Limit: models.LimitDailyOutflow, Max: "200", ResetsAt: &resetsAt}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handleError(c, serviceErrorCode(err), err)
+
+	// 返回超出的限额项和重置时间
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301015`)
+	assert.Contains(t, w.Body.String(), `"limit":"daily_outflow"`)
+	assert.Contains(t, w.Body.String(), `"resets_at":"2024-05-16T00:00:00Z"`)
+}
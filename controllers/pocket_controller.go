@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"wallet-service/models"
+)
+
+// CreatePocket 在钱包下新建 pocket
+func (wc *WalletController) CreatePocket(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency string
+		Name     string
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController CreatePocket BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	pocket, err := wc.walletService.CreatePocket(ctx, userID, parseCurrency(request.Currency), request.Name)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController CreatePocket walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, pocket)
+}
+
+// ListPockets 查询用户未关闭的 pocket, 可按币种过滤
+func (wc *WalletController) ListPockets(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	pockets, err := wc.walletService.ListPockets(ctx, userID, parseCurrency(c.Query("currency")))
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController ListPockets walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{"pockets": pockets})
+}
+
+// MovePocketFunds 在钱包和 pocket 之间划转, pocket id 为空表示钱包本身
+func (wc *WalletController) MovePocketFunds(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency     string
+		FromPocketID *int `json:"from_pocket_id"`
+		ToPocketID   *int `json:"to_pocket_id"`
+		Amount       decimal.Decimal
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController MovePocketFunds BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	result, err := wc.walletService.MovePocketFunds(ctx, userID, models.PocketMove{
+		FromPocketID: request.FromPocketID,
+		ToPocketID:   request.ToPocketID,
+		Amount:       request.Amount,
+		Currency:     parseCurrency(request.Currency),
+	})
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController MovePocketFunds walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleTransactionResult(c, "Pocket transfer successful", result)
+}
+
+// ClosePocket 关闭 pocket, 剩余余额转回钱包
+func (wc *WalletController) ClosePocket(c *gin.Context) {
+	pocketID, err := strconv.Atoi(c.Param("pocket_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	pocket, err := wc.walletService.ClosePocket(ctx, pocketID)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController ClosePocket walletService",
+			zap.Int("pocketID", pocketID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, pocket)
+}
+
+// GetAggregateBalance 按币种汇总钱包和所有 pocket 的余额
+func (wc *WalletController) GetAggregateBalance(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	balances, err := wc.walletService.GetAggregateBalances(ctx, userID)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController GetAggregateBalance walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{"balances": balances})
+}
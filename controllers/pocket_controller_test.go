@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_CreatePocket(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("CreatePocket", mock.Anything, 1, models.USD, "Rent").
+		Return(nil, services.ErrPocketExists)
+
+	router := gin.Default()
+	router.POST("/wallet/:user_id/pockets", controller.CreatePocket)
+
+	req := httptest.NewRequest("POST", "/wallet/1/pockets", strings.NewReader(`{"currency": "usd", "name": "Rent"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301017`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_MovePocketFunds(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	// 未传 from_pocket_id 表示从钱包本身划出
+	pocketID := 5
+	move := models.PocketMove{ToPocketID: &pocketID, Amount: decimal.RequireFromString("30"), Currency: models.USD}
+	mockService.On("MovePocketFunds", mock.Anything, 1, move).
+		Return(&models.TransactionResult{TransactionID: 40}, nil)
+
+	router := gin.Default()
+	router.POST("/wallet/:user_id/pockets/transfer", controller.MovePocketFunds)
+
+	req := httptest.NewRequest("POST", "/wallet/1/pockets/transfer", strings.NewReader(`{"currency": "USD", "to_pocket_id": 5, "amount": "30"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"transaction_id":40`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_GetAggregateBalance(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("GetAggregateBalances", mock.Anything, 1).
+		Return([]models.AggregateBalance{{Currency: models.USD, Pockets: []models.Pocket{}, Total: decimal.RequireFromString("142.5")}}, nil)
+
+	router := gin.Default()
+	router.GET("/wallet/:user_id/balance/aggregate", controller.GetAggregateBalance)
+
+	req := httptest.NewRequest("GET", "/wallet/1/balance/aggregate", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":"142.5"`)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"strconv"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type ReconciliationController struct {
+	reconciliationService services.ReconciliationService
+	logger                *wallet_logger.Logger
+}
+
+// NewReconciliationController new reconciliation controller
+func NewReconciliationController(logger *wallet_logger.Logger, service services.ReconciliationService) *ReconciliationController {
+	return &ReconciliationController{
+		reconciliationService: service,
+		logger:                logger,
+	}
+}
+
+// StartReconciliation 管理接口: 发起一次对账, 在后台执行, 返回对账记录
+func (rc *ReconciliationController) StartReconciliation(c *gin.Context) {
+	ctx := c.Request.Context()
+	var request struct {
+		RepairCache bool `json:"repair_cache"` // 是否删除与数据库不一致的余额缓存
+	}
+	// 请求体可为空, 默认只对账不修复
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&request); err != nil {
+			rc.logger.Error(ctx, "ReconciliationController StartReconciliation BindJSON", zap.Error(err))
+			handleError(c, CODE_INVALID_PARAMS, err)
+			return
+		}
+	}
+
+	run, err := rc.reconciliationService.StartReconciliation(ctx, models.ReconciliationAdmin, request.RepairCache)
+	if err != nil {
+		rc.logger.Error(ctx, "ReconciliationController StartReconciliation reconciliationService", zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, run)
+}
+
+// ListReconciliations 管理接口: 最近的对账记录
+func (rc *ReconciliationController) ListReconciliations(c *gin.Context) {
+	ctx := c.Request.Context()
+	runs, err := rc.reconciliationService.ListReconciliations(ctx)
+	if err != nil {
+		rc.logger.Error(ctx, "ReconciliationController ListReconciliations reconciliationService", zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{"runs": runs})
+}
+
+// GetReconciliation 管理接口: 查询对账记录及发现的不一致
+func (rc *ReconciliationController) GetReconciliation(c *gin.Context) {
+	runID, err := strconv.Atoi(c.Param("run_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	run, err := rc.reconciliationService.GetReconciliation(ctx, runID)
+	if err != nil {
+		rc.logger.Error(ctx, "ReconciliationController GetReconciliation reconciliationService",
+			zap.Int("runID", runID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, run)
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type MockReconciliationService struct {
+	mock.Mock
+}
+
+func (m *MockReconciliationService) Reconcile(ctx context.Context, trigger models.ReconciliationTrigger, repairCache bool) (*models.ReconciliationRun, error) {
+	args := m.Called(ctx, trigger, repairCache)
+	result, _ := args.Get(0).(*models.ReconciliationRun)
+	return result, args.Error(1)
+}
+
+func (m *MockReconciliationService) StartReconciliation(ctx context.Context, trigger models.ReconciliationTrigger, repairCache bool) (*models.ReconciliationRun, error) {
+	args := m.Called(ctx, trigger, repairCache)
+	result, _ := args.Get(0).(*models.ReconciliationRun)
+	return result, args.Error(1)
+}
+
+func (m *MockReconciliationService) ListReconciliations(ctx context.Context) ([]models.ReconciliationRun, error) {
+	args := m.Called(ctx)
+	runs, _ := args.Get(0).([]models.ReconciliationRun)
+	return runs, args.Error(1)
+}
+
+func (m *MockReconciliationService) GetReconciliation(ctx context.Context, runID int) (*models.ReconciliationRun, error) {
+	args := m.Called(ctx, runID)
+	result, _ := args.Get(0).(*models.ReconciliationRun)
+	return result, args.Error(1)
+}
+
+func TestReconciliationController_StartReconciliation(t *testing.T) {
+	mockService := new(MockReconciliationService)
+	controller := NewReconciliationController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("StartReconciliation", mock.Anything, models.ReconciliationAdmin, true).
+		Return(&models.ReconciliationRun{ID: 7, Trigger: models.ReconciliationAdmin, RepairCache: true, Status: models.ReconciliationRunning}, nil)
+	mockService.On("StartReconciliation", mock.Anything, models.ReconciliationAdmin, false).
+		Return(&models.ReconciliationRun{ID: 8, Trigger: models.ReconciliationAdmin, Status: models.ReconciliationRunning}, nil)
+
+	router := gin.Default()
+	router.POST("/admin/reconciliations", controller.StartReconciliation)
+
+	req := httptest.NewRequest("POST", "/admin/reconciliations", strings.NewReader(`{"repair_cache": true}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":7`)
+	assert.Contains(t, w.Body.String(), `"status":"running"`)
+
+	// 请求体为空时只对账不修复
+	req = httptest.NewRequest("POST", "/admin/reconciliations", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":8`)
+	mockService.AssertExpectations(t)
+}
+
+func TestReconciliationController_GetReconciliation(t *testing.T) {
+	mockService := new(MockReconciliationService)
+	controller := NewReconciliationController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("GetReconciliation", mock.Anything, 7).
+		Return(&models.ReconciliationRun{ID: 7, Status: models.ReconciliationCompleted, DriftCount: 1,
+			Drifts: []models.ReconciliationDrift{{RunID: 7, UserID: 2, Currency: models.USD, Kind: models.DriftCache}}}, nil)
+	mockService.On("GetReconciliation", mock.Anything, 8).Return(nil, services.ErrReconciliationNotFound)
+
+	router := gin.Default()
+	router.GET("/admin/reconciliations/:run_id", controller.GetReconciliation)
+
+	req := httptest.NewRequest("GET", "/admin/reconciliations/7", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"drift_count":1`)
+	assert.Contains(t, w.Body.String(), `"kind":"cache"`)
+
+	req = httptest.NewRequest("GET", "/admin/reconciliations/8", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":100001`)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type ScheduleController struct {
+	scheduleService services.ScheduleService
+	logger          *wallet_logger.Logger
+}
+
+// NewScheduleController new schedule controller
+func NewScheduleController(logger *wallet_logger.Logger, service services.ScheduleService) *ScheduleController {
+	return &ScheduleController{
+		scheduleService: service,
+		logger:          logger,
+	}
+}
+
+// CreateSchedule 创建定时/周期转账
+func (sc *ScheduleController) CreateSchedule(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		ReceiverID int             `json:"receiver_id"`
+		Amount     decimal.Decimal `json:"amount"`
+		Currency   string          `json:"currency"`
+		Frequency  string          `json:"frequency"` // once/daily/weekly/monthly
+		StartAt    *time.Time      `json:"start_at"`  // 为空表示立即开始
+		EndAt      *time.Time      `json:"end_at"`    // 为空表示不限结束时间
+	}
+	if err := c.BindJSON(&request); err != nil {
+		sc.logger.Error(ctx, "ScheduleController CreateSchedule BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	schedule := models.TransferSchedule{
+		SenderUserID:   userID,
+		ReceiverUserID: request.ReceiverID,
+		Amount:         request.Amount,
+		Currency:       parseCurrency(request.Currency),
+		Frequency:      models.ScheduleFrequency(request.Frequency),
+		EndAt:          request.EndAt,
+	}
+	if request.StartAt != nil {
+		schedule.StartAt = *request.StartAt
+	}
+	created, err := sc.scheduleService.CreateSchedule(ctx, schedule)
+	if err != nil {
+		sc.logger.Error(ctx, "ScheduleController CreateSchedule scheduleService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, created)
+}
+
+// ListSchedules 查询用户的定时转账
+func (sc *ScheduleController) ListSchedules(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	schedules, err := sc.scheduleService.ListSchedules(ctx, userID)
+	if err != nil {
+		sc.logger.Error(ctx, "ScheduleController ListSchedules scheduleService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, schedules)
+}
+
+// CancelSchedule 取消定时转账
+func (sc *ScheduleController) CancelSchedule(c *gin.Context) {
+	scheduleID, err := strconv.Atoi(c.Param("schedule_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	schedule, err := sc.scheduleService.CancelSchedule(ctx, scheduleID)
+	if err != nil {
+		sc.logger.Error(ctx, "ScheduleController CancelSchedule scheduleService",
+			zap.Int("scheduleID", scheduleID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, schedule)
+}
+
+// ListExecutions 查询定时转账的执行记录
+func (sc *ScheduleController) ListExecutions(c *gin.Context) {
+	scheduleID, err := strconv.Atoi(c.Param("schedule_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	executions, err := sc.scheduleService.ListExecutions(ctx, scheduleID)
+	if err != nil {
+		sc.logger.Error(ctx, "ScheduleController ListExecutions scheduleService",
+			zap.Int("scheduleID", scheduleID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, executions)
+}
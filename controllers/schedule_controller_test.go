@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type MockScheduleService struct {
+	mock.Mock
+}
+
+func (m *MockScheduleService) CreateSchedule(ctx context.Context, schedule models.TransferSchedule) (*models.TransferSchedule, error) {
+	args := m.Called(ctx, schedule)
+	result, _ := args.Get(0).(*models.TransferSchedule)
+	return result, args.Error(1)
+}
+
+func (m *MockScheduleService) ListSchedules(ctx context.Context, userID int) ([]models.TransferSchedule, error) {
+	args := m.Called(ctx, userID)
+	schedules, _ := args.Get(0).([]models.TransferSchedule)
+	return schedules, args.Error(1)
+}
+
+func (m *MockScheduleService) CancelSchedule(ctx context.Context, scheduleID int) (*models.TransferSchedule, error) {
+	args := m.Called(ctx, scheduleID)
+	result, _ := args.Get(0).(*models.TransferSchedule)
+	return result, args.Error(1)
+}
+
+func (m *MockScheduleService) ListExecutions(ctx context.Context, scheduleID int) ([]models.ScheduleExecution, error) {
+	args := m.Called(ctx, scheduleID)
+	executions, _ := args.Get(0).([]models.ScheduleExecution)
+	return executions, args.Error(1)
+}
+
+func TestScheduleController_CreateSchedule_Success(t *testing.T) {
+	mockService := new(MockScheduleService)
+	controller := NewScheduleController(wallet_logger.NewLogger(), mockService)
+
+	// 每月 1 日向用户 42 转账 100 USD
+	start := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	mockService.On("CreateSchedule", mock.Anything, models.TransferSchedule{
+		SenderUserID:   1,
+		ReceiverUserID: 42,
+		Amount:         decimal.RequireFromString("100"),
+		Currency:       models.USD,
+		Frequency:      models.ScheduleMonthly,
+		StartAt:        start,
+	}).Return(&models.TransferSchedule{ID: 3, SenderUserID: 1, ReceiverUserID: 42, Status: models.ScheduleActive, NextRunAt: &start}, nil)
+
+	router := gin.Default()
+	router.POST("/wallet/:user_id/schedules", controller.CreateSchedule)
+
+	req := httptest.NewRequest("POST", "/wallet/1/schedules",
+		strings.NewReader(`{"receiver_id": 42, "amount": "100", "currency": "usd", "frequency": "monthly", "start_at": "2026-11-01T00:00:00Z"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"next_run_at":"2026-11-01T00:00:00Z"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestScheduleController_CancelSchedule_NotActive(t *testing.T) {
+	mockService := new(MockScheduleService)
+	controller := NewScheduleController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("CancelSchedule", mock.Anything, 3).Return(nil, services.ErrScheduleNotActive)
+
+	router := gin.Default()
+	router.POST("/schedules/:schedule_id/cancel", controller.CancelSchedule)
+
+	req := httptest.NewRequest("POST", "/schedules/3/cancel", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301008`)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type SharedWalletController struct {
+	sharedWalletService services.SharedWalletService
+	logger              *wallet_logger.Logger
+}
+
+// NewSharedWalletController new shared wallet controller
+func NewSharedWalletController(logger *wallet_logger.Logger, service services.SharedWalletService) *SharedWalletController {
+	return &SharedWalletController{
+		sharedWalletService: service,
+		logger:              logger,
+	}
+}
+
+// CreateSharedWallet 把已有钱包设为共享钱包
+func (sc *SharedWalletController) CreateSharedWallet(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var request struct {
+		UserID             int             `json:"user_id"`
+		Currency           string          `json:"currency"`
+		OwnerID            int             `json:"owner_id"`
+		RequiredApprovals  int             `json:"required_approvals"`
+		ApprovalThreshold  decimal.Decimal `json:"approval_threshold"`
+		ApprovalTTLSeconds int             `json:"approval_ttl_seconds"` // 为 0 时使用默认的 24 小时
+	}
+	if err := c.BindJSON(&request); err != nil {
+		sc.logger.Error(ctx, "SharedWalletController CreateSharedWallet BindJSON", zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	wallet, err := sc.sharedWalletService.CreateSharedWallet(ctx, models.SharedWallet{
+		UserID:             request.UserID,
+		Currency:           parseCurrency(request.Currency),
+		RequiredApprovals:  request.RequiredApprovals,
+		ApprovalThreshold:  request.ApprovalThreshold,
+		ApprovalTTLSeconds: request.ApprovalTTLSeconds,
+	}, request.OwnerID)
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController CreateSharedWallet sharedWalletService",
+			zap.Int("userID", request.UserID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, wallet)
+}
+
+// ListMembers 查询共享钱包的成员
+func (sc *SharedWalletController) ListMembers(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	actorID, err := strconv.Atoi(c.Query("actor_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	members, err := sc.sharedWalletService.ListMembers(ctx, userID, parseCurrency(c.Query("currency")), actorID)
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController ListMembers sharedWalletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{"members": members})
+}
+
+// SetMember 添加成员或修改成员角色
+func (sc *SharedWalletController) SetMember(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency string `json:"currency"`
+		ActorID  int    `json:"actor_id"`
+		MemberID int    `json:"member_id"`
+		Role     string `json:"role"` // owner/spender/viewer
+	}
+	if err := c.BindJSON(&request); err != nil {
+		sc.logger.Error(ctx, "SharedWalletController SetMember BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	member, err := sc.sharedWalletService.SetMember(ctx, userID, parseCurrency(request.Currency), request.ActorID, request.MemberID, models.MemberRole(request.Role))
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController SetMember sharedWalletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, member)
+}
+
+// RemoveMember 移除成员
+func (sc *SharedWalletController) RemoveMember(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency string `json:"currency"`
+		ActorID  int    `json:"actor_id"`
+		MemberID int    `json:"member_id"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		sc.logger.Error(ctx, "SharedWalletController RemoveMember BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	err = sc.sharedWalletService.RemoveMember(ctx, userID, parseCurrency(request.Currency), request.ActorID, request.MemberID)
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController RemoveMember sharedWalletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{"status": "Member removed"})
+}
+
+// RequestTransfer 成员从共享钱包发起转账, 超过阈值时返回待审批的请求
+func (sc *SharedWalletController) RequestTransfer(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx, err := withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	var request struct {
+		Currency   string          `json:"currency"`
+		ActorID    int             `json:"actor_id"`
+		ReceiverID int             `json:"receiver_id"`
+		Amount     decimal.Decimal `json:"amount"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		sc.logger.Error(ctx, "SharedWalletController RequestTransfer BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	result, err := sc.sharedWalletService.RequestTransfer(ctx, userID, parseCurrency(request.Currency), request.ActorID, request.ReceiverID, request.Amount)
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController RequestTransfer sharedWalletService",
+			zap.Int("userID", userID), zap.Int("actorID", request.ActorID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	if result.Transaction != nil {
+		handleTransactionResult(c, "Transfer successful", result.Transaction)
+		return
+	}
+	handleSuccess(c, result)
+}
+
+// ListApprovals 查询共享钱包的审批请求, 可按状态过滤
+func (sc *SharedWalletController) ListApprovals(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	actorID, err := strconv.Atoi(c.Query("actor_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	approvals, err := sc.sharedWalletService.ListApprovals(ctx, userID, parseCurrency(c.Query("currency")), actorID, models.ApprovalStatus(c.Query("status")))
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController ListApprovals sharedWalletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, gin.H{"approvals": approvals})
+}
+
+// GetApproval 查询审批请求及成员的决定
+func (sc *SharedWalletController) GetApproval(c *gin.Context) {
+	approvalID, err := strconv.Atoi(c.Param("approval_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	approval, err := sc.sharedWalletService.GetApproval(ctx, approvalID)
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController GetApproval sharedWalletService",
+			zap.Int("approvalID", approvalID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, approval)
+}
+
+// Approve 批准审批请求
+func (sc *SharedWalletController) Approve(c *gin.Context) {
+	sc.decide(c, models.DecisionApprove)
+}
+
+// Reject 拒绝审批请求
+func (sc *SharedWalletController) Reject(c *gin.Context) {
+	sc.decide(c, models.DecisionReject)
+}
+
+func (sc *SharedWalletController) decide(c *gin.Context, decision models.Decision) {
+	approvalID, err := strconv.Atoi(c.Param("approval_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		ActorID int    `json:"actor_id"`
+		Reason  string `json:"reason"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		sc.logger.Error(ctx, "SharedWalletController decide BindJSON",
+			zap.Int("approvalID", approvalID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	approval, err := sc.sharedWalletService.DecideApproval(ctx, approvalID, request.ActorID, decision, request.Reason)
+	if err != nil {
+		sc.logger.Error(ctx, "SharedWalletController decide sharedWalletService",
+			zap.Int("approvalID", approvalID), zap.Int("actorID", request.ActorID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, approval)
+}
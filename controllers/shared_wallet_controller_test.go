@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+type MockSharedWalletService struct {
+	mock.Mock
+}
+
+func (m *MockSharedWalletService) CreateSharedWallet(ctx context.Context, wallet models.SharedWallet, ownerID int) (*models.SharedWallet, error) {
+	args := m.Called(ctx, wallet, ownerID)
+	result, _ := args.Get(0).(*models.SharedWallet)
+	return result, args.Error(1)
+}
+
+func (m *MockSharedWalletService) ListMembers(ctx context.Context, userID int, currency models.Currency, actorID int) ([]models.WalletMember, error) {
+	args := m.Called(ctx, userID, currency, actorID)
+	members, _ := args.Get(0).([]models.WalletMember)
+	return members, args.Error(1)
+}
+
+func (m *MockSharedWalletService) SetMember(ctx context.Context, userID int, currency models.Currency, actorID, memberID int, role models.MemberRole) (*models.WalletMember, error) {
+	args := m.Called(ctx, userID, currency, actorID, memberID, role)
+	result, _ := args.Get(0).(*models.WalletMember)
+	return result, args.Error(1)
+}
+
+func (m *MockSharedWalletService) RemoveMember(ctx context.Context, userID int, currency models.Currency, actorID, memberID int) error {
+	args := m.Called(ctx, userID, currency, actorID, memberID)
+	return args.Error(0)
+}
+
+func (m *MockSharedWalletService) RequestTransfer(ctx context.Context, userID int, currency models.Currency, actorID, receiverID int, amount decimal.Decimal) (*models.SharedTransferResult, error) {
+	args := m.Called(ctx, userID, currency, actorID, receiverID, amount)
+	result, _ := args.Get(0).(*models.SharedTransferResult)
+	return result, args.Error(1)
+}
+
+func (m *MockSharedWalletService) ListApprovals(ctx context.Context, userID int, currency models.Currency, actorID int, status models.ApprovalStatus) ([]models.TransferApproval, error) {
+	args := m.Called(ctx, userID, currency, actorID, status)
+	approvals, _ := args.Get(0).([]models.TransferApproval)
+	return approvals, args.Error(1)
+}
+
+func (m *MockSharedWalletService) GetApproval(ctx context.Context, approvalID int) (*models.TransferApproval, error) {
+	args := m.Called(ctx, approvalID)
+	result, _ := args.Get(0).(*models.TransferApproval)
+	return result, args.Error(1)
+}
+
+func (m *MockSharedWalletService) DecideApproval(ctx context.Context, approvalID, actorID int, decision models.Decision, reason string) (*models.TransferApproval, error) {
+	args := m.Called(ctx, approvalID, actorID, decision, reason)
+	result, _ := args.Get(0).(*models.TransferApproval)
+	return result, args.Error(1)
+}
+
+func TestSharedWalletController_RequestTransfer_PendingApproval(t *testing.T) {
+	mockService := new(MockSharedWalletService)
+	controller := NewSharedWalletController(wallet_logger.NewLogger(), mockService)
+
+	// 超过阈值, 返回待审批的请求
+	mockService.On("RequestTransfer", mock.Anything, 100, models.USD, 7, 3, decimal.RequireFromString("80")).
+		Return(&models.SharedTransferResult{Approval: &models.TransferApproval{
+			ID: 9, UserID: 100, RequiredApprovals: 2, Approvals: 1, Status: models.ApprovalPending,
+		}}, nil)
+
+	router := gin.Default()
+	router.POST("/shared-wallets/:user_id/transfers", controller.RequestTransfer)
+
+	req := httptest.NewRequest("POST", "/shared-wallets/100/transfers",
+		strings.NewReader(`{"currency": "usd", "actor_id": 7, "receiver_id": 3, "amount": "80"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"approval":{"id":9`)
+	assert.Contains(t, w.Body.String(), `"status":"pending"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestSharedWalletController_Approve_NotPermitted(t *testing.T) {
+	mockService := new(MockSharedWalletService)
+	controller := NewSharedWalletController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("DecideApproval", mock.Anything, 9, 8, models.DecisionApprove, "").
+		Return(nil, services.ErrNotPermitted)
+
+	router := gin.Default()
+	router.POST("/approvals/:approval_id/approve", controller.Approve)
+
+	req := httptest.NewRequest("POST", "/approvals/9/approve", strings.NewReader(`{"actor_id": 8}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 403, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301020`)
+	mockService.AssertExpectations(t)
+}
+
+func TestSharedWalletController_Reject_AlreadyDecided(t *testing.T) {
+	mockService := new(MockSharedWalletService)
+	controller := NewSharedWalletController(wallet_logger.NewLogger(), mockService)
+
+	mockService.On("DecideApproval", mock.Anything, 9, 5, models.DecisionReject, "too much").
+		Return(nil, services.ErrAlreadyDecided)
+
+	router := gin.Default()
+	router.POST("/approvals/:approval_id/reject", controller.Reject)
+
+	req := httptest.NewRequest("POST", "/approvals/9/reject", strings.NewReader(`{"actor_id": 5, "reason": "too much"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301022`)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wallet-service/models"
+	"wallet-service/services"
+)
+
+// GetStatement 查询 [from, to) 期间的对账单, from/to 为 RFC3339; format 为 json(默认)、csv 或 html, csv/html 以附件下载
+func (wc *WalletController) GetStatement(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	format := models.StatementFormat(strings.ToLower(c.DefaultQuery("format", string(models.StatementJSON))))
+	if !format.Valid() {
+		handleError(c, CODE_INVALID_PARAMS, fmt.Errorf("unsupported format %q", format))
+		return
+	}
+	if c.Query("from") == "" || c.Query("to") == "" {
+		handleError(c, CODE_INVALID_PARAMS, errors.New("from and to are required"))
+		return
+	}
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, fmt.Errorf("invalid from: %w", err))
+		return
+	}
+	to, err := time.Parse(time.RFC3339, c.Query("to"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, fmt.Errorf("invalid to: %w", err))
+		return
+	}
+
+	statement, err := wc.walletService.GetStatement(ctx, userID, parseCurrency(c.Query("currency")), from, to)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController GetStatement walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+	switch format {
+	case models.StatementCSV:
+		contentType = "text/csv; charset=utf-8"
+		err = services.WriteStatementCSV(&buf, statement)
+	case models.StatementHTML:
+		contentType = "text/html; charset=utf-8"
+		err = services.WriteStatementHTML(&buf, statement)
+	default:
+		handleSuccess(c, statement)
+		return
+	}
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController GetStatement export",
+			zap.Int("userID", userID), zap.String("format", string(format)), zap.Error(err))
+		handleError(c, CODE_INTERNALSERVER, err)
+		return
+	}
+	filename := fmt.Sprintf("statement-%d-%s-%s-%s.%s", userID, statement.Currency,
+		statement.From.Format("20060102"), statement.To.Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_GetStatement_CSV(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	mockService.On("GetStatement", mock.Anything, 1, models.USD, from, to).Return(&models.Statement{
+		UserID: 1, Currency: models.USD, From: from, To: to,
+		OpeningBalance: decimal.NewFromInt(500), ClosingBalance: decimal.NewFromInt(500),
+	}, nil)
+
+	router := gin.Default()
+	router.GET("/wallet/:user_id/statements", controller.GetStatement)
+
+	req := httptest.NewRequest("GET", "/wallet/1/statements?currency=usd&from=2024-03-01T00:00:00Z&to=2024-04-01T00:00:00Z&format=csv", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="statement-1-USD-20240301-20240401.csv"`, w.Header().Get("Content-Disposition"))
+	assert.Contains(t, w.Body.String(), "opening balance,,,500")
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_GetStatement_InvalidPeriod(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	mockService.On("GetStatement", mock.Anything, 1, models.USD, from, from).Return(nil, services.ErrInvalidStatement)
+
+	router := gin.Default()
+	router.GET("/wallet/:user_id/statements", controller.GetStatement)
+
+	// 缺少 to
+	req := httptest.NewRequest("GET", "/wallet/1/statements?currency=USD&from=2024-03-01T00:00:00Z", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	req = httptest.NewRequest("GET", "/wallet/1/statements?currency=USD&from=2024-03-01T00:00:00Z&to=2024-03-01T00:00:00Z&format=pdf", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	req = httptest.NewRequest("GET", "/wallet/1/statements?currency=USD&from=2024-03-01T00:00:00Z&to=2024-03-01T00:00:00Z", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":100004`)
+	mockService.AssertExpectations(t)
+}
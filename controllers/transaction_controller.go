@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"wallet-service/models"
+)
+
+// ReverseTransaction 冲正/退款, 不传金额时退还剩余全部金额
+func (wc *WalletController) ReverseTransaction(c *gin.Context) {
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Amount decimal.Decimal
+		Policy models.ReversalPolicy // 对方余额不足时 reject 拒绝 / queue 排队, 为空时使用配置
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&request); err != nil {
+			wc.logger.Error(ctx, "WalletController ReverseTransaction BindJSON",
+				zap.Int("transactionID", transactionID), zap.Error(err))
+			handleError(c, CODE_INVALID_PARAMS, err)
+			return
+		}
+	}
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	result, err := wc.walletService.Reverse(ctx, transactionID, request.Amount, request.Policy)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController ReverseTransaction walletService",
+			zap.Int("transactionID", transactionID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	status := "Reversal successful"
+	if result.PendingReversalID != 0 {
+		status = "Reversal queued"
+	}
+	handleTransactionResult(c, status, result)
+}
+
+// GetTransaction 查询单笔交易及其状态
+func (wc *WalletController) GetTransaction(c *gin.Context) {
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+	transaction, err := wc.walletService.GetTransaction(ctx, transactionID)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController GetTransaction walletService",
+			zap.Int("transactionID", transactionID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, transaction)
+}
+
+// RequestPayout 发起外部出款, 交易为 pending 状态, 金额被冻结直到完成或失败
+func (wc *WalletController) RequestPayout(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Amount   decimal.Decimal
+		Currency string
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController RequestPayout BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	result, err := wc.walletService.RequestPayout(ctx, userID, request.Amount, parseCurrency(request.Currency))
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController RequestPayout walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleTransactionResult(c, "Payout pending", result)
+}
+
+// CompleteTransaction 处理中的交易完成
+func (wc *WalletController) CompleteTransaction(c *gin.Context) {
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+	transaction, err := wc.walletService.CompleteTransaction(ctx, transactionID)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController CompleteTransaction walletService",
+			zap.Int("transactionID", transactionID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, transaction)
+}
+
+// FailTransaction 处理中的交易失败, 释放冻结金额
+func (wc *WalletController) FailTransaction(c *gin.Context) {
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Reason string
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&request); err != nil {
+			wc.logger.Error(ctx, "WalletController FailTransaction BindJSON",
+				zap.Int("transactionID", transactionID), zap.Error(err))
+			handleError(c, CODE_INVALID_PARAMS, err)
+			return
+		}
+	}
+	transaction, err := wc.walletService.FailTransaction(ctx, transactionID, request.Reason)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController FailTransaction walletService",
+			zap.Int("transactionID", transactionID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, transaction)
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_ReverseTransaction_Partial(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	// 设置 mock WalletService 的期望行为
+	mockService.On("Reverse", mock.Anything, 7, decimal.NewFromInt(30), models.ReversalPolicyReject).
+		Return(&models.TransactionResult{TransactionID: 12}, nil)
+
+	router := gin.Default()
+	router.POST("/transactions/:id/reverse", controller.ReverseTransaction)
+
+	req := httptest.NewRequest("POST", "/transactions/7/reverse", strings.NewReader(`{"amount": "30", "policy": "reject"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"transaction_id":12`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_ReverseTransaction_Queued(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	// 不传请求体: 全额退款, 使用配置的默认策略
+	mockService.On("Reverse", mock.Anything, 7, decimal.Decimal{}, models.ReversalPolicy("")).
+		Return(&models.TransactionResult{PendingReversalID: 3}, nil)
+
+	router := gin.Default()
+	router.POST("/transactions/:id/reverse", controller.ReverseTransaction)
+
+	req := httptest.NewRequest("POST", "/transactions/7/reverse", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"pending_reversal_id":3`)
+	assert.Contains(t, w.Body.String(), "Reversal queued")
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_ReverseTransaction_ExceedsRemaining(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("Reverse", mock.Anything, 7, decimal.NewFromInt(500), models.ReversalPolicy("")).
+		Return(nil, services.ErrRefundExceedsRemaining)
+
+	router := gin.Default()
+	router.POST("/transactions/:id/reverse", controller.ReverseTransaction)
+
+	req := httptest.NewRequest("POST", "/transactions/7/reverse", strings.NewReader(`{"amount": "500"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301006`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_GetTransaction(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	// 设置 mock WalletService 的期望行为
+	mockService.On("GetTransaction", mock.Anything, 15).
+		Return(&models.Transaction{ID: 15, SenderUserID: 1, ReceiverUserID: 1, TransactionType: models.WithdrawTransactionType,
+			Amount: decimal.NewFromInt(40), Currency: models.USD, Status: models.TransactionPending}, nil)
+	mockService.On("GetTransaction", mock.Anything, 16).Return(nil, services.ErrTransactionNotFound)
+
+	router := gin.Default()
+	router.GET("/transactions/:id", controller.GetTransaction)
+
+	req := httptest.NewRequest("GET", "/transactions/15", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"Status":"pending"`)
+
+	req = httptest.NewRequest("GET", "/transactions/16", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), `"error_code":100001`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_RequestPayout(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("RequestPayout", mock.Anything, 1, decimal.NewFromInt(40), models.USD).
+		Return(&models.TransactionResult{TransactionID: 15}, nil)
+
+	router := gin.Default()
+	router.POST("/wallet/:user_id/payouts", controller.RequestPayout)
+
+	req := httptest.NewRequest("POST", "/wallet/1/payouts", strings.NewReader(`{"amount": "40", "currency": "usd"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"transaction_id":15`)
+	assert.Contains(t, w.Body.String(), "Payout pending")
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_CompleteTransaction_InvalidTransition(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("CompleteTransaction", mock.Anything, 15).
+		Return(nil, services.ErrInvalidStatusTransition)
+
+	router := gin.Default()
+	router.POST("/transactions/:id/complete", controller.CompleteTransaction)
+
+	req := httptest.NewRequest("POST", "/transactions/15/complete", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301009`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_FailTransaction(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("FailTransaction", mock.Anything, 15, "bank rejected").
+		Return(&models.Transaction{ID: 15, Status: models.TransactionFailed}, nil)
+
+	router := gin.Default()
+	router.POST("/transactions/:id/fail", controller.FailTransaction)
+
+	req := httptest.NewRequest("POST", "/transactions/15/fail", strings.NewReader(`{"reason": "bank rejected"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"Status":"failed"`)
+	mockService.AssertExpectations(t)
+}
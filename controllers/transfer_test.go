@@ -12,6 +12,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"wallet-service/models"
 	wallet_logger "wallet-service/pkg/logger"
 )
 
@@ -39,14 +40,14 @@ func TestWalletController_Transfer_Success(t *testing.T) {
 	amount := decimal.NewFromFloat(30.0).Round(0)
 
 	// 设置 mock WalletService 的期望行为
-	mockService.On("Transfer", ctx, senderID, receiverID, amount).Return(nil)
+	mockService.On("Transfer", ctx, senderID, receiverID, amount, models.USD, models.TransactionDetails{}).Return(&models.TransactionResult{TransactionID: 1}, nil)
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.POST("/wallet/transfer/:sender_id/to/:receiver_id", controller.Transfer)
 
 	// 模拟请求，传递有效的 sender_id 和 receiver_id
-	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/transfer/%d/to/%d", senderID, receiverID), strings.NewReader(fmt.Sprintf(`{"amount": "%s"}`, amount.String())))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/transfer/%d/to/%d", senderID, receiverID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -83,14 +84,14 @@ func TestWalletController_Transfer_Failed(t *testing.T) {
 	amount := decimal.NewFromFloat(30.0).Round(0)
 
 	// 设置 mock WalletService 的期望行为，模拟转账失败
-	mockService.On("Transfer", ctx, senderID, receiverID, amount).Return(errors.New("internal server error"))
+	mockService.On("Transfer", ctx, senderID, receiverID, amount, models.USD, models.TransactionDetails{}).Return(nil, errors.New("internal server error"))
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.POST("/wallet/transfer/:sender_id/to/:receiver_id", controller.Transfer)
 
 	// 模拟请求，传递有效的 sender_id 和 receiver_id
-	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/transfer/%d/to/%d", senderID, receiverID), strings.NewReader(fmt.Sprintf(`{"amount": "%s"}`, amount.String())))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/transfer/%d/to/%d", senderID, receiverID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -138,3 +139,86 @@ func TestWalletController_Transfer_InvalidParams(t *testing.T) {
 	// 验证方法调用
 	mockService.AssertExpectations(t)
 }
+
+func TestWalletController_Transfer_CurrencyMismatch(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.POST("/wallet/transfer/:sender_id/to/:receiver_id", controller.Transfer)
+
+	// 模拟请求，收款方币种与付款币种不同且没有换汇
+	req := httptest.NewRequest("POST", "/wallet/transfer/1/to/2", strings.NewReader(`{"amount": "30", "currency": "USD", "receiver_currency": "EUR"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301003`) // 币种不一致
+
+	// 验证方法调用: 不应调用 Transfer
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_Transfer_WithQuote(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 设置 mock WalletService 的期望行为: 携带报价时走换汇转账
+	amount := decimal.NewFromInt(100)
+	mockService.On("ExchangeTransfer", ctx, 1, 2, amount, models.EUR, "q-1", models.TransactionDetails{Memo: "tuition"}).Return(&models.TransactionResult{
+		TransactionID: 10,
+		Exchange: &models.ExchangeResult{
+			TransactionID: 11,
+			Currency:      models.USD,
+			Amount:        decimal.RequireFromString("108.9"),
+			Rate:          decimal.RequireFromString("1.089"),
+		},
+	}, nil)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.POST("/wallet/transfer/:sender_id/to/:receiver_id", controller.Transfer)
+
+	req := httptest.NewRequest("POST", "/wallet/transfer/1/to/2", strings.NewReader(`{"amount": "100", "currency": "EUR", "receiver_currency": "USD", "quote_id": "q-1", "memo": "tuition"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"exchange":{"transaction_id":11,"currency":"USD","amount":"108.9","rate":"1.089"}`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
@@ -9,6 +10,8 @@ import (
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"strconv"
+	"strings"
+	"time"
 	"wallet-service/models"
 	wallet_logger "wallet-service/pkg/logger"
 	"wallet-service/pkg/rdsLimit"
@@ -17,6 +20,12 @@ import (
 
 const limitCount = 100
 
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
 type WalletController struct {
 	walletService services.WalletService
 	redis         *redis.Client
@@ -32,6 +41,38 @@ func NewWalletController(logger *wallet_logger.Logger, redis *redis.Client, serv
 	}
 }
 
+// withIdempotencyKey 读取请求头中的幂等键并放入 ctx
+func withIdempotencyKey(c *gin.Context) (context.Context, error) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, fmt.Errorf("%s must not exceed %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+	}
+	return services.WithIdempotencyKey(c.Request.Context(), key), nil
+}
+
+// handleTransactionResult 返回资金操作结果, 幂等重放时附加响应头
+func handleTransactionResult(c *gin.Context, status string, result *models.TransactionResult) {
+	if result.Replayed {
+		c.Header(idempotentReplayedHeader, "true")
+	}
+	data := gin.H{"status": status, "transaction_id": result.TransactionID}
+	if result.Exchange != nil {
+		data["exchange"] = result.Exchange
+	}
+	if result.PendingReversalID != 0 {
+		data["pending_reversal_id"] = result.PendingReversalID
+	}
+	if result.Fee != nil {
+		data["fee"] = result.Fee
+	}
+	handleSuccess(c, data)
+}
+
+// parseCurrency 规范化请求中的币种代码, 校验由 service 层完成
+func parseCurrency(code string) models.Currency {
+	return models.Currency(strings.ToUpper(strings.TrimSpace(code)))
+}
+
 func generateTraceID() string {
 	// 生成一个新的 UUID 作为 Trace ID
 	traceID := uuid.New()
@@ -55,20 +96,30 @@ func (wc *WalletController) Deposit(c *gin.Context) {
 		handleError(c, CODE_REQUEST_TOO_QUICKLY, errors.New("trigger limit exceeded"))
 		return
 	}
-	var request struct{ Amount decimal.Decimal }
+	var request struct {
+		Amount   decimal.Decimal
+		Currency string
+		models.TransactionDetails
+	}
 	if err := c.BindJSON(&request); err != nil {
 		wc.logger.Error(ctx, "WalletController Deposit BindJSON",
 			zap.Int("userID", userID), zap.Error(err))
 		handleError(c, CODE_INVALID_PARAMS, err)
 		return
 	}
-	if err := wc.walletService.Deposit(ctx, userID, userID, request.Amount, models.DepositTransactionType); err != nil {
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	result, err := wc.walletService.Deposit(ctx, userID, userID, request.Amount, parseCurrency(request.Currency), models.DepositTransactionType, request.TransactionDetails)
+	if err != nil {
 		wc.logger.Error(ctx, "WalletController Deposit walletService",
 			zap.Int("userID", userID), zap.Error(err))
-		handleError(c, CODE_INTERNALSERVER, err)
+		handleError(c, serviceErrorCode(err), err)
 		return
 	}
-	handleSuccess(c, gin.H{"status": "Deposit successful"})
+	handleTransactionResult(c, "Deposit successful", result)
 }
 
 // Withdraw 取款
@@ -85,20 +136,30 @@ func (wc *WalletController) Withdraw(c *gin.Context) {
 		return
 	}
 
-	var request struct{ Amount decimal.Decimal }
+	var request struct {
+		Amount   decimal.Decimal
+		Currency string
+		models.TransactionDetails
+	}
 	if err := c.BindJSON(&request); err != nil {
 		wc.logger.Error(ctx, "WalletController Withdraw BindJSON",
 			zap.Int("userID", userID), zap.Error(err))
 		handleError(c, CODE_INVALID_PARAMS, err)
 		return
 	}
-	if err := wc.walletService.Withdraw(ctx, userID, userID, request.Amount, models.WithdrawTransactionType); err != nil {
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	result, err := wc.walletService.Withdraw(ctx, userID, userID, request.Amount, parseCurrency(request.Currency), models.WithdrawTransactionType, request.TransactionDetails)
+	if err != nil {
 		wc.logger.Error(ctx, "WalletController Withdraw",
 			zap.Int("userID", userID), zap.Error(err))
-		handleError(c, CODE_INTERNALSERVER, err)
+		handleError(c, serviceErrorCode(err), err)
 		return
 	}
-	handleSuccess(c, gin.H{"status": "Withdraw successful"})
+	handleTransactionResult(c, "Withdraw successful", result)
 }
 
 // Transfer  转账
@@ -122,20 +183,43 @@ func (wc *WalletController) Transfer(c *gin.Context) {
 		return
 	}
 
-	var request struct{ Amount decimal.Decimal }
+	var request struct {
+		Amount           decimal.Decimal
+		Currency         string
+		ReceiverCurrency string `json:"receiver_currency"`
+		QuoteID          string `json:"quote_id"` // 换汇报价, 收款方按报价的目标币种入账
+		models.TransactionDetails
+	}
 	if err := c.BindJSON(&request); err != nil {
 		wc.logger.Error(ctx, "WalletController Transfer BindJSON",
 			zap.Int("senderID", senderID), zap.Int("receiverID", receiverID), zap.Error(err))
 		handleError(c, CODE_INVALID_PARAMS, err)
 		return
 	}
-	if err := wc.walletService.Transfer(ctx, senderID, receiverID, request.Amount); err != nil {
+	currency := parseCurrency(request.Currency)
+	// 收款方币种不同且未换汇时拒绝
+	if request.QuoteID == "" && request.ReceiverCurrency != "" && parseCurrency(request.ReceiverCurrency) != currency {
+		handleError(c, CODE_CURRENCY_MISMATCH, services.ErrCurrencyMismatch)
+		return
+	}
+	ctx, err = withIdempotencyKey(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	var result *models.TransactionResult
+	if request.QuoteID != "" {
+		result, err = wc.walletService.ExchangeTransfer(ctx, senderID, receiverID, request.Amount, currency, request.QuoteID, request.TransactionDetails)
+	} else {
+		result, err = wc.walletService.Transfer(ctx, senderID, receiverID, request.Amount, currency, request.TransactionDetails)
+	}
+	if err != nil {
 		wc.logger.Error(ctx, "WalletController Transfer walletService ",
 			zap.Int("senderID", senderID), zap.Int("receiverID", receiverID), zap.Error(err))
-		handleError(c, CODE_INTERNALSERVER, err)
+		handleError(c, serviceErrorCode(err), err)
 		return
 	}
-	handleSuccess(c, gin.H{"status": "Transfer successful"})
+	handleTransactionResult(c, "Transfer successful", result)
 }
 
 // GetBalance 查询余额
@@ -152,14 +236,64 @@ func (wc *WalletController) GetBalance(c *gin.Context) {
 		handleError(c, CODE_REQUEST_TOO_QUICKLY, errors.New("trigger limit exceeded"))
 		return
 	}
-	balance, err := wc.walletService.GetBalance(ctx, userID)
+	// 指定 at(RFC3339) 时查询该时刻的账面余额, 需指定币种
+	if value := c.Query("at"); value != "" {
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			handleError(c, CODE_INVALID_PARAMS, fmt.Errorf("invalid at: %w", err))
+			return
+		}
+		if c.Query("currency") == "" {
+			handleError(c, CODE_INVALID_PARAMS, errors.New("currency is required with at"))
+			return
+		}
+		balance, err := wc.walletService.GetBalanceAt(ctx, userID, parseCurrency(c.Query("currency")), at)
+		if err != nil {
+			wc.logger.Error(ctx, "WalletController GetBalanceAt",
+				zap.Int("userID", userID), zap.Time("at", at), zap.Error(err))
+			handleError(c, serviceErrorCode(err), err)
+			return
+		}
+		handleSuccess(c, balance)
+		return
+	}
+
+	// 未指定币种时返回所有币种的余额
+	if c.Query("currency") == "" {
+		wallets, err := wc.walletService.GetBalances(ctx, userID)
+		if err != nil {
+			wc.logger.Error(ctx, "WalletController GetBalances",
+				zap.Int("userID", userID), zap.Error(err))
+			handleError(c, serviceErrorCode(err), err)
+			return
+		}
+		balances := make([]gin.H, 0, len(wallets))
+		for _, w := range wallets {
+			balance := gin.H{
+				"currency":          w.Currency,
+				"balance":           w.Balance,
+				"held_balance":      w.HeldBalance,
+				"available_balance": w.Available(),
+			}
+			// 有授信额度时返回额度的使用情况
+			if credit := w.Credit(); credit != nil {
+				balance["credit"] = credit
+			}
+			balances = append(balances, balance)
+		}
+		handleSuccess(c, gin.H{"balances": balances})
+		return
+	}
+
+	currency := parseCurrency(c.Query("currency"))
+	balance, err := wc.walletService.GetBalance(ctx, userID, currency)
 	if err != nil {
 		wc.logger.Error(ctx, "WalletController GetBalance",
 			zap.Int("userID", userID), zap.Error(err))
-		handleError(c, CODE_INTERNALSERVER, err)
+		handleError(c, serviceErrorCode(err), err)
 		return
 	}
-	handleSuccess(c, gin.H{"balance": balance})
+	handleSuccess(c, balance)
 }
 
 // GetTransactionHistory 获取交易历史
@@ -177,16 +311,59 @@ func (wc *WalletController) GetTransactionHistory(c *gin.Context) {
 		return
 	}
 
-	transactions, err := wc.walletService.GetTransactionHistory(userID)
+	filter, err := parseTransactionFilter(c)
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	page, err := wc.walletService.GetTransactionHistory(ctx, userID, filter)
 	if err != nil {
 		wc.logger.Error(ctx, "WalletController GetTransactionHistory",
 			zap.Int("userID", userID), zap.Error(err))
-		handleError(c, CODE_INTERNALSERVER, err)
+		handleError(c, serviceErrorCode(err), err)
 		return
 	}
-	if len(transactions) == 0 {
-		handleError(c, CODE_NOT_FOUND, errors.New("No transaction history"))
-		return
+	handleSuccess(c, page)
+}
+
+// parseTransactionFilter 解析交易历史的查询参数: type(可逗号分隔或重复), direction, from/to(RFC3339),
+// min_amount/max_amount, external_reference, page_token, limit
+func parseTransactionFilter(c *gin.Context) (models.TransactionFilter, error) {
+	var filter models.TransactionFilter
+	for _, value := range c.QueryArray("type") {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				filter.Types = append(filter.Types, models.TransactionType(strings.ToLower(t)))
+			}
+		}
+	}
+	filter.Direction = models.TransactionDirection(strings.ToLower(c.Query("direction")))
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = &t
+		}
+	}
+	for name, target := range map[string]**decimal.Decimal{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		if value := c.Query(name); value != "" {
+			amount, err := decimal.NewFromString(value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = &amount
+		}
+	}
+	filter.ExternalReference = c.Query("external_reference")
+	filter.PageToken = c.Query("page_token")
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid limit: %w", err)
+		}
+		filter.Limit = limit
 	}
-	handleSuccess(c, transactions)
+	return filter, nil
 }
@@ -14,6 +14,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 	"wallet-service/models"
 	wallet_logger "wallet-service/pkg/logger"
 )
@@ -22,29 +23,190 @@ type MockWalletService struct {
 	mock.Mock
 }
 
-func (m *MockWalletService) Deposit(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, transactionType models.TransactionType) error {
-	args := m.Called(ctx, senderID, receiverID, amount, transactionType)
-	return args.Error(0)
+func (m *MockWalletService) CreateWallet(ctx context.Context, userID int, currency models.Currency) (*models.Wallet, error) {
+	args := m.Called(ctx, userID, currency)
+	wallet, _ := args.Get(0).(*models.Wallet)
+	return wallet, args.Error(1)
 }
 
-func (m *MockWalletService) Withdraw(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, transactionType models.TransactionType) error {
-	args := m.Called(ctx, senderID, receiverID, amount, transactionType)
-	return args.Error(0)
+func (m *MockWalletService) ChangeWalletStatus(ctx context.Context, userID int, currency models.Currency, status models.WalletStatus, reason string) (*models.Wallet, error) {
+	args := m.Called(ctx, userID, currency, status, reason)
+	wallet, _ := args.Get(0).(*models.Wallet)
+	return wallet, args.Error(1)
 }
 
-func (m *MockWalletService) Transfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal) error {
-	args := m.Called(ctx, senderID, receiverID, amount)
-	return args.Error(0)
+func (m *MockWalletService) ListWalletStatusChanges(ctx context.Context, userID int, currency models.Currency) ([]models.WalletStatusChange, error) {
+	args := m.Called(ctx, userID, currency)
+	changes, _ := args.Get(0).([]models.WalletStatusChange)
+	return changes, args.Error(1)
 }
 
-func (m *MockWalletService) GetBalance(ctx context.Context, userID int) (decimal.Decimal, error) {
+func (m *MockWalletService) GetWalletLimits(ctx context.Context, userID int, currency models.Currency) (*models.WalletLimits, error) {
+	args := m.Called(ctx, userID, currency)
+	limits, _ := args.Get(0).(*models.WalletLimits)
+	return limits, args.Error(1)
+}
+
+func (m *MockWalletService) SetWalletLimits(ctx context.Context, userID int, currency models.Currency, limits models.SpendingLimits) (*models.WalletLimits, error) {
+	args := m.Called(ctx, userID, currency, limits)
+	result, _ := args.Get(0).(*models.WalletLimits)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) GetInterest(ctx context.Context, userID int, currency models.Currency) (*models.InterestSummary, error) {
+	args := m.Called(ctx, userID, currency)
+	summary, _ := args.Get(0).(*models.InterestSummary)
+	return summary, args.Error(1)
+}
+
+func (m *MockWalletService) SetCreditLimit(ctx context.Context, userID int, currency models.Currency, creditLimit decimal.Decimal) (*models.Wallet, error) {
+	args := m.Called(ctx, userID, currency, creditLimit)
+	wallet, _ := args.Get(0).(*models.Wallet)
+	return wallet, args.Error(1)
+}
+
+func (m *MockWalletService) CreatePocket(ctx context.Context, userID int, currency models.Currency, name string) (*models.Pocket, error) {
+	args := m.Called(ctx, userID, currency, name)
+	pocket, _ := args.Get(0).(*models.Pocket)
+	return pocket, args.Error(1)
+}
+
+func (m *MockWalletService) ListPockets(ctx context.Context, userID int, currency models.Currency) ([]models.Pocket, error) {
+	args := m.Called(ctx, userID, currency)
+	pockets, _ := args.Get(0).([]models.Pocket)
+	return pockets, args.Error(1)
+}
+
+func (m *MockWalletService) MovePocketFunds(ctx context.Context, userID int, move models.PocketMove) (*models.TransactionResult, error) {
+	args := m.Called(ctx, userID, move)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) ClosePocket(ctx context.Context, pocketID int) (*models.Pocket, error) {
+	args := m.Called(ctx, pocketID)
+	pocket, _ := args.Get(0).(*models.Pocket)
+	return pocket, args.Error(1)
+}
+
+func (m *MockWalletService) GetAggregateBalances(ctx context.Context, userID int) ([]models.AggregateBalance, error) {
+	args := m.Called(ctx, userID)
+	balances, _ := args.Get(0).([]models.AggregateBalance)
+	return balances, args.Error(1)
+}
+
+func (m *MockWalletService) Deposit(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, transactionType models.TransactionType, details models.TransactionDetails) (*models.TransactionResult, error) {
+	args := m.Called(ctx, senderID, receiverID, amount, currency, transactionType, details)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) Withdraw(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, transactionType models.TransactionType, details models.TransactionDetails) (*models.TransactionResult, error) {
+	args := m.Called(ctx, senderID, receiverID, amount, currency, transactionType, details)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) Transfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, details models.TransactionDetails) (*models.TransactionResult, error) {
+	args := m.Called(ctx, senderID, receiverID, amount, currency, details)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) ExchangeTransfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, quoteID string, details models.TransactionDetails) (*models.TransactionResult, error) {
+	args := m.Called(ctx, senderID, receiverID, amount, currency, quoteID, details)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) Reserve(ctx context.Context, userID int, amount decimal.Decimal, currency models.Currency, ttl time.Duration) (*models.Hold, error) {
+	args := m.Called(ctx, userID, amount, currency, ttl)
+	hold, _ := args.Get(0).(*models.Hold)
+	return hold, args.Error(1)
+}
+
+func (m *MockWalletService) Capture(ctx context.Context, holdID int, amount decimal.Decimal) (*models.TransactionResult, error) {
+	args := m.Called(ctx, holdID, amount)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) Void(ctx context.Context, holdID int) (*models.Hold, error) {
+	args := m.Called(ctx, holdID)
+	hold, _ := args.Get(0).(*models.Hold)
+	return hold, args.Error(1)
+}
+
+func (m *MockWalletService) Reverse(ctx context.Context, transactionID int, amount decimal.Decimal, policy models.ReversalPolicy) (*models.TransactionResult, error) {
+	args := m.Called(ctx, transactionID, amount, policy)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) RequestPayout(ctx context.Context, userID int, amount decimal.Decimal, currency models.Currency) (*models.TransactionResult, error) {
+	args := m.Called(ctx, userID, amount, currency)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) CompleteTransaction(ctx context.Context, transactionID int) (*models.Transaction, error) {
+	args := m.Called(ctx, transactionID)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Error(1)
+}
+
+func (m *MockWalletService) FailTransaction(ctx context.Context, transactionID int, reason string) (*models.Transaction, error) {
+	args := m.Called(ctx, transactionID, reason)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Error(1)
+}
+
+func (m *MockWalletService) GetTransaction(ctx context.Context, transactionID int) (*models.Transaction, error) {
+	args := m.Called(ctx, transactionID)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Error(1)
+}
+
+func (m *MockWalletService) BatchTransfer(ctx context.Context, senderID int, currency models.Currency, items []models.BatchTransferItem, mode models.BatchMode) (*models.TransactionResult, error) {
+	args := m.Called(ctx, senderID, currency, items, mode)
+	result, _ := args.Get(0).(*models.TransactionResult)
+	return result, args.Error(1)
+}
+
+func (m *MockWalletService) ListHolds(ctx context.Context, userID int, currency models.Currency) ([]models.Hold, error) {
+	args := m.Called(ctx, userID, currency)
+	holds, _ := args.Get(0).([]models.Hold)
+	return holds, args.Error(1)
+}
+
+func (m *MockWalletService) GetBalance(ctx context.Context, userID int, currency models.Currency) (*models.Balance, error) {
+	args := m.Called(ctx, userID, currency)
+	balance, _ := args.Get(0).(*models.Balance)
+	return balance, args.Error(1)
+}
+
+func (m *MockWalletService) GetBalanceAt(ctx context.Context, userID int, currency models.Currency, at time.Time) (*models.HistoricalBalance, error) {
+	args := m.Called(ctx, userID, currency, at)
+	balance, _ := args.Get(0).(*models.HistoricalBalance)
+	return balance, args.Error(1)
+}
+
+func (m *MockWalletService) GetStatement(ctx context.Context, userID int, currency models.Currency, from, to time.Time) (*models.Statement, error) {
+	args := m.Called(ctx, userID, currency, from, to)
+	statement, _ := args.Get(0).(*models.Statement)
+	return statement, args.Error(1)
+}
+
+func (m *MockWalletService) GetBalances(ctx context.Context, userID int) ([]models.Wallet, error) {
 	args := m.Called(ctx, userID)
-	return args.Get(0).(decimal.Decimal), args.Error(1)
+	wallets, _ := args.Get(0).([]models.Wallet)
+	return wallets, args.Error(1)
 }
 
-func (m *MockWalletService) GetTransactionHistory(userID int) ([]models.Transaction, error) {
-	args := m.Called(userID)
-	return args.Get(0).([]models.Transaction), args.Error(1)
+func (m *MockWalletService) GetTransactionHistory(ctx context.Context, userID int, filter models.TransactionFilter) (*models.TransactionPage, error) {
+	args := m.Called(ctx, userID, filter)
+	page, _ := args.Get(0).(*models.TransactionPage)
+	return page, args.Error(1)
 }
 
 // 测试 GetBalance 方法
@@ -73,14 +235,18 @@ func TestWalletController_GetBalance_Success1(t *testing.T) {
 	expectedBalance := decimal.NewFromFloat(100.0)
 
 	// 设置 mock WalletService 的期望行为
-	mockService.On("GetBalance", mock.Anything, userID).Return(expectedBalance, nil)
+	mockService.On("GetBalance", mock.Anything, userID, models.USD).Return(&models.Balance{
+		Currency:  models.USD,
+		Ledger:    expectedBalance,
+		Available: expectedBalance,
+	}, nil)
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.GET("/wallet/:user_id/balance", controller.GetBalance)
 
 	// 模拟请求，传递有效的 user_id
-	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/balance", userID), nil)
+	req := httptest.NewRequest("GET", fmt.Sprintf("/wallet/%d/balance?currency=USD", userID), nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -150,14 +316,14 @@ func TestWalletController_Transfer_Success2(t *testing.T) {
 	controller := NewWalletController(l, redisCli, mockService)
 
 	// 模拟 WalletService 的 Transfer 方法
-	mockService.On("Transfer", mock.Anything, 1, 2, mock.AnythingOfType("decimal.Decimal")).Return(nil)
+	mockService.On("Transfer", mock.Anything, 1, 2, mock.AnythingOfType("decimal.Decimal"), models.USD, models.TransactionDetails{}).Return(&models.TransactionResult{TransactionID: 1}, nil)
 
 	// 创建测试请求数据
 	router := gin.Default()
 	router.POST("/wallet/transfer/:sender_id/to/:receiver_id", controller.Transfer)
 
 	// 模拟请求
-	req := httptest.NewRequest("POST", "/wallet/transfer/1/to/2", strings.NewReader(`{"amount": 30.0}`))
+	req := httptest.NewRequest("POST", "/wallet/transfer/1/to/2", strings.NewReader(`{"amount": 30.0, "currency": "USD"}`))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -190,14 +356,14 @@ func TestWalletController_Withdraw_Success(t *testing.T) {
 	controller := NewWalletController(l, redisCli, mockService)
 
 	// 模拟 WalletService 的 Withdraw 方法
-	mockService.On("Withdraw", mock.Anything, 1, 1, mock.AnythingOfType("decimal.Decimal"), models.WithdrawTransactionType).Return(nil)
+	mockService.On("Withdraw", mock.Anything, 1, 1, mock.AnythingOfType("decimal.Decimal"), models.USD, models.WithdrawTransactionType, models.TransactionDetails{}).Return(&models.TransactionResult{TransactionID: 1}, nil)
 
 	// 创建测试请求数据
 	router := gin.Default()
 	router.POST("/wallet/:user_id/withdraw", controller.Withdraw)
 
 	// 模拟请求
-	req := httptest.NewRequest("POST", "/wallet/1/withdraw", strings.NewReader(`{"amount": 50.0}`))
+	req := httptest.NewRequest("POST", "/wallet/1/withdraw", strings.NewReader(`{"amount": 50.0, "currency": "USD"}`))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -237,7 +403,7 @@ func TestWalletController_Deposit_Failed1(t *testing.T) {
 	t.Run("deposit failed", func(t *testing.T) {
 
 		// 设置 mock WalletService 的期望行为，模拟存款失败
-		mockService.On("Deposit", mock.Anything, userID, userID, amount, models.DepositTransactionType).Return(errors.New("internal server error"))
+		mockService.On("Deposit", mock.Anything, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{}).Return(nil, errors.New("internal server error"))
 
 		// 创建 HTTP 请求
 		router := gin.Default()
@@ -245,7 +411,7 @@ func TestWalletController_Deposit_Failed1(t *testing.T) {
 
 		// 模拟请求，传递有效的 user_id 和存款金额
 		req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), nil)
-		req.Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{"amount": %s}`, amount.String())))
+		req.Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{"amount": %s, "currency": "USD"}`, amount.String())))
 		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -286,14 +452,14 @@ func TestWalletController_Deposit_Success1(t *testing.T) {
 	t.Run("success deposit", func(t *testing.T) {
 
 		// 设置 mock WalletService 的期望行为
-		mockService.On("Deposit", mock.Anything, userID, userID, amount, models.DepositTransactionType).Return(nil)
+		mockService.On("Deposit", mock.Anything, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{}).Return(&models.TransactionResult{TransactionID: 1}, nil)
 
 		// 创建 HTTP 请求
 		router := gin.Default()
 		router.POST("/wallet/:user_id/deposit", controller.Deposit)
 
 		// 模拟请求，传递有效的 user_id 和存款金额
-		req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(`{"amount": `+amount.String()+`}`))
+		req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/deposit", userID), strings.NewReader(`{"amount": `+amount.String()+`, "currency": "USD"}`))
 		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -336,7 +502,8 @@ func TestWalletController_GetTransactionHistory(t *testing.T) {
 	// 测试：成功获取交易历史
 	t.Run("success get transaction history", func(t *testing.T) {
 		// 设置 mock WalletService 的期望行为，返回交易历史
-		mockService.On("GetTransactionHistory", userID).Return(transactions, nil)
+		mockService.On("GetTransactionHistory", mock.Anything, userID, models.TransactionFilter{}).
+			Return(&models.TransactionPage{Transactions: transactions}, nil)
 
 		// 创建 HTTP 请求
 		router := gin.Default()
@@ -383,7 +550,8 @@ func TestWalletController_GetTransactionHistory_Failed(t *testing.T) {
 	// 测试：没有交易历史
 	t.Run("no transaction history", func(t *testing.T) {
 		// 设置 mock WalletService 的期望行为，返回空的交易历史
-		mockService.On("GetTransactionHistory", userID).Return([]models.Transaction{}, nil)
+		mockService.On("GetTransactionHistory", mock.Anything, userID, models.TransactionFilter{}).
+			Return(&models.TransactionPage{Transactions: []models.Transaction{}}, nil)
 
 		// 创建 HTTP 请求
 		router := gin.Default()
@@ -396,7 +564,7 @@ func TestWalletController_GetTransactionHistory_Failed(t *testing.T) {
 
 		// 断言返回结果
 		assert.Equal(t, 200, w.Code)
-		assert.Contains(t, w.Body.String(), `"error_code":100001`) // 没有交易历史的错误码
+		assert.Contains(t, w.Body.String(), `"transactions":[]`) // 没有交易历史时返回空列表
 
 		// 验证方法调用
 		mockService.AssertExpectations(t)
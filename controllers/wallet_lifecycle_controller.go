@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"strconv"
+	"wallet-service/models"
+)
+
+// CreateWallet 创建钱包
+func (wc *WalletController) CreateWallet(c *gin.Context) {
+	ctx := c.Request.Context()
+	var request struct {
+		UserID   int `json:"user_id"`
+		Currency string
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController CreateWallet BindJSON", zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	if request.UserID <= 0 {
+		handleError(c, CODE_INVALID_PARAMS, errors.New("user_id must be greater than zero"))
+		return
+	}
+
+	wallet, err := wc.walletService.CreateWallet(ctx, request.UserID, parseCurrency(request.Currency))
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController CreateWallet walletService",
+			zap.Int("userID", request.UserID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, wallet)
+}
+
+// FreezeWallet 冻结钱包(管理接口), 冻结后只能收款
+func (wc *WalletController) FreezeWallet(c *gin.Context) {
+	wc.changeWalletStatus(c, models.WalletFrozen)
+}
+
+// UnfreezeWallet 解冻钱包(管理接口)
+func (wc *WalletController) UnfreezeWallet(c *gin.Context) {
+	wc.changeWalletStatus(c, models.WalletActive)
+}
+
+// CloseWallet 注销钱包(管理接口), 余额必须为零
+func (wc *WalletController) CloseWallet(c *gin.Context) {
+	wc.changeWalletStatus(c, models.WalletClosed)
+}
+
+func (wc *WalletController) changeWalletStatus(c *gin.Context, status models.WalletStatus) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var request struct {
+		Currency string
+		Reason   string `binding:"required"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		wc.logger.Error(ctx, "WalletController changeWalletStatus BindJSON",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+
+	wallet, err := wc.walletService.ChangeWalletStatus(ctx, userID, parseCurrency(request.Currency), status, request.Reason)
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController changeWalletStatus walletService",
+			zap.Int("userID", userID), zap.String("status", string(status)), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, wallet)
+}
+
+// ListWalletStatusChanges 查询钱包的状态变更记录(管理接口)
+func (wc *WalletController) ListWalletStatusChanges(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		handleError(c, CODE_INVALID_PARAMS, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	changes, err := wc.walletService.ListWalletStatusChanges(ctx, userID, parseCurrency(c.Query("currency")))
+	if err != nil {
+		wc.logger.Error(ctx, "WalletController ListWalletStatusChanges walletService",
+			zap.Int("userID", userID), zap.Error(err))
+		handleError(c, serviceErrorCode(err), err)
+		return
+	}
+	handleSuccess(c, changes)
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+	"wallet-service/services"
+)
+
+func TestWalletController_CreateWallet(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	// 设置 mock WalletService 的期望行为
+	mockService.On("CreateWallet", mock.Anything, 1, models.EUR).
+		Return(&models.Wallet{UserID: 1, Currency: models.EUR, Status: models.WalletActive}, nil).Once()
+	mockService.On("CreateWallet", mock.Anything, 1, models.EUR).
+		Return(nil, services.ErrWalletAlreadyExists).Once()
+
+	router := gin.Default()
+	router.POST("/wallets", controller.CreateWallet)
+
+	req := httptest.NewRequest("POST", "/wallets", strings.NewReader(`{"user_id": 1, "currency": "eur"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"active"`)
+
+	// 重复创建返回 409
+	req = httptest.NewRequest("POST", "/wallets", strings.NewReader(`{"user_id": 1, "currency": "EUR"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":301013`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_FreezeWallet(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	mockService.On("ChangeWalletStatus", mock.Anything, 1, models.USD, models.WalletFrozen, "chargeback investigation").
+		Return(&models.Wallet{UserID: 1, Currency: models.USD, Status: models.WalletFrozen}, nil)
+
+	router := gin.Default()
+	router.POST("/admin/wallets/:user_id/freeze", controller.FreezeWallet)
+
+	req := httptest.NewRequest("POST", "/admin/wallets/1/freeze", strings.NewReader(`{"currency": "USD", "reason": "chargeback investigation"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"frozen"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletController_CloseWallet_RequiresReason(t *testing.T) {
+	mockService := new(MockWalletService)
+	controller := NewWalletController(wallet_logger.NewLogger(), nil, mockService)
+
+	router := gin.Default()
+	router.POST("/admin/wallets/:user_id/close", controller.CloseWallet)
+
+	// 未填写原因时不调用 service
+	req := httptest.NewRequest("POST", "/admin/wallets/1/close", strings.NewReader(`{"currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":100004`)
+	mockService.AssertNotCalled(t, "ChangeWalletStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -39,14 +39,14 @@ func TestWalletController_Withdraw_Success1(t *testing.T) {
 	amount := decimal.NewFromFloat(50.0).Round(0)
 
 	// 设置 mock WalletService 的期望行为
-	mockService.On("Withdraw", ctx, userID, userID, amount, models.WithdrawTransactionType).Return(nil)
+	mockService.On("Withdraw", ctx, userID, userID, amount, models.USD, models.WithdrawTransactionType, models.TransactionDetails{}).Return(&models.TransactionResult{TransactionID: 1}, nil)
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.POST("/wallet/:user_id/withdraw", controller.Withdraw)
 
 	// 模拟请求，传递有效的 user_id 和取款金额
-	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/withdraw", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s"}`, amount.String())))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/withdraw", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -82,14 +82,14 @@ func TestWalletController_Withdraw_Failed(t *testing.T) {
 	amount := decimal.NewFromFloat(50.0).Round(0)
 
 	// 设置 mock WalletService 的期望行为，模拟取款失败
-	mockService.On("Withdraw", ctx, userID, userID, amount, models.WithdrawTransactionType).Return(errors.New("internal server error"))
+	mockService.On("Withdraw", ctx, userID, userID, amount, models.USD, models.WithdrawTransactionType, models.TransactionDetails{}).Return(nil, errors.New("internal server error"))
 
 	// 创建 HTTP 请求
 	router := gin.Default()
 	router.POST("/wallet/:user_id/withdraw", controller.Withdraw)
 
 	// 模拟请求，传递有效的 user_id 和取款金额
-	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/withdraw", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s"}`, amount.String())))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/withdraw", userID), strings.NewReader(fmt.Sprintf(`{"amount": "%s", "currency": "USD"}`, amount.String())))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -137,3 +137,46 @@ func TestWalletController_Withdraw_InvalidParams(t *testing.T) {
 	// 验证方法调用
 	mockService.AssertExpectations(t)
 }
+
+func TestWalletController_Withdraw_WithFee(t *testing.T) {
+	// 模拟 Redis 客户端
+	ctx := context.Background()
+	mockService := new(MockWalletService)
+	var redisCli = redis.NewClient(&redis.Options{
+		PoolSize:     100,
+		MinIdleConns: 25,
+		Addr:         fmt.Sprintf("%s:%d", "127.0.0.1", 6379),
+		Password:     "",
+		DB:           0,
+	})
+	_, err := redisCli.Ping(ctx).Result()
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := wallet_logger.NewLogger()
+	controller := NewWalletController(l, redisCli, mockService)
+
+	// 准备测试数据
+	userID := 1
+	amount := decimal.NewFromInt(100)
+
+	// 设置 mock WalletService 的期望行为, 返回收取的手续费
+	mockService.On("Withdraw", ctx, userID, userID, amount, models.USD, models.WithdrawTransactionType, models.TransactionDetails{}).
+		Return(&models.TransactionResult{TransactionID: 1, Fee: &models.FeeResult{TransactionID: 2, Amount: decimal.NewFromFloat(1.5), Currency: models.USD}}, nil)
+
+	// 创建 HTTP 请求
+	router := gin.Default()
+	router.POST("/wallet/:user_id/withdraw", controller.Withdraw)
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/wallet/%d/withdraw", userID), strings.NewReader(`{"amount": "100", "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 断言返回结果
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"fee":{"transaction_id":2,"amount":"1.5","currency":"USD"}`)
+
+	// 验证方法调用
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,52 @@
+package models
+
+import "github.com/shopspring/decimal"
+
+// BatchMode 批量转账的执行方式
+type BatchMode string
+
+const (
+	BatchAllOrNothing BatchMode = "all_or_nothing" // 任一笔失败则整批回滚
+	BatchBestEffort   BatchMode = "best_effort"    // 跳过失败的明细, 其余照常执行
+)
+
+func (m BatchMode) Valid() bool {
+	return m == BatchAllOrNothing || m == BatchBestEffort
+}
+
+type BatchItemStatus string
+
+const (
+	BatchItemSucceeded BatchItemStatus = "succeeded"
+	BatchItemFailed    BatchItemStatus = "failed"
+)
+
+// BatchTransferItem 批量转账的一笔明细
+type BatchTransferItem struct {
+	ReceiverUserID int             `json:"receiver_id"`
+	Amount         decimal.Decimal `json:"amount"`
+	Reference      string          `json:"reference"` // 调用方的业务单号, 原样返回
+}
+
+// BatchItemResult 一笔明细的执行结果
+type BatchItemResult struct {
+	Index          int             `json:"index"`
+	ReceiverUserID int             `json:"receiver_id"`
+	Amount         decimal.Decimal `json:"amount"`
+	Reference      string          `json:"reference,omitempty"`
+	Status         BatchItemStatus `json:"status"`
+	TransactionID  int             `json:"transaction_id,omitempty"`
+	Fee            *FeeResult      `json:"fee,omitempty"` // 该笔收取的手续费
+	Error          string          `json:"error,omitempty"`
+}
+
+// BatchTransferResult 批量转账的汇总结果
+type BatchTransferResult struct {
+	BatchID     int               `json:"batch_id"`
+	Mode        BatchMode         `json:"mode"`
+	Currency    Currency          `json:"currency"`
+	TotalAmount decimal.Decimal   `json:"total_amount"` // 实际转出的总金额
+	Succeeded   int               `json:"succeeded"`
+	Failed      int               `json:"failed"`
+	Items       []BatchItemResult `json:"items"`
+}
@@ -0,0 +1,44 @@
+package models
+
+// Currency ISO 4217 币种代码
+type Currency string
+
+const (
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	CNY Currency = "CNY"
+	JPY Currency = "JPY"
+)
+
+// currencyMinorUnits ISO 4217 规定的小数位数(最小货币单位)
+var currencyMinorUnits = map[Currency]int32{
+	"USD": 2,
+	"EUR": 2,
+	"CNY": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"HKD": 2,
+	"KWD": 3,
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"SGD": 2,
+	"INR": 2,
+	"KRW": 0,
+	"VND": 0,
+	"BHD": 3,
+	"JOD": 3,
+	"OMR": 3,
+}
+
+// MinorUnits 返回币种的小数位数, 不支持的币种返回 false
+func (c Currency) MinorUnits() (int32, bool) {
+	units, ok := currencyMinorUnits[c]
+	return units, ok
+}
+
+// Valid 是否为支持的币种
+func (c Currency) Valid() bool {
+	_, ok := currencyMinorUnits[c]
+	return ok
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// EscrowStatus 托管状态: funded 资金在托管中; 全部结算后按结算方式为 released / refunded / split
+type EscrowStatus string
+
+const (
+	EscrowFunded   EscrowStatus = "funded"
+	EscrowReleased EscrowStatus = "released"
+	EscrowRefunded EscrowStatus = "refunded"
+	EscrowSplit    EscrowStatus = "split"
+)
+
+// EscrowAction 结算方式: release 放款给卖方; refund 退款给买方
+type EscrowAction string
+
+const (
+	EscrowRelease EscrowAction = "release"
+	EscrowRefund  EscrowAction = "refund"
+)
+
+func (a EscrowAction) Valid() bool {
+	return a == EscrowRelease || a == EscrowRefund
+}
+
+// EscrowTrigger 结算的触发方
+type EscrowTrigger string
+
+const (
+	TriggeredByBuyer  EscrowTrigger = "buyer"
+	TriggeredBySeller EscrowTrigger = "seller"
+	TriggeredByExpiry EscrowTrigger = "expiry"
+)
+
+// Escrow 托管: 买方资金在确认收货前由 escrow:<id> 账户持有
+type Escrow struct {
+	ID                   int                `db:"id" json:"id"`
+	BuyerUserID          int                `db:"buyer_user_id" json:"buyer_user_id"`
+	SellerUserID         int                `db:"seller_user_id" json:"seller_user_id"`
+	Currency             Currency           `db:"currency" json:"currency"`
+	Amount               decimal.Decimal    `db:"amount" json:"amount"`
+	Balance              decimal.Decimal    `db:"balance" json:"balance"` // 尚未结算的金额
+	ReleasedAmount       decimal.Decimal    `db:"released_amount" json:"released_amount"`
+	RefundedAmount       decimal.Decimal    `db:"refunded_amount" json:"refunded_amount"`
+	Status               EscrowStatus       `db:"status" json:"status"`
+	OnExpiry             EscrowAction       `db:"on_expiry" json:"on_expiry"`
+	ExpiresAt            time.Time          `db:"expires_at" json:"expires_at"`
+	ProposedSplitBy      *int               `db:"proposed_split_by" json:"proposed_split_by,omitempty"`
+	ProposedSellerAmount *decimal.Decimal   `db:"proposed_seller_amount" json:"proposed_seller_amount,omitempty"`
+	FundingTransactionID *int               `db:"funding_transaction_id" json:"funding_transaction_id,omitempty"`
+	SettledAt            *time.Time         `db:"settled_at" json:"settled_at,omitempty"`
+	CreatedAt            time.Time          `db:"created_at" json:"created_at"`
+	UpdatedAt            time.Time          `db:"updated_at" json:"updated_at"`
+	Settlements          []EscrowSettlement `db:"-" json:"settlements,omitempty"`
+}
+
+// EscrowSettlement 一次结算, 拆分时放款和退款各一条
+type EscrowSettlement struct {
+	ID             int             `db:"id" json:"id"`
+	EscrowID       int             `db:"escrow_id" json:"escrow_id"`
+	TransactionID  int             `db:"transaction_id" json:"transaction_id"`
+	SettlementType EscrowAction    `db:"settlement_type" json:"settlement_type"`
+	Amount         decimal.Decimal `db:"amount" json:"amount"`
+	TriggeredBy    EscrowTrigger   `db:"triggered_by" json:"triggered_by"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+}
+
+// SettledStatus 按已结算金额返回托管状态, 仍有余额时为 funded
+func (e Escrow) SettledStatus() EscrowStatus {
+	switch {
+	case e.Balance.IsPositive():
+		return EscrowFunded
+	case e.RefundedAmount.IsZero():
+		return EscrowReleased
+	case e.ReleasedAmount.IsZero():
+		return EscrowRefunded
+	default:
+		return EscrowSplit
+	}
+}
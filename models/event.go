@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// EventType 领域事件类型
+type EventType string
+
+const (
+	EventWalletCredited    EventType = "WalletCredited"    // 钱包入账
+	EventWalletDebited     EventType = "WalletDebited"     // 钱包出账
+	EventTransferCompleted EventType = "TransferCompleted" // 转账完成
+)
+
+// OutboxEvent 领域事件, 与余额变动在同一个事务内写入 outbox_events, 提交后发布; 消费者按 ID 去重
+type OutboxEvent struct {
+	ID          int64           `db:"id" json:"id"`
+	AggregateID string          `db:"aggregate_id" json:"aggregate_id"` // 事件所属的钱包账户, 同一钱包的事件按 ID 顺序发布
+	EventType   EventType       `db:"event_type" json:"type"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	Attempts    int             `db:"attempts" json:"-"` // 发布失败的次数
+}
+
+// WalletEvent WalletCredited / WalletDebited 的内容, Amount 为正数
+type WalletEvent struct {
+	TransactionID   *int            `json:"transaction_id"`
+	TransactionType TransactionType `json:"transaction_type"`
+	UserID          int             `json:"user_id"`
+	Currency        Currency        `json:"currency"`
+	Amount          decimal.Decimal `json:"amount"`
+}
+
+// TransferEvent TransferCompleted 的内容
+type TransferEvent struct {
+	TransactionID  *int            `json:"transaction_id"`
+	SenderUserID   int             `json:"sender_user_id"`
+	ReceiverUserID int             `json:"receiver_user_id"`
+	Currency       Currency        `json:"currency"`
+	Amount         decimal.Decimal `json:"amount"`
+}
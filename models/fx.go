@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// FXRate 汇率: 1 单位 BaseCurrency 兑换 Rate 单位 QuoteCurrency
+type FXRate struct {
+	ID            int             `db:"id" json:"id"`
+	BaseCurrency  Currency        `db:"base_currency" json:"base_currency"`
+	QuoteCurrency Currency        `db:"quote_currency" json:"quote_currency"`
+	Rate          decimal.Decimal `db:"rate" json:"rate"`     // 中间价
+	Spread        decimal.Decimal `db:"spread" json:"spread"` // 点差比例, 如 0.005 表示 0.5%
+	ValidFrom     time.Time       `db:"valid_from" json:"valid_from"`
+	ValidTo       *time.Time      `db:"valid_to" json:"valid_to,omitempty"` // 为空表示长期有效
+	CreatedAt     time.Time       `db:"created_at" json:"created_at"`
+}
+
+// FXQuote 锁定汇率的报价, 过期前可用于一次换汇转账
+type FXQuote struct {
+	ID            string          `db:"id" json:"id"`
+	RateID        int             `db:"rate_id" json:"rate_id"`
+	BaseCurrency  Currency        `db:"base_currency" json:"base_currency"`
+	QuoteCurrency Currency        `db:"quote_currency" json:"quote_currency"`
+	Rate          decimal.Decimal `db:"rate" json:"rate"`
+	Spread        decimal.Decimal `db:"spread" json:"spread"`
+	ExpiresAt     time.Time       `db:"expires_at" json:"expires_at"`
+	UsedAt        *time.Time      `db:"used_at" json:"used_at,omitempty"`
+	CreatedAt     time.Time       `db:"created_at" json:"created_at"`
+}
+
+// ClientRate 扣除点差后客户实际成交的汇率
+func (q FXQuote) ClientRate() decimal.Decimal {
+	return q.Rate.Mul(decimal.NewFromInt(1).Sub(q.Spread))
+}
+
+// ExchangeResult 换汇转账中收款方入账的一侧
+type ExchangeResult struct {
+	TransactionID int             `json:"transaction_id"` // 入账流水ID
+	Currency      Currency        `json:"currency"`
+	Amount        decimal.Decimal `json:"amount"`
+	Rate          decimal.Decimal `json:"rate"` // 成交汇率
+}
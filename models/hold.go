@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "active"
+	HoldCaptured HoldStatus = "captured"
+	HoldVoided   HoldStatus = "voided"
+	HoldExpired  HoldStatus = "expired"
+)
+
+// Hold 预授权冻结: 占用可用余额但不改变账面余额, 扣款(Capture)时才真正出账
+type Hold struct {
+	ID             int              `db:"id" json:"id"`
+	UserID         int              `db:"user_id" json:"user_id"`
+	Currency       Currency         `db:"currency" json:"currency"`
+	Amount         decimal.Decimal  `db:"amount" json:"amount"`
+	CapturedAmount *decimal.Decimal `db:"captured_amount" json:"captured_amount,omitempty"`
+	Status         HoldStatus       `db:"status" json:"status"`
+	TransactionID  *int             `db:"transaction_id" json:"transaction_id,omitempty"`
+	ExpiresAt      time.Time        `db:"expires_at" json:"expires_at"`
+	CreatedAt      time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time        `db:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// DayCountConvention 计息基准, 决定日利率 = 年化利率 / 一年的天数
+type DayCountConvention string
+
+const (
+	DayCountActual365 DayCountConvention = "actual/365"    // 一年固定 365 天
+	DayCountActual360 DayCountConvention = "actual/360"    // 一年固定 360 天
+	DayCountActualAct DayCountConvention = "actual/actual" // 按计息日所在年份的实际天数(闰年 366 天)
+)
+
+// DaysInYear 计息日所在年份按该基准的天数, 不支持的基准返回 false
+func (c DayCountConvention) DaysInYear(date time.Time) (int, bool) {
+	switch c {
+	case DayCountActual365:
+		return 365, true
+	case DayCountActual360:
+		return 360, true
+	case DayCountActualAct:
+		year := date.Year()
+		if year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+			return 366, true
+		}
+		return 365, true
+	}
+	return 0, false
+}
+
+// InterestAccrual 钱包某一天的计息记录, Amount 保留计算结果的全部精度, 按月派息时再取整; 透支时按透支利率计提, Amount 为负数
+type InterestAccrual struct {
+	UserID      int             `db:"user_id" json:"user_id"`
+	Currency    Currency        `db:"currency" json:"currency"`
+	AccrualDate time.Time       `db:"accrual_date" json:"accrual_date"`
+	Balance     decimal.Decimal `db:"balance" json:"balance"` // 当日日终余额
+	Rate        decimal.Decimal `db:"rate" json:"rate"`       // 年化利率, 透支时为透支利率
+	Amount      decimal.Decimal `db:"amount" json:"amount"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+}
+
+// InterestPayout 钱包某个月的派息: 当月计提的利息加上月结转的零头, 按币种最小单位向零取整后入账, 余下的零头结转到下月.
+// 透支利息多于存款利息时 Amount 为负数, 表示从钱包收取的透支利息
+type InterestPayout struct {
+	UserID        int             `db:"user_id" json:"user_id"`
+	Currency      Currency        `db:"currency" json:"currency"`
+	Period        time.Time       `db:"period" json:"period"` // 派息月份的第一天
+	Accrued       decimal.Decimal `db:"accrued" json:"accrued"`
+	Amount        decimal.Decimal `db:"amount" json:"amount"`
+	Carry         decimal.Decimal `db:"carry" json:"carry"`
+	TransactionID *int            `db:"transaction_id" json:"transaction_id,omitempty"` // 派息流水, 金额为零或钱包已注销时为空
+	CreatedAt     time.Time       `db:"created_at" json:"created_at"`
+}
+
+// InterestSummary 钱包已计提未派发的利息及最近的派息记录
+type InterestSummary struct {
+	UserID   int              `json:"user_id"`
+	Currency Currency         `json:"currency"`
+	Accrued  decimal.Decimal  `json:"accrued"` // 未派发的利息, 含上次派息结转的零头; 负数为待收取的透支利息
+	Payouts  []InterestPayout `json:"payouts"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type AccountType string
+
+const (
+	WalletAccountType AccountType = "wallet"
+	SystemAccountType AccountType = "system"
+	PocketAccountType AccountType = "pocket"
+	EscrowAccountType AccountType = "escrow"
+)
+
+type LedgerAccount struct {
+	ID          int             `db:"id" json:"id"`
+	Code        string          `db:"code" json:"code"` // "wallet:<user_id>:<currency>"、"pocket:<id>"、"escrow:<id>" 或 "system:<name>:<currency>"
+	AccountType AccountType     `db:"account_type" json:"account_type"`
+	UserID      *int            `db:"user_id" json:"user_id,omitempty"`
+	Currency    Currency        `db:"currency" json:"currency"`
+	Balance     decimal.Decimal `db:"balance" json:"balance"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// Posting 分录中的一行, 正数为入账, 负数为出账
+type Posting struct {
+	ID             int             `db:"id" json:"id"`
+	JournalEntryID int             `db:"journal_entry_id" json:"journal_entry_id"`
+	AccountCode    string          `db:"account_code" json:"account_code"`
+	AccountType    AccountType     `db:"-" json:"-"`
+	UserID         int             `db:"-" json:"-"`
+	Currency       Currency        `db:"-" json:"-"`
+	Amount         decimal.Decimal `db:"amount" json:"amount"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+}
+
+// JournalEntry 一笔复式记账分录, 每个币种的 Postings 之和必须为零
+type JournalEntry struct {
+	ID            int       `db:"id" json:"id"`
+	TransactionID *int      `db:"transaction_id" json:"transaction_id,omitempty"`
+	Description   string    `db:"description" json:"description"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+	Postings      []Posting `db:"-" json:"postings"`
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// LimitName 限额项
+type LimitName string
+
+const (
+	LimitMaxSingleAmount   LimitName = "max_single_amount"   // 单笔付款上限
+	LimitDailyOutflow      LimitName = "daily_outflow"       // 每日付款总额
+	LimitWeeklyOutflow     LimitName = "weekly_outflow"      // 每周付款总额
+	LimitMonthlyOutflow    LimitName = "monthly_outflow"     // 每月付款总额
+	LimitMaxBalance        LimitName = "max_balance"         // 余额上限
+	LimitMaxDailyTransfers LimitName = "max_daily_transfers" // 每日转账笔数
+)
+
+// SpendingLimits 钱包的限额, nil 表示不限制; Tier 为空时使用默认等级
+type SpendingLimits struct {
+	Tier              string           `db:"tier" json:"tier"`
+	MaxSingleAmount   *decimal.Decimal `db:"max_single_amount" json:"max_single_amount,omitempty"`
+	DailyOutflow      *decimal.Decimal `db:"daily_outflow" json:"daily_outflow,omitempty"`
+	WeeklyOutflow     *decimal.Decimal `db:"weekly_outflow" json:"weekly_outflow,omitempty"`
+	MonthlyOutflow    *decimal.Decimal `db:"monthly_outflow" json:"monthly_outflow,omitempty"`
+	MaxBalance        *decimal.Decimal `db:"max_balance" json:"max_balance,omitempty"`
+	MaxDailyTransfers *int             `db:"max_daily_transfers" json:"max_daily_transfers,omitempty"`
+}
+
+// Override 用 o 中已设置的项覆盖当前限额
+func (l SpendingLimits) Override(o SpendingLimits) SpendingLimits {
+	if o.MaxSingleAmount != nil {
+		l.MaxSingleAmount = o.MaxSingleAmount
+	}
+	if o.DailyOutflow != nil {
+		l.DailyOutflow = o.DailyOutflow
+	}
+	if o.WeeklyOutflow != nil {
+		l.WeeklyOutflow = o.WeeklyOutflow
+	}
+	if o.MonthlyOutflow != nil {
+		l.MonthlyOutflow = o.MonthlyOutflow
+	}
+	if o.MaxBalance != nil {
+		l.MaxBalance = o.MaxBalance
+	}
+	if o.MaxDailyTransfers != nil {
+		l.MaxDailyTransfers = o.MaxDailyTransfers
+	}
+	return l
+}
+
+// HasPeriodLimits 是否设置了按周期累计的限额, 只有设置时才记录用量
+func (l SpendingLimits) HasPeriodLimits() bool {
+	return l.DailyOutflow != nil || l.WeeklyOutflow != nil || l.MonthlyOutflow != nil || l.MaxDailyTransfers != nil
+}
+
+// LimitUsage 当前自然日/周/月(UTC)内的累计付款金额和转账笔数
+type LimitUsage struct {
+	DailyOutflow   decimal.Decimal `json:"daily_outflow"`
+	WeeklyOutflow  decimal.Decimal `json:"weekly_outflow"`
+	MonthlyOutflow decimal.Decimal `json:"monthly_outflow"`
+	DailyTransfers int             `json:"daily_transfers"`
+}
+
+// Add 计入一笔付款
+func (u LimitUsage) Add(amount decimal.Decimal, transfers int) LimitUsage {
+	return LimitUsage{
+		DailyOutflow:   u.DailyOutflow.Add(amount),
+		WeeklyOutflow:  u.WeeklyOutflow.Add(amount),
+		MonthlyOutflow: u.MonthlyOutflow.Add(amount),
+		DailyTransfers: u.DailyTransfers + transfers,
+	}
+}
+
+// WalletLimits 钱包生效的限额及当前用量
+type WalletLimits struct {
+	UserID   int            `json:"user_id"`
+	Currency Currency       `json:"currency"`
+	Limits   SpendingLimits `json:"limits"`
+	Usage    LimitUsage     `json:"usage"`
+	ResetsAt LimitResets    `json:"resets_at"`
+}
+
+// LimitResets 各周期用量的重置时间
+type LimitResets struct {
+	Daily   time.Time `json:"daily"`
+	Weekly  time.Time `json:"weekly"`
+	Monthly time.Time `json:"monthly"`
+}
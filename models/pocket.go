@@ -0,0 +1,43 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// PocketStatus pocket 状态: active 正常; closed 已关闭, 关闭时余额转回钱包
+type PocketStatus string
+
+const (
+	PocketActive PocketStatus = "active"
+	PocketClosed PocketStatus = "closed"
+)
+
+// Pocket 子钱包: 从某个币种的钱包中划出的命名余额, 只能在同一用户的钱包和 pocket 之间划转.
+// 钱包本身即默认 pocket, 收付款、冻结、限额等仍只作用于钱包
+type Pocket struct {
+	ID        int             `db:"id" json:"id"`
+	UserID    int             `db:"user_id" json:"user_id"`
+	Currency  Currency        `db:"currency" json:"currency"`
+	Name      string          `db:"name" json:"name"`
+	Balance   decimal.Decimal `db:"balance" json:"balance"`
+	Status    PocketStatus    `db:"status" json:"status"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// PocketMove 同一钱包下的划转, pocket id 为空表示默认 pocket(钱包本身)
+type PocketMove struct {
+	FromPocketID *int            `json:"from_pocket_id"`
+	ToPocketID   *int            `json:"to_pocket_id"`
+	Amount       decimal.Decimal `json:"amount"`
+	Currency     Currency        `json:"currency"`
+}
+
+// AggregateBalance 用户某个币种的钱包和所有 pocket 的合计余额
+type AggregateBalance struct {
+	Currency Currency        `json:"currency"`
+	Wallet   Balance         `json:"wallet"`  // 默认 pocket
+	Pockets  []Pocket        `json:"pockets"` // 未关闭的 pocket
+	Total    decimal.Decimal `json:"total"`   // 钱包账面余额 + pocket 余额
+}
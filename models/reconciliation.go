@@ -0,0 +1,71 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// ReconciliationTrigger 对账的触发方式
+type ReconciliationTrigger string
+
+const (
+	ReconciliationSchedule ReconciliationTrigger = "schedule"
+	ReconciliationAdmin    ReconciliationTrigger = "admin"
+	ReconciliationCLI      ReconciliationTrigger = "cli"
+)
+
+// ReconciliationStatus 对账状态
+type ReconciliationStatus string
+
+const (
+	ReconciliationRunning   ReconciliationStatus = "running"
+	ReconciliationCompleted ReconciliationStatus = "completed"
+	ReconciliationFailed    ReconciliationStatus = "failed"
+)
+
+// DriftKind 不一致的类型
+type DriftKind string
+
+const (
+	DriftWalletBalance DriftKind = "wallet_balance" // wallets.balance 与分录之和不符
+	DriftLedgerAccount DriftKind = "ledger_account" // ledger_accounts.balance 与分录之和不符
+	DriftCache         DriftKind = "cache"          // Redis 缓存的余额与 wallets.balance 不符
+)
+
+// ReconciliationRun 一次对账
+type ReconciliationRun struct {
+	ID             int                    `db:"id" json:"id"`
+	Trigger        ReconciliationTrigger  `db:"trigger" json:"trigger"`
+	RepairCache    bool                   `db:"repair_cache" json:"repair_cache"`
+	Status         ReconciliationStatus   `db:"status" json:"status"`
+	WalletsChecked int                    `db:"wallets_checked" json:"wallets_checked"`
+	DriftCount     int                    `db:"drifts" json:"drift_count"`
+	CacheRepaired  int                    `db:"cache_repaired" json:"cache_repaired"`
+	Error          *string                `db:"error" json:"error,omitempty"`
+	StartedAt      time.Time              `db:"started_at" json:"started_at"`
+	FinishedAt     *time.Time             `db:"finished_at" json:"finished_at,omitempty"`
+	Drifts         []ReconciliationDrift  `db:"-" json:"drifts,omitempty"`
+	SystemBalances []SystemAccountBalance `db:"-" json:"system_balances,omitempty"`
+}
+
+// SystemAccountBalance 对账时按分录之和计算的系统账户余额; ledger_accounts 不保存系统账户的累计余额
+type SystemAccountBalance struct {
+	RunID       int             `db:"run_id" json:"-"`
+	AccountCode string          `db:"account_code" json:"account_code"`
+	Currency    Currency        `db:"currency" json:"currency"`
+	Balance     decimal.Decimal `db:"balance" json:"balance"`
+}
+
+// ReconciliationDrift 对账发现的不一致, Difference = Actual - Expected
+type ReconciliationDrift struct {
+	ID         int             `db:"id" json:"id"`
+	RunID      int             `db:"run_id" json:"run_id"`
+	UserID     int             `db:"user_id" json:"user_id"`
+	Currency   Currency        `db:"currency" json:"currency"`
+	Kind       DriftKind       `db:"kind" json:"kind"`
+	Expected   decimal.Decimal `db:"expected" json:"expected"`
+	Actual     decimal.Decimal `db:"actual" json:"actual"`
+	Difference decimal.Decimal `db:"difference" json:"difference"`
+	Repaired   bool            `db:"repaired" json:"repaired"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+}
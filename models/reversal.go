@@ -0,0 +1,33 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// ReversalPolicy 冲正时对方余额不足的处理方式
+type ReversalPolicy string
+
+const (
+	ReversalPolicyReject ReversalPolicy = "reject" // 直接拒绝
+	ReversalPolicyQueue  ReversalPolicy = "queue"  // 排队, 余额足够后由后台任务执行
+)
+
+type PendingReversalStatus string
+
+const (
+	PendingReversalPending   PendingReversalStatus = "pending"
+	PendingReversalCompleted PendingReversalStatus = "completed"
+)
+
+// PendingReversal 排队等待执行的冲正
+type PendingReversal struct {
+	ID                    int                   `db:"id" json:"id"`
+	OriginalTransactionID int                   `db:"original_transaction_id" json:"original_transaction_id"`
+	Amount                decimal.Decimal       `db:"amount" json:"amount"`
+	Status                PendingReversalStatus `db:"status" json:"status"`
+	Attempts              int                   `db:"attempts" json:"attempts"`
+	TransactionID         *int                  `db:"transaction_id" json:"transaction_id,omitempty"`
+	CreatedAt             time.Time             `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time             `db:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// ScheduleFrequency 定时转账的执行频率
+type ScheduleFrequency string
+
+const (
+	ScheduleOnce    ScheduleFrequency = "once"
+	ScheduleDaily   ScheduleFrequency = "daily"
+	ScheduleWeekly  ScheduleFrequency = "weekly"
+	ScheduleMonthly ScheduleFrequency = "monthly" // 每月 start_at 的同一天, 当月没有这一天时取月末
+)
+
+func (f ScheduleFrequency) Valid() bool {
+	switch f {
+	case ScheduleOnce, ScheduleDaily, ScheduleWeekly, ScheduleMonthly:
+		return true
+	}
+	return false
+}
+
+type ScheduleStatus string
+
+const (
+	ScheduleActive    ScheduleStatus = "active"
+	ScheduleCancelled ScheduleStatus = "cancelled"
+	ScheduleCompleted ScheduleStatus = "completed"
+)
+
+type ExecutionStatus string
+
+const (
+	ExecutionPending   ExecutionStatus = "pending"
+	ExecutionSucceeded ExecutionStatus = "succeeded"
+	ExecutionFailed    ExecutionStatus = "failed"
+	ExecutionCancelled ExecutionStatus = "cancelled"
+)
+
+// TransferSchedule 定时/周期转账
+type TransferSchedule struct {
+	ID             int               `db:"id" json:"id"`
+	SenderUserID   int               `db:"sender_user_id" json:"sender_user_id"`
+	ReceiverUserID int               `db:"receiver_user_id" json:"receiver_user_id"`
+	Amount         decimal.Decimal   `db:"amount" json:"amount"`
+	Currency       Currency          `db:"currency" json:"currency"`
+	Frequency      ScheduleFrequency `db:"frequency" json:"frequency"`
+	StartAt        time.Time         `db:"start_at" json:"start_at"`
+	EndAt          *time.Time        `db:"end_at" json:"end_at,omitempty"`
+	Runs           int               `db:"runs" json:"runs"`
+	NextRunAt      *time.Time        `db:"next_run_at" json:"next_run_at,omitempty"`
+	Status         ScheduleStatus    `db:"status" json:"status"`
+	CreatedAt      time.Time         `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time         `db:"updated_at" json:"updated_at"`
+}
+
+// Occurrence 返回第 n 次(从 0 开始)执行的时间, 总是从 start_at 推算, 避免月末日期漂移
+func (s TransferSchedule) Occurrence(n int) time.Time {
+	switch s.Frequency {
+	case ScheduleDaily:
+		return s.StartAt.AddDate(0, 0, n)
+	case ScheduleWeekly:
+		return s.StartAt.AddDate(0, 0, 7*n)
+	case ScheduleMonthly:
+		first := time.Date(s.StartAt.Year(), s.StartAt.Month()+time.Month(n), 1,
+			s.StartAt.Hour(), s.StartAt.Minute(), s.StartAt.Second(), s.StartAt.Nanosecond(), s.StartAt.Location())
+		lastDay := first.AddDate(0, 1, -1).Day()
+		day := s.StartAt.Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		return first.AddDate(0, 0, day-1)
+	default:
+		return s.StartAt
+	}
+}
+
+// NextRun 生成第 runs 次执行后的下一次执行时间, 没有下一次时返回 false
+func (s TransferSchedule) NextRun() (time.Time, bool) {
+	if s.Frequency == ScheduleOnce {
+		return time.Time{}, false
+	}
+	next := s.Occurrence(s.Runs + 1)
+	if s.EndAt != nil && next.After(*s.EndAt) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// ScheduleExecution 定时转账的一次执行
+type ScheduleExecution struct {
+	ID            int             `db:"id" json:"id"`
+	ScheduleID    int             `db:"schedule_id" json:"schedule_id"`
+	ScheduledFor  time.Time       `db:"scheduled_for" json:"scheduled_for"`
+	Status        ExecutionStatus `db:"status" json:"status"`
+	Attempts      int             `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	TransactionID *int            `db:"transaction_id" json:"transaction_id,omitempty"`
+	Error         *string         `db:"error" json:"error,omitempty"`
+	CreatedAt     time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time       `db:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// MemberRole 共享钱包成员角色: owner 管理成员、发起和审批转账; spender 发起和审批转账; viewer 只能查看
+type MemberRole string
+
+const (
+	MemberOwner   MemberRole = "owner"
+	MemberSpender MemberRole = "spender"
+	MemberViewer  MemberRole = "viewer"
+)
+
+func (r MemberRole) Valid() bool {
+	switch r {
+	case MemberOwner, MemberSpender, MemberViewer:
+		return true
+	}
+	return false
+}
+
+// CanSpend 是否可以发起和审批转账
+func (r MemberRole) CanSpend() bool {
+	return r == MemberOwner || r == MemberSpender
+}
+
+// SharedWallet 共享钱包配置, 钱包本身仍是 (user_id, currency) 对应的普通钱包
+type SharedWallet struct {
+	UserID             int             `db:"user_id" json:"user_id"`
+	Currency           Currency        `db:"currency" json:"currency"`
+	RequiredApprovals  int             `db:"required_approvals" json:"required_approvals"`     // M
+	ApprovalThreshold  decimal.Decimal `db:"approval_threshold" json:"approval_threshold"`     // 超过该金额的转账需要审批
+	ApprovalTTLSeconds int             `db:"approval_ttl_seconds" json:"approval_ttl_seconds"` // 审批请求的有效期
+	CreatedAt          time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// WalletMember 共享钱包成员
+type WalletMember struct {
+	UserID       int        `db:"user_id" json:"user_id"`
+	Currency     Currency   `db:"currency" json:"currency"`
+	MemberUserID int        `db:"member_user_id" json:"member_user_id"`
+	Role         MemberRole `db:"role" json:"role"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// ApprovalStatus 审批请求状态: pending 等待审批; approved 已达到 M 个批准, 等待执行;
+// rejected 剩余成员全部批准也达不到 M; expired 到期未达到 M; executed 已转账; failed 转账失败
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	ApprovalExpired  ApprovalStatus = "expired"
+	ApprovalExecuted ApprovalStatus = "executed"
+	ApprovalFailed   ApprovalStatus = "failed"
+)
+
+func (s ApprovalStatus) Valid() bool {
+	switch s {
+	case ApprovalPending, ApprovalApproved, ApprovalRejected, ApprovalExpired, ApprovalExecuted, ApprovalFailed:
+		return true
+	}
+	return false
+}
+
+type Decision string
+
+const (
+	DecisionApprove Decision = "approve"
+	DecisionReject  Decision = "reject"
+)
+
+// TransferApproval 共享钱包超过阈值的转账请求
+type TransferApproval struct {
+	ID                int                `db:"id" json:"id"`
+	UserID            int                `db:"user_id" json:"user_id"`
+	Currency          Currency           `db:"currency" json:"currency"`
+	InitiatorUserID   int                `db:"initiator_user_id" json:"initiator_user_id"`
+	ReceiverUserID    int                `db:"receiver_user_id" json:"receiver_user_id"`
+	Amount            decimal.Decimal    `db:"amount" json:"amount"`
+	RequiredApprovals int                `db:"required_approvals" json:"required_approvals"`
+	Approvals         int                `db:"approvals" json:"approvals"`
+	Rejections        int                `db:"rejections" json:"rejections"`
+	Status            ApprovalStatus     `db:"status" json:"status"`
+	ExpiresAt         time.Time          `db:"expires_at" json:"expires_at"`
+	TransactionID     *int               `db:"transaction_id" json:"transaction_id,omitempty"`
+	Error             *string            `db:"error" json:"error,omitempty"`
+	CreatedAt         time.Time          `db:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `db:"updated_at" json:"updated_at"`
+	Decisions         []ApprovalDecision `db:"-" json:"decisions,omitempty"`
+}
+
+// ApprovalDecision 成员对审批请求的决定
+type ApprovalDecision struct {
+	ID           int       `db:"id" json:"id"`
+	ApprovalID   int       `db:"approval_id" json:"approval_id"`
+	MemberUserID int       `db:"member_user_id" json:"member_user_id"`
+	Decision     Decision  `db:"decision" json:"decision"`
+	Reason       *string   `db:"reason" json:"reason,omitempty"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// SharedTransferResult 共享钱包发起转账的结果: 不超过阈值时直接转账, 否则返回审批请求
+type SharedTransferResult struct {
+	Transaction *TransactionResult `json:"transaction,omitempty"`
+	Approval    *TransferApproval  `json:"approval,omitempty"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// StatementFormat 对账单的导出格式
+type StatementFormat string
+
+const (
+	StatementJSON StatementFormat = "json"
+	StatementCSV  StatementFormat = "csv"
+	StatementHTML StatementFormat = "html"
+)
+
+func (f StatementFormat) Valid() bool {
+	return f == StatementJSON || f == StatementCSV || f == StatementHTML
+}
+
+// Statement 钱包某个币种在 [From, To) 期间的对账单: 期初余额 + 各笔交易 = 期末余额
+type Statement struct {
+	UserID         int             `db:"user_id" json:"user_id"`
+	Currency       Currency        `db:"currency" json:"currency"`
+	From           time.Time       `db:"period_start" json:"from"`
+	To             time.Time       `db:"period_end" json:"to"`
+	OpeningBalance decimal.Decimal `db:"opening_balance" json:"opening_balance"`
+	ClosingBalance decimal.Decimal `db:"closing_balance" json:"closing_balance"`
+	TotalIn        decimal.Decimal `db:"total_in" json:"total_in"`
+	TotalOut       decimal.Decimal `db:"total_out" json:"total_out"`
+	Lines          StatementLines  `db:"lines" json:"lines"`
+	GeneratedAt    time.Time       `db:"generated_at" json:"generated_at"`
+}
+
+// StatementLine 对账单的一行, 对应一条影响钱包余额的分录; Balance 为入账后的余额
+type StatementLine struct {
+	Time              time.Time       `db:"time" json:"time"`
+	TransactionID     *int            `db:"transaction_id" json:"transaction_id,omitempty"`
+	TransactionType   TransactionType `db:"transaction_type" json:"transaction_type,omitempty"`
+	CounterpartyID    *int            `db:"counterparty_user_id" json:"counterparty_user_id,omitempty"`
+	Description       string          `db:"description" json:"description"`
+	ExternalReference *string         `db:"external_reference" json:"external_reference,omitempty"`
+	Amount            decimal.Decimal `db:"amount" json:"amount"`
+	Balance           decimal.Decimal `db:"balance" json:"balance"`
+}
+
+// StatementLines 预生成的对账单明细, 以 JSONB 保存
+type StatementLines []StatementLine
+
+// Value 以文本传给数据库, 避免 []byte 被当作 bytea
+func (l StatementLines) Value() (driver.Value, error) {
+	if l == nil {
+		l = StatementLines{}
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 读取 JSONB 列
+func (l *StatementLines) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("cannot scan %T into StatementLines", src)
+	}
+}
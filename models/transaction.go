@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"github.com/shopspring/decimal"
 	"time"
 )
@@ -11,13 +14,183 @@ const (
 	DepositTransactionType  TransactionType = "deposit"
 	WithdrawTransactionType TransactionType = "withdraw"
 	TransferTransactionType TransactionType = "transfer"
+	// 换汇转账的两条流水: 付款方币种扣款, 收款方币种入账
+	ExchangeOutTransactionType TransactionType = "exchange_out"
+	ExchangeInTransactionType  TransactionType = "exchange_in"
+	CaptureTransactionType     TransactionType = "capture"  // 预授权扣款
+	ReversalTransactionType    TransactionType = "reversal" // 冲正/退款
+	FeeTransactionType         TransactionType = "fee"      // 手续费, 关联收费的交易
+	InterestTransactionType    TransactionType = "interest" // 按月派发的利息
+	// 按月收取的透支利息
+	OverdraftInterestTransactionType TransactionType = "overdraft_interest"
+	// 同一钱包下钱包与 pocket 之间的划转
+	PocketTransferTransactionType TransactionType = "pocket_transfer"
+	// 托管: 买方资金转入托管账户, 之后放款给卖方或退款给买方
+	EscrowFundTransactionType    TransactionType = "escrow_fund"
+	EscrowReleaseTransactionType TransactionType = "escrow_release"
+	EscrowRefundTransactionType  TransactionType = "escrow_refund"
+	// 把系统账户中累计的手续费收入划入收入钱包
+	FeeSweepTransactionType TransactionType = "fee_sweep"
+)
+
+// Valid 是否为已知的交易类型
+func (t TransactionType) Valid() bool {
+	switch t {
+	case DepositTransactionType, WithdrawTransactionType, TransferTransactionType, ExchangeOutTransactionType,
+		ExchangeInTransactionType, CaptureTransactionType, ReversalTransactionType, FeeTransactionType,
+		InterestTransactionType, OverdraftInterestTransactionType, PocketTransferTransactionType,
+		EscrowFundTransactionType, EscrowReleaseTransactionType, EscrowRefundTransactionType, FeeSweepTransactionType:
+		return true
+	}
+	return false
+}
+
+// TransactionStatus 交易状态: pending 处理中(资金已冻结, 账面余额不变) -> completed 已完成 / failed 已失败;
+// completed -> reversed 已全额冲正. failed 和 reversed 为终态
+type TransactionStatus string
+
+const (
+	TransactionPending   TransactionStatus = "pending"
+	TransactionCompleted TransactionStatus = "completed"
+	TransactionFailed    TransactionStatus = "failed"
+	TransactionReversed  TransactionStatus = "reversed"
+)
+
+// transactionTransitions 允许的状态迁移
+var transactionTransitions = map[TransactionStatus][]TransactionStatus{
+	TransactionPending:   {TransactionCompleted, TransactionFailed},
+	TransactionCompleted: {TransactionReversed},
+}
+
+// CanTransitionTo 是否允许从当前状态迁移到 next
+func (s TransactionStatus) CanTransitionTo(next TransactionStatus) bool {
+	for _, allowed := range transactionTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// TransactionDirection 交易方向: in 资金流入, out 资金流出
+type TransactionDirection string
+
+const (
+	DirectionIn  TransactionDirection = "in"
+	DirectionOut TransactionDirection = "out"
 )
 
 type Transaction struct {
-	ID              int             `db:"id"`
-	SenderUserID    int             `db:"sender_user_id"`
-	ReceiverUserID  int             `db:"receiver_user_id"`
-	TransactionType TransactionType `db:"transaction_type"` // "deposit", "withdraw", "transfer"
-	Amount          decimal.Decimal `db:"amount"`           // 使用 decimal.Decimal 处理金额
-	CreatedAt       time.Time       `db:"created_at"`
+	ID                    int               `db:"id"`
+	SenderUserID          int               `db:"sender_user_id"`
+	ReceiverUserID        int               `db:"receiver_user_id"`
+	TransactionType       TransactionType   `db:"transaction_type"` // "deposit", "withdraw", "transfer"
+	Amount                decimal.Decimal   `db:"amount"`           // 使用 decimal.Decimal 处理金额
+	Currency              Currency          `db:"currency"`
+	FXRate                *decimal.Decimal  `db:"fx_rate"`                 // 换汇成交汇率, 非换汇交易为空
+	FXQuoteID             *string           `db:"fx_quote_id"`             // 换汇使用的报价
+	OriginalTransactionID *int              `db:"original_transaction_id"` // 冲正或手续费流水对应的原交易
+	Memo                  *string           `db:"memo"`
+	ExternalReference     *string           `db:"external_reference"` // 调用方系统中的单号, 同一用户内唯一
+	Metadata              Metadata          `db:"metadata"`
+	Status                TransactionStatus `db:"status"`
+	FailureReason         *string           `db:"failure_reason"` // 失败原因, 仅 failed 状态有值
+	CreatedAt             time.Time         `db:"created_at"`
+	CompletedAt           *time.Time        `db:"completed_at"` // 各状态的迁移时间
+	FailedAt              *time.Time        `db:"failed_at"`
+	ReversedAt            *time.Time        `db:"reversed_at"`
+}
+
+// TransactionDetails 存款/取款/转账请求中可选的附加信息, 随流水保存
+type TransactionDetails struct {
+	Memo              string   `json:"memo"`
+	ExternalReference string   `json:"external_reference"`
+	Metadata          Metadata `json:"metadata"` // 任意 JSON 对象
+}
+
+// IsZero 是否没有任何附加信息
+func (d TransactionDetails) IsZero() bool {
+	return d.Memo == "" && d.ExternalReference == "" && len(d.Metadata) == 0
+}
+
+// Metadata 自定义 JSON 元数据, 以 JSONB 保存, 为空时存为 NULL
+type Metadata json.RawMessage
+
+// MarshalJSON 原样输出, 为空时输出 null
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte("null"), nil
+	}
+	return m, nil
+}
+
+// UnmarshalJSON 保存原始 JSON, null 视为空
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = nil
+		return nil
+	}
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+// Value 以文本传给数据库, 避免 []byte 被当作 bytea
+func (m Metadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return string(m), nil
+}
+
+// Scan 读取 JSONB 列
+func (m *Metadata) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+	case []byte:
+		*m = append((*m)[0:0], v...)
+	case string:
+		*m = Metadata(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", src)
+	}
+	return nil
+}
+
+// TransactionResult 存款/取款/转账的处理结果, 同时作为幂等键的响应内容保存
+type TransactionResult struct {
+	TransactionID     int                  `json:"transaction_id"`
+	Exchange          *ExchangeResult      `json:"exchange,omitempty"`            // 换汇转账的入账信息
+	HoldID            int                  `json:"hold_id,omitempty"`             // 预授权冻结ID
+	PendingReversalID int                  `json:"pending_reversal_id,omitempty"` // 冲正因对方余额不足而排队, 此时 TransactionID 为 0
+	Batch             *BatchTransferResult `json:"batch,omitempty"`               // 批量转账的明细结果
+	Fee               *FeeResult           `json:"fee,omitempty"`                 // 收取的手续费
+	FeeRefund         *FeeResult           `json:"fee_refund,omitempty"`          // 冲正按比例退还的手续费
+	Replayed          bool                 `json:"-"`                             // 是否为幂等重放的结果
+}
+
+// FeeResult 随交易收取的手续费, 记为关联原交易的 fee 流水
+type FeeResult struct {
+	TransactionID int             `json:"transaction_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      Currency        `json:"currency"`
+}
+
+// TransactionFilter 交易历史查询条件, 零值字段表示不过滤
+type TransactionFilter struct {
+	Types             []TransactionType
+	Direction         TransactionDirection
+	From              *time.Time // 包含
+	To                *time.Time // 不包含
+	MinAmount         *decimal.Decimal
+	MaxAmount         *decimal.Decimal
+	ExternalReference string // 按调用方单号精确查找
+	PageToken         string // 上一页返回的 next_page_token
+	Limit             int
+}
+
+// TransactionPage 一页交易历史, 按时间倒序
+type TransactionPage struct {
+	Transactions  []Transaction `json:"transactions"`
+	NextPageToken string        `json:"next_page_token,omitempty"` // 为空表示没有下一页
 }
@@ -5,9 +5,92 @@ import (
 	"time"
 )
 
+// WalletStatus 钱包状态: active 正常; frozen 冻结, 只能收款不能付款; closed 已注销, 拒绝所有操作.
+// active <-> frozen, active / frozen -> closed, closed 为终态
+type WalletStatus string
+
+const (
+	WalletActive WalletStatus = "active"
+	WalletFrozen WalletStatus = "frozen"
+	WalletClosed WalletStatus = "closed"
+)
+
+// walletTransitions 允许的状态迁移
+var walletTransitions = map[WalletStatus][]WalletStatus{
+	WalletActive: {WalletFrozen, WalletClosed},
+	WalletFrozen: {WalletActive, WalletClosed},
+}
+
+// CanTransitionTo 是否允许从当前状态迁移到 next
+func (s WalletStatus) CanTransitionTo(next WalletStatus) bool {
+	for _, allowed := range walletTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
 type Wallet struct {
-	UserID    int             `db:"user_id" json:"user_id"`
-	Balance   decimal.Decimal `db:"balance" json:"balance"` // 使用 decimal.Decimal 处理金额
-	CreatedAt time.Time       `db:"created_at" json:"created_at"`
-	UpdatedAt time.Time       `db:"updated_at" json:"updated_at"`
+	UserID      int             `db:"user_id" json:"user_id"`
+	Currency    Currency        `db:"currency" json:"currency"`
+	Balance     decimal.Decimal `db:"balance" json:"balance"`           // 账面余额, 使用 decimal.Decimal 处理金额
+	HeldBalance decimal.Decimal `db:"held_balance" json:"held_balance"` // 预授权冻结的金额
+	CreditLimit decimal.Decimal `db:"credit_limit" json:"credit_limit"` // 授信额度, 账面余额最低可透支到 -credit_limit
+	Status      WalletStatus    `db:"status" json:"status"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// Available 可用余额 = 账面余额 + 授信额度 - 冻结金额
+func (w Wallet) Available() decimal.Decimal {
+	return w.Balance.Add(w.CreditLimit).Sub(w.HeldBalance)
+}
+
+// Credit 授信额度的使用情况, 没有授信额度时返回 nil
+func (w Wallet) Credit() *CreditUsage {
+	if !w.CreditLimit.IsPositive() {
+		return nil
+	}
+	// 冻结金额同样占用额度
+	used := decimal.Max(w.HeldBalance.Sub(w.Balance), decimal.Zero)
+	return &CreditUsage{
+		Limit:       w.CreditLimit,
+		Used:        used,
+		Utilization: used.DivRound(w.CreditLimit, 4),
+	}
+}
+
+// CreditUsage 授信额度、已使用的额度和使用率
+type CreditUsage struct {
+	Limit       decimal.Decimal `json:"credit_limit"`
+	Used        decimal.Decimal `json:"credit_used"`
+	Utilization decimal.Decimal `json:"utilization"` // 已使用 / 额度, 0.25 即 25%
+}
+
+// WalletStatusChange 钱包状态变更记录
+type WalletStatusChange struct {
+	ID         int          `db:"id" json:"id"`
+	UserID     int          `db:"user_id" json:"user_id"`
+	Currency   Currency     `db:"currency" json:"currency"`
+	FromStatus WalletStatus `db:"from_status" json:"from_status"`
+	ToStatus   WalletStatus `db:"to_status" json:"to_status"`
+	Reason     string       `db:"reason" json:"reason"`
+	CreatedAt  time.Time    `db:"created_at" json:"created_at"`
+}
+
+// Balance 钱包某个币种的账面余额与可用余额
+type Balance struct {
+	Currency  Currency        `json:"currency"`
+	Ledger    decimal.Decimal `json:"balance"`
+	Held      decimal.Decimal `json:"held_balance"`
+	Available decimal.Decimal `json:"available_balance"`
+	Credit    *CreditUsage    `json:"credit,omitempty"`
+}
+
+// HistoricalBalance 钱包某个币种在某一时刻的账面余额
+type HistoricalBalance struct {
+	Currency Currency        `json:"currency"`
+	Ledger   decimal.Decimal `json:"balance"`
+	At       time.Time       `json:"at"`
 }
@@ -29,8 +29,117 @@ type Redis struct {
 	ConnMaxIdleTime int    `mapstructure:"conn_max_idle_time" yaml:"conn_max_idle_time"` // 连接的最大空闲时间 单位秒
 }
 
+// Idempotency 幂等键配置
+type Idempotency struct {
+	RetentionHours int `mapstructure:"retention_hours" yaml:"retention_hours"` // 幂等键保留时长 单位小时
+}
+
+// FX 换汇配置
+type FX struct {
+	QuoteTTLSeconds int `mapstructure:"quote_ttl_seconds" yaml:"quote_ttl_seconds"` // 报价锁定汇率的时长 单位秒
+}
+
+// Holds 预授权冻结配置
+type Holds struct {
+	DefaultTTLSeconds int `mapstructure:"default_ttl_seconds" yaml:"default_ttl_seconds"` // 未指定有效期时的默认时长 单位秒
+}
+
+// Reversal 冲正配置
+type Reversal struct {
+	InsufficientFundsPolicy string `mapstructure:"insufficient_funds_policy" yaml:"insufficient_funds_policy"` // 对方余额不足时的默认处理方式 reject/queue
+}
+
+// Schedules 定时转账配置
+type Schedules struct {
+	MaxRetries           int `mapstructure:"max_retries" yaml:"max_retries"`                       // 余额不足时的最大重试次数
+	RetryIntervalSeconds int `mapstructure:"retry_interval_seconds" yaml:"retry_interval_seconds"` // 余额不足时的重试间隔 单位秒
+}
+
+// Batch 批量转账配置
+type Batch struct {
+	MaxItems int `mapstructure:"max_items" yaml:"max_items"` // 单个批次最多明细数
+}
+
+// LimitTier 某个等级在某个币种下的限额, 金额为空、笔数为 0 表示不限制
+type LimitTier struct {
+	MaxSingleAmount   string `mapstructure:"max_single_amount" yaml:"max_single_amount"`     // 单笔付款上限
+	DailyOutflow      string `mapstructure:"daily_outflow" yaml:"daily_outflow"`             // 每日付款总额
+	WeeklyOutflow     string `mapstructure:"weekly_outflow" yaml:"weekly_outflow"`           // 每周付款总额
+	MonthlyOutflow    string `mapstructure:"monthly_outflow" yaml:"monthly_outflow"`         // 每月付款总额
+	MaxBalance        string `mapstructure:"max_balance" yaml:"max_balance"`                 // 余额上限
+	MaxDailyTransfers int    `mapstructure:"max_daily_transfers" yaml:"max_daily_transfers"` // 每日转账笔数
+}
+
+// Limits 钱包限额配置
+type Limits struct {
+	DefaultTier string                          `mapstructure:"default_tier" yaml:"default_tier"` // 未指定等级的钱包使用的等级
+	Tiers       map[string]map[string]LimitTier `mapstructure:"tiers" yaml:"tiers"`               // 等级 -> 币种 -> 限额
+}
+
+// FeeTier 按交易金额分档的费率, 取 FromAmount 不超过交易金额的最高一档
+type FeeTier struct {
+	FromAmount string `mapstructure:"from_amount" yaml:"from_amount"` // 本档起始金额(含)
+	Flat       string `mapstructure:"flat" yaml:"flat"`               // 固定费用
+	Percentage string `mapstructure:"percentage" yaml:"percentage"`   // 按金额比例收取, 0.01 即 1%
+}
+
+// FeeRule 某个交易类型在某个币种下的收费规则, 设置了分档时使用分档的费率
+type FeeRule struct {
+	TransactionType string    `mapstructure:"transaction_type" yaml:"transaction_type"` // withdraw/transfer/exchange_out/capture
+	Currency        string    `mapstructure:"currency" yaml:"currency"`
+	Flat            string    `mapstructure:"flat" yaml:"flat"`             // 固定费用
+	Percentage      string    `mapstructure:"percentage" yaml:"percentage"` // 按金额比例收取, 0.01 即 1%
+	Min             string    `mapstructure:"min" yaml:"min"`               // 最低收费
+	Max             string    `mapstructure:"max" yaml:"max"`               // 最高收费
+	Tiers           []FeeTier `mapstructure:"tiers" yaml:"tiers"`
+}
+
+// Fees 手续费配置
+type Fees struct {
+	RevenueUserID int       `mapstructure:"revenue_user_id" yaml:"revenue_user_id"` // 手续费收入钱包的用户ID, 为 0 时不收取手续费
+	Rules         []FeeRule `mapstructure:"rules" yaml:"rules"`
+}
+
+// Interest 计息配置
+type Interest struct {
+	Rates          map[string]string `mapstructure:"rates" yaml:"rates"`                     // 币种 -> 年化利率, 0.02 即 2%; 未配置的币种不计息
+	OverdraftRates map[string]string `mapstructure:"overdraft_rates" yaml:"overdraft_rates"` // 币种 -> 透支的年化利率, 按负余额计提; 未配置的币种不收取透支利息
+	DayCount       string            `mapstructure:"day_count" yaml:"day_count"`             // 计息基准 actual/365、actual/360 或 actual/actual
+}
+
+// BalanceCache 余额缓存配置
+type BalanceCache struct {
+	TTLSeconds int `mapstructure:"ttl_seconds" yaml:"ttl_seconds"` // 缓存的有效期 单位秒, 提交后更新缓存失败时旧值最多保留这么久
+}
+
+// Reconciliation 对账配置
+type Reconciliation struct {
+	RepairCache bool `mapstructure:"repair_cache" yaml:"repair_cache"` // 定时对账是否删除与数据库不一致的余额缓存
+}
+
+// Outbox 领域事件发布配置
+type Outbox struct {
+	Sink           string `mapstructure:"sink" yaml:"sink"`                       // 发布目标 redis(默认) 或 file
+	Stream         string `mapstructure:"stream" yaml:"stream"`                   // Redis Stream 的键
+	StreamMaxLen   int64  `mapstructure:"stream_max_len" yaml:"stream_max_len"`   // Redis Stream 近似保留的事件数
+	FilePath       string `mapstructure:"file_path" yaml:"file_path"`             // file 发布目标的文件路径
+	RetentionHours int    `mapstructure:"retention_hours" yaml:"retention_hours"` // 已发布事件的保留时长 单位小时
+}
+
 type ServerConfig struct {
-	WalletService ServiceConfig `mapstructure:"wallet_service" yaml:"wallet_service"`
-	Postgres      Postgres      `mapstructure:"postgres" yaml:"postgres"`
-	Redis         Redis         `mapstructure:"redis" yaml:"redis"`
+	WalletService  ServiceConfig  `mapstructure:"wallet_service" yaml:"wallet_service"`
+	Postgres       Postgres       `mapstructure:"postgres" yaml:"postgres"`
+	Redis          Redis          `mapstructure:"redis" yaml:"redis"`
+	Idempotency    Idempotency    `mapstructure:"idempotency" yaml:"idempotency"`
+	FX             FX             `mapstructure:"fx" yaml:"fx"`
+	Holds          Holds          `mapstructure:"holds" yaml:"holds"`
+	Reversal       Reversal       `mapstructure:"reversal" yaml:"reversal"`
+	Schedules      Schedules      `mapstructure:"schedules" yaml:"schedules"`
+	Batch          Batch          `mapstructure:"batch" yaml:"batch"`
+	Limits         Limits         `mapstructure:"limits" yaml:"limits"`
+	Fees           Fees           `mapstructure:"fees" yaml:"fees"`
+	Interest       Interest       `mapstructure:"interest" yaml:"interest"`
+	Reconciliation Reconciliation `mapstructure:"reconciliation" yaml:"reconciliation"`
+	BalanceCache   BalanceCache   `mapstructure:"balance_cache" yaml:"balance_cache"`
+	Outbox         Outbox         `mapstructure:"outbox" yaml:"outbox"`
 }
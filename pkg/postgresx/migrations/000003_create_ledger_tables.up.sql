@@ -0,0 +1,75 @@
+CREATE TABLE ledger_accounts (
+                                 id SERIAL PRIMARY KEY,
+                                 code VARCHAR(64) NOT NULL UNIQUE,
+                                 account_type VARCHAR(20) NOT NULL CHECK (account_type IN ('wallet', 'system')),
+                                 user_id INT NULL,
+                                 balance NUMERIC(20, 8) NOT NULL DEFAULT 0,
+                                 created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                 updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER set_ledger_accounts_updated_at
+    BEFORE UPDATE ON ledger_accounts
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE journal_entries (
+                                 id SERIAL PRIMARY KEY,
+                                 transaction_id INT NULL REFERENCES transactions (id),
+                                 description VARCHAR(255) NOT NULL DEFAULT '',
+                                 created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_journal_entries_transaction_id ON journal_entries (transaction_id);
+
+CREATE TABLE postings (
+                          id SERIAL PRIMARY KEY,
+                          journal_entry_id INT NOT NULL REFERENCES journal_entries (id),
+                          account_code VARCHAR(64) NOT NULL REFERENCES ledger_accounts (code),
+                          amount NUMERIC(20, 8) NOT NULL CHECK (amount <> 0), -- 正数为入账(贷), 负数为出账(借)
+                          created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_postings_journal_entry_id ON postings (journal_entry_id);
+CREATE INDEX idx_postings_account_code ON postings (account_code);
+
+-- 每条分录的 postings 之和必须为零, 在事务提交时校验
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT COALESCE(SUM(amount), 0) FROM postings WHERE journal_entry_id = NEW.journal_entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.journal_entry_id;
+    END IF;
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT OR UPDATE ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    EXECUTE FUNCTION check_journal_entry_balanced();
+
+-- 系统账户
+INSERT INTO ledger_accounts (code, account_type) VALUES
+    ('system:external_cash_in', 'system'),
+    ('system:external_cash_out', 'system'),
+    ('system:opening_balance', 'system');
+
+-- 为已有钱包建立账户, 并以期初余额分录平衡
+INSERT INTO ledger_accounts (code, account_type, user_id, balance)
+SELECT 'wallet:' || user_id, 'wallet', user_id, balance FROM wallets;
+
+UPDATE ledger_accounts
+SET balance = -(SELECT COALESCE(SUM(balance), 0) FROM wallets)
+WHERE code = 'system:opening_balance';
+
+WITH entry AS (
+    INSERT INTO journal_entries (description)
+    SELECT 'opening balance' WHERE EXISTS (SELECT 1 FROM wallets WHERE balance <> 0)
+    RETURNING id
+)
+INSERT INTO postings (journal_entry_id, account_code, amount)
+SELECT entry.id, 'wallet:' || w.user_id, w.balance FROM entry, wallets w WHERE w.balance <> 0
+UNION ALL
+SELECT entry.id, 'system:opening_balance', -SUM(w.balance) FROM entry, wallets w WHERE w.balance <> 0 GROUP BY entry.id;
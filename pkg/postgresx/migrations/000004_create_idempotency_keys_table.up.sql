@@ -0,0 +1,10 @@
+CREATE TABLE idempotency_keys (
+                                  idempotency_key VARCHAR(255) PRIMARY KEY,
+                                  request_hash CHAR(64) NOT NULL,        -- 请求内容的 sha256, 用于识别同一个 key 的不同请求
+                                  status_code INT NULL,
+                                  response_body JSONB NULL,
+                                  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,32 @@
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT COALESCE(SUM(amount), 0) FROM postings WHERE journal_entry_id = NEW.journal_entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.journal_entry_id;
+    END IF;
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- 回退后只保留 USD 数据
+DELETE FROM postings WHERE journal_entry_id IN (
+    SELECT p.journal_entry_id FROM postings p
+    JOIN ledger_accounts la ON la.code = p.account_code
+    WHERE la.currency <> 'USD'
+);
+DELETE FROM journal_entries WHERE transaction_id IN (SELECT id FROM transactions WHERE currency <> 'USD');
+DELETE FROM ledger_accounts WHERE currency <> 'USD';
+DELETE FROM transactions WHERE currency <> 'USD';
+DELETE FROM wallets WHERE currency <> 'USD';
+
+ALTER TABLE postings DROP CONSTRAINT postings_account_code_fkey;
+UPDATE postings SET account_code = left(account_code, length(account_code) - 4);
+UPDATE ledger_accounts SET code = left(code, length(code) - 4);
+ALTER TABLE postings ADD CONSTRAINT postings_account_code_fkey FOREIGN KEY (account_code) REFERENCES ledger_accounts (code);
+ALTER TABLE ledger_accounts DROP COLUMN currency;
+
+ALTER TABLE transactions DROP COLUMN currency;
+
+ALTER TABLE wallets DROP CONSTRAINT wallets_pkey;
+ALTER TABLE wallets ADD PRIMARY KEY (user_id);
+ALTER TABLE wallets DROP COLUMN currency;
@@ -0,0 +1,36 @@
+-- 已有数据视为 USD
+ALTER TABLE wallets ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE wallets ALTER COLUMN currency DROP DEFAULT;
+
+-- 一个用户每个币种一个余额
+ALTER TABLE wallets DROP CONSTRAINT wallets_pkey;
+ALTER TABLE wallets ADD PRIMARY KEY (user_id, currency);
+
+ALTER TABLE transactions ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE transactions ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE ledger_accounts ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE ledger_accounts ALTER COLUMN currency DROP DEFAULT;
+
+-- 账户编码带上币种: wallet:<user_id>:<currency>, system:<name>:<currency>
+ALTER TABLE postings DROP CONSTRAINT postings_account_code_fkey;
+UPDATE ledger_accounts SET code = code || ':USD';
+UPDATE postings SET account_code = account_code || ':USD';
+ALTER TABLE postings ADD CONSTRAINT postings_account_code_fkey FOREIGN KEY (account_code) REFERENCES ledger_accounts (code);
+
+-- 同一分录内每个币种分别平衡
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM postings p
+        JOIN ledger_accounts la ON la.code = p.account_code
+        WHERE p.journal_entry_id = NEW.journal_entry_id
+        GROUP BY la.currency
+        HAVING SUM(p.amount) <> 0
+    ) THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.journal_entry_id;
+    END IF;
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
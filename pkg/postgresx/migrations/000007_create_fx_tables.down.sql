@@ -0,0 +1,19 @@
+DELETE FROM postings WHERE journal_entry_id IN (
+    SELECT je.id FROM journal_entries je
+    JOIN transactions t ON t.id = je.transaction_id
+    WHERE t.transaction_type IN ('exchange_out', 'exchange_in')
+);
+DELETE FROM journal_entries WHERE transaction_id IN (
+    SELECT id FROM transactions WHERE transaction_type IN ('exchange_out', 'exchange_in')
+);
+DELETE FROM transactions WHERE transaction_type IN ('exchange_out', 'exchange_in');
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer'));
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS fx_quote_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS fx_rate;
+
+DROP TABLE IF EXISTS fx_quotes;
+DROP TABLE IF EXISTS fx_rates;
@@ -0,0 +1,36 @@
+-- 汇率表: 1 单位 base_currency 兑换 rate 单位 quote_currency, 在 [valid_from, valid_to) 内有效
+CREATE TABLE fx_rates (
+                          id SERIAL PRIMARY KEY,
+                          base_currency CHAR(3) NOT NULL,
+                          quote_currency CHAR(3) NOT NULL,
+                          rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),                     -- 中间价
+                          spread NUMERIC(10, 6) NOT NULL DEFAULT 0 CHECK (spread >= 0 AND spread < 1), -- 点差比例, 客户成交价 = rate * (1 - spread)
+                          valid_from TIMESTAMP NOT NULL,
+                          valid_to TIMESTAMP NULL,
+                          created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                          CHECK (base_currency <> quote_currency),
+                          CHECK (valid_to IS NULL OR valid_to > valid_from)
+);
+
+CREATE INDEX idx_fx_rates_pair_valid_from ON fx_rates (base_currency, quote_currency, valid_from DESC);
+
+-- 报价: 锁定某一时刻的汇率, 在 expires_at 之前可以使用一次
+CREATE TABLE fx_quotes (
+                           id VARCHAR(36) PRIMARY KEY,
+                           rate_id INT NOT NULL REFERENCES fx_rates (id),
+                           base_currency CHAR(3) NOT NULL,
+                           quote_currency CHAR(3) NOT NULL,
+                           rate NUMERIC(20, 10) NOT NULL,
+                           spread NUMERIC(10, 6) NOT NULL,
+                           expires_at TIMESTAMP NOT NULL,
+                           used_at TIMESTAMP NULL,
+                           created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 换汇转账的两条流水记录成交汇率和报价
+ALTER TABLE transactions ADD COLUMN fx_rate NUMERIC(20, 10) NULL;
+ALTER TABLE transactions ADD COLUMN fx_quote_id VARCHAR(36) NULL REFERENCES fx_quotes (id);
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in'));
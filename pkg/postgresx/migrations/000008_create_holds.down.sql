@@ -0,0 +1,11 @@
+-- 已扣款的流水改记为取款, 保留分录
+UPDATE transactions SET transaction_type = 'withdraw' WHERE transaction_type = 'capture';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in'));
+
+DROP TABLE IF EXISTS holds;
+
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS wallets_held_balance_valid;
+ALTER TABLE wallets DROP COLUMN IF EXISTS held_balance;
@@ -0,0 +1,29 @@
+-- 冻结金额: 可用余额 = balance - held_balance
+ALTER TABLE wallets ADD COLUMN held_balance NUMERIC(20, 8) NOT NULL DEFAULT 0;
+ALTER TABLE wallets ADD CONSTRAINT wallets_held_balance_valid CHECK (held_balance >= 0 AND held_balance <= balance);
+
+CREATE TABLE holds (
+                       id SERIAL PRIMARY KEY,
+                       user_id INT NOT NULL,
+                       currency CHAR(3) NOT NULL,
+                       amount NUMERIC(20, 8) NOT NULL CHECK (amount > 0),
+                       captured_amount NUMERIC(20, 8) NULL,
+                       status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+                       transaction_id INT NULL REFERENCES transactions (id), -- 扣款产生的流水
+                       expires_at TIMESTAMP NOT NULL,
+                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                       updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                       FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
+
+CREATE INDEX idx_holds_user_id_currency ON holds (user_id, currency);
+CREATE INDEX idx_holds_active_expires_at ON holds (expires_at) WHERE status = 'active';
+
+CREATE TRIGGER set_holds_updated_at
+    BEFORE UPDATE ON holds
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture'));
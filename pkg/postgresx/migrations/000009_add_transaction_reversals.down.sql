@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS pending_reversals;
+
+-- 回退后冲正流水记为 transfer, 分录保持不变
+UPDATE transactions SET transaction_type = 'transfer' WHERE transaction_type = 'reversal';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture'));
+
+DROP INDEX IF EXISTS idx_transactions_original_transaction_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS original_transaction_id;
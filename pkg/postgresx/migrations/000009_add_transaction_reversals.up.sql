@@ -0,0 +1,27 @@
+-- 冲正流水关联原交易
+ALTER TABLE transactions ADD COLUMN original_transaction_id INT NULL REFERENCES transactions (id);
+CREATE INDEX idx_transactions_original_transaction_id ON transactions (original_transaction_id) WHERE original_transaction_id IS NOT NULL;
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal'));
+
+-- 对方余额不足时排队等待执行的冲正
+CREATE TABLE pending_reversals (
+                                   id SERIAL PRIMARY KEY,
+                                   original_transaction_id INT NOT NULL REFERENCES transactions (id),
+                                   amount NUMERIC(20, 8) NOT NULL CHECK (amount > 0),
+                                   status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'completed')),
+                                   attempts INT NOT NULL DEFAULT 0,
+                                   transaction_id INT NULL REFERENCES transactions (id), -- 执行后产生的冲正流水
+                                   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_pending_reversals_original_transaction_id ON pending_reversals (original_transaction_id);
+CREATE INDEX idx_pending_reversals_pending ON pending_reversals (id) WHERE status = 'pending';
+
+CREATE TRIGGER set_pending_reversals_updated_at
+    BEFORE UPDATE ON pending_reversals
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,47 @@
+-- 定时/周期转账
+CREATE TABLE transfer_schedules (
+                                    id SERIAL PRIMARY KEY,
+                                    sender_user_id INT NOT NULL,
+                                    receiver_user_id INT NOT NULL,
+                                    amount NUMERIC(20, 8) NOT NULL CHECK (amount > 0),
+                                    currency CHAR(3) NOT NULL,
+                                    frequency VARCHAR(20) NOT NULL CHECK (frequency IN ('once', 'daily', 'weekly', 'monthly')),
+                                    start_at TIMESTAMP NOT NULL,
+                                    end_at TIMESTAMP NULL, -- 为空表示不限结束时间
+                                    runs INT NOT NULL DEFAULT 0, -- 已生成的执行次数, 用于从 start_at 推算下次执行时间
+                                    next_run_at TIMESTAMP NULL, -- 为空表示不再执行
+                                    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'cancelled', 'completed')),
+                                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                    CHECK (sender_user_id <> receiver_user_id)
+);
+
+CREATE INDEX idx_transfer_schedules_sender_user_id ON transfer_schedules (sender_user_id);
+CREATE INDEX idx_transfer_schedules_due ON transfer_schedules (next_run_at) WHERE status = 'active';
+
+CREATE TRIGGER set_transfer_schedules_updated_at
+    BEFORE UPDATE ON transfer_schedules
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- 每次执行一行, (schedule_id, scheduled_for) 唯一保证同一次执行只生成一次
+CREATE TABLE schedule_executions (
+                                     id SERIAL PRIMARY KEY,
+                                     schedule_id INT NOT NULL REFERENCES transfer_schedules (id),
+                                     scheduled_for TIMESTAMP NOT NULL,
+                                     status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed', 'cancelled')),
+                                     attempts INT NOT NULL DEFAULT 0,
+                                     next_attempt_at TIMESTAMP NOT NULL, -- 下次尝试时间, 执行中时为租约到期时间
+                                     transaction_id INT NULL REFERENCES transactions (id),
+                                     error TEXT NULL,
+                                     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                     UNIQUE (schedule_id, scheduled_for)
+);
+
+CREATE INDEX idx_schedule_executions_pending ON schedule_executions (next_attempt_at) WHERE status = 'pending';
+
+CREATE TRIGGER set_schedule_executions_updated_at
+    BEFORE UPDATE ON schedule_executions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,26 @@
+-- 批量转账(代发)
+CREATE TABLE transfer_batches (
+                                  id SERIAL PRIMARY KEY,
+                                  sender_user_id INT NOT NULL,
+                                  currency CHAR(3) NOT NULL,
+                                  mode VARCHAR(20) NOT NULL CHECK (mode IN ('all_or_nothing', 'best_effort')),
+                                  total_amount NUMERIC(20, 8) NOT NULL DEFAULT 0, -- 实际转出的总金额
+                                  item_count INT NOT NULL,
+                                  succeeded_count INT NOT NULL,
+                                  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_transfer_batches_sender_user_id ON transfer_batches (sender_user_id);
+
+CREATE TABLE transfer_batch_items (
+                                      id SERIAL PRIMARY KEY,
+                                      batch_id INT NOT NULL REFERENCES transfer_batches (id),
+                                      item_index INT NOT NULL,
+                                      receiver_user_id INT NOT NULL,
+                                      amount NUMERIC(20, 8) NOT NULL,
+                                      reference VARCHAR(255) NOT NULL DEFAULT '',
+                                      status VARCHAR(20) NOT NULL CHECK (status IN ('succeeded', 'failed')),
+                                      transaction_id INT NULL REFERENCES transactions (id),
+                                      error TEXT NULL,
+                                      UNIQUE (batch_id, item_index)
+);
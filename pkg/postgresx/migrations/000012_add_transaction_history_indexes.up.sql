@@ -0,0 +1,3 @@
+-- 交易历史按 (created_at, id) 倒序分页, 付款方和收款方分别建索引
+CREATE INDEX idx_transactions_sender_created_at ON transactions (sender_user_id, created_at DESC, id DESC);
+CREATE INDEX idx_transactions_receiver_created_at ON transactions (receiver_user_id, created_at DESC, id DESC);
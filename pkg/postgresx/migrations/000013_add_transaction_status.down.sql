@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_transactions_pending;
+ALTER TABLE transactions DROP COLUMN IF EXISTS reversed_at;
+ALTER TABLE transactions DROP COLUMN IF EXISTS failed_at;
+ALTER TABLE transactions DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE transactions DROP COLUMN IF EXISTS failure_reason;
+ALTER TABLE transactions DROP COLUMN IF EXISTS status;
@@ -0,0 +1,21 @@
+-- 交易状态: pending -> completed / failed, completed -> reversed
+ALTER TABLE transactions ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'completed'
+    CHECK (status IN ('pending', 'completed', 'failed', 'reversed'));
+ALTER TABLE transactions ADD COLUMN failure_reason TEXT NULL;
+ALTER TABLE transactions ADD COLUMN completed_at TIMESTAMP NULL;
+ALTER TABLE transactions ADD COLUMN failed_at TIMESTAMP NULL;
+ALTER TABLE transactions ADD COLUMN reversed_at TIMESTAMP NULL;
+
+-- 已有的交易都是同步完成的
+UPDATE transactions SET completed_at = created_at;
+
+-- 已全额冲正的交易
+UPDATE transactions t SET status = 'reversed', reversed_at = r.reversed_at
+FROM (
+    SELECT original_transaction_id, SUM(amount) AS amount, MAX(created_at) AS reversed_at
+    FROM transactions WHERE original_transaction_id IS NOT NULL
+    GROUP BY original_transaction_id
+) r
+WHERE r.original_transaction_id = t.id AND r.amount >= t.amount;
+
+CREATE INDEX idx_transactions_pending ON transactions (id) WHERE status = 'pending';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_transactions_external_reference;
+ALTER TABLE transactions DROP COLUMN IF EXISTS metadata;
+ALTER TABLE transactions DROP COLUMN IF EXISTS external_reference;
+ALTER TABLE transactions DROP COLUMN IF EXISTS memo;
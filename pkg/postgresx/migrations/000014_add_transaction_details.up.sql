@@ -0,0 +1,7 @@
+-- 备注、调用方单号和自定义元数据
+ALTER TABLE transactions ADD COLUMN memo VARCHAR(255) NULL;
+ALTER TABLE transactions ADD COLUMN external_reference VARCHAR(128) NULL;
+ALTER TABLE transactions ADD COLUMN metadata JSONB NULL;
+
+-- 调用方单号在同一发起用户内唯一, 同时用于按单号查找
+CREATE UNIQUE INDEX idx_transactions_external_reference ON transactions (sender_user_id, external_reference) WHERE external_reference IS NOT NULL;
@@ -0,0 +1,17 @@
+-- 钱包状态: active 正常, frozen 只能收款不能付款, closed 拒绝所有操作
+ALTER TABLE wallets ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'frozen', 'closed'));
+
+-- 状态变更记录
+CREATE TABLE wallet_status_changes (
+                                       id SERIAL PRIMARY KEY,
+                                       user_id INT NOT NULL,
+                                       currency CHAR(3) NOT NULL,
+                                       from_status VARCHAR(20) NOT NULL,
+                                       to_status VARCHAR(20) NOT NULL,
+                                       reason TEXT NOT NULL,
+                                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                       FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
+
+CREATE INDEX idx_wallet_status_changes_wallet ON wallet_status_changes (user_id, currency, created_at);
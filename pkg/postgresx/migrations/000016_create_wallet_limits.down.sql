@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS wallet_limit_usage;
+DROP TABLE IF EXISTS wallet_limits;
@@ -0,0 +1,32 @@
+-- 钱包单独设置的限额, 为空的项使用所属等级(配置文件)的限额; tier 为空表示默认等级
+CREATE TABLE wallet_limits (
+                               user_id INT NOT NULL,
+                               currency CHAR(3) NOT NULL,
+                               tier VARCHAR(32) NOT NULL DEFAULT '',
+                               max_single_amount NUMERIC(20, 8) NULL CHECK (max_single_amount > 0),
+                               daily_outflow NUMERIC(20, 8) NULL CHECK (daily_outflow > 0),
+                               weekly_outflow NUMERIC(20, 8) NULL CHECK (weekly_outflow > 0),
+                               monthly_outflow NUMERIC(20, 8) NULL CHECK (monthly_outflow > 0),
+                               max_balance NUMERIC(20, 8) NULL CHECK (max_balance > 0),
+                               max_daily_transfers INT NULL CHECK (max_daily_transfers > 0),
+                               created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                               updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                               PRIMARY KEY (user_id, currency),
+                               FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
+
+CREATE TRIGGER set_wallet_limits_updated_at
+    BEFORE UPDATE ON wallet_limits
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- 按自然日/周/月(UTC)累计的付款金额和转账笔数, 与扣款在同一事务内更新
+CREATE TABLE wallet_limit_usage (
+                                    user_id INT NOT NULL,
+                                    currency CHAR(3) NOT NULL,
+                                    period VARCHAR(10) NOT NULL CHECK (period IN ('day', 'week', 'month')),
+                                    period_start TIMESTAMP NOT NULL,
+                                    outflow NUMERIC(20, 8) NOT NULL DEFAULT 0,
+                                    transfers INT NOT NULL DEFAULT 0,
+                                    PRIMARY KEY (user_id, currency, period, period_start)
+);
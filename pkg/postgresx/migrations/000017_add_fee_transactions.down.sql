@@ -0,0 +1,6 @@
+-- 回退后手续费流水记为 transfer, 分录保持不变
+UPDATE transactions SET transaction_type = 'transfer', original_transaction_id = NULL WHERE transaction_type = 'fee';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal'));
@@ -0,0 +1,4 @@
+-- 手续费流水, 通过 original_transaction_id 关联收费的交易
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee'));
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS interest_payouts;
+DROP TABLE IF EXISTS interest_accruals;
+DROP TABLE IF EXISTS interest_accrual_runs;
+
+-- 回退后利息流水记为 deposit, 分录保持不变
+UPDATE transactions SET transaction_type = 'deposit' WHERE transaction_type = 'interest';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee'));
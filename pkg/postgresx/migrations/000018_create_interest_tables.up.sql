@@ -0,0 +1,39 @@
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest'));
+
+-- 已完成计息的日期, 每天只计息一次
+CREATE TABLE interest_accrual_runs (
+                                       accrual_date DATE PRIMARY KEY,
+                                       wallets INT NOT NULL DEFAULT 0, -- 当日计息的钱包数
+                                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 每个钱包每天的计息记录, 利息保留计算结果的全部精度
+CREATE TABLE interest_accruals (
+                                   user_id INT NOT NULL,
+                                   currency CHAR(3) NOT NULL,
+                                   accrual_date DATE NOT NULL,
+                                   balance NUMERIC(20, 8) NOT NULL, -- 当日日终余额
+                                   rate NUMERIC(10, 6) NOT NULL, -- 年化利率
+                                   amount NUMERIC(38, 18) NOT NULL CHECK (amount > 0),
+                                   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   PRIMARY KEY (user_id, currency, accrual_date),
+                                   FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
+
+CREATE INDEX idx_interest_accruals_accrual_date ON interest_accruals (accrual_date);
+
+-- 每个钱包每月的派息, 不足最小货币单位的零头结转到下月
+CREATE TABLE interest_payouts (
+                                  user_id INT NOT NULL,
+                                  currency CHAR(3) NOT NULL,
+                                  period DATE NOT NULL, -- 派息月份的第一天
+                                  accrued NUMERIC(38, 18) NOT NULL, -- 当月计提的利息加上月结转的零头
+                                  amount NUMERIC(20, 8) NOT NULL DEFAULT 0,
+                                  carry NUMERIC(38, 18) NOT NULL DEFAULT 0,
+                                  transaction_id INT NULL REFERENCES transactions (id),
+                                  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                  PRIMARY KEY (user_id, currency, period),
+                                  FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
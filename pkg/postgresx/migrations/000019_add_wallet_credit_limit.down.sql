@@ -0,0 +1,17 @@
+-- 回退前透支的钱包需先还清, 否则无法恢复余额非负的约束
+DELETE FROM interest_accruals WHERE amount < 0;
+ALTER TABLE interest_accruals DROP CONSTRAINT IF EXISTS interest_accruals_amount_check;
+ALTER TABLE interest_accruals ADD CONSTRAINT interest_accruals_amount_check CHECK (amount > 0);
+
+-- 回退后透支利息流水记为 withdraw, 分录保持不变
+UPDATE transactions SET transaction_type = 'withdraw' WHERE transaction_type = 'overdraft_interest';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest'));
+
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS wallets_held_balance_valid;
+ALTER TABLE wallets ADD CONSTRAINT wallets_held_balance_valid CHECK (held_balance >= 0 AND held_balance <= balance);
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS wallets_balance_within_credit;
+ALTER TABLE wallets ADD CONSTRAINT wallets_balance_non_negative CHECK (balance >= 0);
+ALTER TABLE wallets DROP COLUMN IF EXISTS credit_limit;
@@ -0,0 +1,15 @@
+-- 授信额度: 可用余额 = balance + credit_limit - held_balance, 账面余额最低可透支到 -credit_limit
+ALTER TABLE wallets ADD COLUMN credit_limit NUMERIC(20, 8) NOT NULL DEFAULT 0 CHECK (credit_limit >= 0);
+
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS wallets_balance_non_negative;
+ALTER TABLE wallets ADD CONSTRAINT wallets_balance_within_credit CHECK (balance + credit_limit >= 0);
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS wallets_held_balance_valid;
+ALTER TABLE wallets ADD CONSTRAINT wallets_held_balance_valid CHECK (held_balance >= 0 AND held_balance <= balance + credit_limit);
+
+-- 透支利息: 负余额按透支利率计提, 记为负数, 与当月的存款利息轧差后按月收取
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest', 'overdraft_interest'));
+
+ALTER TABLE interest_accruals DROP CONSTRAINT IF EXISTS interest_accruals_amount_check;
+ALTER TABLE interest_accruals ADD CONSTRAINT interest_accruals_amount_check CHECK (amount <> 0);
@@ -0,0 +1,8 @@
+-- 回退前 pocket 的余额需先转回钱包, pocket 账户和流水保留
+DROP TABLE IF EXISTS pockets;
+
+UPDATE transactions SET transaction_type = 'transfer' WHERE transaction_type = 'pocket_transfer';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest', 'overdraft_interest'));
@@ -0,0 +1,29 @@
+-- 子钱包(pocket): 用户在某个币种钱包下划出的命名余额, 钱包本身即默认 pocket
+CREATE TABLE pockets (
+                         id SERIAL PRIMARY KEY,
+                         user_id INT NOT NULL,
+                         currency CHAR(3) NOT NULL,
+                         name VARCHAR(50) NOT NULL,
+                         balance NUMERIC(20, 8) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+                         status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'closed')),
+                         created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                         updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                         FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
+
+-- 同一钱包下未关闭的 pocket 名称唯一
+CREATE UNIQUE INDEX idx_pockets_user_currency_name ON pockets (user_id, currency, lower(name)) WHERE status = 'active';
+
+CREATE TRIGGER set_pockets_updated_at
+    BEFORE UPDATE ON pockets
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- pocket 对应 pocket 类型的账户, 编码为 pocket:<id>
+ALTER TABLE ledger_accounts DROP CONSTRAINT IF EXISTS ledger_accounts_account_type_check;
+ALTER TABLE ledger_accounts ADD CONSTRAINT ledger_accounts_account_type_check CHECK (account_type IN ('wallet', 'system', 'pocket'));
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest',
+                                'overdraft_interest', 'pocket_transfer'));
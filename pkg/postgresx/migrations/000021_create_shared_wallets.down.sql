@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS approval_decisions;
+DROP TABLE IF EXISTS transfer_approvals;
+DROP TABLE IF EXISTS wallet_members;
+DROP TABLE IF EXISTS shared_wallets;
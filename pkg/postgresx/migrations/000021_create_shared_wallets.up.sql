@@ -0,0 +1,77 @@
+-- 共享钱包: 多个成员共同使用的钱包, 超过阈值的转账需要 M 个成员批准
+CREATE TABLE shared_wallets (
+                                user_id INT NOT NULL,
+                                currency CHAR(3) NOT NULL,
+                                required_approvals INT NOT NULL CHECK (required_approvals >= 1), -- M: 超过阈值的转账需要的批准数
+                                approval_threshold NUMERIC(20, 8) NOT NULL DEFAULT 0 CHECK (approval_threshold >= 0), -- 不超过阈值的转账直接执行
+                                approval_ttl_seconds INT NOT NULL CHECK (approval_ttl_seconds > 0), -- 审批请求的有效期
+                                created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                PRIMARY KEY (user_id, currency),
+                                FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
+
+CREATE TRIGGER set_shared_wallets_updated_at
+    BEFORE UPDATE ON shared_wallets
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- 共享钱包成员: owner 管理成员、发起和审批转账; spender 发起和审批转账; viewer 只能查看
+CREATE TABLE wallet_members (
+                                user_id INT NOT NULL,
+                                currency CHAR(3) NOT NULL,
+                                member_user_id INT NOT NULL,
+                                role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'spender', 'viewer')),
+                                created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                PRIMARY KEY (user_id, currency, member_user_id),
+                                FOREIGN KEY (user_id, currency) REFERENCES shared_wallets (user_id, currency)
+);
+
+CREATE INDEX idx_wallet_members_member_user_id ON wallet_members (member_user_id);
+
+CREATE TRIGGER set_wallet_members_updated_at
+    BEFORE UPDATE ON wallet_members
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- 待审批的转账, required_approvals 为发起时的 M, 之后修改配置不影响已发起的请求
+CREATE TABLE transfer_approvals (
+                                    id SERIAL PRIMARY KEY,
+                                    user_id INT NOT NULL,
+                                    currency CHAR(3) NOT NULL,
+                                    initiator_user_id INT NOT NULL,
+                                    receiver_user_id INT NOT NULL,
+                                    amount NUMERIC(20, 8) NOT NULL CHECK (amount > 0),
+                                    required_approvals INT NOT NULL CHECK (required_approvals >= 1),
+                                    approvals INT NOT NULL DEFAULT 0,
+                                    rejections INT NOT NULL DEFAULT 0,
+                                    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+                                        CHECK (status IN ('pending', 'approved', 'rejected', 'expired', 'executed', 'failed')),
+                                    expires_at TIMESTAMP NOT NULL,
+                                    transaction_id INT NULL REFERENCES transactions (id),
+                                    error TEXT NULL,
+                                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                    FOREIGN KEY (user_id, currency) REFERENCES shared_wallets (user_id, currency)
+);
+
+CREATE INDEX idx_transfer_approvals_user_currency ON transfer_approvals (user_id, currency, id);
+CREATE INDEX idx_transfer_approvals_pending ON transfer_approvals (expires_at) WHERE status = 'pending';
+CREATE INDEX idx_transfer_approvals_approved ON transfer_approvals (id) WHERE status = 'approved';
+
+CREATE TRIGGER set_transfer_approvals_updated_at
+    BEFORE UPDATE ON transfer_approvals
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- 每个成员对每个请求只能决定一次, 发起人的批准在发起时记录
+CREATE TABLE approval_decisions (
+                                    id SERIAL PRIMARY KEY,
+                                    approval_id INT NOT NULL REFERENCES transfer_approvals (id),
+                                    member_user_id INT NOT NULL,
+                                    decision VARCHAR(20) NOT NULL CHECK (decision IN ('approve', 'reject')),
+                                    reason TEXT NULL,
+                                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                    UNIQUE (approval_id, member_user_id)
+);
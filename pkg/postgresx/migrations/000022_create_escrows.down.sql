@@ -0,0 +1,10 @@
+-- 回退前托管需全部结算, escrow 账户和流水保留
+DROP TABLE IF EXISTS escrow_settlements;
+DROP TABLE IF EXISTS escrows;
+
+UPDATE transactions SET transaction_type = 'transfer' WHERE transaction_type IN ('escrow_fund', 'escrow_release', 'escrow_refund');
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest',
+                                'overdraft_interest', 'pocket_transfer'));
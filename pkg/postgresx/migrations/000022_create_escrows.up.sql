@@ -0,0 +1,55 @@
+-- 托管: 买方资金从钱包转入 escrow:<id> 账户, 之后放款给卖方、退款给买方或按比例拆分
+CREATE TABLE escrows (
+                         id SERIAL PRIMARY KEY,
+                         buyer_user_id INT NOT NULL,
+                         seller_user_id INT NOT NULL,
+                         currency CHAR(3) NOT NULL,
+                         amount NUMERIC(20, 8) NOT NULL CHECK (amount > 0),
+                         balance NUMERIC(20, 8) NOT NULL, -- 尚未结算的金额, 与 escrow:<id> 账户余额一致
+                         released_amount NUMERIC(20, 8) NOT NULL DEFAULT 0,
+                         refunded_amount NUMERIC(20, 8) NOT NULL DEFAULT 0,
+                         status VARCHAR(20) NOT NULL DEFAULT 'funded' CHECK (status IN ('funded', 'released', 'refunded', 'split')),
+                         on_expiry VARCHAR(20) NOT NULL CHECK (on_expiry IN ('release', 'refund')), -- 到期时剩余金额的处理方式
+                         expires_at TIMESTAMP NOT NULL,
+                         proposed_split_by INT NULL, -- 待对方确认的拆分提议
+                         proposed_seller_amount NUMERIC(20, 8) NULL,
+                         funding_transaction_id INT NULL REFERENCES transactions (id),
+                         settled_at TIMESTAMP NULL,
+                         created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                         updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                         FOREIGN KEY (buyer_user_id, currency) REFERENCES wallets (user_id, currency),
+                         FOREIGN KEY (seller_user_id, currency) REFERENCES wallets (user_id, currency),
+                         CHECK (buyer_user_id <> seller_user_id),
+                         CHECK (balance >= 0 AND released_amount >= 0 AND refunded_amount >= 0),
+                         CHECK (balance + released_amount + refunded_amount = amount)
+);
+
+CREATE INDEX idx_escrows_buyer_user_id ON escrows (buyer_user_id);
+CREATE INDEX idx_escrows_seller_user_id ON escrows (seller_user_id);
+CREATE INDEX idx_escrows_due ON escrows (expires_at) WHERE status = 'funded';
+
+CREATE TRIGGER set_escrows_updated_at
+    BEFORE UPDATE ON escrows
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- 每次结算一行, 拆分时放款和退款各一行
+CREATE TABLE escrow_settlements (
+                                    id SERIAL PRIMARY KEY,
+                                    escrow_id INT NOT NULL REFERENCES escrows (id),
+                                    transaction_id INT NOT NULL REFERENCES transactions (id),
+                                    settlement_type VARCHAR(20) NOT NULL CHECK (settlement_type IN ('release', 'refund')),
+                                    amount NUMERIC(20, 8) NOT NULL CHECK (amount > 0),
+                                    triggered_by VARCHAR(20) NOT NULL CHECK (triggered_by IN ('buyer', 'seller', 'expiry')),
+                                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_escrow_settlements_escrow_id ON escrow_settlements (escrow_id);
+
+ALTER TABLE ledger_accounts DROP CONSTRAINT IF EXISTS ledger_accounts_account_type_check;
+ALTER TABLE ledger_accounts ADD CONSTRAINT ledger_accounts_account_type_check CHECK (account_type IN ('wallet', 'system', 'pocket', 'escrow'));
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest',
+                                'overdraft_interest', 'pocket_transfer', 'escrow_fund', 'escrow_release', 'escrow_refund'));
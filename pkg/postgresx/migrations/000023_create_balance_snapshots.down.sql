@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_postings_account_code_created_at;
+DROP TABLE IF EXISTS balance_snapshots;
+DROP TABLE IF EXISTS balance_snapshot_runs;
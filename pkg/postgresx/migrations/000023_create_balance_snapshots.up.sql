@@ -0,0 +1,20 @@
+-- 已完成快照的时间点(UTC 零点), 每个时间点只快照一次
+CREATE TABLE balance_snapshot_runs (
+                                       snapshot_at TIMESTAMP PRIMARY KEY,
+                                       wallets INT NOT NULL DEFAULT 0, -- 快照的钱包数
+                                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 钱包在 snapshot_at 时的账面余额, 即 created_at <= snapshot_at 的分录之和; 历史余额 = 最近的快照 + 之后的分录
+CREATE TABLE balance_snapshots (
+                                   user_id INT NOT NULL,
+                                   currency CHAR(3) NOT NULL,
+                                   snapshot_at TIMESTAMP NOT NULL,
+                                   balance NUMERIC(20, 8) NOT NULL,
+                                   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   PRIMARY KEY (user_id, currency, snapshot_at),
+                                   FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency)
+);
+
+-- 按账户和时间范围汇总分录
+CREATE INDEX idx_postings_account_code_created_at ON postings (account_code, created_at);
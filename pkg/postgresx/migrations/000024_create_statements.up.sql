@@ -0,0 +1,18 @@
+-- 预生成的月结对账单, 期间为 [period_start, period_end)
+CREATE TABLE statements (
+                            user_id INT NOT NULL,
+                            currency CHAR(3) NOT NULL,
+                            period_start TIMESTAMP NOT NULL,
+                            period_end TIMESTAMP NOT NULL,
+                            opening_balance NUMERIC(20, 8) NOT NULL,
+                            closing_balance NUMERIC(20, 8) NOT NULL,
+                            total_in NUMERIC(20, 8) NOT NULL,
+                            total_out NUMERIC(20, 8) NOT NULL,
+                            lines JSONB NOT NULL, -- 每笔交易及入账后的余额
+                            generated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                            PRIMARY KEY (user_id, currency, period_start, period_end),
+                            FOREIGN KEY (user_id, currency) REFERENCES wallets (user_id, currency),
+                            CHECK (period_end > period_start)
+);
+
+CREATE INDEX idx_statements_period_start ON statements (period_start);
@@ -0,0 +1,31 @@
+-- 对账: 按分录重新计算每个钱包的余额, 与 wallets.balance、ledger_accounts 和 Redis 缓存比较
+CREATE TABLE reconciliation_runs (
+                                     id SERIAL PRIMARY KEY,
+                                     trigger VARCHAR(20) NOT NULL CHECK (trigger IN ('schedule', 'admin', 'cli')),
+                                     repair_cache BOOLEAN NOT NULL DEFAULT FALSE, -- 是否删除与数据库不一致的余额缓存
+                                     status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'completed', 'failed')),
+                                     wallets_checked INT NOT NULL DEFAULT 0,
+                                     drifts INT NOT NULL DEFAULT 0,
+                                     cache_repaired INT NOT NULL DEFAULT 0,
+                                     error TEXT NULL,
+                                     started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                     finished_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_reconciliation_runs_trigger_started_at ON reconciliation_runs (trigger, started_at);
+
+-- 每个不一致一行: wallet_balance 钱包余额与分录之和不符; ledger_account 账户余额与分录之和不符; cache 缓存与钱包余额不符
+CREATE TABLE reconciliation_drifts (
+                                       id SERIAL PRIMARY KEY,
+                                       run_id INT NOT NULL REFERENCES reconciliation_runs (id),
+                                       user_id INT NOT NULL,
+                                       currency CHAR(3) NOT NULL,
+                                       kind VARCHAR(20) NOT NULL CHECK (kind IN ('wallet_balance', 'ledger_account', 'cache')),
+                                       expected NUMERIC(20, 8) NOT NULL,
+                                       actual NUMERIC(20, 8) NOT NULL,
+                                       difference NUMERIC(20, 8) NOT NULL, -- actual - expected
+                                       repaired BOOLEAN NOT NULL DEFAULT FALSE,
+                                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_reconciliation_drifts_run_id ON reconciliation_drifts (run_id);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS increment_wallets_version ON wallets;
+DROP FUNCTION IF EXISTS increment_wallet_version();
+ALTER TABLE wallets DROP COLUMN IF EXISTS version;
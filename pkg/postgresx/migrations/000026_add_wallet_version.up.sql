@@ -0,0 +1,17 @@
+-- 余额版本号: 账面余额、冻结金额或授信额度变化时加一; 缓存按版本号只接受更新的值, 旧值不会覆盖新值
+ALTER TABLE wallets ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION increment_wallet_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (NEW.balance, NEW.held_balance, NEW.credit_limit) IS DISTINCT FROM (OLD.balance, OLD.held_balance, OLD.credit_limit) THEN
+        NEW.version = OLD.version + 1;
+    END IF;
+RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER increment_wallets_version
+    BEFORE UPDATE ON wallets
+    FOR EACH ROW
+    EXECUTE FUNCTION increment_wallet_version();
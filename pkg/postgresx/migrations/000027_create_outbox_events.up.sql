@@ -0,0 +1,16 @@
+-- 领域事件 outbox: 与余额变动在同一个事务内写入, 由后台任务按 id 顺序发布, 至少发布一次
+CREATE TABLE outbox_events (
+                               id BIGSERIAL PRIMARY KEY,
+                               aggregate_id VARCHAR(64) NOT NULL, -- 事件所属的钱包账户, 同一钱包的事件按 id 顺序发布
+                               event_type VARCHAR(50) NOT NULL,
+                               payload JSONB NOT NULL,
+                               created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                               published_at TIMESTAMP NULL,
+                               attempts INT NOT NULL DEFAULT 0, -- 发布失败的次数
+                               next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                               last_error TEXT NULL
+);
+
+CREATE INDEX idx_outbox_events_unpublished ON outbox_events (id) WHERE published_at IS NULL;
+CREATE INDEX idx_outbox_events_unpublished_aggregate ON outbox_events (aggregate_id, id) WHERE published_at IS NULL;
+CREATE INDEX idx_outbox_events_published_at ON outbox_events (published_at) WHERE published_at IS NOT NULL;
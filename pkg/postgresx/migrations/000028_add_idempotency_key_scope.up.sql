@@ -0,0 +1,4 @@
+-- 幂等键按来源分开: client 为请求头传入的键, internal 为定时转账、审批执行等内部生成的键, 客户端的键不会与内部键冲突
+ALTER TABLE idempotency_keys ADD COLUMN scope VARCHAR(16) NOT NULL DEFAULT 'client';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, idempotency_key);
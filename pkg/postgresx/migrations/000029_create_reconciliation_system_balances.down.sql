@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS reconciliation_system_balances;
+
+UPDATE ledger_accounts la
+SET balance = COALESCE((SELECT SUM(p.amount) FROM postings p WHERE p.account_code = la.code), 0)
+WHERE la.account_type = 'system';
@@ -0,0 +1,10 @@
+-- 系统账户被同币种的所有存取款共用, 累计余额会让这些交易在同一行上排队; 不再维护系统账户的 balance, 改为对账时按分录之和计算
+UPDATE ledger_accounts SET balance = 0 WHERE account_type = 'system';
+
+CREATE TABLE reconciliation_system_balances (
+                                                run_id INT NOT NULL REFERENCES reconciliation_runs (id),
+                                                account_code VARCHAR(64) NOT NULL,
+                                                currency CHAR(3) NOT NULL,
+                                                balance NUMERIC(20, 8) NOT NULL,
+                                                PRIMARY KEY (run_id, account_code)
+);
@@ -0,0 +1,7 @@
+-- 划转流水和分录保留, 按入账记为 deposit
+UPDATE transactions SET transaction_type = 'deposit' WHERE transaction_type = 'fee_sweep';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest',
+                                'overdraft_interest', 'pocket_transfer', 'escrow_fund', 'escrow_release', 'escrow_refund'));
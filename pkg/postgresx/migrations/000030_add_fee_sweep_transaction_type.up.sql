@@ -0,0 +1,5 @@
+-- 手续费收入先记入 system:fee_revenue:<币种> 系统账户, 再由后台任务以 fee_sweep 流水划入收入钱包
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_transaction_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_type_check
+    CHECK (transaction_type IN ('deposit', 'withdraw', 'transfer', 'exchange_out', 'exchange_in', 'capture', 'reversal', 'fee', 'interest',
+                                'overdraft_interest', 'pocket_transfer', 'escrow_fund', 'escrow_release', 'escrow_refund', 'fee_sweep'));
@@ -0,0 +1,6 @@
+-- 不同用户使用了相同的键时只保留最早的一条
+DELETE FROM idempotency_keys a USING idempotency_keys b
+WHERE a.scope = b.scope AND a.idempotency_key = b.idempotency_key AND (a.created_at, a.user_id) > (b.created_at, b.user_id);
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, idempotency_key);
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,5 @@
+-- 幂等键按用户区分: 不同用户使用相同的键互不冲突, 也无法重放其他用户的结果.
+-- 已有的键没有记录用户, 归到 user_id = 0, 保留期过后由清理任务删除
+ALTER TABLE idempotency_keys ADD COLUMN user_id INT NOT NULL DEFAULT 0;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, user_id, idempotency_key);
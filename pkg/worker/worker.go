@@ -0,0 +1,27 @@
+package worker
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/pkg/logger"
+)
+
+// RunPeriodic 按固定间隔执行 fn, 直到 ctx 被取消; fn 返回的错误只记录日志, 不中断后续执行
+func RunPeriodic(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fn(ctx); err != nil {
+			logger.Error(ctx, "worker run failed", zap.String("worker", name), zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info(ctx, "worker stopped", zap.String("worker", name))
+			return
+		case <-ticker.C:
+		}
+	}
+}
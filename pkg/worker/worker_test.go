@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPeriodic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int32
+
+	done := make(chan struct{})
+	go func() {
+		RunPeriodic(ctx, "test", 10*time.Millisecond, func(ctx context.Context) error {
+			if atomic.AddInt32(&count, 1) == 3 {
+				cancel()
+			}
+			return errors.New("keep running")
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunPeriodic did not stop after context cancel")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const defaultBalanceCacheTTL = 10 * time.Minute
+
+func balanceCacheTTL() time.Duration {
+	seconds := config.GetConfig().BalanceCache.TTLSeconds
+	if seconds <= 0 {
+		return defaultBalanceCacheTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// balanceCacheKey 钱包余额缓存的键, 哈希中保存版本号、账面余额、冻结金额和授信额度
+func balanceCacheKey(userID int, currency models.Currency) string {
+	return fmt.Sprintf("wallet:balance_cache:%d:%s", userID, currency)
+}
+
+// walletKey 钱包的唯一标识
+type walletKey struct {
+	UserID   int
+	Currency models.Currency
+}
+
+// cachedBalance 缓存的钱包余额, Version 即 wallets.version
+type cachedBalance struct {
+	UserID      int             `db:"user_id"`
+	Currency    models.Currency `db:"currency"`
+	Balance     decimal.Decimal `db:"balance"`
+	HeldBalance decimal.Decimal `db:"held_balance"`
+	CreditLimit decimal.Decimal `db:"credit_limit"`
+	Version     int64           `db:"version"`
+}
+
+var balanceCacheFields = []string{"version", "balance", "held", "credit_limit"}
+
+// setBalanceScript 缓存中没有该钱包或版本号更旧时才写入, 相同或更新的版本保持不变
+var setBalanceScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'version')
+if current and tonumber(current) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'version', ARGV[1], 'balance', ARGV[2], 'held', ARGV[3], 'credit_limit', ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// balanceCache 钱包余额缓存: 只写入已提交的余额, 金额保存为精确的十进制字符串, 并带有版本号和有效期
+type balanceCache struct {
+	redis  *redis.Client
+	logger *wallet_logger.Logger
+}
+
+// get 读取缓存, 没有缓存时返回 nil; 无法解析的缓存直接删除, 视为没有缓存
+func (c balanceCache) get(ctx context.Context, userID int, currency models.Currency) (*cachedBalance, error) {
+	values, err := c.redis.HMGet(ctx, balanceCacheKey(userID, currency), balanceCacheFields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return c.parse(ctx, userID, currency, values), nil
+}
+
+// getMany 批量读取缓存, 结果与 keys 一一对应, 没有缓存的为 nil
+func (c balanceCache) getMany(ctx context.Context, keys []walletKey) ([]*cachedBalance, error) {
+	cmds := make([]*redis.SliceCmd, len(keys))
+	_, err := c.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HMGet(ctx, balanceCacheKey(key.UserID, key.Currency), balanceCacheFields...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*cachedBalance, len(keys))
+	for i, key := range keys {
+		entries[i] = c.parse(ctx, key.UserID, key.Currency, cmds[i].Val())
+	}
+	return entries, nil
+}
+
+func (c balanceCache) parse(ctx context.Context, userID int, currency models.Currency, values []interface{}) *cachedBalance {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		field, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		fields[i] = field
+	}
+
+	entry := &cachedBalance{UserID: userID, Currency: currency}
+	var err error
+	if entry.Version, err = strconv.ParseInt(fields[0], 10, 64); err == nil {
+		if entry.Balance, err = decimal.NewFromString(fields[1]); err == nil {
+			if entry.HeldBalance, err = decimal.NewFromString(fields[2]); err == nil {
+				entry.CreditLimit, err = decimal.NewFromString(fields[3])
+			}
+		}
+	}
+	if err != nil {
+		c.logger.Warn(ctx, "balanceCache invalid entry", zap.Int("userID", userID), zap.String("currency", string(currency)), zap.Error(err))
+		if err = c.invalidate(ctx, walletKey{UserID: userID, Currency: currency}); err != nil {
+			c.logger.Warn(ctx, "balanceCache Failed to delete invalid entry", zap.Int("userID", userID), zap.Error(err))
+		}
+		return nil
+	}
+	return entry
+}
+
+// set 按版本号写入, 不会用旧版本覆盖新版本
+func (c balanceCache) set(ctx context.Context, entries ...cachedBalance) error {
+	ttl := balanceCacheTTL().Milliseconds()
+	for _, entry := range entries {
+		err := setBalanceScript.Run(ctx, c.redis, []string{balanceCacheKey(entry.UserID, entry.Currency)},
+			entry.Version, entry.Balance.String(), entry.HeldBalance.String(), entry.CreditLimit.String(), ttl).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidate 删除缓存, 下次查询时从数据库重新加载
+func (c balanceCache) invalidate(ctx context.Context, keys ...walletKey) error {
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = balanceCacheKey(key.UserID, key.Currency)
+	}
+	return c.redis.Del(ctx, cacheKeys...).Err()
+}
+
+// walletChanges 各事务内余额有变化的钱包, 事务结束时取出
+type walletChanges struct {
+	mu   sync.Mutex
+	byTx map[*sqlx.Tx][]walletKey
+}
+
+func (c *walletChanges) add(tx *sqlx.Tx, key walletKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byTx == nil {
+		c.byTx = make(map[*sqlx.Tx][]walletKey)
+	}
+	for _, existing := range c.byTx[tx] {
+		if existing == key {
+			return
+		}
+	}
+	c.byTx[tx] = append(c.byTx[tx], key)
+}
+
+func (c *walletChanges) take(tx *sqlx.Tx) []walletKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := c.byTx[tx]
+	delete(c.byTx, tx)
+	return keys
+}
+
+func (s *walletService) balanceCache() balanceCache {
+	return balanceCache{redis: s.redis, logger: s.logger}
+}
+
+// walletChanged 记录事务内余额、冻结金额或授信额度有变化的钱包, 事务提交后刷新缓存, 回滚时丢弃
+func (s *walletService) walletChanged(tx *sqlx.Tx, userID int, currency models.Currency) {
+	s.changes.add(tx, walletKey{UserID: userID, Currency: currency})
+}
+
+// refreshBalanceCache 事务提交后从数据库读取钱包的最新余额和版本号写入缓存;
+// 并发事务的刷新顺序不确定, 由版本号保证缓存不会倒退; 失败只记录日志, 旧值最多保留一个有效期
+func (s *walletService) refreshBalanceCache(ctx context.Context, keys []walletKey) {
+	userIDs := make([]int, len(keys))
+	currencies := make([]string, len(keys))
+	for i, key := range keys {
+		userIDs[i], currencies[i] = key.UserID, string(key.Currency)
+	}
+
+	var entries []cachedBalance
+	err := s.db.SelectContext(ctx, &entries, `
+		SELECT w.user_id, w.currency, w.balance, w.held_balance, w.credit_limit, w.version
+		FROM wallets w
+		JOIN unnest($1::int[], $2::text[]) AS k(user_id, currency) ON w.user_id = k.user_id AND w.currency = k.currency`,
+		pq.Array(userIDs), pq.Array(currencies))
+	if err == nil {
+		err = s.balanceCache().set(ctx, entries...)
+	}
+	if err != nil {
+		// 记录日志，不影响主流程; 尽量删除旧值, 删除也失败时旧值在有效期后过期
+		s.logger.Warn(ctx, "Failed to refresh balance cache", zap.Any("wallets", keys), zap.Error(err))
+		if err = s.balanceCache().invalidate(ctx, keys...); err != nil {
+			s.logger.Warn(ctx, "Failed to invalidate balance cache", zap.Any("wallets", keys), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+// expectBalanceCacheRefresh 事务提交后重新读取钱包并按版本号写入缓存
+func expectBalanceCacheRefresh(mockDB sqlmock.Sqlmock, mockRedis redismock.ClientMock, entries ...cachedBalance) {
+	userIDs := make([]int, len(entries))
+	currencies := make([]string, len(entries))
+	rows := sqlmock.NewRows([]string{"user_id", "currency", "balance", "held_balance", "credit_limit", "version"})
+	for i, entry := range entries {
+		userIDs[i], currencies[i] = entry.UserID, string(entry.Currency)
+		rows.AddRow(entry.UserID, entry.Currency, entry.Balance.String(), entry.HeldBalance.String(), entry.CreditLimit.String(), entry.Version)
+	}
+	mockDB.ExpectQuery(`JOIN unnest\(\$1::int\[\], \$2::text\[\]\)`).
+		WithArgs(pq.Array(userIDs), pq.Array(currencies)).
+		WillReturnRows(rows)
+	for _, entry := range entries {
+		expectBalanceCacheSet(mockRedis, entry).SetVal(int64(1))
+	}
+}
+
+func expectBalanceCacheSet(mockRedis redismock.ClientMock, entry cachedBalance) *redismock.ExpectedCmd {
+	return mockRedis.ExpectEvalSha(setBalanceScript.Hash(), []string{balanceCacheKey(entry.UserID, entry.Currency)},
+		entry.Version, entry.Balance.String(), entry.HeldBalance.String(), entry.CreditLimit.String(), defaultBalanceCacheTTL.Milliseconds())
+}
+
+func TestBalanceCache_Get_InvalidEntry(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	cache := balanceCache{redis: client, logger: logger.NewLogger()}
+
+	// 无法解析的缓存被删除, 视为没有缓存
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).SetVal([]interface{}{"3", "abc", "0", "0"})
+	mockRedis.ExpectDel(balanceCacheKey(1, models.USD)).SetVal(1)
+
+	entry, err := cache.get(context.Background(), 1, models.USD)
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestBalanceCache_Get_ExactDecimals(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	cache := balanceCache{redis: client, logger: logger.NewLogger()}
+
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).
+		SetVal([]interface{}{"7", "0.30000000000000000001", "0.1", "500"})
+
+	entry, err := cache.get(context.Background(), 1, models.USD)
+	assert.NoError(t, err)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, int64(7), entry.Version)
+		assert.Equal(t, "0.30000000000000000001", entry.Balance.String())
+		assert.True(t, entry.HeldBalance.Equal(decimal.RequireFromString("0.1")))
+		assert.True(t, entry.CreditLimit.Equal(decimal.NewFromInt(500)))
+	}
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_GetBalances_MergesCache(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}
+	now := time.Now()
+
+	// CNY 缓存较旧、EUR 缓存缺失, 使用数据库并写回缓存; USD 查询后又有事务提交, 使用版本更新的缓存
+	mockDB.ExpectQuery("FROM wallets WHERE user_id = \\$1 ORDER BY currency").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(append(walletRowColumns, "version")).
+			AddRow(1, "CNY", "30", "0", "0", "active", now, now, 4).
+			AddRow(1, "EUR", "20", "0", "0", "active", now, now, 1).
+			AddRow(1, "USD", "100", "10", "0", "active", now, now, 3))
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.CNY), balanceCacheFields...).SetVal([]interface{}{"2", "25", "0", "0"})
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.EUR), balanceCacheFields...).SetVal([]interface{}{nil, nil, nil, nil})
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).SetVal([]interface{}{"4", "80", "10", "0"})
+	expectBalanceCacheSet(mockRedis, cachedBalance{UserID: 1, Currency: models.CNY, Balance: decimal.NewFromInt(30), Version: 4}).SetVal(int64(1))
+	expectBalanceCacheSet(mockRedis, cachedBalance{UserID: 1, Currency: models.EUR, Balance: decimal.NewFromInt(20), Version: 1}).SetVal(int64(1))
+
+	wallets, err := service.GetBalances(context.Background(), 1)
+
+	assert.NoError(t, err)
+	if assert.Len(t, wallets, 3) {
+		assert.Equal(t, "30", wallets[0].Balance.String())
+		assert.Equal(t, "20", wallets[1].Balance.String())
+		assert.Equal(t, "80", wallets[2].Balance.String())
+		assert.Equal(t, models.WalletActive, wallets[2].Status)
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_RefreshBalanceCache_Failed(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}
+
+	// 读取失败时删除缓存, 下次查询从数据库加载
+	mockDB.ExpectQuery(`JOIN unnest`).WillReturnError(fmt.Errorf("db error"))
+	mockRedis.ExpectDel(balanceCacheKey(1, models.USD), balanceCacheKey(2, models.EUR)).SetVal(2)
+
+	service.refreshBalanceCache(context.Background(), []walletKey{{UserID: 1, Currency: models.USD}, {UserID: 2, Currency: models.EUR}})
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_ExecTx_RollbackDiscardsChanges(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}
+
+	// 回滚的事务不刷新缓存
+	mockDB.ExpectBegin()
+	mockDB.ExpectRollback()
+	err = service.runInTx(context.Background(), "Test", func(tx *sqlx.Tx) error {
+		service.walletChanged(tx, 1, models.USD)
+		return fmt.Errorf("failed")
+	})
+	assert.Error(t, err)
+	assert.Empty(t, service.changes.byTx)
+
+	// 提交的事务刷新缓存, 同一钱包只刷新一次
+	mockDB.ExpectBegin()
+	mockDB.ExpectCommit()
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: 1, Currency: models.USD, Balance: decimal.NewFromInt(100), Version: 4})
+	err = service.runInTx(context.Background(), "Test", func(tx *sqlx.Tx) error {
+		service.walletChanged(tx, 1, models.USD)
+		service.walletChanged(tx, 1, models.USD)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, service.changes.byTx)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+// balanceSnapshotDelay 零点过后等待一段时间再做快照, 保证零点前开始的事务都已提交
+const balanceSnapshotDelay = 10 * time.Minute
+
+var ErrInvalidBalanceTime = errors.New("invalid balance time")
+
+// GetBalanceAt 查询某一时刻的账面余额: 该时刻之前最近的快照加上快照之后到该时刻的分录.
+// 钱包余额始终与 wallet 账户的余额一致, 结果即当时 GetBalance 返回的账面余额; 冻结金额和授信额度没有历史记录, 不返回
+func (s *walletService) GetBalanceAt(ctx context.Context, userID int, currency models.Currency, at time.Time) (*models.HistoricalBalance, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	if at.After(time.Now()) {
+		return nil, fmt.Errorf("%w: at must not be in the future", ErrInvalidBalanceTime)
+	}
+	// 时间字段不带时区, 统一按 UTC 比较
+	at = at.UTC()
+
+	var createdAt time.Time
+	err := s.db.GetContext(ctx, &createdAt, "SELECT created_at FROM wallets WHERE user_id = $1 AND currency = $2", userID, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWalletNotFound
+	}
+	if err != nil {
+		s.logger.Error(ctx, "GetBalanceAt Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	if createdAt.After(at) {
+		// 当时钱包还不存在, 与当时查询余额的结果一致
+		return nil, fmt.Errorf("%w: created at %s", ErrWalletNotFound, createdAt.Format(time.RFC3339))
+	}
+
+	balance, err := s.ledgerBalanceAt(ctx, userID, currency, at)
+	if err != nil {
+		return nil, err
+	}
+	return &models.HistoricalBalance{Currency: currency, Ledger: balance, At: at}, nil
+}
+
+// ledgerBalanceAt 钱包账户在 at(UTC) 时的余额, 即 created_at <= at 的分录之和; 没有快照时从第一条分录开始累加
+func (s *walletService) ledgerBalanceAt(ctx context.Context, userID int, currency models.Currency, at time.Time) (decimal.Decimal, error) {
+	var snapshot struct {
+		SnapshotAt time.Time       `db:"snapshot_at"`
+		Balance    decimal.Decimal `db:"balance"`
+	}
+	err := s.db.GetContext(ctx, &snapshot, `
+		SELECT snapshot_at, balance FROM balance_snapshots
+		WHERE user_id = $1 AND currency = $2 AND snapshot_at <= $3
+		ORDER BY snapshot_at DESC LIMIT 1`, userID, currency, at)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Error(ctx, "ledgerBalanceAt Failed select from balance_snapshots", zap.Int("userID", userID), zap.Error(err))
+		return decimal.Zero, err
+	}
+
+	var delta decimal.Decimal
+	err = s.db.GetContext(ctx, &delta, "SELECT COALESCE(SUM(amount), 0) FROM postings WHERE account_code = $1 AND created_at > $2 AND created_at <= $3",
+		WalletAccountCode(userID, currency), snapshot.SnapshotAt, at)
+	if err != nil {
+		s.logger.Error(ctx, "ledgerBalanceAt Failed to sum postings", zap.Int("userID", userID), zap.Error(err))
+		return decimal.Zero, err
+	}
+	return snapshot.Balance.Add(delta), nil
+}
+
+// BalanceSnapshotter 每天为所有钱包的账面余额做快照, 缩短历史余额需要累加的分录
+type BalanceSnapshotter struct {
+	service *walletService
+}
+
+// NewBalanceSnapshotter new balance snapshotter
+func NewBalanceSnapshotter(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *BalanceSnapshotter {
+	return &BalanceSnapshotter{
+		service: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// Run 为今天零点(UTC)的余额做快照; 停机错过的日期不补, 查询时从更早的快照累加即可
+func (b *BalanceSnapshotter) Run(ctx context.Context) error {
+	now := time.Now()
+	day, _, _ := limitPeriods(now)
+	if now.Sub(day) < balanceSnapshotDelay {
+		return nil
+	}
+	return b.snapshot(ctx, day)
+}
+
+// snapshot 快照余额 = 当前账户余额 - 快照时间点之后的分录; 时间点由 balance_snapshot_runs 占用, 多实例或重复运行时只快照一次
+func (b *BalanceSnapshotter) snapshot(ctx context.Context, at time.Time) error {
+	s := b.service
+	return s.runInTx(ctx, "SnapshotBalances", func(tx *sqlx.Tx) error {
+		var claimed time.Time
+		err := tx.Get(&claimed, "INSERT INTO balance_snapshot_runs (snapshot_at) VALUES ($1) ON CONFLICT (snapshot_at) DO NOTHING RETURNING snapshot_at", at)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// 已注销的钱包余额不再变化, 不做快照
+		res, err := tx.Exec(`
+			INSERT INTO balance_snapshots (user_id, currency, snapshot_at, balance)
+			SELECT la.user_id, la.currency, $1, la.balance - COALESCE(SUM(p.amount), 0)
+			FROM ledger_accounts la
+			JOIN wallets w ON w.user_id = la.user_id AND w.currency = la.currency
+			LEFT JOIN postings p ON p.account_code = la.code AND p.created_at > $1
+			WHERE la.account_type = $2 AND w.status <> $3 AND w.created_at <= $1
+			GROUP BY la.user_id, la.currency, la.balance`,
+			at, models.WalletAccountType, models.WalletClosed)
+		if err != nil {
+			s.logger.Error(ctx, "SnapshotBalances Failed insert into balance_snapshots", zap.Time("at", at), zap.Error(err))
+			return err
+		}
+		wallets, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec("UPDATE balance_snapshot_runs SET wallets = $1 WHERE snapshot_at = $2", wallets, at); err != nil {
+			return err
+		}
+		s.logger.Info(ctx, "BalanceSnapshotter took snapshot", zap.Time("at", at), zap.Int64("wallets", wallets))
+		return nil
+	})
+}
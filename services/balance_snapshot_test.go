@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func newTestBalanceSnapshotter(t *testing.T) (*BalanceSnapshotter, sqlmock.Sqlmock, redismock.ClientMock) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return &BalanceSnapshotter{service: &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}}, mockDB, mockRedis
+}
+
+func TestWalletService_GetBalanceAt_FromSnapshot(t *testing.T) {
+	snapshotter, mockDB, _ := newTestBalanceSnapshotter(t)
+	service := snapshotter.service
+
+	// 最近的快照加上快照之后到该时刻的分录, 带时区的时间按 UTC 比较
+	at := time.Date(2024, 4, 1, 7, 59, 0, 0, time.FixedZone("CST", 8*3600))
+	snapshotAt := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	mockDB.ExpectQuery("SELECT created_at FROM wallets").
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	mockDB.ExpectQuery("FROM balance_snapshots").
+		WithArgs(1, models.USD, at.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot_at", "balance"}).AddRow(snapshotAt, "500"))
+	mockDB.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM postings").
+		WithArgs(WalletAccountCode(1, models.USD), snapshotAt, at.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("-120.5"))
+
+	balance, err := service.GetBalanceAt(context.Background(), 1, models.USD, at)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "379.5", balance.Ledger.String())
+	assert.Equal(t, time.Date(2024, 3, 31, 23, 59, 0, 0, time.UTC), balance.At)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_GetBalanceAt_NoSnapshot(t *testing.T) {
+	snapshotter, mockDB, _ := newTestBalanceSnapshotter(t)
+	service := snapshotter.service
+
+	// 没有更早的快照时累加全部分录
+	at := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	mockDB.ExpectQuery("SELECT created_at FROM wallets").
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	mockDB.ExpectQuery("FROM balance_snapshots").
+		WithArgs(1, models.USD, at).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot_at", "balance"}))
+	mockDB.ExpectQuery("FROM postings").
+		WithArgs(WalletAccountCode(1, models.USD), time.Time{}, at).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("80"))
+
+	balance, err := service.GetBalanceAt(context.Background(), 1, models.USD, at)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "80", balance.Ledger.String())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_GetBalanceAt_Invalid(t *testing.T) {
+	snapshotter, mockDB, _ := newTestBalanceSnapshotter(t)
+	service := snapshotter.service
+
+	_, err := service.GetBalanceAt(context.Background(), 1, models.USD, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidBalanceTime)
+
+	// 当时钱包还不存在
+	mockDB.ExpectQuery("SELECT created_at FROM wallets").
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	_, err = service.GetBalanceAt(context.Background(), 1, models.USD, time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestBalanceSnapshotter_Snapshot(t *testing.T) {
+	snapshotter, mockDB, _ := newTestBalanceSnapshotter(t)
+	at := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO balance_snapshot_runs").
+		WithArgs(at).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot_at"}).AddRow(at))
+	mockDB.ExpectExec("INSERT INTO balance_snapshots").
+		WithArgs(at, models.WalletAccountType, models.WalletClosed).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mockDB.ExpectExec("UPDATE balance_snapshot_runs SET wallets").
+		WithArgs(int64(3), at).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	err := snapshotter.snapshot(context.Background(), at)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestBalanceSnapshotter_Snapshot_AlreadyTaken(t *testing.T) {
+	snapshotter, mockDB, _ := newTestBalanceSnapshotter(t)
+	at := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO balance_snapshot_runs").
+		WithArgs(at).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot_at"}))
+	mockDB.ExpectCommit()
+
+	err := snapshotter.snapshot(context.Background(), at)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
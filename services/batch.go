@@ -0,0 +1,445 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"unicode/utf8"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+)
+
+const defaultBatchMaxItems = 1000
+
+var ErrInvalidBatch = errors.New("invalid batch transfer")
+
+func batchMaxItems() int {
+	items := config.GetConfig().Batch.MaxItems
+	if items <= 0 {
+		return defaultBatchMaxItems
+	}
+	return items
+}
+
+// validateBatchItem 校验单笔明细, 返回失败原因
+func validateBatchItem(senderID int, currency models.Currency, item models.BatchTransferItem) error {
+	if item.ReceiverUserID <= 0 {
+		return errors.New("invalid receiver")
+	}
+	if item.ReceiverUserID == senderID {
+		return errors.New("cannot transfer to the same wallet")
+	}
+	if !item.Amount.IsPositive() {
+		return errors.New("amount must be greater than zero")
+	}
+	if utf8.RuneCountInString(item.Reference) > maxExternalReferenceLength {
+		return fmt.Errorf("reference must not exceed %d characters", maxExternalReferenceLength)
+	}
+	return validateMoney(item.Amount, currency)
+}
+
+// BatchTransfer 一个付款方向多个收款方批量转账: 只锁付款方钱包一次, 流水和分录批量写入.
+// all_or_nothing 模式下任一笔无效或余额不足则整批失败; best_effort 模式下按顺序尽量执行, 跳过失败的明细
+func (s *walletService) BatchTransfer(ctx context.Context, senderID int, currency models.Currency, items []models.BatchTransferItem, mode models.BatchMode) (*models.TransactionResult, error) {
+	if !mode.Valid() {
+		return nil, fmt.Errorf("%w: unsupported mode %q", ErrInvalidBatch, mode)
+	}
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	if len(items) == 0 || len(items) > batchMaxItems() {
+		return nil, fmt.Errorf("%w: batch must contain between 1 and %d items", ErrInvalidBatch, batchMaxItems())
+	}
+
+	var result *models.TransactionResult
+	err := s.runInTx(ctx, "BatchTransfer", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, senderID, requestHash("batch", senderID, currency, mode, items))
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			result = replayed
+			return nil
+		}
+
+		// 按 user_id 升序锁定付款方和所有收款方, 与 Transfer 的加锁顺序一致; 之后在内存中按顺序分配可用余额和收款方的余额上限
+		userIDs := []int{senderID}
+		seen := map[int]bool{senderID: true}
+		for _, item := range items {
+			if !seen[item.ReceiverUserID] {
+				seen[item.ReceiverUserID] = true
+				userIDs = append(userIDs, item.ReceiverUserID)
+			}
+		}
+		if err = s.lockWallets(ctx, tx, currency, userIDs...); err != nil {
+			return err
+		}
+		wallets, err := s.batchWallets(ctx, tx, currency, userIDs)
+		if err != nil {
+			return err
+		}
+		sender, ok := wallets[senderID]
+		if !ok {
+			return ErrWalletNotFound
+		}
+		if err = walletStatusError(sender.Status); err != nil {
+			return err
+		}
+		available := sender.Available()
+
+		// 付款方的限额用量和收款方的余额上限同样在内存中按顺序分配
+		limits, err := s.walletLimits(ctx, tx, currency, userIDs...)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		var usage models.LimitUsage
+		if limits[senderID].HasPeriodLimits() {
+			if usage, err = s.limitUsage(ctx, tx, senderID, currency, now); err != nil {
+				return err
+			}
+		}
+
+		// 共享钱包超过审批阈值的明细不能通过批量付款绕过审批
+		threshold, err := s.approvalThreshold(ctx, tx, senderID, currency)
+		if err != nil {
+			return err
+		}
+
+		// 明细的 reference 作为流水的 external_reference, 与付款方已有的单号及批次内其他明细都不能重复
+		usedReferences, err := s.usedExternalReferences(ctx, tx, senderID, items)
+		if err != nil {
+			return err
+		}
+
+		batch := &models.BatchTransferResult{Mode: mode, Currency: currency, Items: make([]models.BatchItemResult, len(items))}
+		var (
+			accepted      []int
+			fees          = make([]decimal.Decimal, len(items))
+			totalFee      decimal.Decimal
+			revenueUserID int
+		)
+		for i, item := range items {
+			batch.Items[i] = models.BatchItemResult{Index: i, ReceiverUserID: item.ReceiverUserID, Amount: item.Amount, Reference: item.Reference}
+			itemErr := validateBatchItem(senderID, currency, item)
+			if itemErr == nil && item.Reference != "" && usedReferences[item.Reference] {
+				itemErr = fmt.Errorf("%w: %s", ErrDuplicateExternalReference, item.Reference)
+			}
+			if itemErr == nil {
+				itemErr = batchReceiverError(wallets, item.ReceiverUserID)
+			}
+			if itemErr == nil {
+				itemErr = checkApprovalThreshold(threshold, item.Amount)
+			}
+			// 每笔按 transfer 规则收取手续费, 与金额一起占用可用余额和限额用量
+			if itemErr == nil {
+				if fees[i], revenueUserID, err = feeFor(models.TransferTransactionType, senderID, item.Amount, currency); err != nil {
+					s.logger.Error(ctx, "BatchTransfer Failed to calculate fee", zap.Int("senderID", senderID), zap.Error(err))
+					return err
+				}
+			}
+			debit := item.Amount.Add(fees[i])
+			if itemErr == nil && debit.GreaterThan(available) {
+				itemErr = ErrInsufficientFunds
+			}
+			if itemErr == nil {
+				itemErr = checkOutflowLimits(limits[senderID], usage.Add(debit, 1), item.Amount, now)
+			}
+			receiver := wallets[item.ReceiverUserID]
+			if itemErr == nil {
+				itemErr = checkBalanceLimit(limits[item.ReceiverUserID], receiver.Balance.Add(item.Amount))
+			}
+			if itemErr != nil {
+				if mode == models.BatchAllOrNothing {
+					return fmt.Errorf("item %d: %w", i, batchItemError(itemErr))
+				}
+				batch.Items[i].Status, batch.Items[i].Error = models.BatchItemFailed, itemErr.Error()
+				batch.Failed++
+				continue
+			}
+			if item.Reference != "" {
+				usedReferences[item.Reference] = true
+			}
+			available = available.Sub(debit)
+			usage = usage.Add(debit, 1)
+			totalFee = totalFee.Add(fees[i])
+			receiver.Balance = receiver.Balance.Add(item.Amount)
+			wallets[item.ReceiverUserID] = receiver
+			batch.TotalAmount = batch.TotalAmount.Add(item.Amount)
+			accepted = append(accepted, i)
+		}
+
+		if len(accepted) > 0 {
+			if err = s.applyBatch(ctx, tx, senderID, currency, items, accepted, batch); err != nil {
+				return err
+			}
+			if totalFee.IsPositive() {
+				if err = s.applyBatchFees(ctx, tx, senderID, revenueUserID, currency, fees, totalFee, batch); err != nil {
+					return err
+				}
+			}
+			if limits[senderID].HasPeriodLimits() {
+				if _, err = s.recordLimitUsage(ctx, tx, senderID, currency, batch.TotalAmount.Add(totalFee), len(accepted), now); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = s.recordBatch(ctx, tx, senderID, batch); err != nil {
+			return err
+		}
+		result = &models.TransactionResult{Batch: batch}
+		return s.saveIdempotencyResult(ctx, tx, senderID, result)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed", zap.Int("senderID", senderID), zap.Int("items", len(items)), zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
+// batchWallets 查询批次中付款方和收款方钱包的状态和余额, 调用前已由 lockWallets 锁定
+func (s *walletService) batchWallets(ctx context.Context, tx *sqlx.Tx, currency models.Currency, userIDs []int) (map[int]models.Wallet, error) {
+	var wallets []models.Wallet
+	err := tx.Select(&wallets, "SELECT user_id, balance, held_balance, credit_limit, status FROM wallets WHERE user_id = ANY($1) AND currency = $2",
+		pq.Array(userIDs), currency)
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed to select wallets", zap.Error(err))
+		return nil, err
+	}
+	result := make(map[int]models.Wallet, len(wallets))
+	for _, wallet := range wallets {
+		result[wallet.UserID] = wallet
+	}
+	return result, nil
+}
+
+// usedExternalReferences 查询付款方已使用过的明细单号
+func (s *walletService) usedExternalReferences(ctx context.Context, tx *sqlx.Tx, senderID int, items []models.BatchTransferItem) (map[string]bool, error) {
+	used := make(map[string]bool)
+	var references []string
+	for _, item := range items {
+		if item.Reference != "" {
+			references = append(references, item.Reference)
+		}
+	}
+	if len(references) == 0 {
+		return used, nil
+	}
+	var existing []string
+	err := tx.Select(&existing, "SELECT external_reference FROM transactions WHERE sender_user_id = $1 AND external_reference = ANY($2)",
+		senderID, pq.Array(references))
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed to select external references", zap.Int("senderID", senderID), zap.Error(err))
+		return nil, err
+	}
+	for _, reference := range existing {
+		used[reference] = true
+	}
+	return used, nil
+}
+
+// batchReceiverError 收款方钱包不存在或已注销时的失败原因, 冻结的钱包可以收款
+func batchReceiverError(receivers map[int]models.Wallet, receiverID int) error {
+	receiver, ok := receivers[receiverID]
+	if !ok {
+		return ErrWalletNotFound
+	}
+	if receiver.Status == models.WalletClosed {
+		return ErrWalletClosed
+	}
+	return nil
+}
+
+// batchItemError all_or_nothing 模式下保留可映射为错误码的错误, 其他原因归为批量参数错误
+func batchItemError(err error) error {
+	if errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrInvalidAmountPrecision) ||
+		errors.Is(err, ErrWalletNotFound) || errors.Is(err, ErrWalletClosed) || errors.Is(err, ErrLimitExceeded) ||
+		errors.Is(err, ErrDuplicateExternalReference) || errors.Is(err, ErrApprovalRequired) {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidBatch, err.Error())
+}
+
+// applyBatch 对已通过校验的明细执行资金变动, 批量写入流水和分录
+func (s *walletService) applyBatch(ctx context.Context, tx *sqlx.Tx, senderID int, currency models.Currency,
+	items []models.BatchTransferItem, accepted []int, batch *models.BatchTransferResult) error {
+	_, err := tx.Exec("UPDATE wallets SET balance = balance - $1 WHERE user_id = $2 AND currency = $3", batch.TotalAmount, senderID, currency)
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed to debit sender", zap.Int("senderID", senderID), zap.Error(err))
+		return err
+	}
+	s.walletChanged(tx, senderID, currency)
+
+	// 同一收款方的多笔合并入账
+	credits := make(map[int]decimal.Decimal)
+	var receivers []int
+	for _, i := range accepted {
+		receiverID := items[i].ReceiverUserID
+		if _, ok := credits[receiverID]; !ok {
+			receivers = append(receivers, receiverID)
+		}
+		credits[receiverID] = credits[receiverID].Add(items[i].Amount)
+	}
+	creditAmounts := make([]string, 0, len(receivers))
+	for _, receiverID := range receivers {
+		creditAmounts = append(creditAmounts, credits[receiverID].String())
+	}
+	res, err := tx.Exec(`
+		UPDATE wallets w SET balance = w.balance + c.amount
+		FROM unnest($1::int[], $2::numeric[]) AS c(user_id, amount)
+		WHERE w.user_id = c.user_id AND w.currency = $3 AND w.status <> 'closed'`,
+		pq.Array(receivers), pq.Array(creditAmounts), currency)
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed to credit receivers", zap.Int("senderID", senderID), zap.Error(err))
+		return err
+	}
+	// 收款方钱包已在校验前锁定, 防御性校验: 未全部入账时整批回滚
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if int(affected) != len(receivers) {
+		return fmt.Errorf("%w: credited %d of %d receivers", ErrWalletClosed, affected, len(receivers))
+	}
+	for _, receiverID := range receivers {
+		s.walletChanged(tx, receiverID, currency)
+	}
+
+	// 预先分配流水ID, 保证明细与流水一一对应
+	var transactionIDs []int
+	err = tx.Select(&transactionIDs, "SELECT nextval(pg_get_serial_sequence('transactions', 'id')) FROM generate_series(1, $1)", len(accepted))
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed to allocate transaction ids", zap.Error(err))
+		return err
+	}
+	if len(transactionIDs) != len(accepted) {
+		return fmt.Errorf("allocated %d transaction ids for %d items", len(transactionIDs), len(accepted))
+	}
+
+	itemReceivers := make([]int, 0, len(accepted))
+	itemAmounts := make([]string, 0, len(accepted))
+	itemReferences := make([]string, 0, len(accepted))
+	entries := make([]models.JournalEntry, 0, len(accepted))
+	for n, i := range accepted {
+		item := items[i]
+		itemReceivers = append(itemReceivers, item.ReceiverUserID)
+		itemAmounts = append(itemAmounts, item.Amount.String())
+		itemReferences = append(itemReferences, item.Reference)
+		entries = append(entries, models.JournalEntry{
+			TransactionID: &transactionIDs[n],
+			Description:   string(models.TransferTransactionType),
+			Postings:      []models.Posting{walletPosting(senderID, currency, item.Amount.Neg()), walletPosting(item.ReceiverUserID, currency, item.Amount)},
+		})
+		batch.Items[i].Status, batch.Items[i].TransactionID = models.BatchItemSucceeded, transactionIDs[n]
+		batch.Succeeded++
+	}
+	_, err = tx.Exec(`
+		INSERT INTO transactions (id, sender_user_id, receiver_user_id, transaction_type, amount, currency, created_at, status, completed_at, external_reference)
+		SELECT t.id, $5, t.receiver_user_id, $6, t.amount, $7, $8, $9, $8, NULLIF(t.external_reference, '')
+		FROM unnest($1::int[], $2::int[], $3::numeric[], $4::text[]) AS t(id, receiver_user_id, amount, external_reference)`,
+		pq.Array(transactionIDs), pq.Array(itemReceivers), pq.Array(itemAmounts), pq.Array(itemReferences),
+		senderID, models.TransferTransactionType, currency, time.Now().UTC(), models.TransactionCompleted)
+	if isUniqueViolation(err, externalReferenceIndex) {
+		// 校验之后并发的请求使用了相同的单号
+		return fmt.Errorf("%w: %v", ErrDuplicateExternalReference, err)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed insert into transactions", zap.Int("senderID", senderID), zap.Error(err))
+		return err
+	}
+
+	if err = s.postJournalEntries(ctx, tx, entries); err != nil {
+		return err
+	}
+	return nil
+}
+
+// applyBatchFees 收取成功明细的手续费: 付款方一次扣除总额计入 FeeRevenueAccount, 每笔明细一条关联该笔流水的 fee 流水
+func (s *walletService) applyBatchFees(ctx context.Context, tx *sqlx.Tx, senderID, revenueUserID int, currency models.Currency,
+	fees []decimal.Decimal, totalFee decimal.Decimal, batch *models.BatchTransferResult) error {
+	if err := s.WithdrawWithTx(ctx, tx, senderID, totalFee, currency); err != nil {
+		return err
+	}
+
+	var charged []int
+	for i, item := range batch.Items {
+		if item.Status == models.BatchItemSucceeded && fees[i].IsPositive() {
+			charged = append(charged, i)
+		}
+	}
+	var feeIDs []int
+	err := tx.Select(&feeIDs, "SELECT nextval(pg_get_serial_sequence('transactions', 'id')) FROM generate_series(1, $1)", len(charged))
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed to allocate fee transaction ids", zap.Error(err))
+		return err
+	}
+	if len(feeIDs) != len(charged) {
+		return fmt.Errorf("allocated %d fee transaction ids for %d items", len(feeIDs), len(charged))
+	}
+
+	originalIDs := make([]int, 0, len(charged))
+	amounts := make([]string, 0, len(charged))
+	entries := make([]models.JournalEntry, 0, len(charged))
+	for n, i := range charged {
+		originalIDs = append(originalIDs, batch.Items[i].TransactionID)
+		amounts = append(amounts, fees[i].String())
+		entries = append(entries, models.JournalEntry{
+			TransactionID: &feeIDs[n],
+			Description:   string(models.FeeTransactionType),
+			Postings:      []models.Posting{walletPosting(senderID, currency, fees[i].Neg()), systemPosting(FeeRevenueAccount, currency, fees[i])},
+		})
+		batch.Items[i].Fee = &models.FeeResult{TransactionID: feeIDs[n], Amount: fees[i], Currency: currency}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO transactions (id, sender_user_id, receiver_user_id, transaction_type, amount, currency, original_transaction_id, created_at, status, completed_at)
+		SELECT t.id, $4, $5, $6, t.amount, $7, t.original_transaction_id, $8, $9, $8
+		FROM unnest($1::int[], $2::int[], $3::numeric[]) AS t(id, original_transaction_id, amount)`,
+		pq.Array(feeIDs), pq.Array(originalIDs), pq.Array(amounts),
+		senderID, revenueUserID, models.FeeTransactionType, currency, time.Now().UTC(), models.TransactionCompleted)
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed insert fee transactions", zap.Int("senderID", senderID), zap.Error(err))
+		return err
+	}
+	return s.postJournalEntries(ctx, tx, entries)
+}
+
+// recordBatch 保存批次和每笔明细的结果
+func (s *walletService) recordBatch(ctx context.Context, tx *sqlx.Tx, senderID int, batch *models.BatchTransferResult) error {
+	err := tx.Get(&batch.BatchID, `
+		INSERT INTO transfer_batches (sender_user_id, currency, mode, total_amount, item_count, succeeded_count)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		senderID, batch.Currency, batch.Mode, batch.TotalAmount, len(batch.Items), batch.Succeeded)
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed insert into transfer_batches", zap.Int("senderID", senderID), zap.Error(err))
+		return err
+	}
+
+	n := len(batch.Items)
+	receivers, transactionIDs := make([]int, 0, n), make([]sql.NullInt64, 0, n)
+	amounts, references, statuses, errs := make([]string, 0, n), make([]string, 0, n), make([]string, 0, n), make([]sql.NullString, 0, n)
+	for _, item := range batch.Items {
+		receivers = append(receivers, item.ReceiverUserID)
+		amounts = append(amounts, item.Amount.String())
+		references = append(references, item.Reference)
+		statuses = append(statuses, string(item.Status))
+		transactionIDs = append(transactionIDs, sql.NullInt64{Int64: int64(item.TransactionID), Valid: item.TransactionID != 0})
+		errs = append(errs, sql.NullString{String: item.Error, Valid: item.Error != ""})
+	}
+	_, err = tx.Exec(`
+		INSERT INTO transfer_batch_items (batch_id, item_index, receiver_user_id, amount, reference, status, transaction_id, error)
+		SELECT $1, i.ord - 1, i.receiver_user_id, i.amount, i.reference, i.status, i.transaction_id, i.error
+		FROM unnest($2::int[], $3::numeric[], $4::text[], $5::text[], $6::int[], $7::text[])
+		    WITH ORDINALITY AS i(receiver_user_id, amount, reference, status, transaction_id, error, ord)`,
+		batch.BatchID, pq.Array(receivers), pq.Array(amounts), pq.Array(references), pq.Array(statuses),
+		pq.Array(transactionIDs), pq.Array(errs))
+	if err != nil {
+		s.logger.Error(ctx, "BatchTransfer Failed insert into transfer_batch_items", zap.Int("batchID", batch.BatchID), zap.Error(err))
+		return err
+	}
+	return nil
+}
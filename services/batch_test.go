@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var batchWalletColumns = []string{"user_id", "balance", "held_balance", "credit_limit", "status"}
+
+// expectBatchWallets 期望按 user_id 升序锁定付款方和收款方后读取余额和状态
+func expectBatchWallets(mockDB sqlmock.Sqlmock, userIDs []int, rows *sqlmock.Rows) {
+	sorted := append([]int(nil), userIDs...)
+	sort.Ints(sorted)
+	locked := sqlmock.NewRows([]string{"user_id"})
+	for _, userID := range sorted {
+		locked.AddRow(userID)
+	}
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\) AND currency = \$2 ORDER BY user_id FOR UPDATE`).
+		WithArgs(pq.Array(sorted), models.USD).
+		WillReturnRows(locked)
+	mockDB.ExpectQuery(`SELECT user_id, balance, held_balance, credit_limit, status FROM wallets WHERE user_id = ANY\(\$1\)`).
+		WithArgs(pq.Array(userIDs), models.USD).
+		WillReturnRows(rows)
+}
+
+func TestWalletService_BatchTransfer_BestEffort(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, mockRedis := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据: 可用余额 100, 第二笔收款方钱包已注销, 第三笔转给自己, 其余两笔成功
+	senderID := 1
+	items := []models.BatchTransferItem{
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(60), Reference: "inv-1"},
+		{ReceiverUserID: 3, Amount: decimal.NewFromInt(50)},
+		{ReceiverUserID: senderID, Amount: decimal.NewFromInt(10)},
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(30)},
+	}
+	senderCode, receiverCode := WalletAccountCode(senderID, models.USD), WalletAccountCode(2, models.USD)
+
+	mockDB.ExpectBegin()
+	expectBatchWallets(mockDB, []int{senderID, 2, 3}, sqlmock.NewRows(batchWalletColumns).
+		AddRow(senderID, "120", "20", "0", models.WalletActive).AddRow(2, "0", "0", "0", models.WalletFrozen).AddRow(3, "0", "0", "0", models.WalletClosed))
+	expectWalletLimits(mockDB, models.USD, senderID, 2, 3)
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	mockDB.ExpectQuery("SELECT external_reference FROM transactions").
+		WithArgs(senderID, pq.Array([]string{"inv-1"})).
+		WillReturnRows(sqlmock.NewRows([]string{"external_reference"}))
+	// 付款方一次扣减总额, 同一收款方合并入账
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(decimal.NewFromInt(90), senderID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(`UPDATE wallets w SET balance = w.balance \+ c.amount`).
+		WithArgs(pq.Array([]int{2}), pq.Array([]string{"90"}), models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("SELECT nextval").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(21).AddRow(22))
+	mockDB.ExpectExec("INSERT INTO transactions").
+		WithArgs(pq.Array([]int{21, 22}), pq.Array([]int{2, 2}), pq.Array([]string{"60", "30"}), pq.Array([]string{"inv-1", ""}),
+			senderID, models.TransferTransactionType, models.USD, sqlmock.AnyArg(), models.TransactionCompleted).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(pq.Array([]int{21, 22}), pq.Array([]string{"transfer", "transfer"}), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id"}).AddRow(101, 21).AddRow(102, 22))
+	mockDB.ExpectExec("INSERT INTO ledger_accounts").
+		WithArgs(pq.Array([]string{senderCode, receiverCode}), pq.Array([]string{"wallet", "wallet"}),
+			pq.Array([]int{senderID, 2}), pq.Array([]string{"USD", "USD"}), pq.Array([]string{"-90", "90"})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec("INSERT INTO postings").
+		WithArgs(pq.Array([]int{101, 101, 102, 102}), pq.Array([]string{senderCode, receiverCode, senderCode, receiverCode}),
+			pq.Array([]string{"-60", "60", "-30", "30"}), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mockDB.ExpectQuery("SELECT COUNT").
+		WithArgs(pq.Array([]string{senderCode, receiverCode})).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	// 每笔明细: 付款方出账、收款方入账和转账完成
+	mockDB.ExpectExec("INSERT INTO outbox_events").
+		WithArgs(pq.Array([]string{senderCode, receiverCode, senderCode, senderCode, receiverCode, senderCode}),
+			pq.Array([]string{"WalletDebited", "WalletCredited", "TransferCompleted", "WalletDebited", "WalletCredited", "TransferCompleted"}),
+			sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 6))
+	mockDB.ExpectQuery("INSERT INTO transfer_batches").
+		WithArgs(senderID, models.USD, models.BatchBestEffort, decimal.NewFromInt(90), 4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mockDB.ExpectExec("INSERT INTO transfer_batch_items").
+		WithArgs(9, pq.Array([]int{2, 3, senderID, 2}), pq.Array([]string{"60", "50", "10", "30"}), pq.Array([]string{"inv-1", "", "", ""}),
+			pq.Array([]string{"succeeded", "failed", "failed", "succeeded"}),
+			pq.Array([]sql.NullInt64{{Int64: 21, Valid: true}, {}, {}, {Int64: 22, Valid: true}}),
+			pq.Array([]sql.NullString{{}, {String: ErrWalletClosed.Error(), Valid: true}, {String: "cannot transfer to the same wallet", Valid: true}, {}})).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mockDB.ExpectCommit()
+
+	// 执行 BatchTransfer 方法
+	result, err := service.BatchTransfer(context.Background(), senderID, models.USD, items, models.BatchBestEffort)
+
+	// 断言没有错误
+	assert.NoError(t, err)
+	assert.Equal(t, 9, result.Batch.BatchID)
+	assert.Equal(t, 2, result.Batch.Succeeded)
+	assert.Equal(t, 2, result.Batch.Failed)
+	assert.Equal(t, 22, result.Batch.Items[3].TransactionID)
+	assert.Equal(t, models.BatchItemFailed, result.Batch.Items[1].Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_BatchTransfer_AllOrNothingInsufficientFunds(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 总额 110 超过可用余额 100, 整批回滚, 不写入任何流水
+	items := []models.BatchTransferItem{
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(60)},
+		{ReceiverUserID: 3, Amount: decimal.NewFromInt(50)},
+	}
+	mockDB.ExpectBegin()
+	expectBatchWallets(mockDB, []int{1, 2, 3}, sqlmock.NewRows(batchWalletColumns).
+		AddRow(1, "100", "0", "0", models.WalletActive).AddRow(2, "0", "0", "0", models.WalletActive).AddRow(3, "0", "0", "0", models.WalletActive))
+	expectWalletLimits(mockDB, models.USD, 1, 2, 3)
+	expectApprovalThreshold(mockDB, 1, models.USD, "")
+	mockDB.ExpectRollback()
+
+	_, err = service.BatchTransfer(context.Background(), 1, models.USD, items, models.BatchAllOrNothing)
+
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_BatchTransfer_ApprovalRequired(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 共享钱包超过审批阈值 50 的明细不能通过批量付款绕过审批
+	items := []models.BatchTransferItem{
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(40)},
+		{ReceiverUserID: 3, Amount: decimal.NewFromInt(60)},
+	}
+	mockDB.ExpectBegin()
+	expectBatchWallets(mockDB, []int{1, 2, 3}, sqlmock.NewRows(batchWalletColumns).
+		AddRow(1, "100", "0", "0", models.WalletActive).AddRow(2, "0", "0", "0", models.WalletActive).AddRow(3, "0", "0", "0", models.WalletActive))
+	expectWalletLimits(mockDB, models.USD, 1, 2, 3)
+	expectApprovalThreshold(mockDB, 1, models.USD, "50")
+	mockDB.ExpectRollback()
+
+	_, err = service.BatchTransfer(context.Background(), 1, models.USD, items, models.BatchAllOrNothing)
+
+	assert.ErrorIs(t, err, ErrApprovalRequired)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_BatchTransfer_DuplicateReference(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 单号已被付款方之前的流水使用
+	expectDuplicateReferenceBatch := func(existing ...string) {
+		mockDB.ExpectBegin()
+		expectBatchWallets(mockDB, []int{1, 2, 3}, sqlmock.NewRows(batchWalletColumns).
+			AddRow(1, "100", "0", "0", models.WalletActive).AddRow(2, "0", "0", "0", models.WalletActive).AddRow(3, "0", "0", "0", models.WalletActive))
+		expectWalletLimits(mockDB, models.USD, 1, 2, 3)
+		expectApprovalThreshold(mockDB, 1, models.USD, "")
+		rows := sqlmock.NewRows([]string{"external_reference"})
+		for _, reference := range existing {
+			rows.AddRow(reference)
+		}
+		mockDB.ExpectQuery("SELECT external_reference FROM transactions").WillReturnRows(rows)
+		mockDB.ExpectRollback()
+	}
+
+	expectDuplicateReferenceBatch("inv-2")
+	_, err = service.BatchTransfer(context.Background(), 1, models.USD, []models.BatchTransferItem{
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(10), Reference: "inv-1"},
+		{ReceiverUserID: 3, Amount: decimal.NewFromInt(10), Reference: "inv-2"},
+	}, models.BatchAllOrNothing)
+	assert.ErrorIs(t, err, ErrDuplicateExternalReference)
+
+	// 同一批次内的明细重复使用单号
+	expectDuplicateReferenceBatch()
+	_, err = service.BatchTransfer(context.Background(), 1, models.USD, []models.BatchTransferItem{
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(10), Reference: "inv-1"},
+		{ReceiverUserID: 3, Amount: decimal.NewFromInt(10), Reference: "inv-1"},
+	}, models.BatchAllOrNothing)
+	assert.ErrorIs(t, err, ErrDuplicateExternalReference)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_BatchTransfer_Invalid(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 空批次和未知模式在开启事务前拒绝
+	_, err = service.BatchTransfer(context.Background(), 1, models.USD, nil, models.BatchAllOrNothing)
+	assert.ErrorIs(t, err, ErrInvalidBatch)
+	_, err = service.BatchTransfer(context.Background(), 1, models.USD, []models.BatchTransferItem{{ReceiverUserID: 2, Amount: decimal.NewFromInt(1)}}, "sometimes")
+	assert.ErrorIs(t, err, ErrInvalidBatch)
+
+	// all_or_nothing 模式下任一明细无效则整批失败
+	mockDB.ExpectBegin()
+	expectBatchWallets(mockDB, []int{1, 2, 3}, sqlmock.NewRows(batchWalletColumns).
+		AddRow(1, "100", "0", "0", models.WalletActive).AddRow(2, "0", "0", "0", models.WalletActive).AddRow(3, "0", "0", "0", models.WalletActive))
+	expectWalletLimits(mockDB, models.USD, 1, 2, 3)
+	expectApprovalThreshold(mockDB, 1, models.USD, "")
+	mockDB.ExpectRollback()
+
+	items := []models.BatchTransferItem{
+		{ReceiverUserID: 2, Amount: decimal.NewFromInt(10)},
+		{ReceiverUserID: 3, Amount: decimal.NewFromInt(-5)},
+	}
+	_, err = service.BatchTransfer(context.Background(), 1, models.USD, items, models.BatchAllOrNothing)
+	assert.ErrorIs(t, err, ErrInvalidBatch)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_BatchTransfer_SenderFrozen(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 付款方钱包被冻结时两种模式都整批拒绝; 钱包按 user_id 升序加锁, 与付款方、收款方的角色无关
+	mockDB.ExpectBegin()
+	expectBatchWallets(mockDB, []int{5, 2}, sqlmock.NewRows(batchWalletColumns).
+		AddRow(2, "0", "0", "0", models.WalletActive).AddRow(5, "100", "0", "0", models.WalletFrozen))
+	mockDB.ExpectRollback()
+
+	items := []models.BatchTransferItem{{ReceiverUserID: 2, Amount: decimal.NewFromInt(10)}}
+	_, err = service.BatchTransfer(context.Background(), 5, models.USD, items, models.BatchBestEffort)
+	assert.ErrorIs(t, err, ErrWalletFrozen)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ApplyBatchFees(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	// 只对成功的明细收费, 付款方一次扣除总额计入手续费收入账户(不锁定收入钱包), 每笔 fee 流水关联该笔转账
+	senderID, revenueUserID := 1, 99
+	fees := []decimal.Decimal{decimal.NewFromInt(1), decimal.NewFromInt(2), decimal.RequireFromString("0.5")}
+	totalFee := decimal.RequireFromString("1.5")
+	batch := &models.BatchTransferResult{Items: []models.BatchItemResult{
+		{Index: 0, Status: models.BatchItemSucceeded, TransactionID: 21},
+		{Index: 1, Status: models.BatchItemFailed},
+		{Index: 2, Status: models.BatchItemSucceeded, TransactionID: 22},
+	}}
+	senderCode, revenueCode := WalletAccountCode(senderID, models.USD), SystemAccountCode(FeeRevenueAccount, models.USD)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(totalFee, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("10"))
+	mockDB.ExpectQuery("SELECT nextval").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(31).AddRow(32))
+	mockDB.ExpectExec("INSERT INTO transactions").
+		WithArgs(pq.Array([]int{31, 32}), pq.Array([]int{21, 22}), pq.Array([]string{"1", "0.5"}),
+			senderID, revenueUserID, models.FeeTransactionType, models.USD, sqlmock.AnyArg(), models.TransactionCompleted).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(pq.Array([]int{31, 32}), pq.Array([]string{"fee", "fee"}), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id"}).AddRow(101, 31).AddRow(102, 32))
+	mockDB.ExpectExec("INSERT INTO ledger_accounts").
+		WithArgs(pq.Array([]string{senderCode}), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), pq.Array([]string{"-1.5"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec("INSERT INTO ledger_accounts").
+		WithArgs(pq.Array([]string{revenueCode}), pq.Array([]string{string(models.USD)}), models.SystemAccountType).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec("INSERT INTO postings").
+		WithArgs(pq.Array([]int{101, 101, 102, 102}), pq.Array([]string{senderCode, revenueCode, senderCode, revenueCode}),
+			pq.Array([]string{"-1", "1", "-0.5", "0.5"}), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mockDB.ExpectQuery("SELECT COUNT").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	expectOutboxEvents(mockDB)
+	mockDB.ExpectCommit()
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+	err = service.applyBatchFees(context.Background(), tx, senderID, revenueUserID, models.USD, fees, totalFee, batch)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	assert.Equal(t, &models.FeeResult{TransactionID: 31, Amount: fees[0], Currency: models.USD}, batch.Items[0].Fee)
+	assert.Nil(t, batch.Items[1].Fee)
+	assert.Equal(t, 32, batch.Items[2].Fee.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"wallet-service/models"
+)
+
+var (
+	ErrInvalidCreditLimit = errors.New("invalid credit limit")
+	ErrCreditLimitInUse   = errors.New("credit limit is below the credit in use")
+)
+
+// SetCreditLimit 设置钱包的授信额度, 为 0 时取消授信; 新额度不能低于已使用的额度(透支金额加冻结金额)
+func (s *walletService) SetCreditLimit(ctx context.Context, userID int, currency models.Currency, creditLimit decimal.Decimal) (*models.Wallet, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	if creditLimit.IsNegative() {
+		return nil, fmt.Errorf("%w: must not be negative", ErrInvalidCreditLimit)
+	}
+	if !creditLimit.IsZero() {
+		if err := validateMoney(creditLimit, currency); err != nil {
+			return nil, err
+		}
+	}
+
+	var wallet models.Wallet
+	err := s.runInTx(ctx, "SetCreditLimit", func(tx *sqlx.Tx) error {
+		err := tx.Get(&wallet, "SELECT "+walletColumns+" FROM wallets WHERE user_id = $1 AND currency = $2 FOR UPDATE", userID, currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			s.logger.Error(ctx, "SetCreditLimit Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+		if wallet.Status == models.WalletClosed {
+			return ErrWalletClosed
+		}
+		if used := wallet.HeldBalance.Sub(wallet.Balance); used.GreaterThan(creditLimit) {
+			return fmt.Errorf("%w: %s in use", ErrCreditLimitInUse, used.String())
+		}
+
+		err = tx.Get(&wallet.UpdatedAt, "UPDATE wallets SET credit_limit = $1 WHERE user_id = $2 AND currency = $3 RETURNING updated_at",
+			creditLimit, userID, currency)
+		if err != nil {
+			s.logger.Error(ctx, "SetCreditLimit Failed to update wallets", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+		s.walletChanged(tx, userID, currency)
+		wallet.CreditLimit = creditLimit
+		return nil
+	})
+	if err != nil {
+		s.logger.Error(ctx, "SetCreditLimit Failed", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return &wallet, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func TestWalletService_SetCreditLimit(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+	now := time.Now()
+	creditLimit := decimal.RequireFromString("500")
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT .* FROM wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns).AddRow(1, "USD", "-100", "20", "200", "active", now, now))
+	mockDB.ExpectQuery(`UPDATE wallets SET credit_limit = \$1`).
+		WithArgs(creditLimit, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(now))
+	mockDB.ExpectCommit()
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: 1, Currency: models.USD, Balance: decimal.RequireFromString("-100"),
+		HeldBalance: decimal.RequireFromString("20"), CreditLimit: creditLimit, Version: 4})
+
+	wallet, err := service.SetCreditLimit(context.Background(), 1, models.USD, creditLimit)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "380", wallet.Available().String())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_SetCreditLimit_InUse(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+	now := time.Now()
+
+	// 已透支 100 并冻结 20, 额度不能降到 120 以下
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT .* FROM wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns).AddRow(1, "USD", "-100", "20", "200", "active", now, now))
+	mockDB.ExpectRollback()
+
+	_, err = service.SetCreditLimit(context.Background(), 1, models.USD, decimal.NewFromInt(100))
+	assert.ErrorIs(t, err, ErrCreditLimitInUse)
+
+	_, err = service.SetCreditLimit(context.Background(), 1, models.USD, decimal.NewFromInt(-1))
+	assert.ErrorIs(t, err, ErrInvalidCreditLimit)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_GetBalance_WithCredit(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	service := NewWalletService(logger.NewLogger(), nil, client)
+
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).
+		SetVal([]interface{}{"4", "-100", "20", "480"})
+
+	balance, err := service.GetBalance(context.Background(), 1, models.USD)
+
+	// 可用余额 = -100 + 480 - 20, 透支和冻结共占用额度 120
+	assert.NoError(t, err)
+	assert.Equal(t, "360", balance.Available.String())
+	assert.Equal(t, "120", balance.Credit.Used.String())
+	assert.Equal(t, "0.25", balance.Credit.Utilization.String())
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"wallet-service/models"
+)
+
+var (
+	ErrUnsupportedCurrency    = errors.New("unsupported currency")
+	ErrInvalidAmountPrecision = errors.New("amount has more decimal places than the currency allows")
+	ErrCurrencyMismatch       = errors.New("cannot move funds between different currencies without conversion")
+)
+
+// validateMoney 校验金额为正数, 币种受支持, 且小数位不超过该币种的最小单位
+func validateMoney(amount decimal.Decimal, currency models.Currency) error {
+	if !amount.IsPositive() {
+		return errors.New("amount must be greater than zero")
+	}
+	units, ok := currency.MinorUnits()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	if !amount.Equal(amount.Truncate(units)) {
+		return fmt.Errorf("%w: %s allows %d decimal places", ErrInvalidAmountPrecision, currency, units)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"wallet-service/models"
+)
+
+func TestValidateMoney(t *testing.T) {
+	assert.NoError(t, validateMoney(decimal.RequireFromString("10.25"), models.USD))
+	assert.NoError(t, validateMoney(decimal.RequireFromString("100"), models.JPY))
+	assert.NoError(t, validateMoney(decimal.RequireFromString("1.125"), models.Currency("KWD")))
+
+	// 日元没有小数位
+	assert.ErrorIs(t, validateMoney(decimal.RequireFromString("100.5"), models.JPY), ErrInvalidAmountPrecision)
+	assert.ErrorIs(t, validateMoney(decimal.RequireFromString("10.001"), models.USD), ErrInvalidAmountPrecision)
+	assert.ErrorIs(t, validateMoney(decimal.RequireFromString("10"), models.Currency("XXX")), ErrUnsupportedCurrency)
+	assert.EqualError(t, validateMoney(decimal.Zero, models.USD), "amount must be greater than zero")
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+	"wallet-service/models"
+)
+
+const (
+	maxMemoLength              = 255
+	maxExternalReferenceLength = 128
+	maxMetadataSize            = 4096
+	// externalReferenceIndex 调用方单号的唯一索引
+	externalReferenceIndex = "idx_transactions_external_reference"
+)
+
+var (
+	ErrInvalidTransactionDetails  = errors.New("invalid transaction details")
+	ErrDuplicateExternalReference = errors.New("external reference already used")
+)
+
+// validateTransactionDetails 校验备注、单号长度, 元数据必须是 JSON 对象
+func validateTransactionDetails(details models.TransactionDetails) error {
+	if utf8.RuneCountInString(details.Memo) > maxMemoLength {
+		return fmt.Errorf("%w: memo must not exceed %d characters", ErrInvalidTransactionDetails, maxMemoLength)
+	}
+	if utf8.RuneCountInString(details.ExternalReference) > maxExternalReferenceLength {
+		return fmt.Errorf("%w: external_reference must not exceed %d characters", ErrInvalidTransactionDetails, maxExternalReferenceLength)
+	}
+	if len(details.Metadata) == 0 {
+		return nil
+	}
+	if len(details.Metadata) > maxMetadataSize {
+		return fmt.Errorf("%w: metadata must not exceed %d bytes", ErrInvalidTransactionDetails, maxMetadataSize)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(details.Metadata, &object); err != nil || object == nil {
+		return fmt.Errorf("%w: metadata must be a JSON object", ErrInvalidTransactionDetails)
+	}
+	return nil
+}
+
+// withDetails 把附加信息写入待保存的流水
+func withDetails(transaction models.Transaction, details models.TransactionDetails) models.Transaction {
+	if details.Memo != "" {
+		transaction.Memo = &details.Memo
+	}
+	if details.ExternalReference != "" {
+		transaction.ExternalReference = &details.ExternalReference
+	}
+	transaction.Metadata = details.Metadata
+	return transaction
+}
+
+// detailsRequestHash 有附加信息时计入请求摘要; 没有时保持原摘要, 已保存的幂等键不受影响
+func detailsRequestHash(hash string, details models.TransactionDetails) string {
+	if details.IsZero() {
+		return hash
+	}
+	var metadata bytes.Buffer
+	if err := json.Compact(&metadata, details.Metadata); err != nil {
+		metadata.Write(details.Metadata)
+	}
+	return requestHash(hash, details.Memo, details.ExternalReference, metadata.String())
+}
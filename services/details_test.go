@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func TestValidateTransactionDetails(t *testing.T) {
+	assert.NoError(t, validateTransactionDetails(models.TransactionDetails{}))
+	assert.NoError(t, validateTransactionDetails(models.TransactionDetails{Memo: "rent", ExternalReference: "inv-1", Metadata: models.Metadata(`{"order": 42}`)}))
+
+	err := validateTransactionDetails(models.TransactionDetails{Metadata: models.Metadata(`[1, 2]`)})
+	assert.ErrorIs(t, err, ErrInvalidTransactionDetails)
+	err = validateTransactionDetails(models.TransactionDetails{Metadata: models.Metadata(`{"order":`)})
+	assert.ErrorIs(t, err, ErrInvalidTransactionDetails)
+	err = validateTransactionDetails(models.TransactionDetails{ExternalReference: strings.Repeat("x", maxExternalReferenceLength+1)})
+	assert.ErrorIs(t, err, ErrInvalidTransactionDetails)
+}
+
+func TestDetailsRequestHash(t *testing.T) {
+	hash := requestHash("deposit", 1, 1, decimal.NewFromInt(100), models.USD, models.DepositTransactionType)
+
+	// 没有附加信息时摘要不变, 元数据只有格式差异时摘要相同
+	assert.Equal(t, hash, detailsRequestHash(hash, models.TransactionDetails{}))
+	a := detailsRequestHash(hash, models.TransactionDetails{Memo: "rent", Metadata: models.Metadata(`{"order": 42}`)})
+	b := detailsRequestHash(hash, models.TransactionDetails{Memo: "rent", Metadata: models.Metadata(`{"order":42}`)})
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, hash, a)
+	assert.NotEqual(t, a, detailsRequestHash(hash, models.TransactionDetails{Memo: "salary", Metadata: models.Metadata(`{"order":42}`)}))
+}
+
+func TestWalletService_Deposit_WithDetails(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, _ := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据
+	amount := decimal.NewFromInt(100)
+	details := models.TransactionDetails{Memo: "top up", ExternalReference: "psp-991", Metadata: models.Metadata(`{"channel":"card"}`)}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// 备注、单号和元数据随流水保存
+	expectWalletLimits(mockDB, models.USD, 1)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.DepositTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(),
+			"top up", "psp-991", `{"channel":"card"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	expectJournalEntry(mockDB, systemPosting(ExternalCashInAccount, models.USD, amount.Neg()), walletPosting(1, models.USD, amount))
+	mockDB.ExpectCommit()
+
+	// 执行 Deposit 方法
+	result, err := service.Deposit(context.Background(), 1, 1, amount, models.USD, models.DepositTransactionType, details)
+
+	// 断言没有错误
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Deposit_DuplicateExternalReference(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 同一用户重复使用单号时违反唯一索引, 整个事务回滚
+	amount := decimal.NewFromInt(100)
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectWalletLimits(mockDB, models.USD, 1)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WillReturnError(&pq.Error{Code: pqUniqueViolation, Constraint: externalReferenceIndex})
+	mockDB.ExpectRollback()
+
+	_, err = service.Deposit(context.Background(), 1, 1, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{ExternalReference: "psp-991"})
+
+	assert.ErrorIs(t, err, ErrDuplicateExternalReference)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
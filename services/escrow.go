@@ -0,0 +1,415 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const (
+	defaultEscrowTTL = 14 * 24 * time.Hour
+	escrowBatchSize  = 100
+)
+
+var (
+	ErrEscrowNotFound = errors.New("escrow not found")
+	ErrInvalidEscrow  = errors.New("invalid escrow")
+	ErrEscrowSettled  = errors.New("escrow is already settled")
+)
+
+type EscrowService interface {
+	CreateEscrow(ctx context.Context, escrow models.Escrow) (*models.Escrow, error)
+	GetEscrow(ctx context.Context, escrowID int) (*models.Escrow, error)
+	ListEscrows(ctx context.Context, userID int) ([]models.Escrow, error)
+	ReleaseEscrow(ctx context.Context, escrowID, actorID int, amount decimal.Decimal) (*models.Escrow, error)
+	RefundEscrow(ctx context.Context, escrowID, actorID int, amount decimal.Decimal) (*models.Escrow, error)
+	SplitEscrow(ctx context.Context, escrowID, actorID int, sellerAmount decimal.Decimal) (*models.Escrow, error)
+}
+
+type escrowService struct {
+	db      *sqlx.DB
+	logger  *wallet_logger.Logger
+	wallets *walletService // 资金变动复用钱包的事务内操作
+}
+
+var _ EscrowService = &escrowService{}
+
+// NewEscrowService service
+func NewEscrowService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) EscrowService {
+	return newEscrowService(logger, db, redis)
+}
+
+func newEscrowService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *escrowService {
+	return &escrowService{
+		db:     db,
+		logger: logger,
+		wallets: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// CreateEscrow 创建托管, 买方资金在同一事务内转入托管账户; 未指定到期时间时默认 14 天, 到期默认退款给买方
+func (s *escrowService) CreateEscrow(ctx context.Context, escrow models.Escrow) (*models.Escrow, error) {
+	if escrow.BuyerUserID == escrow.SellerUserID {
+		return nil, fmt.Errorf("%w: buyer and seller are the same", ErrInvalidEscrow)
+	}
+	// 按请求原样计算幂等摘要, 默认值不参与
+	hash := requestHash("escrow", escrow.BuyerUserID, escrow.SellerUserID, escrow.Amount, escrow.Currency, escrow.OnExpiry, escrow.ExpiresAt.Unix())
+	if err := validateMoney(escrow.Amount, escrow.Currency); err != nil {
+		return nil, err
+	}
+	if escrow.OnExpiry == "" {
+		escrow.OnExpiry = models.EscrowRefund
+	}
+	if !escrow.OnExpiry.Valid() {
+		return nil, fmt.Errorf("%w: unsupported on_expiry %q", ErrInvalidEscrow, escrow.OnExpiry)
+	}
+	if escrow.ExpiresAt.IsZero() {
+		escrow.ExpiresAt = time.Now().Add(defaultEscrowTTL)
+	}
+	if !escrow.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidEscrow)
+	}
+
+	w := s.wallets
+	var created models.Escrow
+	err := w.runInTx(ctx, "CreateEscrow", func(tx *sqlx.Tx) error {
+		replayed, err := w.claimIdempotencyKey(ctx, tx, escrow.BuyerUserID, hash)
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			return tx.Get(&created, "SELECT * FROM escrows WHERE funding_transaction_id = $1", replayed.TransactionID)
+		}
+
+		// 卖方钱包需已存在且未注销, 否则之后无法放款
+		var sellerStatus models.WalletStatus
+		err = tx.Get(&sellerStatus, "SELECT status FROM wallets WHERE user_id = $1 AND currency = $2", escrow.SellerUserID, escrow.Currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: seller %d", ErrWalletNotFound, escrow.SellerUserID)
+		}
+		if err != nil {
+			return err
+		}
+		if sellerStatus == models.WalletClosed {
+			return fmt.Errorf("%w: seller %d", ErrWalletClosed, escrow.SellerUserID)
+		}
+
+		if err = w.WithdrawWithTx(ctx, tx, escrow.BuyerUserID, escrow.Amount, escrow.Currency); err != nil {
+			return err
+		}
+		if err = w.chargeOutflow(ctx, tx, escrow.BuyerUserID, escrow.Currency, escrow.Amount, 1); err != nil {
+			return err
+		}
+
+		err = tx.Get(&created, `
+			INSERT INTO escrows (buyer_user_id, seller_user_id, currency, amount, balance, status, on_expiry, expires_at)
+			VALUES ($1, $2, $3, $4, $4, $5, $6, $7) RETURNING *`,
+			escrow.BuyerUserID, escrow.SellerUserID, escrow.Currency, escrow.Amount, models.EscrowFunded, escrow.OnExpiry, escrow.ExpiresAt)
+		if err != nil {
+			s.logger.Error(ctx, "CreateEscrow Failed insert into escrows", zap.Int("buyerID", escrow.BuyerUserID), zap.Error(err))
+			return err
+		}
+
+		// 资金变动记在买方自己名下, 卖方在放款时才入账
+		transactionID, err := w.recordTransaction(ctx, tx, models.Transaction{
+			SenderUserID:    created.BuyerUserID,
+			ReceiverUserID:  created.BuyerUserID,
+			TransactionType: models.EscrowFundTransactionType,
+			Amount:          created.Amount,
+			Currency:        created.Currency,
+		}, []models.Posting{
+			walletPosting(created.BuyerUserID, created.Currency, created.Amount.Neg()),
+			escrowPosting(created, created.Amount),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("UPDATE escrows SET funding_transaction_id = $1 WHERE id = $2", transactionID, created.ID); err != nil {
+			return err
+		}
+		created.FundingTransactionID = &transactionID
+		return w.saveIdempotencyResult(ctx, tx, escrow.BuyerUserID, &models.TransactionResult{TransactionID: transactionID})
+	})
+	if err != nil {
+		s.logger.Error(ctx, "CreateEscrow Failed", zap.Int("buyerID", escrow.BuyerUserID), zap.Int("sellerID", escrow.SellerUserID), zap.Error(err))
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetEscrow 查询托管及其结算记录
+func (s *escrowService) GetEscrow(ctx context.Context, escrowID int) (*models.Escrow, error) {
+	var escrow models.Escrow
+	err := s.db.GetContext(ctx, &escrow, "SELECT * FROM escrows WHERE id = $1", escrowID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrEscrowNotFound, escrowID)
+	}
+	if err == nil {
+		err = s.db.SelectContext(ctx, &escrow.Settlements, "SELECT * FROM escrow_settlements WHERE escrow_id = $1 ORDER BY id", escrowID)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "GetEscrow Failed", zap.Int("escrowID", escrowID), zap.Error(err))
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+// ListEscrows 查询用户作为买方或卖方的托管
+func (s *escrowService) ListEscrows(ctx context.Context, userID int) ([]models.Escrow, error) {
+	escrows := []models.Escrow{}
+	err := s.db.SelectContext(ctx, &escrows, "SELECT * FROM escrows WHERE buyer_user_id = $1 OR seller_user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		s.logger.Error(ctx, "ListEscrows Failed select from escrows", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return escrows, nil
+}
+
+// settleAmount 校验部分结算的金额, 为零时表示全部剩余金额
+func settleAmount(escrow *models.Escrow, amount decimal.Decimal) (decimal.Decimal, error) {
+	if amount.IsZero() {
+		return escrow.Balance, nil
+	}
+	if err := validateMoney(amount, escrow.Currency); err != nil {
+		return decimal.Zero, err
+	}
+	if amount.GreaterThan(escrow.Balance) {
+		return decimal.Zero, fmt.Errorf("%w: amount exceeds the remaining %s", ErrInvalidEscrow, escrow.Balance.String())
+	}
+	return amount, nil
+}
+
+// ReleaseEscrow 买方确认收货, 放款给卖方; amount 为零时放款全部剩余金额
+func (s *escrowService) ReleaseEscrow(ctx context.Context, escrowID, actorID int, amount decimal.Decimal) (*models.Escrow, error) {
+	return s.settle(ctx, "ReleaseEscrow", escrowID, actorID, requestHash("escrow_release", escrowID, actorID, amount),
+		func(tx *sqlx.Tx, escrow *models.Escrow) error {
+			if actorID != escrow.BuyerUserID {
+				return fmt.Errorf("%w: only the buyer can release escrow", ErrNotPermitted)
+			}
+			release, err := settleAmount(escrow, amount)
+			if err != nil {
+				return err
+			}
+			return s.settleWithTx(ctx, tx, escrow, release, decimal.Zero, models.TriggeredByBuyer)
+		})
+}
+
+// RefundEscrow 卖方退款给买方; amount 为零时退还全部剩余金额
+func (s *escrowService) RefundEscrow(ctx context.Context, escrowID, actorID int, amount decimal.Decimal) (*models.Escrow, error) {
+	return s.settle(ctx, "RefundEscrow", escrowID, actorID, requestHash("escrow_refund", escrowID, actorID, amount),
+		func(tx *sqlx.Tx, escrow *models.Escrow) error {
+			if actorID != escrow.SellerUserID {
+				return fmt.Errorf("%w: only the seller can refund escrow", ErrNotPermitted)
+			}
+			refund, err := settleAmount(escrow, amount)
+			if err != nil {
+				return err
+			}
+			return s.settleWithTx(ctx, tx, escrow, decimal.Zero, refund, models.TriggeredBySeller)
+		})
+}
+
+// SplitEscrow 拆分剩余金额: sellerAmount 放款给卖方, 其余退款给买方. 一方提议后需另一方提交相同的金额确认才会执行
+func (s *escrowService) SplitEscrow(ctx context.Context, escrowID, actorID int, sellerAmount decimal.Decimal) (*models.Escrow, error) {
+	return s.settle(ctx, "SplitEscrow", escrowID, actorID, requestHash("escrow_split", escrowID, actorID, sellerAmount),
+		func(tx *sqlx.Tx, escrow *models.Escrow) error {
+			var trigger models.EscrowTrigger
+			switch actorID {
+			case escrow.BuyerUserID:
+				trigger = models.TriggeredByBuyer
+			case escrow.SellerUserID:
+				trigger = models.TriggeredBySeller
+			default:
+				return fmt.Errorf("%w: only the buyer or seller can split escrow", ErrNotPermitted)
+			}
+			if sellerAmount.IsNegative() || sellerAmount.GreaterThan(escrow.Balance) {
+				return fmt.Errorf("%w: seller_amount must be between 0 and %s", ErrInvalidEscrow, escrow.Balance.String())
+			}
+			if !sellerAmount.IsZero() {
+				if err := validateMoney(sellerAmount, escrow.Currency); err != nil {
+					return err
+				}
+			}
+
+			proposal := escrow.ProposedSellerAmount
+			if escrow.ProposedSplitBy == nil || *escrow.ProposedSplitBy == actorID || !proposal.Equal(sellerAmount) {
+				// 新的提议覆盖之前的提议
+				return tx.Get(escrow, "UPDATE escrows SET proposed_split_by = $1, proposed_seller_amount = $2 WHERE id = $3 RETURNING *",
+					actorID, sellerAmount, escrow.ID)
+			}
+			return s.settleWithTx(ctx, tx, escrow, sellerAmount, escrow.Balance.Sub(sellerAmount), trigger)
+		})
+}
+
+// settle 锁定托管后执行结算, 支持按操作方 actorID 区分的幂等键; 已结算的托管返回 ErrEscrowSettled
+func (s *escrowService) settle(ctx context.Context, name string, escrowID, actorID int, hash string, fn func(tx *sqlx.Tx, escrow *models.Escrow) error) (*models.Escrow, error) {
+	w := s.wallets
+	var escrow models.Escrow
+	replayed := false
+	err := w.runInTx(ctx, name, func(tx *sqlx.Tx) error {
+		result, err := w.claimIdempotencyKey(ctx, tx, actorID, hash)
+		if err != nil {
+			return err
+		}
+		if replayed = result != nil; replayed {
+			return nil
+		}
+
+		escrow = models.Escrow{}
+		if err = s.lockEscrow(tx, escrowID, &escrow); err != nil {
+			return err
+		}
+		if err = fn(tx, &escrow); err != nil {
+			return err
+		}
+		// 重放时重新查询托管, 不依赖保存的结果
+		return w.saveIdempotencyResult(ctx, tx, actorID, &models.TransactionResult{})
+	})
+	if err != nil {
+		s.logger.Error(ctx, name+" Failed", zap.Int("escrowID", escrowID), zap.Error(err))
+		return nil, err
+	}
+	if replayed {
+		return s.GetEscrow(ctx, escrowID)
+	}
+	return &escrow, nil
+}
+
+// lockEscrow 锁定未结算的托管
+func (s *escrowService) lockEscrow(tx *sqlx.Tx, escrowID int, escrow *models.Escrow) error {
+	err := tx.Get(escrow, "SELECT * FROM escrows WHERE id = $1 FOR UPDATE", escrowID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %d", ErrEscrowNotFound, escrowID)
+	}
+	if err != nil {
+		return err
+	}
+	if escrow.Status != models.EscrowFunded {
+		return fmt.Errorf("%w: escrow %d is %s", ErrEscrowSettled, escrowID, escrow.Status)
+	}
+	return nil
+}
+
+// settleWithTx 在事务内从托管账户放款给卖方、退款给买方, 每笔记录一条交易和结算记录; 余额结清后更新状态, 并清除未确认的拆分提议
+func (s *escrowService) settleWithTx(ctx context.Context, tx *sqlx.Tx, escrow *models.Escrow,
+	sellerAmount, buyerAmount decimal.Decimal, trigger models.EscrowTrigger) error {
+	w := s.wallets
+	legs := []struct {
+		action          models.EscrowAction
+		userID          int
+		amount          decimal.Decimal
+		column          string
+		transactionType models.TransactionType
+	}{
+		{models.EscrowRelease, escrow.SellerUserID, sellerAmount, "released_amount", models.EscrowReleaseTransactionType},
+		{models.EscrowRefund, escrow.BuyerUserID, buyerAmount, "refunded_amount", models.EscrowRefundTransactionType},
+	}
+	for _, leg := range legs {
+		if !leg.amount.IsPositive() {
+			continue
+		}
+		// 先扣减托管余额, 分录核对时托管余额与账户余额一致
+		err := tx.Get(escrow, fmt.Sprintf("UPDATE escrows SET balance = balance - $1, %[1]s = %[1]s + $1 WHERE id = $2 RETURNING *", leg.column),
+			leg.amount, escrow.ID)
+		if err != nil {
+			s.logger.Error(ctx, "settleWithTx Failed to update escrows", zap.Int("escrowID", escrow.ID), zap.Error(err))
+			return err
+		}
+		if err = w.DepositWithTx(ctx, tx, leg.userID, leg.amount, escrow.Currency); err != nil {
+			return err
+		}
+		if err = w.checkCreditLimit(ctx, tx, leg.userID, escrow.Currency); err != nil {
+			return err
+		}
+
+		transactionID, err := w.recordTransaction(ctx, tx, models.Transaction{
+			SenderUserID:    leg.userID,
+			ReceiverUserID:  leg.userID,
+			TransactionType: leg.transactionType,
+			Amount:          leg.amount,
+			Currency:        escrow.Currency,
+		}, []models.Posting{
+			escrowPosting(*escrow, leg.amount.Neg()),
+			walletPosting(leg.userID, escrow.Currency, leg.amount),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO escrow_settlements (escrow_id, transaction_id, settlement_type, amount, triggered_by) VALUES ($1, $2, $3, $4, $5)",
+			escrow.ID, transactionID, leg.action, leg.amount, trigger)
+		if err != nil {
+			s.logger.Error(ctx, "settleWithTx Failed insert into escrow_settlements", zap.Int("escrowID", escrow.ID), zap.Error(err))
+			return err
+		}
+	}
+
+	status := escrow.SettledStatus()
+	var settledAt *time.Time
+	if status != models.EscrowFunded {
+		now := time.Now()
+		settledAt = &now
+	}
+	return tx.Get(escrow, "UPDATE escrows SET status = $1, settled_at = $2, proposed_split_by = NULL, proposed_seller_amount = NULL WHERE id = $3 RETURNING *",
+		status, settledAt, escrow.ID)
+}
+
+// EscrowExpirer 定期按 on_expiry 结算到期的托管
+type EscrowExpirer struct {
+	service *escrowService
+}
+
+// NewEscrowExpirer new escrow expirer
+func NewEscrowExpirer(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *EscrowExpirer {
+	return &EscrowExpirer{service: newEscrowService(logger, db, redis)}
+}
+
+// ExpireEscrows 结算到期的托管, 单个托管失败(如收款方钱包已注销)不影响其他托管, 下一轮重试
+func (e *EscrowExpirer) ExpireEscrows(ctx context.Context) error {
+	s := e.service
+	var ids []int
+	err := s.db.SelectContext(ctx, &ids, "SELECT id FROM escrows WHERE status = $1 AND expires_at <= $2 ORDER BY expires_at LIMIT $3",
+		models.EscrowFunded, time.Now(), escrowBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = e.expire(ctx, id); err != nil {
+			s.logger.Error(ctx, "EscrowExpirer Failed to settle expired escrow", zap.Int("escrowID", id), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (e *EscrowExpirer) expire(ctx context.Context, escrowID int) error {
+	s := e.service
+	return s.wallets.runInTx(ctx, "ExpireEscrow", func(tx *sqlx.Tx) error {
+		var escrow models.Escrow
+		err := s.lockEscrow(tx, escrowID, &escrow)
+		if errors.Is(err, ErrEscrowSettled) {
+			// 已被买卖双方结算
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if escrow.ExpiresAt.After(time.Now()) {
+			return nil
+		}
+		if escrow.OnExpiry == models.EscrowRelease {
+			return s.settleWithTx(ctx, tx, &escrow, escrow.Balance, decimal.Zero, models.TriggeredByExpiry)
+		}
+		return s.settleWithTx(ctx, tx, &escrow, decimal.Zero, escrow.Balance, models.TriggeredByExpiry)
+	})
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var escrowRowColumns = []string{"id", "buyer_user_id", "seller_user_id", "currency", "amount", "balance", "released_amount", "refunded_amount",
+	"status", "on_expiry", "expires_at", "proposed_split_by", "proposed_seller_amount", "funding_transaction_id", "settled_at", "created_at", "updated_at"}
+
+func newTestEscrowService(t *testing.T) (*escrowService, sqlmock.Sqlmock, redismock.ClientMock) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return newEscrowService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client), mockDB, mockRedis
+}
+
+// escrowRows 托管 5: 买方 1 向卖方 2 托管 100 USD
+func escrowRows(balance, released, refunded string, status models.EscrowStatus, expiresAt time.Time, proposedBy, proposedAmount interface{}) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(escrowRowColumns).
+		AddRow(5, 1, 2, "USD", "100", balance, released, refunded, status, models.EscrowRefund, expiresAt, proposedBy, proposedAmount, 30, nil, now, now)
+}
+
+func expectLockEscrow(mockDB sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mockDB.ExpectQuery(`SELECT \* FROM escrows WHERE id = \$1 FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(rows)
+}
+
+// expectEscrowLeg 期望从托管 5 向 userID 结算一笔
+func expectEscrowLeg(mockDB sqlmock.Sqlmock, mockRedis redismock.ClientMock, action models.EscrowAction, userID int, amount decimal.Decimal,
+	transactionID int, trigger models.EscrowTrigger, after *sqlmock.Rows) {
+	column, transactionType := "released_amount", models.EscrowReleaseTransactionType
+	if action == models.EscrowRefund {
+		column, transactionType = "refunded_amount", models.EscrowRefundTransactionType
+	}
+	mockDB.ExpectQuery(`UPDATE escrows SET balance = balance - \$1, `+column).
+		WithArgs(amount, 5).
+		WillReturnRows(after)
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, userID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectWalletLimits(mockDB, models.USD, userID)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, userID, transactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(transactionID))
+	expectJournalEntry(mockDB,
+		escrowPosting(models.Escrow{ID: 5, Currency: models.USD}, amount.Neg()),
+		walletPosting(userID, models.USD, amount))
+	mockDB.ExpectExec("INSERT INTO escrow_settlements").
+		WithArgs(5, transactionID, action, amount, trigger).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestEscrowService_CreateEscrow(t *testing.T) {
+	service, mockDB, mockRedis := newTestEscrowService(t)
+
+	amount := decimal.NewFromInt(100)
+	expiresAt := time.Now().Add(time.Hour)
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("SELECT status FROM wallets").
+		WithArgs(2, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("400"))
+	expectApprovalThreshold(mockDB, 1, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, 1)
+	mockDB.ExpectQuery("INSERT INTO escrows").
+		WithArgs(1, 2, models.USD, amount, models.EscrowFunded, models.EscrowRefund, expiresAt).
+		WillReturnRows(escrowRows("100", "0", "0", models.EscrowFunded, expiresAt, nil, nil))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.EscrowFundTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(30))
+	expectJournalEntry(mockDB,
+		walletPosting(1, models.USD, amount.Neg()),
+		escrowPosting(models.Escrow{ID: 5, Currency: models.USD}, amount))
+	mockDB.ExpectExec("UPDATE escrows SET funding_transaction_id").
+		WithArgs(30, 5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	escrow, err := service.CreateEscrow(context.Background(), models.Escrow{
+		BuyerUserID: 1, SellerUserID: 2, Amount: amount, Currency: models.USD, ExpiresAt: expiresAt,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, escrow.ID)
+	assert.Equal(t, 30, *escrow.FundingTransactionID)
+	assert.Equal(t, models.EscrowFunded, escrow.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestEscrowService_CreateEscrow_Invalid(t *testing.T) {
+	service, mockDB, _ := newTestEscrowService(t)
+
+	_, err := service.CreateEscrow(context.Background(), models.Escrow{
+		BuyerUserID: 1, SellerUserID: 1, Amount: decimal.NewFromInt(100), Currency: models.USD,
+	})
+	assert.ErrorIs(t, err, ErrInvalidEscrow)
+
+	_, err = service.CreateEscrow(context.Background(), models.Escrow{
+		BuyerUserID: 1, SellerUserID: 2, Amount: decimal.NewFromInt(100), Currency: models.USD, OnExpiry: "keep",
+	})
+	assert.ErrorIs(t, err, ErrInvalidEscrow)
+
+	_, err = service.CreateEscrow(context.Background(), models.Escrow{
+		BuyerUserID: 1, SellerUserID: 2, Amount: decimal.NewFromInt(100), Currency: models.USD, ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	assert.ErrorIs(t, err, ErrInvalidEscrow)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestEscrowService_ReleaseEscrow_Partial(t *testing.T) {
+	service, mockDB, mockRedis := newTestEscrowService(t)
+
+	// 买方部分放款, 托管仍为 funded
+	expiresAt := time.Now().Add(time.Hour)
+	amount := decimal.NewFromInt(40)
+	mockDB.ExpectBegin()
+	expectLockEscrow(mockDB, escrowRows("100", "0", "0", models.EscrowFunded, expiresAt, nil, nil))
+	expectEscrowLeg(mockDB, mockRedis, models.EscrowRelease, 2, amount, 31, models.TriggeredByBuyer,
+		escrowRows("60", "40", "0", models.EscrowFunded, expiresAt, nil, nil))
+	mockDB.ExpectQuery("UPDATE escrows SET status").
+		WithArgs(models.EscrowFunded, nil, 5).
+		WillReturnRows(escrowRows("60", "40", "0", models.EscrowFunded, expiresAt, nil, nil))
+	mockDB.ExpectCommit()
+
+	escrow, err := service.ReleaseEscrow(context.Background(), 5, 1, amount)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.EscrowFunded, escrow.Status)
+	assert.Equal(t, "60", escrow.Balance.String())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestEscrowService_ReleaseEscrow_NotPermitted(t *testing.T) {
+	service, mockDB, _ := newTestEscrowService(t)
+
+	// 只有买方可以放款
+	mockDB.ExpectBegin()
+	expectLockEscrow(mockDB, escrowRows("100", "0", "0", models.EscrowFunded, time.Now().Add(time.Hour), nil, nil))
+	mockDB.ExpectRollback()
+
+	_, err := service.ReleaseEscrow(context.Background(), 5, 2, decimal.Zero)
+
+	assert.ErrorIs(t, err, ErrNotPermitted)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestEscrowService_RefundEscrow_Settled(t *testing.T) {
+	service, mockDB, _ := newTestEscrowService(t)
+
+	mockDB.ExpectBegin()
+	expectLockEscrow(mockDB, escrowRows("0", "100", "0", models.EscrowReleased, time.Now().Add(time.Hour), nil, nil))
+	mockDB.ExpectRollback()
+
+	_, err := service.RefundEscrow(context.Background(), 5, 2, decimal.Zero)
+
+	assert.ErrorIs(t, err, ErrEscrowSettled)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestEscrowService_SplitEscrow_ProposeThenConfirm(t *testing.T) {
+	service, mockDB, mockRedis := newTestEscrowService(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	sellerAmount := decimal.NewFromInt(30)
+
+	// 买方提议: 只记录提议, 不动资金
+	mockDB.ExpectBegin()
+	expectLockEscrow(mockDB, escrowRows("100", "0", "0", models.EscrowFunded, expiresAt, nil, nil))
+	mockDB.ExpectQuery("UPDATE escrows SET proposed_split_by").
+		WithArgs(1, sellerAmount, 5).
+		WillReturnRows(escrowRows("100", "0", "0", models.EscrowFunded, expiresAt, 1, "30"))
+	mockDB.ExpectCommit()
+
+	escrow, err := service.SplitEscrow(context.Background(), 5, 1, sellerAmount)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *escrow.ProposedSplitBy)
+	assert.Equal(t, models.EscrowFunded, escrow.Status)
+
+	// 卖方确认相同金额: 放款 30 给卖方, 退款 70 给买方
+	mockDB.ExpectBegin()
+	expectLockEscrow(mockDB, escrowRows("100", "0", "0", models.EscrowFunded, expiresAt, 1, "30"))
+	expectEscrowLeg(mockDB, mockRedis, models.EscrowRelease, 2, sellerAmount, 31, models.TriggeredBySeller,
+		escrowRows("70", "30", "0", models.EscrowFunded, expiresAt, 1, "30"))
+	expectEscrowLeg(mockDB, mockRedis, models.EscrowRefund, 1, decimal.NewFromInt(70), 32, models.TriggeredBySeller,
+		escrowRows("0", "30", "70", models.EscrowFunded, expiresAt, 1, "30"))
+	mockDB.ExpectQuery("UPDATE escrows SET status").
+		WithArgs(models.EscrowSplit, sqlmock.AnyArg(), 5).
+		WillReturnRows(escrowRows("0", "30", "70", models.EscrowSplit, expiresAt, nil, nil))
+	mockDB.ExpectCommit()
+
+	escrow, err = service.SplitEscrow(context.Background(), 5, 2, sellerAmount)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.EscrowSplit, escrow.Status)
+	assert.Nil(t, escrow.ProposedSplitBy)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestEscrowExpirer_ExpireEscrows(t *testing.T) {
+	service, mockDB, mockRedis := newTestEscrowService(t)
+	expirer := &EscrowExpirer{service: service}
+
+	// 到期默认退款给买方
+	expiresAt := time.Now().Add(-time.Minute)
+	mockDB.ExpectQuery("SELECT id FROM escrows WHERE status = \\$1 AND expires_at <= \\$2").
+		WithArgs(models.EscrowFunded, sqlmock.AnyArg(), escrowBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mockDB.ExpectBegin()
+	expectLockEscrow(mockDB, escrowRows("60", "40", "0", models.EscrowFunded, expiresAt, nil, nil))
+	expectEscrowLeg(mockDB, mockRedis, models.EscrowRefund, 1, decimal.NewFromInt(60), 33, models.TriggeredByExpiry,
+		escrowRows("0", "40", "60", models.EscrowFunded, expiresAt, nil, nil))
+	mockDB.ExpectQuery("UPDATE escrows SET status").
+		WithArgs(models.EscrowSplit, sqlmock.AnyArg(), 5).
+		WillReturnRows(escrowRows("0", "40", "60", models.EscrowSplit, expiresAt, nil, nil))
+	mockDB.ExpectCommit()
+
+	err := expirer.ExpireEscrows(context.Background())
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
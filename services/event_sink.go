@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+)
+
+const (
+	defaultEventStream       = "wallet:events"
+	defaultEventStreamMaxLen = 1000000
+	defaultEventFilePath     = "./data/events.jsonl"
+)
+
+// EventSink 领域事件的发布目标; 返回 nil 表示事件已送达, 返回错误时由 OutboxRelay 稍后重试
+type EventSink interface {
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// NewEventSink 按 outbox.sink 配置创建发布目标: redis(默认) 或 file
+func NewEventSink(redis *redis.Client) (EventSink, error) {
+	cfg := config.GetConfig().Outbox
+	switch cfg.Sink {
+	case "", "redis":
+		stream := cfg.Stream
+		if stream == "" {
+			stream = defaultEventStream
+		}
+		maxLen := cfg.StreamMaxLen
+		if maxLen <= 0 {
+			maxLen = defaultEventStreamMaxLen
+		}
+		return NewRedisStreamSink(redis, stream, maxLen), nil
+	case "file":
+		path := cfg.FilePath
+		if path == "" {
+			path = defaultEventFilePath
+		}
+		return NewFileSink(path)
+	default:
+		return nil, fmt.Errorf("unsupported outbox sink %q", cfg.Sink)
+	}
+}
+
+// RedisStreamSink 把事件追加到 Redis Stream, 消费者通过消费组读取, 按字段 id 去重
+type RedisStreamSink struct {
+	redis  *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisStreamSink new redis stream sink, stream 的长度近似保持在 maxLen 以内
+func NewRedisStreamSink(redis *redis.Client, stream string, maxLen int64) *RedisStreamSink {
+	return &RedisStreamSink{
+		redis:  redis,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (s *RedisStreamSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	return s.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: []interface{}{
+			"id", event.ID,
+			"type", string(event.EventType),
+			"aggregate_id", event.AggregateID,
+			"created_at", event.CreatedAt.UTC().Format(time.RFC3339Nano),
+			"payload", string(event.Payload),
+		},
+	}).Err()
+}
+
+// FileSink 把事件以 JSON Lines 追加到本地文件, 每条写入后落盘; 用于本地开发和没有消息系统的部署
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink 打开(不存在时创建)事件文件
+func NewFileSink(path string) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close 关闭事件文件
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
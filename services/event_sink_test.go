@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wallet-service/models"
+)
+
+func testOutboxEvent(id int64) models.OutboxEvent {
+	return models.OutboxEvent{
+		ID:          id,
+		AggregateID: WalletAccountCode(1, models.USD),
+		EventType:   models.EventWalletCredited,
+		Payload:     json.RawMessage(`{"user_id":1,"amount":"10"}`),
+		CreatedAt:   time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestRedisStreamSink_Publish(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	sink := NewRedisStreamSink(client, "wallet:events", 1000)
+
+	mockRedis.ExpectXAdd(&redis.XAddArgs{
+		Stream: "wallet:events",
+		MaxLen: 1000,
+		Approx: true,
+		Values: []interface{}{
+			"id", int64(5),
+			"type", "WalletCredited",
+			"aggregate_id", "wallet:1:USD",
+			"created_at", "2024-03-01T08:00:00Z",
+			"payload", `{"user_id":1,"amount":"10"}`,
+		},
+	}).SetVal("1709280000000-0")
+
+	assert.NoError(t, sink.Publish(context.Background(), testOutboxEvent(5)))
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestFileSink_Publish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "events.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("failed to create file sink: %v", err)
+	}
+
+	assert.NoError(t, sink.Publish(context.Background(), testOutboxEvent(1)))
+	assert.NoError(t, sink.Publish(context.Background(), testOutboxEvent(2)))
+	assert.NoError(t, sink.Close())
+
+	// 每行一个事件, 按发布顺序追加
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.JSONEq(t, `{"id":1,"aggregate_id":"wallet:1:USD","type":"WalletCredited","payload":{"user_id":1,"amount":"10"},"created_at":"2024-03-01T08:00:00Z"}`, lines[0])
+		var event models.OutboxEvent
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+		assert.Equal(t, int64(2), event.ID)
+	}
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strings"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+// FeeRevenueAccount 手续费收入先记入该系统账户(实际编码按币种区分), 由 FeeSweeper 定期划入收入钱包,
+// 收费的交易只写分录, 不锁定收入钱包
+const FeeRevenueAccount = "system:fee_revenue"
+
+var ErrInvalidFeeRule = errors.New("invalid fee rule")
+
+// parseFeeAmount 解析规则中的金额或比例, 为空时返回零
+func parseFeeAmount(name, value string) (decimal.Decimal, error) {
+	if value == "" {
+		return decimal.Zero, nil
+	}
+	amount, err := decimal.NewFromString(value)
+	if err != nil || amount.IsNegative() {
+		return decimal.Zero, fmt.Errorf("%w: %s %q", ErrInvalidFeeRule, name, value)
+	}
+	return amount, nil
+}
+
+// findFeeRule 按交易类型和币种查找收费规则
+func findFeeRule(rules []config.FeeRule, transactionType models.TransactionType, currency models.Currency) (config.FeeRule, bool) {
+	for _, rule := range rules {
+		if models.TransactionType(rule.TransactionType) == transactionType && strings.EqualFold(rule.Currency, string(currency)) {
+			return rule, true
+		}
+	}
+	return config.FeeRule{}, false
+}
+
+// calculateFee 计算手续费: 固定费用 + 金额 * 比例, 再按最低/最高收费截断, 按币种最小单位四舍五入
+func calculateFee(rule config.FeeRule, amount decimal.Decimal, currency models.Currency) (decimal.Decimal, error) {
+	flat, percentage := rule.Flat, rule.Percentage
+	var from *decimal.Decimal
+	for _, tier := range rule.Tiers {
+		tierFrom, err := parseFeeAmount("from_amount", tier.FromAmount)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if tierFrom.GreaterThan(amount) || (from != nil && tierFrom.LessThan(*from)) {
+			continue
+		}
+		from, flat, percentage = &tierFrom, tier.Flat, tier.Percentage
+	}
+
+	flatFee, err := parseFeeAmount("flat", flat)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rate, err := parseFeeAmount("percentage", percentage)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	fee := flatFee.Add(amount.Mul(rate))
+
+	if rule.Min != "" {
+		min, err := parseFeeAmount("min", rule.Min)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		fee = decimal.Max(fee, min)
+	}
+	if rule.Max != "" {
+		max, err := parseFeeAmount("max", rule.Max)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		fee = decimal.Min(fee, max)
+	}
+
+	units, ok := currency.MinorUnits()
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	return fee.Round(units), nil
+}
+
+// feeFor 按配置的规则计算付款方应付的手续费和收入钱包; 未配置收入钱包、付款方即收入钱包或没有匹配的规则时费用为零
+func feeFor(transactionType models.TransactionType, payerID int, amount decimal.Decimal, currency models.Currency) (decimal.Decimal, int, error) {
+	cfg := config.GetConfig().Fees
+	if cfg.RevenueUserID == 0 || payerID == cfg.RevenueUserID {
+		return decimal.Zero, 0, nil
+	}
+	rule, ok := findFeeRule(cfg.Rules, transactionType, currency)
+	if !ok {
+		return decimal.Zero, 0, nil
+	}
+	fee, err := calculateFee(rule, amount, currency)
+	if err != nil {
+		return decimal.Zero, 0, err
+	}
+	return fee, cfg.RevenueUserID, nil
+}
+
+// chargeFee 按配置的规则计算交易的手续费, 在同一事务内向付款方收取; 未配置收入钱包、没有匹配的规则或费用为零时返回 nil
+func (s *walletService) chargeFee(ctx context.Context, tx *sqlx.Tx, transactionID int, transactionType models.TransactionType,
+	payerID int, amount decimal.Decimal, currency models.Currency) (*models.FeeResult, error) {
+	fee, revenueUserID, err := feeFor(transactionType, payerID, amount, currency)
+	if err != nil {
+		s.logger.Error(ctx, "chargeFee Failed to calculate fee", zap.String("transactionType", string(transactionType)),
+			zap.String("currency", string(currency)), zap.Error(err))
+		return nil, err
+	}
+	if !fee.IsPositive() {
+		return nil, nil
+	}
+	return s.applyFee(ctx, tx, transactionID, payerID, revenueUserID, fee, currency)
+}
+
+// applyFee 从付款方扣除手续费计入 FeeRevenueAccount, 记为关联原交易的 fee 流水(收款方为收入钱包); 余额不足以支付交易金额和手续费时返回 ErrInsufficientFunds.
+// 手续费同样计入付款方的周期用量, 交易金额加手续费超过限额时整个交易回滚
+func (s *walletService) applyFee(ctx context.Context, tx *sqlx.Tx, transactionID, payerID, revenueUserID int,
+	fee decimal.Decimal, currency models.Currency) (*models.FeeResult, error) {
+	if err := s.WithdrawWithTx(ctx, tx, payerID, fee, currency); err != nil {
+		return nil, err
+	}
+	if err := s.recordOutflow(ctx, tx, payerID, currency, fee, 0); err != nil {
+		return nil, err
+	}
+
+	feeID, err := s.recordTransaction(ctx, tx, models.Transaction{
+		SenderUserID:          payerID,
+		ReceiverUserID:        revenueUserID,
+		TransactionType:       models.FeeTransactionType,
+		Amount:                fee,
+		Currency:              currency,
+		OriginalTransactionID: &transactionID,
+	}, []models.Posting{
+		walletPosting(payerID, currency, fee.Neg()),
+		systemPosting(FeeRevenueAccount, currency, fee),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.FeeResult{TransactionID: feeID, Amount: fee, Currency: currency}, nil
+}
+
+// refundFees 全额退还交易 transactionID 已收取的手续费, 返回退还的手续费流水
+func (s *walletService) refundFees(ctx context.Context, tx *sqlx.Tx, transactionID int) ([]models.Transaction, error) {
+	var fees []models.Transaction
+	err := tx.Select(&fees, "SELECT * FROM transactions WHERE original_transaction_id = $1 AND transaction_type = $2 AND status = $3 ORDER BY id FOR UPDATE",
+		transactionID, models.FeeTransactionType, models.TransactionCompleted)
+	if err != nil {
+		s.logger.Error(ctx, "refundFees Failed select from transactions", zap.Int("transactionID", transactionID), zap.Error(err))
+		return nil, err
+	}
+	for _, fee := range fees {
+		if _, err = s.refundFee(ctx, tx, fee, fee.Amount); err != nil {
+			return nil, err
+		}
+	}
+	return fees, nil
+}
+
+// refundFeesForReversal 冲正原交易时按累计冲正金额占原交易金额的比例退还关联的手续费, 按币种最小单位四舍五入;
+// 每次按累计比例计算应退总额再减去已退金额, 多次部分冲正的舍入误差不会累积, 全额冲正时手续费全部退还.
+// 没有手续费时返回 nil
+func (s *walletService) refundFeesForReversal(ctx context.Context, tx *sqlx.Tx, original models.Transaction) (*models.FeeResult, error) {
+	var fees []models.Transaction
+	err := tx.Select(&fees, "SELECT * FROM transactions WHERE original_transaction_id = $1 AND transaction_type = $2 AND status = $3 ORDER BY id FOR UPDATE",
+		original.ID, models.FeeTransactionType, models.TransactionCompleted)
+	if err != nil {
+		s.logger.Error(ctx, "refundFeesForReversal Failed select from transactions", zap.Int("transactionID", original.ID), zap.Error(err))
+		return nil, err
+	}
+	if len(fees) == 0 {
+		return nil, nil
+	}
+	units, ok := original.Currency.MinorUnits()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, original.Currency)
+	}
+
+	// 本次冲正流水已写入, 累计金额包含本次
+	reversedSum := func(transactionID int) (decimal.Decimal, error) {
+		var sum decimal.Decimal
+		err := tx.Get(&sum, "SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE original_transaction_id = $1 AND transaction_type = $2",
+			transactionID, models.ReversalTransactionType)
+		if err != nil {
+			s.logger.Error(ctx, "refundFeesForReversal Failed to sum reversed amount", zap.Int("transactionID", transactionID), zap.Error(err))
+		}
+		return sum, err
+	}
+	reversed, err := reversedSum(original.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *models.FeeResult
+	for _, fee := range fees {
+		refunded, err := reversedSum(fee.ID)
+		if err != nil {
+			return nil, err
+		}
+		due := decimal.Min(fee.Amount.Mul(reversed).Div(original.Amount).Round(units), fee.Amount).Sub(refunded)
+		if !due.IsPositive() {
+			continue
+		}
+		refundID, err := s.refundFee(ctx, tx, fee, due)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = &models.FeeResult{Currency: fee.Currency}
+		}
+		result.TransactionID = refundID
+		result.Amount = result.Amount.Add(due)
+	}
+	return result, nil
+}
+
+// refundFee 从 FeeRevenueAccount 向付款方退还手续费流水 fee 中的 amount, 记为关联该手续费流水的 reversal 流水;
+// 付款方钱包被冻结时同样入账. 累计退还达到手续费金额时手续费流水迁移到 reversed
+func (s *walletService) refundFee(ctx context.Context, tx *sqlx.Tx, fee models.Transaction, amount decimal.Decimal) (int, error) {
+	if err := s.DepositWithTx(ctx, tx, fee.SenderUserID, amount, fee.Currency); err != nil {
+		return 0, err
+	}
+	refundID, err := s.recordTransaction(ctx, tx, models.Transaction{
+		SenderUserID:          fee.SenderUserID,
+		ReceiverUserID:        fee.SenderUserID,
+		TransactionType:       models.ReversalTransactionType,
+		Amount:                amount,
+		Currency:              fee.Currency,
+		OriginalTransactionID: &fee.ID,
+	}, []models.Posting{
+		systemPosting(FeeRevenueAccount, fee.Currency, amount.Neg()),
+		walletPosting(fee.SenderUserID, fee.Currency, amount),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = s.markFullyReversed(ctx, tx, fee.ID); err != nil {
+		return 0, err
+	}
+	return refundID, nil
+}
+
+// FeeSweeper 定期把 FeeRevenueAccount 中累计的手续费划入收入钱包
+type FeeSweeper struct {
+	service *walletService
+}
+
+// NewFeeSweeper new fee sweeper
+func NewFeeSweeper(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *FeeSweeper {
+	return &FeeSweeper{
+		service: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// Run 按币种逐个划转, 单个币种失败不影响其他币种; 未配置收入钱包时不划转
+func (w *FeeSweeper) Run(ctx context.Context) error {
+	s := w.service
+	revenueUserID := config.GetConfig().Fees.RevenueUserID
+	if revenueUserID == 0 {
+		return nil
+	}
+
+	var currencies []models.Currency
+	err := s.db.SelectContext(ctx, &currencies, "SELECT currency FROM ledger_accounts WHERE code LIKE $1 ORDER BY currency", FeeRevenueAccount+":%")
+	if err != nil {
+		s.logger.Error(ctx, "FeeSweeper Failed select from ledger_accounts", zap.Error(err))
+		return err
+	}
+	for _, currency := range currencies {
+		swept, err := s.sweepFeeRevenue(ctx, revenueUserID, currency)
+		if err != nil {
+			s.logger.Error(ctx, "FeeSweeper Failed to sweep fee revenue", zap.String("currency", string(currency)), zap.Error(err))
+			continue
+		}
+		if swept.IsPositive() {
+			s.logger.Info(ctx, "FeeSweeper swept fee revenue", zap.String("currency", string(currency)), zap.String("amount", swept.String()))
+		}
+	}
+	return nil
+}
+
+// sweepFeeRevenue 把某个币种 FeeRevenueAccount 的余额(按分录汇总)全部划入收入钱包, 返回划转的金额.
+// 锁定系统账户行串行化同一币种的划转, 收费交易只插入分录不会等待该锁; 退还手续费可能使余额为负, 此时不划转, 由之后的收费抵消
+func (s *walletService) sweepFeeRevenue(ctx context.Context, revenueUserID int, currency models.Currency) (decimal.Decimal, error) {
+	code := SystemAccountCode(FeeRevenueAccount, currency)
+	var swept decimal.Decimal
+	err := s.runInTx(ctx, "sweepFeeRevenue", func(tx *sqlx.Tx) error {
+		swept = decimal.Zero
+		if _, err := tx.Exec("SELECT 1 FROM ledger_accounts WHERE code = $1 FOR UPDATE", code); err != nil {
+			s.logger.Error(ctx, "sweepFeeRevenue Failed to lock ledger_accounts", zap.String("account", code), zap.Error(err))
+			return err
+		}
+		var balance decimal.Decimal
+		if err := tx.Get(&balance, "SELECT COALESCE(SUM(amount), 0) FROM postings WHERE account_code = $1", code); err != nil {
+			s.logger.Error(ctx, "sweepFeeRevenue Failed select from postings", zap.String("account", code), zap.Error(err))
+			return err
+		}
+		if !balance.IsPositive() {
+			return nil
+		}
+
+		if err := s.DepositWithTx(ctx, tx, revenueUserID, balance, currency); err != nil {
+			return err
+		}
+		_, err := s.recordTransaction(ctx, tx, models.Transaction{
+			SenderUserID:    revenueUserID,
+			ReceiverUserID:  revenueUserID,
+			TransactionType: models.FeeSweepTransactionType,
+			Amount:          balance,
+			Currency:        currency,
+		}, []models.Posting{
+			systemPosting(FeeRevenueAccount, currency, balance.Neg()),
+			walletPosting(revenueUserID, currency, balance),
+		})
+		if err != nil {
+			return err
+		}
+		swept = balance
+		return nil
+	})
+	return swept, err
+}
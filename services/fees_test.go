@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	"wallet-service/pkg/logger"
+)
+
+func TestCalculateFee(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     config.FeeRule
+		amount   string
+		currency models.Currency
+		expected string
+	}{
+		{"flat", config.FeeRule{Flat: "0.5"}, "100", models.USD, "0.5"},
+		{"flat plus percentage", config.FeeRule{Flat: "0.5", Percentage: "0.01"}, "100", models.USD, "1.5"},
+		{"min", config.FeeRule{Percentage: "0.01", Min: "1"}, "20", models.USD, "1"},
+		{"max", config.FeeRule{Percentage: "0.01", Max: "25"}, "10000", models.USD, "25"},
+		{"rounded to minor units", config.FeeRule{Percentage: "0.015"}, "10.01", models.USD, "0.15"},
+		{"jpy has no minor units", config.FeeRule{Percentage: "0.003"}, "1234", models.JPY, "4"},
+		{"below first tier uses rule", config.FeeRule{Flat: "2", Tiers: []config.FeeTier{{FromAmount: "100", Percentage: "0.01"}}}, "50", models.USD, "2"},
+		{"highest matching tier", config.FeeRule{Max: "10", Tiers: []config.FeeTier{
+			{FromAmount: "1000", Percentage: "0.002"}, {FromAmount: "0", Flat: "0"}, {FromAmount: "5000", Percentage: "0.001"},
+		}}, "2000", models.USD, "4"},
+		{"tier capped by max", config.FeeRule{Max: "10", Tiers: []config.FeeTier{{FromAmount: "1000", Percentage: "0.002"}}}, "8000", models.USD, "10"},
+		{"free tier", config.FeeRule{Tiers: []config.FeeTier{{FromAmount: "0"}, {FromAmount: "1000", Percentage: "0.002"}}}, "999.99", models.USD, "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee, err := calculateFee(tt.rule, decimal.RequireFromString(tt.amount), tt.currency)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, fee.String())
+		})
+	}
+
+	_, err := calculateFee(config.FeeRule{Percentage: "-0.01"}, decimal.NewFromInt(100), models.USD)
+	assert.ErrorIs(t, err, ErrInvalidFeeRule)
+	_, err = calculateFee(config.FeeRule{Tiers: []config.FeeTier{{FromAmount: "abc"}}}, decimal.NewFromInt(100), models.USD)
+	assert.ErrorIs(t, err, ErrInvalidFeeRule)
+}
+
+func TestFindFeeRule(t *testing.T) {
+	rules := []config.FeeRule{
+		{TransactionType: "withdraw", Currency: "USD", Flat: "1"},
+		{TransactionType: "transfer", Currency: "usd", Flat: "2"},
+	}
+
+	rule, ok := findFeeRule(rules, models.TransferTransactionType, models.USD)
+	assert.True(t, ok)
+	assert.Equal(t, "2", rule.Flat)
+
+	_, ok = findFeeRule(rules, models.WithdrawTransactionType, models.EUR)
+	assert.False(t, ok)
+}
+
+func TestWalletService_ApplyFee(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	payerID, revenueUserID, transactionID := 1, 99, 10
+	fee := decimal.NewFromFloat(1.5)
+
+	// 手续费从付款方扣除并计入付款方的周期用量, 记入手续费收入账户而不锁定收入钱包, 流水关联原交易
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(fee, payerID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("48.5"))
+	mockDB.ExpectQuery("FROM wallet_limits").
+		WithArgs(models.USD, pq.Array([]int{payerID})).
+		WillReturnRows(sqlmock.NewRows(walletLimitColumns).AddRow(payerID, "", nil, "200", nil, nil, nil, nil))
+	mockDB.ExpectQuery("INSERT INTO wallet_limit_usage").
+		WithArgs(payerID, models.USD, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), fee, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"period", "outflow", "transfers"}).
+			AddRow("day", "101.5", 1).AddRow("week", "101.5", 1).AddRow("month", "101.5", 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(payerID, revenueUserID, models.FeeTransactionType, fee, models.USD, nil, nil, transactionID, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	expectJournalEntry(mockDB, walletPosting(payerID, models.USD, fee.Neg()), systemPosting(FeeRevenueAccount, models.USD, fee))
+	mockDB.ExpectCommit()
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+	result, err := service.applyFee(context.Background(), tx, transactionID, payerID, revenueUserID, fee, models.USD)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	assert.Equal(t, 11, result.TransactionID)
+	assert.True(t, fee.Equal(result.Amount))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ApplyFee_LimitExceeded(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	fee := decimal.NewFromInt(2)
+
+	// 交易金额用满当日限额 200, 加上手续费后超限
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(fee, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("48"))
+	mockDB.ExpectQuery("FROM wallet_limits").
+		WillReturnRows(sqlmock.NewRows(walletLimitColumns).AddRow(1, "", nil, "200", nil, nil, nil, nil))
+	mockDB.ExpectQuery("INSERT INTO wallet_limit_usage").
+		WillReturnRows(sqlmock.NewRows([]string{"period", "outflow", "transfers"}).
+			AddRow("day", "202", 1).AddRow("week", "202", 1).AddRow("month", "202", 1))
+	mockDB.ExpectRollback()
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+	_, err = service.applyFee(context.Background(), tx, 10, 1, 99, fee, models.USD)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ApplyFee_InsufficientFunds(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	fee := decimal.NewFromInt(2)
+
+	// 余额只够支付交易金额, 不够支付手续费
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(fee, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectRollback()
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+	_, err = service.applyFee(context.Background(), tx, 10, 1, 99, fee, models.USD)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_SweepFeeRevenue(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	revenueUserID := 99
+	code := SystemAccountCode(FeeRevenueAccount, models.USD)
+	balance := decimal.RequireFromString("12.5")
+
+	// 锁定手续费收入账户后按分录汇总余额, 全部划入收入钱包
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`SELECT 1 FROM ledger_accounts WHERE code = \$1 FOR UPDATE`).
+		WithArgs(code).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\) FROM postings WHERE account_code = \$1`).
+		WithArgs(code).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("12.5"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(balance, revenueUserID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(revenueUserID, revenueUserID, models.FeeSweepTransactionType, balance, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(40))
+	expectJournalEntry(mockDB, systemPosting(FeeRevenueAccount, models.USD, balance.Neg()), walletPosting(revenueUserID, models.USD, balance))
+	mockDB.ExpectCommit()
+
+	swept, err := service.sweepFeeRevenue(context.Background(), revenueUserID, models.USD)
+	assert.NoError(t, err)
+	assert.True(t, balance.Equal(swept))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_SweepFeeRevenue_NothingToSweep(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	code := SystemAccountCode(FeeRevenueAccount, models.USD)
+
+	// 退还的手续费多于新收取的手续费时余额为负, 不划转
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`SELECT 1 FROM ledger_accounts WHERE code = \$1 FOR UPDATE`).
+		WithArgs(code).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\) FROM postings WHERE account_code = \$1`).
+		WithArgs(code).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("-1"))
+	mockDB.ExpectCommit()
+
+	swept, err := service.sweepFeeRevenue(context.Background(), 99, models.USD)
+	assert.NoError(t, err)
+	assert.True(t, swept.IsZero())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const defaultFXQuoteTTL = 30 * time.Second
+
+// 换汇使用的系统账户
+const (
+	FXPositionAccount = "system:fx_position" // 平台持有的外汇头寸
+	FXHouseAccount    = "system:fx_house"    // 点差收入
+)
+
+var (
+	ErrInvalidFXRate      = errors.New("invalid fx rate")
+	ErrFXRateNotFound     = errors.New("no fx rate available for currency pair")
+	ErrFXQuoteUnavailable = errors.New("fx quote not found, expired or already used")
+	ErrExchangeTooSmall   = errors.New("amount is too small to exchange")
+)
+
+type FXService interface {
+	PublishRate(ctx context.Context, rate models.FXRate) (*models.FXRate, error)
+	ListRates(ctx context.Context, base, quote models.Currency) ([]models.FXRate, error)
+	CreateQuote(ctx context.Context, base, quote models.Currency) (*models.FXQuote, error)
+}
+
+type fxService struct {
+	db     *sqlx.DB
+	logger *wallet_logger.Logger
+}
+
+var _ FXService = &fxService{}
+
+// NewFXService service
+func NewFXService(logger *wallet_logger.Logger, db *sqlx.DB) FXService {
+	return &fxService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func fxQuoteTTL() time.Duration {
+	seconds := config.GetConfig().FX.QuoteTTLSeconds
+	if seconds <= 0 {
+		return defaultFXQuoteTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// validateFXPair 校验币种对
+func validateFXPair(base, quote models.Currency) error {
+	if !base.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnsupportedCurrency, base)
+	}
+	if !quote.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnsupportedCurrency, quote)
+	}
+	if base == quote {
+		return fmt.Errorf("%w: base and quote currency are the same", ErrInvalidFXRate)
+	}
+	return nil
+}
+
+// PublishRate 发布汇率, 同一币种对有效期重叠时以 valid_from 最新的为准
+func (s *fxService) PublishRate(ctx context.Context, rate models.FXRate) (*models.FXRate, error) {
+	if err := validateFXPair(rate.BaseCurrency, rate.QuoteCurrency); err != nil {
+		return nil, err
+	}
+	if !rate.Rate.IsPositive() {
+		return nil, fmt.Errorf("%w: rate must be greater than zero", ErrInvalidFXRate)
+	}
+	if rate.Spread.IsNegative() || rate.Spread.GreaterThanOrEqual(decimal.NewFromInt(1)) {
+		return nil, fmt.Errorf("%w: spread must be in [0, 1)", ErrInvalidFXRate)
+	}
+	if rate.ValidFrom.IsZero() {
+		rate.ValidFrom = time.Now()
+	}
+	if rate.ValidTo != nil && !rate.ValidTo.After(rate.ValidFrom) {
+		return nil, fmt.Errorf("%w: valid_to must be after valid_from", ErrInvalidFXRate)
+	}
+
+	err := s.db.QueryRowxContext(ctx, `
+		INSERT INTO fx_rates (base_currency, quote_currency, rate, spread, valid_from, valid_to, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		rate.BaseCurrency, rate.QuoteCurrency, rate.Rate, rate.Spread, rate.ValidFrom, rate.ValidTo, time.Now()).
+		Scan(&rate.ID, &rate.CreatedAt)
+	if err != nil {
+		s.logger.Error(ctx, "PublishRate Failed insert into fx_rates", zap.String("base", string(rate.BaseCurrency)),
+			zap.String("quote", string(rate.QuoteCurrency)), zap.Error(err))
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// ListRates 查询币种对已发布的汇率, 按生效时间倒序
+func (s *fxService) ListRates(ctx context.Context, base, quote models.Currency) ([]models.FXRate, error) {
+	if err := validateFXPair(base, quote); err != nil {
+		return nil, err
+	}
+	var rates []models.FXRate
+	err := s.db.SelectContext(ctx, &rates, `
+		SELECT id, base_currency, quote_currency, rate, spread, valid_from, valid_to, created_at FROM fx_rates
+		WHERE base_currency = $1 AND quote_currency = $2 ORDER BY valid_from DESC, id DESC`, base, quote)
+	if err != nil {
+		s.logger.Error(ctx, "ListRates Failed select from fx_rates", zap.Error(err))
+		return nil, err
+	}
+	return rates, nil
+}
+
+// CreateQuote 按当前有效的汇率生成报价, 报价在 quote_ttl_seconds 内锁定汇率
+func (s *fxService) CreateQuote(ctx context.Context, base, quote models.Currency) (*models.FXQuote, error) {
+	if err := validateFXPair(base, quote); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var rate models.FXRate
+	err := s.db.GetContext(ctx, &rate, `
+		SELECT id, base_currency, quote_currency, rate, spread, valid_from, valid_to, created_at FROM fx_rates
+		WHERE base_currency = $1 AND quote_currency = $2 AND valid_from <= $3 AND (valid_to IS NULL OR valid_to > $3)
+		ORDER BY valid_from DESC, id DESC LIMIT 1`, base, quote, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrFXRateNotFound, base, quote)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "CreateQuote Failed select from fx_rates", zap.Error(err))
+		return nil, err
+	}
+
+	q := models.FXQuote{
+		ID:            uuid.New().String(),
+		RateID:        rate.ID,
+		BaseCurrency:  base,
+		QuoteCurrency: quote,
+		Rate:          rate.Rate,
+		Spread:        rate.Spread,
+		ExpiresAt:     now.Add(fxQuoteTTL()),
+		CreatedAt:     now,
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO fx_quotes (id, rate_id, base_currency, quote_currency, rate, spread, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		q.ID, q.RateID, q.BaseCurrency, q.QuoteCurrency, q.Rate, q.Spread, q.ExpiresAt, q.CreatedAt)
+	if err != nil {
+		s.logger.Error(ctx, "CreateQuote Failed insert into fx_quotes", zap.Error(err))
+		return nil, err
+	}
+	return &q, nil
+}
+
+// useFXQuote 在事务内占用报价, 报价不存在、已过期或已被使用时返回 ErrFXQuoteUnavailable
+func (s *walletService) useFXQuote(ctx context.Context, tx *sqlx.Tx, quoteID string) (*models.FXQuote, error) {
+	var quote models.FXQuote
+	now := time.Now()
+	err := tx.Get(&quote, `
+		UPDATE fx_quotes SET used_at = $1 WHERE id = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING id, rate_id, base_currency, quote_currency, rate, spread, expires_at, used_at, created_at`, now, quoteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFXQuoteUnavailable
+	}
+	if err != nil {
+		s.logger.Error(ctx, "useFXQuote Failed update fx_quotes", zap.String("quoteID", quoteID), zap.Error(err))
+		return nil, err
+	}
+	return &quote, nil
+}
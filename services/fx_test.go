@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func TestFXService_PublishRate_Invalid(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewFXService(logger.NewLogger(), sqlx.NewDb(db, "postgres"))
+	ctx := context.Background()
+
+	// 相同币种
+	_, err = service.PublishRate(ctx, models.FXRate{BaseCurrency: models.USD, QuoteCurrency: models.USD, Rate: decimal.NewFromInt(1)})
+	assert.ErrorIs(t, err, ErrInvalidFXRate)
+
+	// 汇率必须为正
+	_, err = service.PublishRate(ctx, models.FXRate{BaseCurrency: models.EUR, QuoteCurrency: models.USD, Rate: decimal.Zero})
+	assert.ErrorIs(t, err, ErrInvalidFXRate)
+
+	// 点差必须小于 1
+	_, err = service.PublishRate(ctx, models.FXRate{BaseCurrency: models.EUR, QuoteCurrency: models.USD,
+		Rate: decimal.RequireFromString("1.1"), Spread: decimal.NewFromInt(1)})
+	assert.ErrorIs(t, err, ErrInvalidFXRate)
+
+	// 有效期结束时间必须晚于开始时间
+	now := time.Now()
+	_, err = service.PublishRate(ctx, models.FXRate{BaseCurrency: models.EUR, QuoteCurrency: models.USD,
+		Rate: decimal.RequireFromString("1.1"), ValidFrom: now, ValidTo: &now})
+	assert.ErrorIs(t, err, ErrInvalidFXRate)
+
+	_, err = service.PublishRate(ctx, models.FXRate{BaseCurrency: models.EUR, QuoteCurrency: "XXX", Rate: decimal.NewFromInt(1)})
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+}
+
+func TestFXService_PublishRate_Success(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewFXService(logger.NewLogger(), sqlx.NewDb(db, "postgres"))
+
+	rate := decimal.RequireFromString("1.1")
+	spread := decimal.RequireFromString("0.01")
+	mockDB.ExpectQuery("INSERT INTO fx_rates").
+		WithArgs(models.EUR, models.USD, rate, spread, sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+
+	published, err := service.PublishRate(context.Background(), models.FXRate{
+		BaseCurrency:  models.EUR,
+		QuoteCurrency: models.USD,
+		Rate:          rate,
+		Spread:        spread,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, published.ID)
+	assert.False(t, published.ValidFrom.IsZero())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestFXService_CreateQuote_Success(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewFXService(logger.NewLogger(), sqlx.NewDb(db, "postgres"))
+
+	now := time.Now()
+	mockDB.ExpectQuery("SELECT (.+) FROM fx_rates").
+		WithArgs(models.EUR, models.USD, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "base_currency", "quote_currency", "rate", "spread", "valid_from", "valid_to", "created_at"}).
+			AddRow(3, "EUR", "USD", "1.1", "0.01", now.Add(-time.Hour), nil, now))
+	mockDB.ExpectExec("INSERT INTO fx_quotes").
+		WithArgs(sqlmock.AnyArg(), 3, models.EUR, models.USD, decimal.RequireFromString("1.1"), decimal.RequireFromString("0.01"), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	quote, err := service.CreateQuote(context.Background(), models.EUR, models.USD)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, quote.ID)
+	assert.Equal(t, "1.089", quote.ClientRate().String())
+	assert.WithinDuration(t, time.Now().Add(defaultFXQuoteTTL), quote.ExpiresAt, time.Second)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestFXService_CreateQuote_NoRate(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewFXService(logger.NewLogger(), sqlx.NewDb(db, "postgres"))
+
+	mockDB.ExpectQuery("SELECT (.+) FROM fx_rates").
+		WithArgs(models.EUR, models.JPY, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	quote, err := service.CreateQuote(context.Background(), models.EUR, models.JPY)
+
+	assert.Nil(t, quote)
+	assert.ErrorIs(t, err, ErrFXRateNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ExchangeTransfer_Success(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, mockRedis := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据: 100 EUR 按 1.1 中间价、1% 点差兑换, 收款方得到 108.90 USD, 点差 1.10 USD;
+	// 备注记在两笔流水上, 外部单号只记在付款方币种的流水上
+	senderID := 1
+	receiverID := 2
+	amount := decimal.NewFromInt(100)
+	clientRate := decimal.RequireFromString("1.089")
+	credited := decimal.RequireFromString("108.9")
+	gross := decimal.RequireFromString("110")
+	now := time.Now()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("UPDATE fx_quotes SET used_at").
+		WithArgs(sqlmock.AnyArg(), "q-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rate_id", "base_currency", "quote_currency", "rate", "spread", "expires_at", "used_at", "created_at"}).
+			AddRow("q-1", 3, "EUR", "USD", "1.1", "0.01", now.Add(time.Minute), now, now))
+	// 按币种顺序加锁: EUR 在 USD 之前
+	mockDB.ExpectQuery("SELECT user_id FROM wallets").
+		WithArgs(sqlmock.AnyArg(), models.EUR).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID))
+	mockDB.ExpectQuery("SELECT user_id FROM wallets").
+		WithArgs(sqlmock.AnyArg(), models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.EUR).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("400"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(credited, receiverID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, senderID, models.EUR, "")
+	expectWalletLimits(mockDB, models.EUR, senderID)
+	expectWalletLimits(mockDB, models.USD, receiverID)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(senderID, receiverID, models.ExchangeOutTransactionType, amount, models.EUR, clientRate, "q-1", nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), "tuition", "inv-1", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.EUR, amount.Neg()), systemPosting(FXPositionAccount, models.EUR, amount))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(senderID, receiverID, models.ExchangeInTransactionType, credited, models.USD, clientRate, "q-1", nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), "tuition", nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	expectJournalEntry(mockDB, systemPosting(FXPositionAccount, models.USD, gross.Neg()),
+		walletPosting(receiverID, models.USD, credited),
+		systemPosting(FXHouseAccount, models.USD, decimal.RequireFromString("1.1")))
+	mockDB.ExpectCommit()
+
+	result, err := service.ExchangeTransfer(context.Background(), senderID, receiverID, amount, models.EUR, "q-1",
+		models.TransactionDetails{Memo: "tuition", ExternalReference: "inv-1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 10, result.TransactionID)
+	assert.Equal(t, 11, result.Exchange.TransactionID)
+	assert.Equal(t, models.USD, result.Exchange.Currency)
+	assert.True(t, credited.Equal(result.Exchange.Amount))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_ExchangeTransfer_QuoteUnavailable(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 报价已过期或已被使用: 条件更新不返回行, 事务回滚
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("UPDATE fx_quotes SET used_at").
+		WithArgs(sqlmock.AnyArg(), "q-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mockDB.ExpectRollback()
+
+	result, err := service.ExchangeTransfer(context.Background(), 1, 2, decimal.NewFromInt(100), models.EUR, "q-1", models.TransactionDetails{})
+
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrFXQuoteUnavailable)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ExchangeTransfer_CurrencyMismatch(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 报价卖出 EUR, 请求却用 CNY 付款
+	now := time.Now()
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("UPDATE fx_quotes SET used_at").
+		WithArgs(sqlmock.AnyArg(), "q-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rate_id", "base_currency", "quote_currency", "rate", "spread", "expires_at", "used_at", "created_at"}).
+			AddRow("q-1", 3, "EUR", "USD", "1.1", "0.01", now.Add(time.Minute), now, now))
+	mockDB.ExpectRollback()
+
+	_, err = service.ExchangeTransfer(context.Background(), 1, 2, decimal.NewFromInt(100), models.CNY, "q-1", models.TransactionDetails{})
+
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -7,10 +7,10 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-redis/redismock/v9"
 	"github.com/jmoiron/sqlx"
-	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"wallet-service/models"
 	"wallet-service/pkg/logger"
 )
 
@@ -35,14 +35,16 @@ func TestWalletService_GetBalance_SuccessFromRedis(t *testing.T) {
 	expectedBalance := decimal.NewFromFloat(100.0).Round(0)
 
 	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", userID)).SetVal(expectedBalance.String())
+	mockRedis.ExpectHMGet(balanceCacheKey(userID, models.USD), balanceCacheFields...).
+		SetVal([]interface{}{"3", expectedBalance.String(), "30", "0"})
 
 	// 执行 GetBalance 方法
-	balance, err := service.GetBalance(context.Background(), userID)
+	balance, err := service.GetBalance(context.Background(), userID, models.USD)
 
-	// 断言没有错误，并且余额正确
+	// 断言没有错误，并且账面余额和可用余额正确
 	assert.NoError(t, err)
-	assert.Equal(t, expectedBalance, balance)
+	assert.Equal(t, expectedBalance, balance.Ledger)
+	assert.Equal(t, "70", balance.Available.String())
 }
 
 func TestWalletService_GetBalance_SuccessFromDB(t *testing.T) {
@@ -66,19 +68,23 @@ func TestWalletService_GetBalance_SuccessFromDB(t *testing.T) {
 	expectedBalance := decimal.NewFromFloat(100.0).Round(0)
 
 	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", userID)).SetErr(redis.Nil)
+	mockRedis.ExpectHMGet(balanceCacheKey(userID, models.USD), balanceCacheFields...).
+		SetVal([]interface{}{nil, nil, nil, nil})
+	expectBalanceCacheSet(mockRedis, cachedBalance{UserID: userID, Currency: models.USD, Balance: expectedBalance, Version: 5}).SetVal(int64(1))
 
 	// 设置 mock DB 的期望行为
-	mockDB.ExpectQuery(`SELECT balance FROM wallets WHERE user_id = \$1`).
-		WithArgs(userID).
-		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(expectedBalance))
+	mockDB.ExpectQuery(`SELECT balance, held_balance, credit_limit, version FROM wallets WHERE user_id = \$1`).
+		WithArgs(userID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "held_balance", "credit_limit", "version"}).AddRow(expectedBalance, "0", "0", 5))
 
 	// 执行 GetBalance 方法
-	balance, err := service.GetBalance(context.Background(), userID)
+	balance, err := service.GetBalance(context.Background(), userID, models.USD)
 
 	// 断言没有错误，并且余额正确
 	assert.NoError(t, err)
-	assert.Equal(t, expectedBalance, balance)
+	assert.True(t, expectedBalance.Equal(balance.Ledger))
+	assert.True(t, expectedBalance.Equal(balance.Available))
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
 }
 
 func TestWalletService_GetBalance_RedisError(t *testing.T) {
@@ -101,10 +107,10 @@ func TestWalletService_GetBalance_RedisError(t *testing.T) {
 	userID := 1
 
 	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", userID)).SetErr(fmt.Errorf("redis error"))
+	mockRedis.ExpectHMGet(balanceCacheKey(userID, models.USD), balanceCacheFields...).SetErr(fmt.Errorf("redis error"))
 
 	// 执行 GetBalance 方法
-	_, err = service.GetBalance(context.Background(), userID)
+	_, err = service.GetBalance(context.Background(), userID, models.USD)
 
 	// 断言返回错误
 	assert.Error(t, err)
@@ -130,15 +136,15 @@ func TestWalletService_GetBalance_DBError(t *testing.T) {
 	userID := 1
 
 	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", userID)).SetErr(redis.Nil)
+	mockRedis.ExpectHMGet(balanceCacheKey(userID, models.USD), balanceCacheFields...).SetVal([]interface{}{nil, nil, nil, nil})
 
 	// 设置 mock DB 的期望行为
-	mockDB.ExpectQuery(`SELECT balance FROM wallets WHERE user_id = \$1`).
-		WithArgs(userID).
+	mockDB.ExpectQuery(`SELECT balance, held_balance, credit_limit, version FROM wallets WHERE user_id = \$1`).
+		WithArgs(userID, models.USD).
 		WillReturnError(fmt.Errorf("database error"))
 
 	// 执行 GetBalance 方法
-	_, err = service.GetBalance(context.Background(), userID)
+	_, err = service.GetBalance(context.Background(), userID, models.USD)
 
 	// 断言返回错误
 	assert.Error(t, err)
@@ -164,15 +170,15 @@ func TestWalletService_GetBalance_WalletNotFound(t *testing.T) {
 	userID := 1
 
 	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", userID)).SetErr(redis.Nil)
+	mockRedis.ExpectHMGet(balanceCacheKey(userID, models.USD), balanceCacheFields...).SetVal([]interface{}{nil, nil, nil, nil})
 
 	// 设置 mock DB 的期望行为
-	mockDB.ExpectQuery(`SELECT balance FROM wallets WHERE user_id = \$1`).
-		WithArgs(userID).
+	mockDB.ExpectQuery(`SELECT balance, held_balance, credit_limit, version FROM wallets WHERE user_id = \$1`).
+		WithArgs(userID, models.USD).
 		WillReturnError(errors.New("wallet not found"))
 
 	// 执行 GetBalance 方法
-	_, err = service.GetBalance(context.Background(), userID)
+	_, err = service.GetBalance(context.Background(), userID, models.USD)
 
 	// 断言返回错误
 	assert.EqualError(t, err, "wallet not found")
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+	"wallet-service/models"
+)
+
+const (
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 200
+)
+
+var (
+	ErrInvalidHistoryFilter = errors.New("invalid transaction history filter")
+	ErrInvalidPageToken     = errors.New("invalid page token")
+)
+
+// historyCursor 上一页最后一条记录的排序键
+type historyCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+func encodePageToken(c historyCursor) string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageToken(token string) (historyCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return historyCursor{}, ErrInvalidPageToken
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return historyCursor{}, ErrInvalidPageToken
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return historyCursor{}, ErrInvalidPageToken
+	}
+	transactionID, err := strconv.Atoi(id)
+	if err != nil {
+		return historyCursor{}, ErrInvalidPageToken
+	}
+	// created_at 为不带时区的 TIMESTAMP, 读出时按 UTC 解释
+	return historyCursor{CreatedAt: time.Unix(0, n).UTC(), ID: transactionID}, nil
+}
+
+// validateHistoryFilter 校验查询条件并填充默认分页大小
+func validateHistoryFilter(filter *models.TransactionFilter) error {
+	for _, t := range filter.Types {
+		if !t.Valid() {
+			return fmt.Errorf("%w: unknown transaction type %q", ErrInvalidHistoryFilter, t)
+		}
+	}
+	switch filter.Direction {
+	case "", models.DirectionIn, models.DirectionOut:
+	default:
+		return fmt.Errorf("%w: unknown direction %q", ErrInvalidHistoryFilter, filter.Direction)
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidHistoryFilter)
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && filter.MinAmount.GreaterThan(*filter.MaxAmount) {
+		return fmt.Errorf("%w: min_amount must not exceed max_amount", ErrInvalidHistoryFilter)
+	}
+	if filter.Limit < 0 {
+		return fmt.Errorf("%w: limit must not be negative", ErrInvalidHistoryFilter)
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultHistoryPageSize
+	}
+	if filter.Limit > maxHistoryPageSize {
+		filter.Limit = maxHistoryPageSize
+	}
+	return nil
+}
+
+// GetTransactionHistory 分页查询用户作为付款方或收款方的交易, 按 (created_at, id) 倒序, 使用游标翻页
+func (s *walletService) GetTransactionHistory(ctx context.Context, userID int, filter models.TransactionFilter) (*models.TransactionPage, error) {
+	if err := validateHistoryFilter(&filter); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	// 存款/利息/取款/预授权扣款的双方都是本人, 按交易类型区分方向; 换汇的两条流水分别归入付款方和收款方;
+	// pocket 之间的划转既不算收入也不算支出; 托管的放款和退款记在收款方自己名下, 算作收入
+	selfCredits := pq.Array([]string{string(models.DepositTransactionType), string(models.InterestTransactionType),
+		string(models.EscrowReleaseTransactionType), string(models.EscrowRefundTransactionType)})
+	selfNonDebits := pq.Array([]string{string(models.DepositTransactionType), string(models.InterestTransactionType), string(models.PocketTransferTransactionType),
+		string(models.EscrowReleaseTransactionType), string(models.EscrowRefundTransactionType)})
+	var conditions []string
+	switch filter.Direction {
+	case models.DirectionIn:
+		conditions = append(conditions, fmt.Sprintf("receiver_user_id = $1 AND transaction_type <> %s AND (sender_user_id <> $1 OR transaction_type = ANY(%s))",
+			arg(models.ExchangeOutTransactionType), arg(selfCredits)))
+	case models.DirectionOut:
+		conditions = append(conditions, fmt.Sprintf("sender_user_id = $1 AND transaction_type <> %s AND (receiver_user_id <> $1 OR transaction_type <> ALL(%s))",
+			arg(models.ExchangeInTransactionType), arg(selfNonDebits)))
+	default:
+		conditions = append(conditions, "(sender_user_id = $1 OR receiver_user_id = $1)")
+	}
+	if len(filter.Types) > 0 {
+		types := make([]string, 0, len(filter.Types))
+		for _, t := range filter.Types {
+			types = append(types, string(t))
+		}
+		conditions = append(conditions, "transaction_type = ANY("+arg(pq.Array(types))+")")
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= "+arg(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at < "+arg(*filter.To))
+	}
+	if filter.MinAmount != nil {
+		conditions = append(conditions, "amount >= "+arg(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		conditions = append(conditions, "amount <= "+arg(*filter.MaxAmount))
+	}
+	if filter.ExternalReference != "" {
+		conditions = append(conditions, "external_reference = "+arg(filter.ExternalReference))
+	}
+	if filter.PageToken != "" {
+		cursor, err := decodePageToken(filter.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(cursor.CreatedAt), arg(cursor.ID)))
+	}
+
+	// 多取一条判断是否还有下一页
+	query := fmt.Sprintf("SELECT * FROM transactions WHERE %s ORDER BY created_at DESC, id DESC LIMIT %s",
+		strings.Join(conditions, " AND "), arg(filter.Limit+1))
+	transactions := []models.Transaction{}
+	if err := s.db.SelectContext(ctx, &transactions, query, args...); err != nil {
+		s.logger.Error(ctx, "GetTransactionHistory Failed select from transactions", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	page := &models.TransactionPage{Transactions: transactions}
+	if len(transactions) > filter.Limit {
+		page.Transactions = transactions[:filter.Limit]
+		last := page.Transactions[filter.Limit-1]
+		page.NextPageToken = encodePageToken(historyCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	return page, nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func TestWalletService_GetTransactionHistory_FilteredPage(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据: 每页 2 条, 返回 3 条说明还有下一页
+	userID := 1
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	minAmount, maxAmount := decimal.NewFromInt(10), decimal.NewFromInt(500)
+	cursor := historyCursor{CreatedAt: to.Add(-time.Hour), ID: 40}
+	filter := models.TransactionFilter{
+		Types:     []models.TransactionType{models.TransferTransactionType},
+		Direction: models.DirectionIn,
+		From:      &from,
+		To:        &to,
+		MinAmount: &minAmount,
+		MaxAmount: &maxAmount,
+		PageToken: encodePageToken(cursor),
+		Limit:     2,
+	}
+	t1, t2, t3 := to.Add(-2*time.Hour), to.Add(-3*time.Hour), to.Add(-4*time.Hour)
+
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE receiver_user_id = \$1 AND transaction_type <> \$2 AND \(sender_user_id <> \$1 OR transaction_type = ANY\(\$3\)\)`+
+		` AND transaction_type = ANY\(\$4\) AND created_at >= \$5 AND created_at < \$6 AND amount >= \$7 AND amount <= \$8`+
+		` AND \(created_at, id\) < \(\$9, \$10\) ORDER BY created_at DESC, id DESC LIMIT \$11`).
+		WithArgs(userID, models.ExchangeOutTransactionType, pq.Array([]string{"deposit", "interest", "escrow_release", "escrow_refund"}), pq.Array([]string{"transfer"}),
+			from, to, minAmount, maxAmount, cursor.CreatedAt, cursor.ID, 3).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(39, 2, userID, "transfer", "20", "USD", nil, nil, nil, "completed", t1).
+			AddRow(35, 3, userID, "transfer", "30", "USD", nil, nil, nil, "completed", t2).
+			AddRow(31, 2, userID, "transfer", "40", "USD", nil, nil, nil, "completed", t3))
+
+	page, err := service.GetTransactionHistory(context.Background(), userID, filter)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Transactions, 2)
+	assert.Equal(t, 35, page.Transactions[1].ID)
+	next, err := decodePageToken(page.NextPageToken)
+	assert.NoError(t, err)
+	assert.Equal(t, historyCursor{CreatedAt: t2, ID: 35}, next)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_GetTransactionHistory_InvalidFilter(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = service.GetTransactionHistory(context.Background(), 1, models.TransactionFilter{Direction: "sideways"})
+	assert.ErrorIs(t, err, ErrInvalidHistoryFilter)
+	_, err = service.GetTransactionHistory(context.Background(), 1, models.TransactionFilter{Types: []models.TransactionType{"gift"}})
+	assert.ErrorIs(t, err, ErrInvalidHistoryFilter)
+	_, err = service.GetTransactionHistory(context.Background(), 1, models.TransactionFilter{From: &from, To: &from})
+	assert.ErrorIs(t, err, ErrInvalidHistoryFilter)
+	_, err = service.GetTransactionHistory(context.Background(), 1, models.TransactionFilter{PageToken: "not-a-token"})
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const defaultHoldTTL = 7 * 24 * time.Hour
+
+var (
+	ErrHoldNotFound       = errors.New("hold not found")
+	ErrHoldNotActive      = errors.New("hold is not active")
+	ErrHoldExpired        = errors.New("hold has expired")
+	ErrCaptureExceedsHold = errors.New("capture amount exceeds the held amount")
+)
+
+func holdTTL(ttl time.Duration) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	seconds := config.GetConfig().Holds.DefaultTTLSeconds
+	if seconds <= 0 {
+		return defaultHoldTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+const holdColumns = "id, user_id, currency, amount, captured_amount, status, transaction_id, expires_at, created_at, updated_at"
+
+// Reserve 冻结资金: 减少可用余额, 账面余额不变; ttl 为 0 时使用默认有效期
+func (s *walletService) Reserve(ctx context.Context, userID int, amount decimal.Decimal, currency models.Currency, ttl time.Duration) (*models.Hold, error) {
+	if err := validateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+	if ttl < 0 {
+		return nil, errors.New("ttl must not be negative")
+	}
+
+	var hold models.Hold
+	err := s.runInTx(ctx, "Reserve", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, userID, requestHash("reserve", userID, amount, currency, ttl))
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			return tx.Get(&hold, "SELECT "+holdColumns+" FROM holds WHERE id = $1", replayed.HoldID)
+		}
+
+		// 条件更新: 只有钱包正常且可用余额足够时才冻结
+		res, err := tx.Exec("UPDATE wallets SET held_balance = held_balance + $1 WHERE user_id = $2 AND currency = $3 AND status = 'active' AND balance + credit_limit - held_balance >= $1",
+			amount, userID, currency)
+		if err != nil {
+			s.logger.Error(ctx, "Reserve Failed to update wallets", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return s.walletUnavailable(ctx, tx, userID, currency, true)
+		}
+		s.walletChanged(tx, userID, currency)
+		// 共享钱包超过审批阈值的冻结在冻结时即拒绝, 不必等到扣款
+		if err = s.requireApproval(ctx, tx, userID, currency, amount); err != nil {
+			return err
+		}
+
+		err = tx.Get(&hold, `
+			INSERT INTO holds (user_id, currency, amount, status, expires_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6) RETURNING `+holdColumns,
+			userID, currency, amount, models.HoldActive, time.Now().Add(holdTTL(ttl)), time.Now())
+		if err != nil {
+			s.logger.Error(ctx, "Reserve Failed insert into holds", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+
+		return s.saveIdempotencyResult(ctx, tx, userID, &models.TransactionResult{HoldID: hold.ID})
+	})
+	if err != nil {
+		s.logger.Error(ctx, "Reserve Failed", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// lockHold 在事务内锁定一笔冻结, 只有 active 状态的冻结可以扣款或撤销
+func (s *walletService) lockHold(ctx context.Context, tx *sqlx.Tx, holdID int) (*models.Hold, error) {
+	var hold models.Hold
+	err := tx.Get(&hold, "SELECT "+holdColumns+" FROM holds WHERE id = $1 FOR UPDATE", holdID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrHoldNotFound
+	}
+	if err != nil {
+		s.logger.Error(ctx, "lockHold Failed select from holds", zap.Int("holdID", holdID), zap.Error(err))
+		return nil, err
+	}
+	if hold.Status != models.HoldActive {
+		return nil, fmt.Errorf("%w: hold %d is %s", ErrHoldNotActive, holdID, hold.Status)
+	}
+	return &hold, nil
+}
+
+// Capture 对冻结扣款, amount 为零时全额扣款; 部分扣款后剩余的冻结金额一并释放
+func (s *walletService) Capture(ctx context.Context, holdID int, amount decimal.Decimal) (*models.TransactionResult, error) {
+	if amount.IsNegative() {
+		return nil, errors.New("amount must be greater than zero")
+	}
+
+	var (
+		result *models.TransactionResult
+		hold   *models.Hold
+	)
+	err := s.runInTx(ctx, "Capture", func(tx *sqlx.Tx) error {
+		// 幂等键按冻结所属的用户区分; 扣款后冻结不再是 active, 需在校验状态之前重放
+		var userID int
+		err := tx.Get(&userID, "SELECT user_id FROM holds WHERE id = $1", holdID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrHoldNotFound
+		}
+		if err != nil {
+			s.logger.Error(ctx, "Capture Failed select from holds", zap.Int("holdID", holdID), zap.Error(err))
+			return err
+		}
+		replayed, err := s.claimIdempotencyKey(ctx, tx, userID, requestHash("capture", holdID, amount))
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			result = replayed
+			return nil
+		}
+
+		hold, err = s.lockHold(ctx, tx, holdID)
+		if err != nil {
+			return err
+		}
+		if !hold.ExpiresAt.After(time.Now()) {
+			return ErrHoldExpired
+		}
+		captured := amount
+		if captured.IsZero() {
+			captured = hold.Amount
+		}
+		if err = validateMoney(captured, hold.Currency); err != nil {
+			return err
+		}
+		if captured.GreaterThan(hold.Amount) {
+			return ErrCaptureExceedsHold
+		}
+
+		// 扣款并释放整笔冻结, 约束 held_balance <= balance 保证不会透支; 钱包被冻结后不能扣款, 冻结可以撤销
+		var balance decimal.Decimal
+		err = tx.Get(&balance, "UPDATE wallets SET balance = balance - $1, held_balance = held_balance - $2 WHERE user_id = $3 AND currency = $4 AND status = 'active' RETURNING balance",
+			captured, hold.Amount, hold.UserID, hold.Currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.walletUnavailable(ctx, tx, hold.UserID, hold.Currency, true)
+		}
+		if err != nil {
+			s.logger.Error(ctx, "Capture Failed to update wallets", zap.Int("holdID", holdID), zap.Error(err))
+			return err
+		}
+		s.walletChanged(tx, hold.UserID, hold.Currency)
+		if err = s.chargeOutflow(ctx, tx, hold.UserID, hold.Currency, captured, 0); err != nil {
+			return err
+		}
+
+		// 用户钱包 -> 外部现金流出
+		transactionID, err := s.recordTransaction(ctx, tx, models.Transaction{
+			SenderUserID:    hold.UserID,
+			ReceiverUserID:  hold.UserID,
+			TransactionType: models.CaptureTransactionType,
+			Amount:          captured,
+			Currency:        hold.Currency,
+		}, []models.Posting{
+			walletPosting(hold.UserID, hold.Currency, captured.Neg()),
+			systemPosting(ExternalCashOutAccount, hold.Currency, captured),
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE holds SET status = $1, captured_amount = $2, transaction_id = $3 WHERE id = $4",
+			models.HoldCaptured, captured, transactionID, holdID)
+		if err != nil {
+			s.logger.Error(ctx, "Capture Failed to update holds", zap.Int("holdID", holdID), zap.Error(err))
+			return err
+		}
+
+		// 按 capture 规则收取手续费, 从释放冻结后的可用余额中扣除
+		fee, err := s.chargeFee(ctx, tx, transactionID, models.CaptureTransactionType, hold.UserID, captured, hold.Currency)
+		if err != nil {
+			return err
+		}
+
+		result = &models.TransactionResult{TransactionID: transactionID, HoldID: holdID, Fee: fee}
+		return s.saveIdempotencyResult(ctx, tx, userID, result)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "Capture Failed", zap.Int("holdID", holdID), zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
+// Void 撤销冻结, 释放全部冻结金额
+func (s *walletService) Void(ctx context.Context, holdID int) (*models.Hold, error) {
+	var hold *models.Hold
+	err := s.runInTx(ctx, "Void", func(tx *sqlx.Tx) error {
+		var err error
+		hold, err = s.lockHold(ctx, tx, holdID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE wallets SET held_balance = held_balance - $1 WHERE user_id = $2 AND currency = $3",
+			hold.Amount, hold.UserID, hold.Currency)
+		if err != nil {
+			s.logger.Error(ctx, "Void Failed to update wallets", zap.Int("holdID", holdID), zap.Error(err))
+			return err
+		}
+		s.walletChanged(tx, hold.UserID, hold.Currency)
+
+		_, err = tx.Exec("UPDATE holds SET status = $1 WHERE id = $2", models.HoldVoided, holdID)
+		if err != nil {
+			s.logger.Error(ctx, "Void Failed to update holds", zap.Int("holdID", holdID), zap.Error(err))
+			return err
+		}
+		hold.Status = models.HoldVoided
+		return nil
+	})
+	if err != nil {
+		s.logger.Error(ctx, "Void Failed", zap.Int("holdID", holdID), zap.Error(err))
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ListHolds 查询钱包的冻结记录, currency 为空时返回所有币种
+func (s *walletService) ListHolds(ctx context.Context, userID int, currency models.Currency) ([]models.Hold, error) {
+	holds := []models.Hold{}
+	query, args := "SELECT "+holdColumns+" FROM holds WHERE user_id = $1", []interface{}{userID}
+	if currency != "" {
+		if !currency.Valid() {
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+		}
+		query, args = query+" AND currency = $2", append(args, currency)
+	}
+	err := s.db.Select(&holds, query+" ORDER BY id DESC", args...)
+	if err != nil {
+		s.logger.Error(ctx, "ListHolds Failed select from holds", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return holds, nil
+}
+
+// HoldExpirer 定期释放已过期的冻结
+type HoldExpirer struct {
+	db     *sqlx.DB
+	redis  *redis.Client
+	logger *wallet_logger.Logger
+}
+
+// NewHoldExpirer new hold expirer
+func NewHoldExpirer(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *HoldExpirer {
+	return &HoldExpirer{
+		db:     db,
+		redis:  redis,
+		logger: logger,
+	}
+}
+
+// ExpireHolds 把过期的 active 冻结标记为 expired, 并在同一条语句内释放钱包的冻结金额
+func (e *HoldExpirer) ExpireHolds(ctx context.Context) error {
+	var released []cachedBalance
+	err := e.db.SelectContext(ctx, &released, `
+		WITH expired AS (
+			UPDATE holds SET status = $1 WHERE status = $2 AND expires_at <= $3
+			RETURNING user_id, currency, amount
+		), totals AS (
+			SELECT user_id, currency, SUM(amount) AS amount FROM expired GROUP BY user_id, currency
+		)
+		UPDATE wallets w SET held_balance = w.held_balance - t.amount
+		FROM totals t WHERE w.user_id = t.user_id AND w.currency = t.currency
+		RETURNING w.user_id, w.currency, w.balance, w.held_balance, w.credit_limit, w.version`,
+		models.HoldExpired, models.HoldActive, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(released) == 0 {
+		return nil
+	}
+
+	// 单条语句已提交, 直接按返回的余额和版本号更新缓存
+	if err = (balanceCache{redis: e.redis, logger: e.logger}).set(ctx, released...); err != nil {
+		e.logger.Warn(ctx, "HoldExpirer Failed to update balance cache", zap.Error(err))
+	}
+	e.logger.Info(ctx, "HoldExpirer released expired holds", zap.Int("wallets", len(released)))
+	return nil
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var holdRowColumns = []string{"id", "user_id", "currency", "amount", "captured_amount", "status", "transaction_id", "expires_at", "created_at", "updated_at"}
+
+func TestWalletService_Reserve_Success(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, mockRedis := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据
+	userID := 1
+	amount := decimal.NewFromInt(40)
+	now := time.Now()
+
+	// 冻结只改变 held_balance, 不写分录
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`UPDATE wallets SET held_balance = held_balance \+ \$1 WHERE user_id = \$2 AND currency = \$3 AND status = 'active' AND balance \+ credit_limit - held_balance >= \$1`).
+		WithArgs(amount, userID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, userID, models.USD, "")
+	mockDB.ExpectQuery("INSERT INTO holds").
+		WithArgs(userID, models.USD, amount, models.HoldActive, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(5, userID, "USD", "40", nil, "active", nil, now.Add(time.Hour), now, now))
+	mockDB.ExpectCommit()
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: userID, Currency: models.USD, Balance: decimal.NewFromInt(100), HeldBalance: amount, Version: 2})
+
+	// 执行 Reserve 方法
+	hold, err := service.Reserve(context.Background(), userID, amount, models.USD, time.Hour)
+
+	// 断言没有错误
+	assert.NoError(t, err)
+	assert.Equal(t, 5, hold.ID)
+	assert.Equal(t, models.HoldActive, hold.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_Reserve_InsufficientFunds(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 可用余额不足: 条件更新没有影响任何行
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("UPDATE wallets SET held_balance").
+		WithArgs(decimal.NewFromInt(40), 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectRollback()
+
+	hold, err := service.Reserve(context.Background(), 1, decimal.NewFromInt(40), models.USD, 0)
+
+	assert.Nil(t, hold)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Reserve_ApprovalRequired(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 共享钱包的审批阈值为 30, 冻结 40 在冻结时即被拒绝, 事务回滚
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("UPDATE wallets SET held_balance").
+		WithArgs(decimal.NewFromInt(40), 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, 1, models.USD, "30")
+	mockDB.ExpectRollback()
+
+	hold, err := service.Reserve(context.Background(), 1, decimal.NewFromInt(40), models.USD, 0)
+
+	assert.Nil(t, hold)
+	assert.ErrorIs(t, err, ErrApprovalRequired)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Capture_Partial(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, mockRedis := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据: 冻结 40, 扣款 25, 剩余 15 一并释放
+	userID := 1
+	held := decimal.NewFromInt(40)
+	captured := decimal.NewFromInt(25)
+	now := time.Now()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT user_id FROM holds WHERE id = \$1`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mockDB.ExpectQuery(`SELECT (.+) FROM holds WHERE id = \$1 FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(5, userID, "USD", "40", nil, "active", nil, now.Add(time.Hour), now, now))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1, held_balance = held_balance - \$2`).
+		WithArgs(captured, held, userID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("75"))
+	expectApprovalThreshold(mockDB, userID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, userID)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, userID, models.CaptureTransactionType, captured, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	expectJournalEntry(mockDB, walletPosting(userID, models.USD, captured.Neg()), systemPosting(ExternalCashOutAccount, models.USD, captured))
+	mockDB.ExpectExec("UPDATE holds SET status").
+		WithArgs(models.HoldCaptured, captured, 9, 5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: userID, Currency: models.USD, Balance: decimal.RequireFromString("75"), Version: 3})
+
+	// 执行 Capture 方法
+	result, err := service.Capture(context.Background(), 5, captured)
+
+	// 断言没有错误
+	assert.NoError(t, err)
+	assert.Equal(t, 9, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_Capture_ExceedsHold(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	now := time.Now()
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT user_id FROM holds WHERE id = \$1`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mockDB.ExpectQuery(`SELECT (.+) FROM holds WHERE id = \$1 FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(5, 1, "USD", "40", nil, "active", nil, now.Add(time.Hour), now, now))
+	mockDB.ExpectRollback()
+
+	_, err = service.Capture(context.Background(), 5, decimal.NewFromInt(41))
+
+	assert.ErrorIs(t, err, ErrCaptureExceedsHold)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Capture_Expired(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 已过期但尚未被后台任务释放的冻结不能扣款
+	now := time.Now()
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT user_id FROM holds WHERE id = \$1`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mockDB.ExpectQuery(`SELECT (.+) FROM holds WHERE id = \$1 FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(5, 1, "USD", "40", nil, "active", nil, now.Add(-time.Minute), now, now))
+	mockDB.ExpectRollback()
+
+	_, err = service.Capture(context.Background(), 5, decimal.Zero)
+
+	assert.ErrorIs(t, err, ErrHoldExpired)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Void_Success(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	now := time.Now()
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT (.+) FROM holds WHERE id = \$1 FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(5, 1, "USD", "40", nil, "active", nil, now.Add(time.Hour), now, now))
+	mockDB.ExpectExec(`UPDATE wallets SET held_balance = held_balance - \$1`).
+		WithArgs(decimal.NewFromInt(40), 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec("UPDATE holds SET status").
+		WithArgs(models.HoldVoided, 5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	hold, err := service.Void(context.Background(), 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.HoldVoided, hold.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Void_NotActive(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	now := time.Now()
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT (.+) FROM holds WHERE id = \$1 FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(holdRowColumns).
+			AddRow(5, 1, "USD", "40", "40", "captured", 9, now.Add(time.Hour), now, now))
+	mockDB.ExpectRollback()
+
+	_, err = service.Void(context.Background(), 5)
+
+	assert.ErrorIs(t, err, ErrHoldNotActive)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestHoldExpirer_ExpireHolds(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	expirer := NewHoldExpirer(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 过期冻结的状态更新与冻结金额释放在同一条语句内完成, 返回的最新余额和版本号直接写入缓存
+	mockDB.ExpectQuery(`WITH expired AS \(\s*UPDATE holds SET status = \$1`).
+		WithArgs(models.HoldExpired, models.HoldActive, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "balance", "held_balance", "credit_limit", "version"}).
+			AddRow(1, "USD", "100", "0", "0", 3).
+			AddRow(2, "EUR", "50", "10", "0", 8))
+	expectBalanceCacheSet(mockRedis, cachedBalance{UserID: 1, Currency: models.USD, Balance: decimal.NewFromInt(100), Version: 3}).SetVal(int64(1))
+	expectBalanceCacheSet(mockRedis, cachedBalance{UserID: 2, Currency: models.EUR, Balance: decimal.NewFromInt(50), HeldBalance: decimal.NewFromInt(10), Version: 8}).SetVal(int64(0))
+
+	err = expirer.ExpireHolds(context.Background())
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
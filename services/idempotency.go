@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const defaultIdempotencyRetention = 24 * time.Hour
+
+var ErrIdempotencyKeyConflict = errors.New("idempotency key already used with a different request")
+
+// 幂等键的命名空间: 客户端传入的键和内部生成的键分开保存, 客户端无法占用或重放内部键
+const (
+	clientIdempotencyScope   = "client"
+	internalIdempotencyScope = "internal"
+)
+
+type idempotencyKeyCtx struct{}
+
+type idempotencyKey struct {
+	scope string
+	key   string
+}
+
+// WithIdempotencyKey 把客户端传入的幂等键放入 ctx, 由 Deposit/Withdraw/Transfer 在事务内使用
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return withScopedIdempotencyKey(ctx, clientIdempotencyScope, key)
+}
+
+// withInternalIdempotencyKey 放入内部生成的幂等键, 如定时转账的执行记录、审批通过后的转账
+func withInternalIdempotencyKey(ctx context.Context, key string) context.Context {
+	return withScopedIdempotencyKey(ctx, internalIdempotencyScope, key)
+}
+
+func withScopedIdempotencyKey(ctx context.Context, scope, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyCtx{}, idempotencyKey{scope: scope, key: key})
+}
+
+func idempotencyKeyFromContext(ctx context.Context) idempotencyKey {
+	key, _ := ctx.Value(idempotencyKeyCtx{}).(idempotencyKey)
+	return key
+}
+
+// requestHash 计算请求内容的摘要, 同一个幂等键的重复请求摘要必须一致
+func requestHash(parts ...interface{}) string {
+	values := make([]string, 0, len(parts))
+	for _, p := range parts {
+		values = append(values, fmt.Sprint(p))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(values, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+func idempotencyRetention() time.Duration {
+	hours := config.GetConfig().Idempotency.RetentionHours
+	if hours <= 0 {
+		return defaultIdempotencyRetention
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// claimIdempotencyKey 在事务内以 userID 的名义占用幂等键, 不同用户使用相同的键互不影响.
+// 返回非 nil 的结果表示该键已有成功结果, 调用方应直接重放该结果;
+// 同一个键但请求内容不同返回 ErrIdempotencyKeyConflict.
+// ctx 中没有幂等键时不做任何处理.
+func (s *walletService) claimIdempotencyKey(ctx context.Context, tx *sqlx.Tx, userID int, hash string) (*models.TransactionResult, error) {
+	key := idempotencyKeyFromContext(ctx)
+	if key.key == "" {
+		return nil, nil
+	}
+
+	now := time.Now()
+	// 已过期的键可以被重新占用; 并发的相同请求会在唯一索引上等待先到者提交
+	res, err := tx.Exec(`
+		INSERT INTO idempotency_keys (scope, user_id, idempotency_key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (scope, user_id, idempotency_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, response_body = NULL,
+		    created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < EXCLUDED.created_at`,
+		key.scope, userID, key.key, hash, now, now.Add(idempotencyRetention()))
+	if err != nil {
+		s.logger.Error(ctx, "claimIdempotencyKey Failed insert into idempotency_keys", zap.String("scope", key.scope), zap.Int("userID", userID),
+			zap.String("key", key.key), zap.Error(err))
+		return nil, err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected > 0 {
+		return nil, nil
+	}
+
+	var record struct {
+		RequestHash  string  `db:"request_hash"`
+		StatusCode   *int    `db:"status_code"`
+		ResponseBody *[]byte `db:"response_body"`
+	}
+	err = tx.Get(&record, "SELECT request_hash, status_code, response_body FROM idempotency_keys WHERE scope = $1 AND user_id = $2 AND idempotency_key = $3",
+		key.scope, userID, key.key)
+	if err != nil {
+		s.logger.Error(ctx, "claimIdempotencyKey Failed select from idempotency_keys", zap.String("scope", key.scope), zap.Int("userID", userID),
+			zap.String("key", key.key), zap.Error(err))
+		return nil, err
+	}
+	if record.RequestHash != hash {
+		return nil, ErrIdempotencyKeyConflict
+	}
+	if record.ResponseBody == nil {
+		return nil, fmt.Errorf("idempotency key %s has no stored result", key.key)
+	}
+
+	var result models.TransactionResult
+	if err = json.Unmarshal(*record.ResponseBody, &result); err != nil {
+		s.logger.Error(ctx, "claimIdempotencyKey Failed to decode stored result", zap.String("scope", key.scope), zap.String("key", key.key), zap.Error(err))
+		return nil, err
+	}
+	result.Replayed = true
+	s.logger.Info(ctx, "claimIdempotencyKey replay stored result", zap.String("scope", key.scope), zap.String("key", key.key))
+	return &result, nil
+}
+
+// saveIdempotencyResult 在同一事务内保存处理结果, 与资金变动一起提交; userID 与占用幂等键时一致
+func (s *walletService) saveIdempotencyResult(ctx context.Context, tx *sqlx.Tx, userID int, result *models.TransactionResult) error {
+	key := idempotencyKeyFromContext(ctx)
+	if key.key == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE idempotency_keys SET status_code = $1, response_body = $2 WHERE scope = $3 AND user_id = $4 AND idempotency_key = $5",
+		http.StatusOK, body, key.scope, userID, key.key)
+	if err != nil {
+		s.logger.Error(ctx, "saveIdempotencyResult Failed update idempotency_keys", zap.String("scope", key.scope), zap.Int("userID", userID),
+			zap.String("key", key.key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// IdempotencyJanitor 定期清理过期的幂等键
+type IdempotencyJanitor struct {
+	db     *sqlx.DB
+	logger *wallet_logger.Logger
+}
+
+// NewIdempotencyJanitor new idempotency janitor
+func NewIdempotencyJanitor(logger *wallet_logger.Logger, db *sqlx.DB) *IdempotencyJanitor {
+	return &IdempotencyJanitor{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// PurgeExpired 删除已过期的幂等键
+func (j *IdempotencyJanitor) PurgeExpired(ctx context.Context) error {
+	res, err := j.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < $1", time.Now())
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected > 0 {
+		j.logger.Info(ctx, "IdempotencyJanitor purged expired keys", zap.Int64("count", rowsAffected))
+	}
+	return nil
+}
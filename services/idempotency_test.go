@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func TestRequestHash(t *testing.T) {
+	// 金额的不同写法视为同一请求
+	a := requestHash("deposit", 1, 1, decimal.RequireFromString("100"), models.USD, models.DepositTransactionType)
+	b := requestHash("deposit", 1, 1, decimal.RequireFromString("100.00"), models.USD, models.DepositTransactionType)
+	assert.Equal(t, a, b)
+
+	c := requestHash("deposit", 1, 1, decimal.RequireFromString("100.01"), models.USD, models.DepositTransactionType)
+	assert.NotEqual(t, a, c)
+}
+
+func TestWalletService_Deposit_WithIdempotencyKey(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, _ := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	service := NewWalletService(logger.NewLogger(), sqlxDB, client)
+
+	// 准备测试数据
+	userID := 1
+	amount := decimal.NewFromFloat(100.0)
+	ctx := WithIdempotencyKey(context.Background(), "key-1")
+
+	// 设置 mock DB 的期望行为: 幂等键与资金变动在同一事务内提交
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs(clientIdempotencyScope, userID, "key-1", requestHash("deposit", userID, userID, amount, models.USD, models.DepositTransactionType), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
+		WithArgs(amount, userID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectWalletLimits(mockDB, models.USD, userID)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	expectJournalEntry(mockDB, systemPosting(ExternalCashInAccount, models.USD, amount.Neg()), walletPosting(userID, models.USD, amount))
+	mockDB.ExpectExec("UPDATE idempotency_keys SET status_code").
+		WithArgs(200, []byte(`{"transaction_id":7}`), clientIdempotencyScope, userID, "key-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	// 执行 Deposit 方法
+	result, err := service.Deposit(ctx, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{})
+
+	// 断言没有错误
+	assert.NoError(t, err)
+	assert.Equal(t, &models.TransactionResult{TransactionID: 7}, result)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Deposit_IdempotentReplay(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, _ := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	service := NewWalletService(logger.NewLogger(), sqlxDB, client)
+
+	// 准备测试数据
+	userID := 1
+	amount := decimal.NewFromFloat(100.0)
+	hash := requestHash("deposit", userID, userID, amount, models.USD, models.DepositTransactionType)
+	ctx := WithIdempotencyKey(context.Background(), "key-1")
+
+	// 设置 mock DB 的期望行为: 键已存在, 不再变动余额
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery("SELECT request_hash, status_code, response_body FROM idempotency_keys").
+		WithArgs(clientIdempotencyScope, userID, "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "response_body"}).
+			AddRow(hash, 200, []byte(`{"transaction_id":7}`)))
+	mockDB.ExpectCommit()
+
+	// 执行 Deposit 方法
+	result, err := service.Deposit(ctx, userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{})
+
+	// 断言返回保存的结果
+	assert.NoError(t, err)
+	assert.Equal(t, &models.TransactionResult{TransactionID: 7, Replayed: true}, result)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Transfer_IdempotencyConflict(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, _ := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	service := NewWalletService(logger.NewLogger(), sqlxDB, client)
+	ctx := WithIdempotencyKey(context.Background(), "key-1")
+
+	// 设置 mock DB 的期望行为: 同一个键对应的请求内容不同
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery("SELECT request_hash, status_code, response_body FROM idempotency_keys").
+		WithArgs(clientIdempotencyScope, 1, "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "response_body"}).
+			AddRow(requestHash("transfer", 1, 2, decimal.NewFromFloat(10), models.USD), 200, []byte(`{"transaction_id":7}`)))
+	mockDB.ExpectRollback()
+
+	// 执行 Transfer 方法
+	result, err := service.Transfer(ctx, 1, 2, decimal.NewFromFloat(20), models.USD, models.TransactionDetails{})
+
+	// 断言返回冲突错误
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrIdempotencyKeyConflict)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ClaimIdempotencyKey_PerUser(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+	ctx := WithIdempotencyKey(context.Background(), "key-1")
+	hash := requestHash("transfer", 2, 3, decimal.NewFromInt(10), models.USD)
+
+	// 用户 1 已使用过 key-1, 用户 2 使用同一个键时按新请求处理, 不会重放或冲突
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs(clientIdempotencyScope, 2, "key-1", hash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec("UPDATE idempotency_keys SET status_code").
+		WithArgs(200, []byte(`{"transaction_id":9}`), clientIdempotencyScope, 2, "key-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+	replayed, err := service.claimIdempotencyKey(ctx, tx, 2, hash)
+	assert.NoError(t, err)
+	assert.Nil(t, replayed)
+	assert.NoError(t, service.saveIdempotencyResult(ctx, tx, 2, &models.TransactionResult{TransactionID: 9}))
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestIdempotencyKeyFromContext_Scopes(t *testing.T) {
+	// 客户端传入与内部键相同的字符串也只占用 client 命名空间
+	clientCtx := WithIdempotencyKey(context.Background(), "schedule-execution:8")
+	internalCtx := withInternalIdempotencyKey(context.Background(), "schedule-execution:8")
+
+	assert.Equal(t, idempotencyKey{scope: clientIdempotencyScope, key: "schedule-execution:8"}, idempotencyKeyFromContext(clientCtx))
+	assert.Equal(t, idempotencyKey{scope: internalIdempotencyScope, key: "schedule-execution:8"}, idempotencyKeyFromContext(internalCtx))
+	assert.Equal(t, idempotencyKey{}, idempotencyKeyFromContext(WithIdempotencyKey(context.Background(), "")))
+	assert.False(t, permanentTransferError(ErrIdempotencyKeyConflict))
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const (
+	InterestExpenseAccount = "system:interest_expense" // 派息支出
+	InterestIncomeAccount  = "system:interest_income"  // 透支利息收入
+
+	// interestAccrualScale 每日利息保留的小数位数, 与 interest_accruals.amount 的精度一致
+	interestAccrualScale = 18
+	// interestBackfillDays 每次运行最多补算的天数, 停机较久时分多次补齐
+	interestBackfillDays    = 31
+	interestPayoutBatchSize = 100
+	interestSummaryPayouts  = 12
+)
+
+var ErrInvalidInterestConfig = errors.New("invalid interest configuration")
+
+// interestRates 按币种的存款利率和透支利率
+type interestRates struct {
+	deposit   map[models.Currency]decimal.Decimal
+	overdraft map[models.Currency]decimal.Decimal
+}
+
+// rate 按日终余额的正负选择利率, 未配置时返回 false
+func (r interestRates) rate(currency models.Currency, balance decimal.Decimal) (decimal.Decimal, bool) {
+	rates := r.deposit
+	if balance.IsNegative() {
+		rates = r.overdraft
+	}
+	rate, ok := rates[currency]
+	return rate, ok
+}
+
+// currencies 配置了存款利率或透支利率的币种
+func (r interestRates) currencies() []string {
+	var currencies []string
+	for currency := range r.deposit {
+		currencies = append(currencies, string(currency))
+	}
+	for currency := range r.overdraft {
+		if _, ok := r.deposit[currency]; !ok {
+			currencies = append(currencies, string(currency))
+		}
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// interestConfig 解析配置的存款利率、透支利率和计息基准, 计息基准为空时使用 actual/365
+func interestConfig() (interestRates, models.DayCountConvention, error) {
+	cfg := config.GetConfig().Interest
+	convention := models.DayCountConvention(cfg.DayCount)
+	if convention == "" {
+		convention = models.DayCountActual365
+	}
+	if _, ok := convention.DaysInYear(time.Now()); !ok {
+		return interestRates{}, "", fmt.Errorf("%w: unsupported day count %q", ErrInvalidInterestConfig, cfg.DayCount)
+	}
+
+	deposit, err := parseInterestRates(cfg.Rates)
+	if err != nil {
+		return interestRates{}, "", err
+	}
+	overdraft, err := parseInterestRates(cfg.OverdraftRates)
+	if err != nil {
+		return interestRates{}, "", err
+	}
+	return interestRates{deposit: deposit, overdraft: overdraft}, convention, nil
+}
+
+// parseInterestRates 解析币种 -> 年化利率的配置, 利率为零的币种不计息
+func parseInterestRates(values map[string]string) (map[models.Currency]decimal.Decimal, error) {
+	rates := make(map[models.Currency]decimal.Decimal, len(values))
+	for code, value := range values {
+		// viper 读取的 map 键为小写
+		currency := models.Currency(strings.ToUpper(code))
+		rate, err := decimal.NewFromString(value)
+		if err != nil || rate.IsNegative() || !currency.Valid() {
+			return nil, fmt.Errorf("%w: rate %q for %q", ErrInvalidInterestConfig, value, code)
+		}
+		if rate.IsPositive() {
+			rates[currency] = rate
+		}
+	}
+	return rates, nil
+}
+
+// dailyInterest 按计息基准计算 date 当天的利息: 余额 * 年化利率 / 一年的天数, 余额为负数时利息同样为负数
+func dailyInterest(balance, rate decimal.Decimal, convention models.DayCountConvention, date time.Time) (decimal.Decimal, error) {
+	days, ok := convention.DaysInYear(date)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%w: unsupported day count %q", ErrInvalidInterestConfig, convention)
+	}
+	return balance.Mul(rate).DivRound(decimal.NewFromInt(int64(days)), interestAccrualScale), nil
+}
+
+// monthStart 所在月份的第一天(UTC)
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// InterestAccruer 每日计息并按月派息
+type InterestAccruer struct {
+	service *walletService
+}
+
+// NewInterestAccruer new interest accruer
+func NewInterestAccruer(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *InterestAccruer {
+	return &InterestAccruer{
+		service: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// Run 先补齐到昨天为止的计息, 再派发已计息完整的月份的利息
+func (a *InterestAccruer) Run(ctx context.Context) error {
+	if err := a.AccrueDaily(ctx); err != nil {
+		return err
+	}
+	return a.PayMonthly(ctx)
+}
+
+// AccrueDaily 从上次计息的次日起逐日计息到昨天(UTC), 首次运行只计昨天
+func (a *InterestAccruer) AccrueDaily(ctx context.Context) error {
+	rates, convention, err := interestConfig()
+	if err != nil {
+		return err
+	}
+	if len(rates.deposit) == 0 && len(rates.overdraft) == 0 {
+		return nil
+	}
+
+	s := a.service
+	var last *time.Time
+	if err = s.db.GetContext(ctx, &last, "SELECT MAX(accrual_date) FROM interest_accrual_runs"); err != nil {
+		return err
+	}
+	day, _, _ := limitPeriods(time.Now())
+	yesterday := day.AddDate(0, 0, -1)
+	from := yesterday
+	if last != nil {
+		from = last.UTC().AddDate(0, 0, 1)
+	}
+
+	for date, n := from, 0; !date.After(yesterday) && n < interestBackfillDays; date, n = date.AddDate(0, 0, 1), n+1 {
+		// 按日期顺序计息, 某一天失败时停止, 下次从这一天重试
+		if err = a.accrueDay(ctx, date, rates, convention); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accrueDay 按日终余额为当天计息, 负余额按透支利率计提负数的利息; 计息日期由 interest_accrual_runs 占用, 多实例或重复运行时每天只计一次
+func (a *InterestAccruer) accrueDay(ctx context.Context, date time.Time, rates interestRates, convention models.DayCountConvention) error {
+	s := a.service
+	return s.runInTx(ctx, "AccrueInterest", func(tx *sqlx.Tx) error {
+		var claimed time.Time
+		err := tx.Get(&claimed, "INSERT INTO interest_accrual_runs (accrual_date) VALUES ($1) ON CONFLICT (accrual_date) DO NOTHING RETURNING accrual_date", date)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		currencies := rates.currencies()
+		// 日终余额 = 当前账户余额 - 次日零点之后的分录金额, 停机后补算的日期同样适用
+		var balances []struct {
+			UserID   int             `db:"user_id"`
+			Currency models.Currency `db:"currency"`
+			Balance  decimal.Decimal `db:"balance"`
+		}
+		err = tx.Select(&balances, `
+			SELECT la.user_id, la.currency, la.balance - COALESCE(SUM(p.amount), 0) AS balance
+			FROM ledger_accounts la
+			JOIN wallets w ON w.user_id = la.user_id AND w.currency = la.currency
+			LEFT JOIN postings p ON p.account_code = la.code AND p.created_at >= $2
+			WHERE la.account_type = $3 AND la.currency = ANY($1) AND w.status <> $4
+			GROUP BY la.user_id, la.currency, la.balance`,
+			pq.Array(currencies), date.AddDate(0, 0, 1), models.WalletAccountType, models.WalletClosed)
+		if err != nil {
+			s.logger.Error(ctx, "accrueDay Failed to select end of day balances", zap.Time("date", date), zap.Error(err))
+			return err
+		}
+
+		var userIDs []int
+		var codes, balanceValues, rateValues, amounts []string
+		for _, b := range balances {
+			rate, ok := rates.rate(b.Currency, b.Balance)
+			if !ok || b.Balance.IsZero() {
+				continue
+			}
+			amount, err := dailyInterest(b.Balance, rate, convention, date)
+			if err != nil {
+				return err
+			}
+			if amount.IsZero() {
+				continue
+			}
+			userIDs = append(userIDs, b.UserID)
+			codes = append(codes, string(b.Currency))
+			balanceValues = append(balanceValues, b.Balance.String())
+			rateValues = append(rateValues, rate.String())
+			amounts = append(amounts, amount.String())
+		}
+
+		if len(userIDs) > 0 {
+			_, err = tx.Exec(`
+				INSERT INTO interest_accruals (user_id, currency, accrual_date, balance, rate, amount)
+				SELECT a.user_id, a.currency, $6, a.balance, a.rate, a.amount
+				FROM unnest($1::int[], $2::text[], $3::numeric[], $4::numeric[], $5::numeric[]) AS a(user_id, currency, balance, rate, amount)`,
+				pq.Array(userIDs), pq.Array(codes), pq.Array(balanceValues), pq.Array(rateValues), pq.Array(amounts), date)
+			if err != nil {
+				s.logger.Error(ctx, "accrueDay Failed insert into interest_accruals", zap.Time("date", date), zap.Error(err))
+				return err
+			}
+		}
+
+		if _, err = tx.Exec("UPDATE interest_accrual_runs SET wallets = $1 WHERE accrual_date = $2", len(userIDs), date); err != nil {
+			return err
+		}
+		s.logger.Info(ctx, "InterestAccruer accrued interest", zap.Time("date", date), zap.Int("wallets", len(userIDs)))
+		return nil
+	})
+}
+
+// interestDue 某个钱包某个月计提的利息
+type interestDue struct {
+	UserID   int             `db:"user_id"`
+	Currency models.Currency `db:"currency"`
+	Period   time.Time       `db:"period"`
+	Accrued  decimal.Decimal `db:"accrued"`
+}
+
+// PayMonthly 派发已计息完整(最后一天已计息)且尚未派息的月份的利息, 按月份顺序逐个钱包派发
+func (a *InterestAccruer) PayMonthly(ctx context.Context) error {
+	s := a.service
+	var through *time.Time
+	if err := s.db.GetContext(ctx, &through, "SELECT MAX(accrual_date) FROM interest_accrual_runs"); err != nil {
+		return err
+	}
+	if through == nil {
+		return nil
+	}
+	cutoff := monthStart(through.UTC().AddDate(0, 0, 1))
+
+	var dues []interestDue
+	err := s.db.SelectContext(ctx, &dues, `
+		SELECT a.user_id, a.currency, date_trunc('month', a.accrual_date)::date AS period, SUM(a.amount) AS accrued
+		FROM interest_accruals a
+		WHERE a.accrual_date < $1 AND NOT EXISTS (
+			SELECT 1 FROM interest_payouts p
+			WHERE p.user_id = a.user_id AND p.currency = a.currency AND p.period = date_trunc('month', a.accrual_date)
+		)
+		GROUP BY a.user_id, a.currency, date_trunc('month', a.accrual_date)
+		ORDER BY period, a.user_id, a.currency
+		LIMIT $2`, cutoff, interestPayoutBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, due := range dues {
+		if err = a.payOne(ctx, due); err != nil {
+			s.logger.Error(ctx, "InterestAccruer Failed to pay interest", zap.Int("userID", due.UserID),
+				zap.String("currency", string(due.Currency)), zap.Time("period", due.Period), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// payOne 派发一个钱包一个月的利息: 加上上月结转的零头后按最小货币单位向零取整, 为正数时入账, 为负数时收取透支利息, 余下的零头结转到下月
+func (a *InterestAccruer) payOne(ctx context.Context, due interestDue) error {
+	s := a.service
+	return s.runInTx(ctx, "PayInterest", func(tx *sqlx.Tx) error {
+		var carry decimal.Decimal
+		err := tx.Get(&carry, "SELECT carry FROM interest_payouts WHERE user_id = $1 AND currency = $2 AND period < $3 ORDER BY period DESC LIMIT 1",
+			due.UserID, due.Currency, due.Period)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		units, ok := due.Currency.MinorUnits()
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnsupportedCurrency, due.Currency)
+		}
+		total := due.Accrued.Add(carry)
+		amount := total.Truncate(units)
+
+		// 派息记录按 (钱包, 月份) 唯一, 已派发时直接返回
+		var period time.Time
+		err = tx.Get(&period, `
+			INSERT INTO interest_payouts (user_id, currency, period, accrued, amount, carry) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id, currency, period) DO NOTHING RETURNING period`,
+			due.UserID, due.Currency, due.Period, total, amount, total.Sub(amount))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if amount.IsZero() {
+			return nil
+		}
+
+		transaction := models.Transaction{
+			SenderUserID:    due.UserID,
+			ReceiverUserID:  due.UserID,
+			TransactionType: models.InterestTransactionType,
+			Amount:          amount,
+			Currency:        due.Currency,
+		}
+		var postings []models.Posting
+		if amount.IsPositive() {
+			err = s.DepositWithTx(ctx, tx, due.UserID, amount, due.Currency)
+			if errors.Is(err, ErrWalletClosed) {
+				// 已注销的钱包不再派息, 未派发的利息作废
+				return a.forfeitPayout(tx, due)
+			}
+			if err != nil {
+				return err
+			}
+			// 派息支出 -> 用户钱包
+			postings = []models.Posting{
+				systemPosting(InterestExpenseAccount, due.Currency, amount.Neg()),
+				walletPosting(due.UserID, due.Currency, amount),
+			}
+		} else {
+			charged, err := a.chargeOverdraft(ctx, tx, due, total, amount.Neg())
+			if err != nil || charged.IsZero() {
+				return err
+			}
+			// 用户钱包 -> 透支利息收入
+			transaction.TransactionType, transaction.Amount = models.OverdraftInterestTransactionType, charged
+			postings = []models.Posting{
+				walletPosting(due.UserID, due.Currency, charged.Neg()),
+				systemPosting(InterestIncomeAccount, due.Currency, charged),
+			}
+		}
+
+		transactionID, err := s.recordTransaction(ctx, tx, transaction, postings)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE interest_payouts SET transaction_id = $1 WHERE user_id = $2 AND currency = $3 AND period = $4",
+			transactionID, due.UserID, due.Currency, due.Period)
+		return err
+	})
+}
+
+// chargeOverdraft 从钱包收取透支利息, 冻结的钱包同样收取; 收取后账面余额不能低于 -credit_limit,
+// 额度不足时只收取额度内的部分, 余下的结转到下月. 返回实际收取的金额
+func (a *InterestAccruer) chargeOverdraft(ctx context.Context, tx *sqlx.Tx, due interestDue, total, charge decimal.Decimal) (decimal.Decimal, error) {
+	s := a.service
+	var wallet models.Wallet
+	err := tx.Get(&wallet, "SELECT balance, credit_limit, status FROM wallets WHERE user_id = $1 AND currency = $2 FOR UPDATE", due.UserID, due.Currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if wallet.Status == models.WalletClosed {
+		return decimal.Zero, a.forfeitPayout(tx, due)
+	}
+
+	if headroom := decimal.Max(wallet.Balance.Add(wallet.CreditLimit), decimal.Zero); charge.GreaterThan(headroom) {
+		charge = headroom
+		_, err = tx.Exec("UPDATE interest_payouts SET amount = $1, carry = $2 WHERE user_id = $3 AND currency = $4 AND period = $5",
+			charge.Neg(), total.Add(charge), due.UserID, due.Currency, due.Period)
+		if err != nil {
+			return decimal.Zero, err
+		}
+	}
+	if charge.IsZero() {
+		return decimal.Zero, nil
+	}
+
+	_, err = tx.Exec("UPDATE wallets SET balance = balance - $1 WHERE user_id = $2 AND currency = $3", charge, due.UserID, due.Currency)
+	if err != nil {
+		s.logger.Error(ctx, "chargeOverdraft Failed to update wallets", zap.Int("userID", due.UserID), zap.Error(err))
+		return decimal.Zero, err
+	}
+	s.walletChanged(tx, due.UserID, due.Currency)
+	return charge, nil
+}
+
+// forfeitPayout 钱包已注销时作废当月的派息和结转的零头
+func (a *InterestAccruer) forfeitPayout(tx *sqlx.Tx, due interestDue) error {
+	_, err := tx.Exec("UPDATE interest_payouts SET amount = 0, carry = 0 WHERE user_id = $1 AND currency = $2 AND period = $3",
+		due.UserID, due.Currency, due.Period)
+	return err
+}
+
+// GetInterest 查询钱包已计提未派发的利息和最近的派息记录
+func (s *walletService) GetInterest(ctx context.Context, userID int, currency models.Currency) (*models.InterestSummary, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+
+	summary := &models.InterestSummary{UserID: userID, Currency: currency, Payouts: []models.InterestPayout{}}
+	err := s.db.SelectContext(ctx, &summary.Payouts, "SELECT * FROM interest_payouts WHERE user_id = $1 AND currency = $2 ORDER BY period DESC LIMIT $3",
+		userID, currency, interestSummaryPayouts)
+	if err != nil {
+		s.logger.Error(ctx, "GetInterest Failed select from interest_payouts", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	// 未派发 = 最近一次派息之后的计提 + 该次结转的零头
+	var paidThrough *time.Time
+	if len(summary.Payouts) > 0 {
+		latest := summary.Payouts[0]
+		summary.Accrued = latest.Carry
+		next := latest.Period.AddDate(0, 1, 0)
+		paidThrough = &next
+	}
+	var accrued decimal.Decimal
+	err = s.db.GetContext(ctx, &accrued, `
+		SELECT COALESCE(SUM(amount), 0) FROM interest_accruals
+		WHERE user_id = $1 AND currency = $2 AND ($3::date IS NULL OR accrual_date >= $3)`,
+		userID, currency, paidThrough)
+	if err != nil {
+		s.logger.Error(ctx, "GetInterest Failed select from interest_accruals", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	summary.Accrued = summary.Accrued.Add(accrued)
+	return summary, nil
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func newTestInterestAccruer(t *testing.T) (*InterestAccruer, sqlmock.Sqlmock, redismock.ClientMock) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return &InterestAccruer{service: &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}}, mockDB, mockRedis
+}
+
+func TestDailyInterest(t *testing.T) {
+	balance, rate := decimal.NewFromInt(1000), decimal.RequireFromString("0.0365")
+
+	interest, err := dailyInterest(balance, rate, models.DayCountActual365, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1", interest.String())
+
+	interest, err = dailyInterest(balance, rate, models.DayCountActual360, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.101388888888888889", interest.String())
+
+	// 2024 年为闰年, 一年按 366 天计
+	interest, err = dailyInterest(balance, rate, models.DayCountActualAct, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.099726775956284153", interest.String())
+	interest, err = dailyInterest(balance, rate, models.DayCountActualAct, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1", interest.String())
+
+	_, err = dailyInterest(balance, rate, "30/360", time.Now())
+	assert.ErrorIs(t, err, ErrInvalidInterestConfig)
+}
+
+func TestInterestAccruer_AccrueDay(t *testing.T) {
+	accruer, mockDB, _ := newTestInterestAccruer(t)
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	rates := interestRates{deposit: map[models.Currency]decimal.Decimal{models.USD: decimal.RequireFromString("0.0365")}}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO interest_accrual_runs").
+		WithArgs(date).
+		WillReturnRows(sqlmock.NewRows([]string{"accrual_date"}).AddRow(date))
+	// 日终余额按次日零点之前的分录计算, 余额为零或负数的钱包不计息
+	mockDB.ExpectQuery("FROM ledger_accounts la").
+		WithArgs(pq.Array([]string{"USD"}), date.AddDate(0, 0, 1), models.WalletAccountType, models.WalletClosed).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "balance"}).
+			AddRow(1, "USD", "1000").AddRow(2, "USD", "0").AddRow(3, "USD", "250.5"))
+	mockDB.ExpectExec("INSERT INTO interest_accruals").
+		WithArgs(pq.Array([]int{1, 3}), pq.Array([]string{"USD", "USD"}), pq.Array([]string{"1000", "250.5"}),
+			pq.Array([]string{"0.0365", "0.0365"}), pq.Array([]string{"0.1", "0.02505"}), date).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec("UPDATE interest_accrual_runs SET wallets").
+		WithArgs(2, date).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	err := accruer.accrueDay(context.Background(), date, rates, models.DayCountActual365)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestInterestAccruer_AccrueDay_AlreadyAccrued(t *testing.T) {
+	accruer, mockDB, _ := newTestInterestAccruer(t)
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// 当天已计息(重复运行或其他实例), 不再计息
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO interest_accrual_runs").
+		WithArgs(date).
+		WillReturnRows(sqlmock.NewRows([]string{"accrual_date"}))
+	mockDB.ExpectCommit()
+
+	rates := interestRates{deposit: map[models.Currency]decimal.Decimal{models.USD: decimal.RequireFromString("0.02")}}
+	err := accruer.accrueDay(context.Background(), date, rates, models.DayCountActual365)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestInterestAccruer_PayMonthly_WaitsForLastDay(t *testing.T) {
+	accruer, mockDB, _ := newTestInterestAccruer(t)
+
+	// 1 月 31 日尚未计息, 1 月的利息暂不派发
+	mockDB.ExpectQuery(`SELECT MAX\(accrual_date\) FROM interest_accrual_runs`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC)))
+	mockDB.ExpectQuery("FROM interest_accruals a").
+		WithArgs(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), interestPayoutBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "period", "accrued"}))
+
+	assert.NoError(t, accruer.PayMonthly(context.Background()))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestInterestAccruer_PayMonthly(t *testing.T) {
+	accruer, mockDB, mockRedis := newTestInterestAccruer(t)
+	userID := 1
+	period := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	paid := decimal.RequireFromString("3.1")
+
+	mockDB.ExpectQuery(`SELECT MAX\(accrual_date\) FROM interest_accrual_runs`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)))
+	mockDB.ExpectQuery("FROM interest_accruals a").
+		WithArgs(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), interestPayoutBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "period", "accrued"}).
+			AddRow(userID, "USD", period, "3.095890410958904100"))
+
+	// 上月结转 0.007, 合计 3.1028..., 派发 3.10, 余下的零头结转到下月
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("SELECT carry FROM interest_payouts").
+		WithArgs(userID, models.USD, period).
+		WillReturnRows(sqlmock.NewRows([]string{"carry"}).AddRow("0.007"))
+	mockDB.ExpectQuery("INSERT INTO interest_payouts").
+		WithArgs(userID, models.USD, period, decimal.RequireFromString("3.1028904109589041"), paid, decimal.RequireFromString("0.0028904109589041")).
+		WillReturnRows(sqlmock.NewRows([]string{"period"}).AddRow(period))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(paid, userID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, userID, models.InterestTransactionType, paid, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(30))
+	expectJournalEntry(mockDB, systemPosting(InterestExpenseAccount, models.USD, paid.Neg()), walletPosting(userID, models.USD, paid))
+	mockDB.ExpectExec("UPDATE interest_payouts SET transaction_id").
+		WithArgs(30, userID, models.USD, period).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: userID, Currency: models.USD, Balance: decimal.RequireFromString("103.1"), Version: 2})
+
+	assert.NoError(t, accruer.PayMonthly(context.Background()))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_GetInterest(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), nil)
+	period := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectQuery("FROM interest_payouts").
+		WithArgs(1, models.USD, interestSummaryPayouts).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "period", "accrued", "amount", "carry", "transaction_id", "created_at"}).
+			AddRow(1, "USD", period, "3.1028", "3.1", "0.0028", 30, time.Now()))
+	// 只统计最近一次派息月份之后的计提
+	mockDB.ExpectQuery("FROM interest_accruals").
+		WithArgs(1, models.USD, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0.5"))
+
+	summary, err := service.GetInterest(context.Background(), 1, models.USD)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "0.5028", summary.Accrued.String())
+	assert.Len(t, summary.Payouts, 1)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestInterestAccruer_AccrueDay_Overdraft(t *testing.T) {
+	accruer, mockDB, _ := newTestInterestAccruer(t)
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	rates := interestRates{
+		deposit:   map[models.Currency]decimal.Decimal{models.USD: decimal.RequireFromString("0.0365")},
+		overdraft: map[models.Currency]decimal.Decimal{models.USD: decimal.RequireFromString("0.1825"), models.EUR: decimal.RequireFromString("0.1825")},
+	}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO interest_accrual_runs").
+		WithArgs(date).
+		WillReturnRows(sqlmock.NewRows([]string{"accrual_date"}).AddRow(date))
+	mockDB.ExpectQuery("FROM ledger_accounts la").
+		WithArgs(pq.Array([]string{"EUR", "USD"}), date.AddDate(0, 0, 1), models.WalletAccountType, models.WalletClosed).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "balance"}).
+			AddRow(1, "USD", "1000").AddRow(2, "USD", "-200").AddRow(3, "EUR", "500"))
+	// 负余额按透支利率计提负数的利息, EUR 未配置存款利率, 正余额不计息
+	mockDB.ExpectExec("INSERT INTO interest_accruals").
+		WithArgs(pq.Array([]int{1, 2}), pq.Array([]string{"USD", "USD"}), pq.Array([]string{"1000", "-200"}),
+			pq.Array([]string{"0.0365", "0.1825"}), pq.Array([]string{"0.1", "-0.1"}), date).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec("UPDATE interest_accrual_runs SET wallets").
+		WithArgs(2, date).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	err := accruer.accrueDay(context.Background(), date, rates, models.DayCountActual365)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestInterestAccruer_PayMonthly_ChargesOverdraft(t *testing.T) {
+	accruer, mockDB, mockRedis := newTestInterestAccruer(t)
+	userID := 1
+	period := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectQuery(`SELECT MAX\(accrual_date\) FROM interest_accrual_runs`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)))
+	mockDB.ExpectQuery("FROM interest_accruals a").
+		WithArgs(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), interestPayoutBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "period", "accrued"}).
+			AddRow(userID, "USD", period, "-3.105"))
+
+	// 透支利息 3.10, 但授信额度只剩 2.00: 收取 2.00, 余下的 1.105 结转到下月
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("SELECT carry FROM interest_payouts").
+		WithArgs(userID, models.USD, period).
+		WillReturnRows(sqlmock.NewRows([]string{"carry"}))
+	mockDB.ExpectQuery("INSERT INTO interest_payouts").
+		WithArgs(userID, models.USD, period, decimal.RequireFromString("-3.105"), decimal.RequireFromString("-3.1"), decimal.RequireFromString("-0.005")).
+		WillReturnRows(sqlmock.NewRows([]string{"period"}).AddRow(period))
+	mockDB.ExpectQuery(`SELECT balance, credit_limit, status FROM wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(userID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "credit_limit", "status"}).AddRow("-498", "500", models.WalletActive))
+	charged := decimal.RequireFromString("2")
+	mockDB.ExpectExec(`UPDATE interest_payouts SET amount = \$1, carry = \$2`).
+		WithArgs(charged.Neg(), decimal.RequireFromString("-1.105"), userID, models.USD, period).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2 AND currency = \$3`).
+		WithArgs(charged, userID, models.USD).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, userID, models.OverdraftInterestTransactionType, charged, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(31))
+	expectJournalEntry(mockDB, walletPosting(userID, models.USD, charged.Neg()), systemPosting(InterestIncomeAccount, models.USD, charged))
+	mockDB.ExpectExec("UPDATE interest_payouts SET transaction_id").
+		WithArgs(31, userID, models.USD, period).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: userID, Currency: models.USD, Balance: decimal.RequireFromString("-500"),
+		CreditLimit: decimal.NewFromInt(500), Version: 6})
+
+	assert.NoError(t, accruer.PayMonthly(context.Background()))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
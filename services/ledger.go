@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"time"
+	"wallet-service/models"
+)
+
+// 系统账户, 实际编码按币种区分, 见 SystemAccountCode
+const (
+	ExternalCashInAccount  = "system:external_cash_in"
+	ExternalCashOutAccount = "system:external_cash_out"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry postings do not sum to zero")
+	ErrLedgerMismatch  = errors.New("wallet balance does not match ledger")
+)
+
+// WalletAccountCode 用户钱包对应的账户编码
+func WalletAccountCode(userID int, currency models.Currency) string {
+	return fmt.Sprintf("wallet:%d:%s", userID, currency)
+}
+
+// PocketAccountCode pocket 对应的账户编码
+func PocketAccountCode(pocketID int) string {
+	return fmt.Sprintf("pocket:%d", pocketID)
+}
+
+// EscrowAccountCode 托管对应的账户编码
+func EscrowAccountCode(escrowID int) string {
+	return fmt.Sprintf("escrow:%d", escrowID)
+}
+
+// SystemAccountCode 系统账户在某个币种下的账户编码
+func SystemAccountCode(name string, currency models.Currency) string {
+	return fmt.Sprintf("%s:%s", name, currency)
+}
+
+func walletPosting(userID int, currency models.Currency, amount decimal.Decimal) models.Posting {
+	return models.Posting{
+		AccountCode: WalletAccountCode(userID, currency),
+		AccountType: models.WalletAccountType,
+		UserID:      userID,
+		Currency:    currency,
+		Amount:      amount,
+	}
+}
+
+func pocketPosting(pocket models.Pocket, amount decimal.Decimal) models.Posting {
+	return models.Posting{
+		AccountCode: PocketAccountCode(pocket.ID),
+		AccountType: models.PocketAccountType,
+		UserID:      pocket.UserID,
+		Currency:    pocket.Currency,
+		Amount:      amount,
+	}
+}
+
+func escrowPosting(escrow models.Escrow, amount decimal.Decimal) models.Posting {
+	return models.Posting{
+		AccountCode: EscrowAccountCode(escrow.ID),
+		AccountType: models.EscrowAccountType,
+		Currency:    escrow.Currency,
+		Amount:      amount,
+	}
+}
+
+func systemPosting(name string, currency models.Currency, amount decimal.Decimal) models.Posting {
+	return models.Posting{
+		AccountCode: SystemAccountCode(name, currency),
+		AccountType: models.SystemAccountType,
+		Currency:    currency,
+		Amount:      amount,
+	}
+}
+
+// validateJournalEntry 校验分录: 至少两行, 金额非零, 每个币种合计为零
+func validateJournalEntry(entry models.JournalEntry) error {
+	if len(entry.Postings) < 2 {
+		return errors.New("journal entry needs at least two postings")
+	}
+	sums := make(map[models.Currency]decimal.Decimal)
+	for _, p := range entry.Postings {
+		if p.AccountCode == "" {
+			return errors.New("posting account code is empty")
+		}
+		if p.Currency == "" {
+			return errors.New("posting currency is empty")
+		}
+		if p.Amount.IsZero() {
+			return errors.New("posting amount must not be zero")
+		}
+		sums[p.Currency] = sums[p.Currency].Add(p.Amount)
+	}
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return ErrUnbalancedEntry
+		}
+	}
+	return nil
+}
+
+// ensureSystemAccounts 确保系统账户存在. 系统账户被同币种的所有存取款共用, 不维护累计余额,
+// 已存在时 DO NOTHING 不会锁定该行, 避免所有交易在这一行上排队; 系统账户的余额由对账按分录之和计算
+func (s *walletService) ensureSystemAccounts(ctx context.Context, tx *sqlx.Tx, codes, currencies []string) error {
+	_, err := tx.Exec(`
+		INSERT INTO ledger_accounts (code, account_type, currency)
+		SELECT a.code, $3, a.currency FROM unnest($1::text[], $2::text[]) AS a(code, currency)
+		ON CONFLICT (code) DO NOTHING`,
+		pq.Array(codes), pq.Array(currencies), models.SystemAccountType)
+	if err != nil {
+		s.logger.Error(ctx, "ensureSystemAccounts Failed insert into ledger_accounts", zap.Strings("accounts", codes), zap.Error(err))
+	}
+	return err
+}
+
+// postJournalEntry 在事务内写入分录, 更新账户余额, 并核对钱包、pocket 和托管的余额与账户余额一致
+func (s *walletService) postJournalEntry(ctx context.Context, tx *sqlx.Tx, entry models.JournalEntry) error {
+	if err := validateJournalEntry(entry); err != nil {
+		s.logger.Error(ctx, "postJournalEntry invalid entry", zap.Any("entry", entry), zap.Error(err))
+		return err
+	}
+
+	// created_at 不带时区, 统一写入 UTC, 与 GetBalanceAt 和余额快照按 UTC 取的时间点一致
+	now := time.Now().UTC()
+	var entryID int
+	err := tx.QueryRowx("INSERT INTO journal_entries (transaction_id, description, created_at) VALUES ($1, $2, $3) RETURNING id",
+		entry.TransactionID, entry.Description, now).Scan(&entryID)
+	if err != nil {
+		s.logger.Error(ctx, "postJournalEntry Failed insert into journal_entries", zap.Error(err))
+		return err
+	}
+
+	var walletCodes, pocketCodes, escrowCodes []string
+	for _, p := range entry.Postings {
+		if p.AccountType == models.SystemAccountType {
+			err = s.ensureSystemAccounts(ctx, tx, []string{p.AccountCode}, []string{string(p.Currency)})
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO ledger_accounts (code, account_type, user_id, currency, balance)
+				VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+				ON CONFLICT (code)
+				DO UPDATE SET balance = ledger_accounts.balance + EXCLUDED.balance`,
+				p.AccountCode, p.AccountType, p.UserID, p.Currency, p.Amount)
+		}
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntry Failed to update ledger_accounts", zap.String("account", p.AccountCode), zap.Error(err))
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO postings (journal_entry_id, account_code, amount, created_at) VALUES ($1, $2, $3, $4)",
+			entryID, p.AccountCode, p.Amount, now)
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntry Failed insert into postings", zap.String("account", p.AccountCode), zap.Error(err))
+			return err
+		}
+
+		switch p.AccountType {
+		case models.WalletAccountType:
+			walletCodes = append(walletCodes, p.AccountCode)
+		case models.PocketAccountType:
+			pocketCodes = append(pocketCodes, p.AccountCode)
+		case models.EscrowAccountType:
+			escrowCodes = append(escrowCodes, p.AccountCode)
+		}
+	}
+
+	// 钱包、pocket 和托管的余额必须与账户余额一致
+	var mismatched int
+	if len(walletCodes) > 0 {
+		err = tx.Get(&mismatched, `
+			SELECT COUNT(*) FROM ledger_accounts la
+			JOIN wallets w ON w.user_id = la.user_id AND w.currency = la.currency
+			WHERE la.code = ANY($1) AND la.balance <> w.balance`, pq.Array(walletCodes))
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntry Failed to check wallet balance against ledger", zap.Error(err))
+			return err
+		}
+		if mismatched > 0 {
+			s.logger.Error(ctx, "postJournalEntry wallet balance does not match ledger", zap.Strings("accounts", walletCodes))
+			return ErrLedgerMismatch
+		}
+	}
+	if len(pocketCodes) > 0 {
+		err = tx.Get(&mismatched, `
+			SELECT COUNT(*) FROM ledger_accounts la
+			JOIN pockets pk ON la.code = 'pocket:' || pk.id
+			WHERE la.code = ANY($1) AND la.balance <> pk.balance`, pq.Array(pocketCodes))
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntry Failed to check pocket balance against ledger", zap.Error(err))
+			return err
+		}
+		if mismatched > 0 {
+			s.logger.Error(ctx, "postJournalEntry pocket balance does not match ledger", zap.Strings("accounts", pocketCodes))
+			return ErrLedgerMismatch
+		}
+	}
+	if len(escrowCodes) > 0 {
+		err = tx.Get(&mismatched, `
+			SELECT COUNT(*) FROM ledger_accounts la
+			JOIN escrows e ON la.code = 'escrow:' || e.id
+			WHERE la.code = ANY($1) AND la.balance <> e.balance`, pq.Array(escrowCodes))
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntry Failed to check escrow balance against ledger", zap.Error(err))
+			return err
+		}
+		if mismatched > 0 {
+			s.logger.Error(ctx, "postJournalEntry escrow balance does not match ledger", zap.Strings("accounts", escrowCodes))
+			return ErrLedgerMismatch
+		}
+	}
+
+	// 钱包余额的变动以领域事件发布
+	events, err := journalEvents(entry)
+	if err != nil {
+		s.logger.Error(ctx, "postJournalEntry Failed to build events", zap.Error(err))
+		return err
+	}
+	return s.insertEvents(ctx, tx, events)
+}
+
+// postJournalEntries 批量写入分录, 每条分录必须关联交易; 账户余额按编码合并后一次更新, 最后统一核对钱包余额
+func (s *walletService) postJournalEntries(ctx context.Context, tx *sqlx.Tx, entries []models.JournalEntry) error {
+	transactionIDs := make([]int, 0, len(entries))
+	descriptions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.TransactionID == nil {
+			return errors.New("journal entry in a batch must reference a transaction")
+		}
+		if err := validateJournalEntry(entry); err != nil {
+			s.logger.Error(ctx, "postJournalEntries invalid entry", zap.Any("entry", entry), zap.Error(err))
+			return err
+		}
+		transactionIDs = append(transactionIDs, *entry.TransactionID)
+		descriptions = append(descriptions, entry.Description)
+	}
+
+	// created_at 不带时区, 统一写入 UTC
+	now := time.Now().UTC()
+	var inserted []struct {
+		ID            int `db:"id"`
+		TransactionID int `db:"transaction_id"`
+	}
+	err := tx.Select(&inserted, `
+		INSERT INTO journal_entries (transaction_id, description, created_at)
+		SELECT e.transaction_id, e.description, $3 FROM unnest($1::int[], $2::text[]) AS e(transaction_id, description)
+		RETURNING id, transaction_id`,
+		pq.Array(transactionIDs), pq.Array(descriptions), now)
+	if err != nil {
+		s.logger.Error(ctx, "postJournalEntries Failed insert into journal_entries", zap.Error(err))
+		return err
+	}
+	entryIDs := make(map[int]int, len(inserted))
+	for _, row := range inserted {
+		entryIDs[row.TransactionID] = row.ID
+	}
+
+	// 同一账户在一条语句内只能更新一次, 先按编码合并
+	accounts := make(map[string]models.Posting)
+	var postingEntryIDs []int
+	var postingCodes, postingAmounts []string
+	for _, entry := range entries {
+		for _, p := range entry.Postings {
+			postingEntryIDs = append(postingEntryIDs, entryIDs[*entry.TransactionID])
+			postingCodes = append(postingCodes, p.AccountCode)
+			postingAmounts = append(postingAmounts, p.Amount.String())
+			if account, ok := accounts[p.AccountCode]; ok {
+				p.Amount = account.Amount.Add(p.Amount)
+			}
+			accounts[p.AccountCode] = p
+		}
+	}
+
+	// 按编码排序加锁, 降低与其他事务死锁的概率
+	codes := make([]string, 0, len(accounts))
+	for code := range accounts {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	var accountCodes, accountTypes, currencies, balances []string
+	var userIDs []int
+	var walletCodes, systemCodes, systemCurrencies []string
+	for _, code := range codes {
+		account := accounts[code]
+		if account.AccountType == models.SystemAccountType {
+			systemCodes = append(systemCodes, code)
+			systemCurrencies = append(systemCurrencies, string(account.Currency))
+			continue
+		}
+		accountCodes = append(accountCodes, code)
+		accountTypes = append(accountTypes, string(account.AccountType))
+		userIDs = append(userIDs, account.UserID)
+		currencies = append(currencies, string(account.Currency))
+		balances = append(balances, account.Amount.String())
+		if account.AccountType == models.WalletAccountType {
+			walletCodes = append(walletCodes, code)
+		}
+	}
+	if len(accountCodes) > 0 {
+		_, err = tx.Exec(`
+			INSERT INTO ledger_accounts (code, account_type, user_id, currency, balance)
+			SELECT a.code, a.account_type, NULLIF(a.user_id, 0), a.currency, a.balance
+			FROM unnest($1::text[], $2::text[], $3::int[], $4::text[], $5::numeric[]) AS a(code, account_type, user_id, currency, balance)
+			ON CONFLICT (code)
+			DO UPDATE SET balance = ledger_accounts.balance + EXCLUDED.balance`,
+			pq.Array(accountCodes), pq.Array(accountTypes), pq.Array(userIDs), pq.Array(currencies), pq.Array(balances))
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntries Failed to update ledger_accounts", zap.Error(err))
+			return err
+		}
+	}
+	if len(systemCodes) > 0 {
+		if err = s.ensureSystemAccounts(ctx, tx, systemCodes, systemCurrencies); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO postings (journal_entry_id, account_code, amount, created_at)
+		SELECT p.journal_entry_id, p.account_code, p.amount, $4
+		FROM unnest($1::int[], $2::text[], $3::numeric[]) AS p(journal_entry_id, account_code, amount)`,
+		pq.Array(postingEntryIDs), pq.Array(postingCodes), pq.Array(postingAmounts), now)
+	if err != nil {
+		s.logger.Error(ctx, "postJournalEntries Failed insert into postings", zap.Error(err))
+		return err
+	}
+
+	// 钱包余额必须与账户余额一致
+	if len(walletCodes) > 0 {
+		var mismatched int
+		err = tx.Get(&mismatched, `
+			SELECT COUNT(*) FROM ledger_accounts la
+			JOIN wallets w ON w.user_id = la.user_id AND w.currency = la.currency
+			WHERE la.code = ANY($1) AND la.balance <> w.balance`, pq.Array(walletCodes))
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntries Failed to check wallet balance against ledger", zap.Error(err))
+			return err
+		}
+		if mismatched > 0 {
+			s.logger.Error(ctx, "postJournalEntries wallet balance does not match ledger", zap.Strings("accounts", walletCodes))
+			return ErrLedgerMismatch
+		}
+	}
+
+	// 钱包余额的变动以领域事件发布
+	var events []models.OutboxEvent
+	for _, entry := range entries {
+		entryEvents, err := journalEvents(entry)
+		if err != nil {
+			s.logger.Error(ctx, "postJournalEntries Failed to build events", zap.Error(err))
+			return err
+		}
+		events = append(events, entryEvents...)
+	}
+	return s.insertEvents(ctx, tx, events)
+}
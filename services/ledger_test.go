@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+// expectLedgerAccount 钱包等账户累加余额, 系统账户只确保存在
+func expectLedgerAccount(mockDB sqlmock.Sqlmock, p models.Posting) {
+	if p.AccountType == models.SystemAccountType {
+		mockDB.ExpectExec(`INSERT INTO ledger_accounts \(code, account_type, currency\)(.|\s)+ON CONFLICT \(code\) DO NOTHING`).
+			WithArgs(pq.Array([]string{p.AccountCode}), pq.Array([]string{string(p.Currency)}), models.SystemAccountType).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		return
+	}
+	mockDB.ExpectExec("INSERT INTO ledger_accounts").
+		WithArgs(p.AccountCode, p.AccountType, p.UserID, p.Currency, p.Amount).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectJournalEntry 设置写入一条分录的 mock 期望
+func expectJournalEntry(mockDB sqlmock.Sqlmock, postings ...models.Posting) {
+	mockDB.ExpectQuery("INSERT INTO journal_entries").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	hasWallet, hasPocket, hasEscrow := false, false, false
+	for _, p := range postings {
+		expectLedgerAccount(mockDB, p)
+		mockDB.ExpectExec("INSERT INTO postings").
+			WithArgs(1, p.AccountCode, p.Amount, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		switch p.AccountType {
+		case models.WalletAccountType:
+			hasWallet = true
+		case models.PocketAccountType:
+			hasPocket = true
+		case models.EscrowAccountType:
+			hasEscrow = true
+		}
+	}
+	if hasWallet {
+		mockDB.ExpectQuery("SELECT COUNT").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	if hasPocket {
+		mockDB.ExpectQuery(`SELECT COUNT\(\*\) FROM ledger_accounts la\s+JOIN pockets`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	if hasEscrow {
+		mockDB.ExpectQuery(`SELECT COUNT\(\*\) FROM ledger_accounts la\s+JOIN escrows`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	if hasWallet {
+		expectOutboxEvents(mockDB)
+	}
+}
+
+func TestValidateJournalEntry(t *testing.T) {
+	amount := decimal.NewFromFloat(50.0)
+
+	err := validateJournalEntry(models.JournalEntry{Postings: []models.Posting{
+		systemPosting(ExternalCashInAccount, models.USD, amount.Neg()),
+		walletPosting(1, models.USD, amount),
+	}})
+	assert.NoError(t, err)
+
+	err = validateJournalEntry(models.JournalEntry{Postings: []models.Posting{
+		walletPosting(1, models.USD, amount.Neg()),
+		walletPosting(2, models.USD, decimal.NewFromFloat(49.99)),
+	}})
+	assert.ErrorIs(t, err, ErrUnbalancedEntry)
+
+	err = validateJournalEntry(models.JournalEntry{Postings: []models.Posting{
+		walletPosting(1, models.USD, amount),
+	}})
+	assert.Error(t, err)
+
+	err = validateJournalEntry(models.JournalEntry{Postings: []models.Posting{
+		walletPosting(1, models.USD, decimal.Zero),
+		walletPosting(2, models.USD, decimal.Zero),
+	}})
+	assert.EqualError(t, err, "posting amount must not be zero")
+
+	// 不同币种各自合计为零, 不能互相抵消
+	err = validateJournalEntry(models.JournalEntry{Postings: []models.Posting{
+		walletPosting(1, models.USD, amount.Neg()),
+		walletPosting(2, models.EUR, amount),
+	}})
+	assert.ErrorIs(t, err, ErrUnbalancedEntry)
+}
+
+func TestWalletService_PostJournalEntry_Unbalanced(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}
+
+	// 分录不平衡时不应访问数据库
+	err = service.postJournalEntry(context.Background(), nil, models.JournalEntry{Postings: []models.Posting{
+		walletPosting(1, models.USD, decimal.NewFromFloat(-10)),
+		walletPosting(2, models.USD, decimal.NewFromFloat(20)),
+	}})
+	assert.ErrorIs(t, err, ErrUnbalancedEntry)
+}
+
+func TestWalletService_PostJournalEntry_LedgerMismatch(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	amount := decimal.NewFromFloat(10)
+	postings := []models.Posting{
+		systemPosting(ExternalCashInAccount, models.USD, amount.Neg()),
+		walletPosting(1, models.USD, amount),
+	}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO journal_entries").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	for range postings {
+		mockDB.ExpectExec("INSERT INTO ledger_accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+		mockDB.ExpectExec("INSERT INTO postings").WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mockDB.ExpectQuery("SELECT COUNT").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+
+	err = service.postJournalEntry(context.Background(), tx, models.JournalEntry{Postings: postings})
+	assert.ErrorIs(t, err, ErrLedgerMismatch)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// utcTime 匹配 UTC 时区的时间参数
+type utcTime struct{}
+
+func (utcTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Location() == time.UTC
+}
+
+func TestWalletService_PostJournalEntry_UTC(t *testing.T) {
+	// 本地时区不是 UTC 时, 分录和明细的时间仍按 UTC 写入
+	local := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*60*60)
+	defer func() { time.Local = local }()
+
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	amount := decimal.NewFromFloat(10)
+	postings := []models.Posting{
+		systemPosting(ExternalCashInAccount, models.USD, amount.Neg()),
+		systemPosting(ExternalCashOutAccount, models.USD, amount),
+	}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(nil, "", utcTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	for _, p := range postings {
+		mockDB.ExpectExec("INSERT INTO ledger_accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+		mockDB.ExpectExec("INSERT INTO postings").
+			WithArgs(1, p.AccountCode, p.Amount, utcTime{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	// 批量写入同样按 UTC
+	transactionID := 7
+	mockDB.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), utcTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id"}).AddRow(2, transactionID))
+	mockDB.ExpectExec("INSERT INTO ledger_accounts").WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec("INSERT INTO postings").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), utcTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.postJournalEntry(context.Background(), tx, models.JournalEntry{Postings: postings}))
+	assert.NoError(t, service.postJournalEntries(context.Background(), tx, []models.JournalEntry{{TransactionID: &transactionID, Postings: postings}}))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+)
+
+const (
+	limitPeriodDay   = "day"
+	limitPeriodWeek  = "week"
+	limitPeriodMonth = "month"
+)
+
+var (
+	ErrLimitExceeded = errors.New("spending limit exceeded")
+	ErrInvalidLimits = errors.New("invalid spending limits")
+)
+
+// LimitExceededError 超出的限额项及用量重置时间, 单笔和余额上限不随时间重置
+type LimitExceededError struct {
+	Limit    models.LimitName `json:"limit"`
+	Max      string           `json:"max"`
+	ResetsAt *time.Time       `json:"resets_at,omitempty"`
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.ResetsAt != nil {
+		return fmt.Sprintf("%s: %s %s, resets at %s", ErrLimitExceeded, e.Limit, e.Max, e.ResetsAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s: %s %s", ErrLimitExceeded, e.Limit, e.Max)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// limitPeriods 当前自然日/周/月(UTC, 周一为一周的开始)的起始时间
+func limitPeriods(now time.Time) (day, week, month time.Time) {
+	now = now.UTC()
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	week = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return day, week, month
+}
+
+// limitResets 各周期用量的重置时间
+func limitResets(now time.Time) models.LimitResets {
+	day, week, month := limitPeriods(now)
+	return models.LimitResets{Daily: day.AddDate(0, 0, 1), Weekly: week.AddDate(0, 0, 7), Monthly: month.AddDate(0, 1, 0)}
+}
+
+func parseLimitAmount(value string) *decimal.Decimal {
+	if value == "" {
+		return nil
+	}
+	amount, err := decimal.NewFromString(value)
+	if err != nil || !amount.IsPositive() {
+		return nil
+	}
+	return &amount
+}
+
+// tierLimits 配置文件中等级在该币种下的限额, tier 为空时使用默认等级
+func tierLimits(tier string, currency models.Currency) models.SpendingLimits {
+	cfg := config.GetConfig().Limits
+	limits := models.SpendingLimits{Tier: tier}
+	if tier == "" {
+		tier = cfg.DefaultTier
+	}
+	// viper 读取的 map 键为小写
+	t, ok := cfg.Tiers[strings.ToLower(tier)][strings.ToLower(string(currency))]
+	if !ok {
+		return limits
+	}
+	limits.MaxSingleAmount = parseLimitAmount(t.MaxSingleAmount)
+	limits.DailyOutflow = parseLimitAmount(t.DailyOutflow)
+	limits.WeeklyOutflow = parseLimitAmount(t.WeeklyOutflow)
+	limits.MonthlyOutflow = parseLimitAmount(t.MonthlyOutflow)
+	limits.MaxBalance = parseLimitAmount(t.MaxBalance)
+	if t.MaxDailyTransfers > 0 {
+		transfers := t.MaxDailyTransfers
+		limits.MaxDailyTransfers = &transfers
+	}
+	return limits
+}
+
+// walletLimits 查询同一币种下多个钱包生效的限额: 钱包单独设置的项优先, 其余使用所属等级的配置
+func (s *walletService) walletLimits(ctx context.Context, q sqlx.Queryer, currency models.Currency, userIDs ...int) (map[int]models.SpendingLimits, error) {
+	var rows []struct {
+		UserID int `db:"user_id"`
+		models.SpendingLimits
+	}
+	err := sqlx.Select(q, &rows, `
+		SELECT user_id, tier, max_single_amount, daily_outflow, weekly_outflow, monthly_outflow, max_balance, max_daily_transfers
+		FROM wallet_limits WHERE currency = $1 AND user_id = ANY($2)`, currency, pq.Array(userIDs))
+	if err != nil {
+		s.logger.Error(ctx, "walletLimits Failed select from wallet_limits", zap.Ints("userIDs", userIDs), zap.Error(err))
+		return nil, err
+	}
+
+	limits := make(map[int]models.SpendingLimits, len(userIDs))
+	for _, userID := range userIDs {
+		limits[userID] = tierLimits("", currency)
+	}
+	for _, row := range rows {
+		limits[row.UserID] = tierLimits(row.Tier, currency).Override(row.SpendingLimits)
+	}
+	return limits, nil
+}
+
+// checkOutflowLimits 校验单笔金额, 以及计入本笔后的周期累计用量 usage
+func checkOutflowLimits(limits models.SpendingLimits, usage models.LimitUsage, amount decimal.Decimal, now time.Time) error {
+	resets := limitResets(now)
+	exceeded := func(limit models.LimitName, max string, resetsAt *time.Time) error {
+		return &LimitExceededError{Limit: limit, Max: max, ResetsAt: resetsAt}
+	}
+	switch {
+	case limits.MaxSingleAmount != nil && amount.GreaterThan(*limits.MaxSingleAmount):
+		return exceeded(models.LimitMaxSingleAmount, limits.MaxSingleAmount.String(), nil)
+	case limits.MaxDailyTransfers != nil && usage.DailyTransfers > *limits.MaxDailyTransfers:
+		return exceeded(models.LimitMaxDailyTransfers, strconv.Itoa(*limits.MaxDailyTransfers), &resets.Daily)
+	case limits.DailyOutflow != nil && usage.DailyOutflow.GreaterThan(*limits.DailyOutflow):
+		return exceeded(models.LimitDailyOutflow, limits.DailyOutflow.String(), &resets.Daily)
+	case limits.WeeklyOutflow != nil && usage.WeeklyOutflow.GreaterThan(*limits.WeeklyOutflow):
+		return exceeded(models.LimitWeeklyOutflow, limits.WeeklyOutflow.String(), &resets.Weekly)
+	case limits.MonthlyOutflow != nil && usage.MonthlyOutflow.GreaterThan(*limits.MonthlyOutflow):
+		return exceeded(models.LimitMonthlyOutflow, limits.MonthlyOutflow.String(), &resets.Monthly)
+	default:
+		return nil
+	}
+}
+
+// checkBalanceLimit 校验入账后的余额是否超过上限
+func checkBalanceLimit(limits models.SpendingLimits, balance decimal.Decimal) error {
+	if limits.MaxBalance != nil && balance.GreaterThan(*limits.MaxBalance) {
+		return &LimitExceededError{Limit: models.LimitMaxBalance, Max: limits.MaxBalance.String()}
+	}
+	return nil
+}
+
+// chargeOutflow 在扣款的同一事务内校验共享钱包的审批阈值, 累计付款方的用量并校验限额, 超限时返回错误由调用方回滚整个事务, 用量随之撤销.
+// 调用前钱包行已被扣款语句锁定, 同一钱包的并发付款在此串行
+func (s *walletService) chargeOutflow(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency, amount decimal.Decimal, transfers int) error {
+	if err := s.requireApproval(ctx, tx, userID, currency, amount); err != nil {
+		return err
+	}
+	return s.recordOutflow(ctx, tx, userID, currency, amount, transfers)
+}
+
+// recordOutflow 累计付款方的用量并校验限额; 手续费随交易一起收取, 只计入用量不再单独校验审批阈值
+func (s *walletService) recordOutflow(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency, amount decimal.Decimal, transfers int) error {
+	limits, err := s.walletLimits(ctx, tx, currency, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !limits[userID].HasPeriodLimits() {
+		return checkOutflowLimits(limits[userID], models.LimitUsage{}, amount, now)
+	}
+	usage, err := s.recordLimitUsage(ctx, tx, userID, currency, amount, transfers, now)
+	if err != nil {
+		return err
+	}
+	return checkOutflowLimits(limits[userID], usage, amount, now)
+}
+
+// checkCreditLimit 入账后校验收款方的余额上限
+func (s *walletService) checkCreditLimit(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency) error {
+	limits, err := s.walletLimits(ctx, tx, currency, userID)
+	if err != nil {
+		return err
+	}
+	if limits[userID].MaxBalance == nil {
+		return nil
+	}
+	var balance decimal.Decimal
+	if err = tx.Get(&balance, "SELECT balance FROM wallets WHERE user_id = $1 AND currency = $2", userID, currency); err != nil {
+		s.logger.Error(ctx, "checkCreditLimit Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	return checkBalanceLimit(limits[userID], balance)
+}
+
+// recordLimitUsage 累加当前日/周/月的用量并返回累加后的结果
+func (s *walletService) recordLimitUsage(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency,
+	amount decimal.Decimal, transfers int, now time.Time) (models.LimitUsage, error) {
+	day, week, month := limitPeriods(now)
+	rows, err := tx.Queryx(`
+		INSERT INTO wallet_limit_usage (user_id, currency, period, period_start, outflow, transfers)
+		VALUES ($1, $2, 'day', $3, $6, $7), ($1, $2, 'week', $4, $6, $7), ($1, $2, 'month', $5, $6, $7)
+		ON CONFLICT (user_id, currency, period, period_start)
+		DO UPDATE SET outflow = wallet_limit_usage.outflow + EXCLUDED.outflow, transfers = wallet_limit_usage.transfers + EXCLUDED.transfers
+		RETURNING period, outflow, transfers`,
+		userID, currency, day, week, month, amount, transfers)
+	if err != nil {
+		s.logger.Error(ctx, "recordLimitUsage Failed to upsert wallet_limit_usage", zap.Int("userID", userID), zap.Error(err))
+		return models.LimitUsage{}, err
+	}
+	return scanLimitUsage(rows)
+}
+
+// limitUsage 查询当前日/周/月的用量
+func (s *walletService) limitUsage(ctx context.Context, q sqlx.Queryer, userID int, currency models.Currency, now time.Time) (models.LimitUsage, error) {
+	day, week, month := limitPeriods(now)
+	rows, err := q.Queryx(`
+		SELECT period, outflow, transfers FROM wallet_limit_usage
+		WHERE user_id = $1 AND currency = $2 AND (period, period_start) IN (('day', $3::timestamp), ('week', $4::timestamp), ('month', $5::timestamp))`,
+		userID, currency, day, week, month)
+	if err != nil {
+		s.logger.Error(ctx, "limitUsage Failed select from wallet_limit_usage", zap.Int("userID", userID), zap.Error(err))
+		return models.LimitUsage{}, err
+	}
+	return scanLimitUsage(rows)
+}
+
+func scanLimitUsage(rows *sqlx.Rows) (models.LimitUsage, error) {
+	defer rows.Close()
+	var usage models.LimitUsage
+	for rows.Next() {
+		var (
+			period    string
+			outflow   decimal.Decimal
+			transfers int
+		)
+		if err := rows.Scan(&period, &outflow, &transfers); err != nil {
+			return models.LimitUsage{}, err
+		}
+		switch period {
+		case limitPeriodDay:
+			usage.DailyOutflow, usage.DailyTransfers = outflow, transfers
+		case limitPeriodWeek:
+			usage.WeeklyOutflow = outflow
+		case limitPeriodMonth:
+			usage.MonthlyOutflow = outflow
+		}
+	}
+	return usage, rows.Err()
+}
+
+// GetWalletLimits 查询钱包生效的限额和当前用量
+func (s *walletService) GetWalletLimits(ctx context.Context, userID int, currency models.Currency) (*models.WalletLimits, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	var exists bool
+	err := s.db.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM wallets WHERE user_id = $1 AND currency = $2)", userID, currency)
+	if err != nil {
+		s.logger.Error(ctx, "GetWalletLimits Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWalletNotFound
+	}
+
+	limits, err := s.walletLimits(ctx, s.db, currency, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	usage, err := s.limitUsage(ctx, s.db, userID, currency, now)
+	if err != nil {
+		return nil, err
+	}
+	return &models.WalletLimits{UserID: userID, Currency: currency, Limits: limits[userID], Usage: usage, ResetsAt: limitResets(now)}, nil
+}
+
+// SetWalletLimits 设置钱包的限额等级和单独的限额项(整体替换), 未设置的项使用等级的配置
+func (s *walletService) SetWalletLimits(ctx context.Context, userID int, currency models.Currency, limits models.SpendingLimits) (*models.WalletLimits, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	if err := validateSpendingLimits(limits); err != nil {
+		return nil, err
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO wallet_limits (user_id, currency, tier, max_single_amount, daily_outflow, weekly_outflow, monthly_outflow, max_balance, max_daily_transfers)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (user_id, currency) DO UPDATE SET
+			tier = EXCLUDED.tier, max_single_amount = EXCLUDED.max_single_amount, daily_outflow = EXCLUDED.daily_outflow,
+			weekly_outflow = EXCLUDED.weekly_outflow, monthly_outflow = EXCLUDED.monthly_outflow,
+			max_balance = EXCLUDED.max_balance, max_daily_transfers = EXCLUDED.max_daily_transfers`,
+		userID, currency, limits.Tier, limits.MaxSingleAmount, limits.DailyOutflow, limits.WeeklyOutflow, limits.MonthlyOutflow,
+		limits.MaxBalance, limits.MaxDailyTransfers)
+	if isForeignKeyViolation(err) {
+		return nil, ErrWalletNotFound
+	}
+	if err != nil {
+		s.logger.Error(ctx, "SetWalletLimits Failed upsert wallet_limits", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return s.GetWalletLimits(ctx, userID, currency)
+}
+
+// validateSpendingLimits 金额和笔数必须为正, 等级必须在配置中存在
+func validateSpendingLimits(limits models.SpendingLimits) error {
+	for name, amount := range map[models.LimitName]*decimal.Decimal{
+		models.LimitMaxSingleAmount: limits.MaxSingleAmount,
+		models.LimitDailyOutflow:    limits.DailyOutflow,
+		models.LimitWeeklyOutflow:   limits.WeeklyOutflow,
+		models.LimitMonthlyOutflow:  limits.MonthlyOutflow,
+		models.LimitMaxBalance:      limits.MaxBalance,
+	} {
+		if amount != nil && !amount.IsPositive() {
+			return fmt.Errorf("%w: %s must be greater than zero", ErrInvalidLimits, name)
+		}
+	}
+	if limits.MaxDailyTransfers != nil && *limits.MaxDailyTransfers <= 0 {
+		return fmt.Errorf("%w: %s must be greater than zero", ErrInvalidLimits, models.LimitMaxDailyTransfers)
+	}
+	if limits.Tier != "" {
+		if _, ok := config.GetConfig().Limits.Tiers[strings.ToLower(limits.Tier)]; !ok {
+			return fmt.Errorf("%w: unknown tier %q", ErrInvalidLimits, limits.Tier)
+		}
+	}
+	return nil
+}
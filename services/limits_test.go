@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var walletLimitColumns = []string{"user_id", "tier", "max_single_amount", "daily_outflow", "weekly_outflow", "monthly_outflow", "max_balance", "max_daily_transfers"}
+
+// expectWalletLimits 期望查询钱包限额, 没有单独设置限额(测试中也没有配置等级)
+func expectWalletLimits(mockDB sqlmock.Sqlmock, currency models.Currency, userIDs ...int) {
+	mockDB.ExpectQuery("FROM wallet_limits").
+		WithArgs(currency, pq.Array(userIDs)).
+		WillReturnRows(sqlmock.NewRows(walletLimitColumns))
+}
+
+func TestLimitPeriods(t *testing.T) {
+	// 2024-05-15 为周三, 一周从周一开始
+	day, week, month := limitPeriods(time.Date(2024, 5, 15, 18, 30, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC), day)
+	assert.Equal(t, time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), week)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), month)
+
+	// 周日仍属于上周一开始的一周
+	_, week, _ = limitPeriods(time.Date(2024, 5, 19, 23, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), week)
+
+	resets := limitResets(time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), resets.Daily)
+	assert.Equal(t, time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC), resets.Weekly)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), resets.Monthly)
+}
+
+func TestCheckOutflowLimits(t *testing.T) {
+	single, daily, transfers := decimal.NewFromInt(100), decimal.NewFromInt(300), 2
+	limits := models.SpendingLimits{MaxSingleAmount: &single, DailyOutflow: &daily, MaxDailyTransfers: &transfers}
+	now := time.Date(2024, 5, 15, 18, 30, 0, 0, time.UTC)
+
+	assert.NoError(t, checkOutflowLimits(limits, models.LimitUsage{}.Add(decimal.NewFromInt(100), 1), decimal.NewFromInt(100), now))
+
+	var limitErr *LimitExceededError
+	err := checkOutflowLimits(limits, models.LimitUsage{}.Add(decimal.NewFromInt(150), 1), decimal.NewFromInt(150), now)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, models.LimitMaxSingleAmount, limitErr.Limit)
+	assert.Nil(t, limitErr.ResetsAt)
+
+	err = checkOutflowLimits(limits, models.LimitUsage{DailyOutflow: decimal.NewFromInt(50), DailyTransfers: 3}, decimal.NewFromInt(50), now)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, models.LimitMaxDailyTransfers, limitErr.Limit)
+
+	err = checkOutflowLimits(limits, models.LimitUsage{DailyOutflow: decimal.NewFromInt(350)}, decimal.NewFromInt(50), now)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, models.LimitDailyOutflow, limitErr.Limit)
+	assert.Equal(t, "300", limitErr.Max)
+	assert.Equal(t, time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC), *limitErr.ResetsAt)
+}
+
+func TestWalletService_Withdraw_DailyLimitExceeded(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	userID := 1
+	amount := decimal.NewFromInt(80)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, userID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("920"))
+	// 钱包单独设置了每日 200 的付款限额
+	expectApprovalThreshold(mockDB, userID, models.USD, "")
+	mockDB.ExpectQuery("FROM wallet_limits").
+		WithArgs(models.USD, pq.Array([]int{userID})).
+		WillReturnRows(sqlmock.NewRows(walletLimitColumns).AddRow(userID, "", nil, "200", nil, nil, nil, nil))
+	// 累加后当日用量为 230, 超出限额, 整个事务回滚
+	mockDB.ExpectQuery("INSERT INTO wallet_limit_usage").
+		WithArgs(userID, models.USD, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), amount, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"period", "outflow", "transfers"}).
+			AddRow("day", "230", 0).AddRow("week", "230", 0).AddRow("month", "500", 0))
+	mockDB.ExpectRollback()
+
+	_, err = service.Withdraw(context.Background(), userID, userID, amount, models.USD, models.WithdrawTransactionType, models.TransactionDetails{})
+
+	var limitErr *LimitExceededError
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, models.LimitDailyOutflow, limitErr.Limit)
+	assert.NotNil(t, limitErr.ResetsAt)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Deposit_MaxBalanceExceeded(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	userID := 1
+	amount := decimal.NewFromInt(100)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, userID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("FROM wallet_limits").
+		WithArgs(models.USD, pq.Array([]int{userID})).
+		WillReturnRows(sqlmock.NewRows(walletLimitColumns).AddRow(userID, "", nil, nil, nil, nil, "1000", nil))
+	mockDB.ExpectQuery("SELECT balance FROM wallets").
+		WithArgs(userID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("1050"))
+	mockDB.ExpectRollback()
+
+	_, err = service.Deposit(context.Background(), userID, userID, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{})
+
+	var limitErr *LimitExceededError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, models.LimitMaxBalance, limitErr.Limit)
+	assert.Nil(t, limitErr.ResetsAt)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_SetWalletLimits(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), nil)
+
+	userID := 1
+	daily := decimal.NewFromInt(500)
+
+	// 金额必须为正
+	negative := decimal.NewFromInt(-1)
+	_, err = service.SetWalletLimits(context.Background(), userID, models.USD, models.SpendingLimits{MaxBalance: &negative})
+	assert.ErrorIs(t, err, ErrInvalidLimits)
+
+	mockDB.ExpectExec("INSERT INTO wallet_limits").
+		WithArgs(userID, models.USD, "", nil, &daily, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT EXISTS`).WithArgs(userID, models.USD).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mockDB.ExpectQuery("FROM wallet_limits").
+		WithArgs(models.USD, pq.Array([]int{userID})).
+		WillReturnRows(sqlmock.NewRows(walletLimitColumns).AddRow(userID, "", nil, "500", nil, nil, nil, nil))
+	mockDB.ExpectQuery("FROM wallet_limit_usage").
+		WithArgs(userID, models.USD, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"period", "outflow", "transfers"}).AddRow("day", "120", 2))
+
+	limits, err := service.SetWalletLimits(context.Background(), userID, models.USD, models.SpendingLimits{DailyOutflow: &daily})
+
+	assert.NoError(t, err)
+	assert.True(t, daily.Equal(*limits.Limits.DailyOutflow))
+	assert.True(t, decimal.NewFromInt(120).Equal(limits.Usage.DailyOutflow))
+	assert.Equal(t, 2, limits.Usage.DailyTransfers)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
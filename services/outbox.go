@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const (
+	outboxBatchSize        = 100
+	outboxMinRetryDelay    = time.Second
+	outboxMaxRetryDelay    = 5 * time.Minute
+	defaultOutboxRetention = 72 * time.Hour
+	// outboxRelayLockKey 发布事件时持有的 advisory lock, 多实例时只有一个实例发布
+	outboxRelayLockKey int64 = 0x6f7574626f78
+)
+
+func outboxRetention() time.Duration {
+	hours := config.GetConfig().Outbox.RetentionHours
+	if hours <= 0 {
+		return defaultOutboxRetention
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// outboxRetryDelay 第 attempts 次发布失败后的重试间隔, 从 1 秒开始翻倍, 最长 5 分钟
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := outboxMinRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxRetryDelay {
+		delay = outboxMaxRetryDelay
+	}
+	return delay
+}
+
+func newOutboxEvent(aggregateID string, eventType models.EventType, payload interface{}) (models.OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.OutboxEvent{}, err
+	}
+	return models.OutboxEvent{AggregateID: aggregateID, EventType: eventType, Payload: data}, nil
+}
+
+// journalEvents 分录对应的领域事件: 每个钱包行一个入账或出账事件;
+// 转账的分录即付款方和收款方各一行, 另外产生一个 TransferCompleted, 归属付款方钱包
+func journalEvents(entry models.JournalEntry) ([]models.OutboxEvent, error) {
+	transactionType := models.TransactionType(entry.Description)
+	var events []models.OutboxEvent
+	var sender, receiver *models.Posting
+	for i, p := range entry.Postings {
+		if p.AccountType != models.WalletAccountType {
+			continue
+		}
+		eventType := models.EventWalletCredited
+		if p.Amount.IsNegative() {
+			eventType = models.EventWalletDebited
+			sender = &entry.Postings[i]
+		} else {
+			receiver = &entry.Postings[i]
+		}
+		event, err := newOutboxEvent(p.AccountCode, eventType, models.WalletEvent{
+			TransactionID:   entry.TransactionID,
+			TransactionType: transactionType,
+			UserID:          p.UserID,
+			Currency:        p.Currency,
+			Amount:          p.Amount.Abs(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if transactionType == models.TransferTransactionType && sender != nil && receiver != nil {
+		event, err := newOutboxEvent(sender.AccountCode, models.EventTransferCompleted, models.TransferEvent{
+			TransactionID:  entry.TransactionID,
+			SenderUserID:   sender.UserID,
+			ReceiverUserID: receiver.UserID,
+			Currency:       receiver.Currency,
+			Amount:         receiver.Amount,
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// insertEvents 在余额变动的事务内写入领域事件, 事务提交后由 OutboxRelay 发布
+func (s *walletService) insertEvents(ctx context.Context, tx *sqlx.Tx, events []models.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	aggregateIDs := make([]string, len(events))
+	eventTypes := make([]string, len(events))
+	payloads := make([]string, len(events))
+	for i, event := range events {
+		aggregateIDs[i], eventTypes[i], payloads[i] = event.AggregateID, string(event.EventType), string(event.Payload)
+	}
+	// 按顺序分配 id, 同一钱包的事件按写入顺序发布
+	_, err := tx.Exec(`
+		INSERT INTO outbox_events (aggregate_id, event_type, payload, created_at, next_attempt_at)
+		SELECT e.aggregate_id, e.event_type, e.payload, $4, $4
+		FROM unnest($1::text[], $2::text[], $3::jsonb[]) WITH ORDINALITY AS e(aggregate_id, event_type, payload, n)
+		ORDER BY e.n`,
+		pq.Array(aggregateIDs), pq.Array(eventTypes), pq.Array(payloads), time.Now())
+	if err != nil {
+		s.logger.Error(ctx, "insertEvents Failed insert into outbox_events", zap.Int("events", len(events)), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// OutboxRelay 把已提交的领域事件发布到 EventSink
+type OutboxRelay struct {
+	service *walletService
+	sink    EventSink
+}
+
+// NewOutboxRelay new outbox relay
+func NewOutboxRelay(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client, sink EventSink) *OutboxRelay {
+	return &OutboxRelay{
+		service: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+		sink: sink,
+	}
+}
+
+// Run 按 id 顺序发布未发布的事件, 直到没有可发布的事件
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	for {
+		more, err := r.relayBatch(ctx)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// relayBatch 发布一批事件, 发布成功后才标记为已发布, 因此事件至少发布一次;
+// 发布失败的事件按退避间隔重试, 重试之前同一钱包后面的事件不发布, 保证同一钱包的事件有序
+func (r *OutboxRelay) relayBatch(ctx context.Context) (bool, error) {
+	s := r.service
+	more := false
+	err := s.runInTx(ctx, "OutboxRelay", func(tx *sqlx.Tx) error {
+		more = false
+		var locked bool
+		if err := tx.Get(&locked, "SELECT pg_try_advisory_xact_lock($1)", outboxRelayLockKey); err != nil {
+			s.logger.Error(ctx, "OutboxRelay Failed to acquire lock", zap.Error(err))
+			return err
+		}
+		if !locked {
+			// 其他实例正在发布
+			return nil
+		}
+
+		// 等待重试的事件及同一钱包在它之后的事件都跳过
+		now := time.Now()
+		var events []models.OutboxEvent
+		err := tx.Select(&events, `
+			SELECT e.id, e.aggregate_id, e.event_type, e.payload, e.created_at, e.attempts
+			FROM outbox_events e
+			WHERE e.published_at IS NULL
+			  AND NOT EXISTS (
+			      SELECT 1 FROM outbox_events w
+			      WHERE w.aggregate_id = e.aggregate_id AND w.published_at IS NULL AND w.id <= e.id AND w.next_attempt_at > $1)
+			ORDER BY e.id LIMIT $2`, now, outboxBatchSize)
+		if err != nil {
+			s.logger.Error(ctx, "OutboxRelay Failed select from outbox_events", zap.Error(err))
+			return err
+		}
+
+		blocked := make(map[string]bool)
+		var published []int64
+		for _, event := range events {
+			if blocked[event.AggregateID] {
+				continue
+			}
+			if publishErr := r.sink.Publish(ctx, event); publishErr != nil {
+				blocked[event.AggregateID] = true
+				s.logger.Warn(ctx, "OutboxRelay Failed to publish event", zap.Int64("eventID", event.ID),
+					zap.Int("attempts", event.Attempts+1), zap.Error(publishErr))
+				_, err = tx.Exec("UPDATE outbox_events SET attempts = attempts + 1, next_attempt_at = $1, last_error = $2 WHERE id = $3",
+					now.Add(outboxRetryDelay(event.Attempts+1)), publishErr.Error(), event.ID)
+				if err != nil {
+					s.logger.Error(ctx, "OutboxRelay Failed to update outbox_events", zap.Int64("eventID", event.ID), zap.Error(err))
+					return err
+				}
+				continue
+			}
+			published = append(published, event.ID)
+		}
+
+		if len(published) > 0 {
+			_, err = tx.Exec("UPDATE outbox_events SET published_at = $1 WHERE id = ANY($2)", now, pq.Array(published))
+			if err != nil {
+				s.logger.Error(ctx, "OutboxRelay Failed to mark events published", zap.Int("events", len(published)), zap.Error(err))
+				return err
+			}
+		}
+		more = len(events) == outboxBatchSize && len(published) > 0
+		return nil
+	})
+	return more, err
+}
+
+// PurgePublished 删除发布超过保留时长的事件
+func (r *OutboxRelay) PurgePublished(ctx context.Context) error {
+	s := r.service
+	res, err := s.db.ExecContext(ctx, "DELETE FROM outbox_events WHERE published_at < $1", time.Now().Add(-outboxRetention()))
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected > 0 {
+		s.logger.Info(ctx, "OutboxRelay purged published events", zap.Int64("count", rowsAffected))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+// expectOutboxEvents 余额变动的事务内写入领域事件
+func expectOutboxEvents(mockDB sqlmock.Sqlmock) {
+	mockDB.ExpectExec("INSERT INTO outbox_events").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// fakeEventSink 记录发布的事件, 对 failIDs 中的事件返回错误
+type fakeEventSink struct {
+	published []int64
+	failIDs   map[int64]bool
+}
+
+func (f *fakeEventSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	if f.failIDs[event.ID] {
+		return fmt.Errorf("sink unavailable")
+	}
+	f.published = append(f.published, event.ID)
+	return nil
+}
+
+var outboxEventColumns = []string{"id", "aggregate_id", "event_type", "payload", "created_at", "attempts"}
+
+func newTestOutboxRelay(t *testing.T, sink EventSink) (*OutboxRelay, sqlmock.Sqlmock) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return NewOutboxRelay(logger.NewLogger(), sqlx.NewDb(db, "postgres"), nil, sink), mockDB
+}
+
+func TestJournalEvents_Transfer(t *testing.T) {
+	transactionID := 7
+	amount := decimal.RequireFromString("12.5")
+	events, err := journalEvents(models.JournalEntry{
+		TransactionID: &transactionID,
+		Description:   string(models.TransferTransactionType),
+		Postings:      []models.Posting{walletPosting(1, models.USD, amount.Neg()), walletPosting(2, models.USD, amount)},
+	})
+
+	assert.NoError(t, err)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, models.EventWalletDebited, events[0].EventType)
+		assert.Equal(t, WalletAccountCode(1, models.USD), events[0].AggregateID)
+		assert.JSONEq(t, `{"transaction_id":7,"transaction_type":"transfer","user_id":1,"currency":"USD","amount":"12.5"}`, string(events[0].Payload))
+		assert.Equal(t, models.EventWalletCredited, events[1].EventType)
+		assert.Equal(t, WalletAccountCode(2, models.USD), events[1].AggregateID)
+		// 转账完成事件归属付款方钱包, 与付款方的出账事件有序
+		assert.Equal(t, models.EventTransferCompleted, events[2].EventType)
+		assert.Equal(t, WalletAccountCode(1, models.USD), events[2].AggregateID)
+		assert.JSONEq(t, `{"transaction_id":7,"sender_user_id":1,"receiver_user_id":2,"currency":"USD","amount":"12.5"}`, string(events[2].Payload))
+	}
+}
+
+func TestJournalEvents_SystemAccountsOnly(t *testing.T) {
+	amount := decimal.NewFromInt(5)
+	events, err := journalEvents(models.JournalEntry{
+		Description: string(models.DepositTransactionType),
+		Postings: []models.Posting{
+			systemPosting(ExternalCashInAccount, models.USD, amount.Neg()),
+			walletPosting(3, models.USD, amount),
+		},
+	})
+
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, models.EventWalletCredited, events[0].EventType)
+		var payload models.WalletEvent
+		assert.NoError(t, json.Unmarshal(events[0].Payload, &payload))
+		assert.Nil(t, payload.TransactionID)
+		assert.Equal(t, models.DepositTransactionType, payload.TransactionType)
+	}
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, outboxRetryDelay(1))
+	assert.Equal(t, 2*time.Second, outboxRetryDelay(2))
+	assert.Equal(t, 8*time.Second, outboxRetryDelay(4))
+	assert.Equal(t, outboxMaxRetryDelay, outboxRetryDelay(20))
+}
+
+func TestOutboxRelay_Run_OrderedPerWallet(t *testing.T) {
+	sink := &fakeEventSink{failIDs: map[int64]bool{2: true}}
+	relay, mockDB := newTestOutboxRelay(t, sink)
+	now := time.Now()
+	walletA, walletB := WalletAccountCode(1, models.USD), WalletAccountCode(2, models.USD)
+
+	// 事件 2 发布失败后, 同一钱包的事件 4 留到事件 2 重试成功之后; 其他钱包不受影响
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock\(\$1\)`).
+		WithArgs(outboxRelayLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mockDB.ExpectQuery("FROM outbox_events e").
+		WithArgs(sqlmock.AnyArg(), outboxBatchSize).
+		WillReturnRows(sqlmock.NewRows(outboxEventColumns).
+			AddRow(1, walletA, "WalletDebited", []byte(`{}`), now, 0).
+			AddRow(2, walletB, "WalletCredited", []byte(`{}`), now, 2).
+			AddRow(3, walletA, "TransferCompleted", []byte(`{}`), now, 0).
+			AddRow(4, walletB, "WalletDebited", []byte(`{}`), now, 0))
+	mockDB.ExpectExec("UPDATE outbox_events SET attempts = attempts \\+ 1").
+		WithArgs(sqlmock.AnyArg(), "sink unavailable", int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec("UPDATE outbox_events SET published_at").
+		WithArgs(sqlmock.AnyArg(), pq.Array([]int64{1, 3})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectCommit()
+
+	err := relay.Run(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 3}, sink.published)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestOutboxRelay_Run_LockedByOtherInstance(t *testing.T) {
+	sink := &fakeEventSink{}
+	relay, mockDB := newTestOutboxRelay(t, sink)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock\(\$1\)`).
+		WithArgs(outboxRelayLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mockDB.ExpectCommit()
+
+	err := relay.Run(context.Background())
+
+	assert.NoError(t, err)
+	assert.Empty(t, sink.published)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strings"
+	"unicode/utf8"
+	"wallet-service/models"
+)
+
+const (
+	maxPocketNameLength = 50
+	// defaultPocketName 钱包本身作为默认 pocket 的名称, 不能用于新建的 pocket
+	defaultPocketName = "default"
+)
+
+var (
+	ErrPocketNotFound = errors.New("pocket not found")
+	ErrPocketExists   = errors.New("pocket already exists")
+	ErrPocketClosed   = errors.New("pocket is closed")
+	ErrInvalidPocket  = errors.New("invalid pocket")
+)
+
+// CreatePocket 在用户某个币种的钱包下新建 pocket, 同一钱包下未关闭的 pocket 名称唯一(不区分大小写)
+func (s *walletService) CreatePocket(ctx context.Context, userID int, currency models.Currency, name string) (*models.Pocket, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxPocketNameLength {
+		return nil, fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidPocket, maxPocketNameLength)
+	}
+	if strings.EqualFold(name, defaultPocketName) {
+		return nil, fmt.Errorf("%w: name %q is reserved", ErrInvalidPocket, name)
+	}
+
+	var pocket models.Pocket
+	err := s.runInTx(ctx, "CreatePocket", func(tx *sqlx.Tx) error {
+		var status models.WalletStatus
+		err := tx.Get(&status, "SELECT status FROM wallets WHERE user_id = $1 AND currency = $2 FOR UPDATE", userID, currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status == models.WalletClosed {
+			return ErrWalletClosed
+		}
+
+		err = tx.Get(&pocket, `
+			INSERT INTO pockets (user_id, currency, name, status) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, currency, lower(name)) WHERE status = 'active' DO NOTHING RETURNING *`,
+			userID, currency, name, models.PocketActive)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %q", ErrPocketExists, name)
+		}
+		return err
+	})
+	if err != nil {
+		s.logger.Error(ctx, "CreatePocket Failed", zap.Int("userID", userID), zap.String("name", name), zap.Error(err))
+		return nil, err
+	}
+	return &pocket, nil
+}
+
+// ListPockets 查询用户未关闭的 pocket, currency 为空时返回所有币种
+func (s *walletService) ListPockets(ctx context.Context, userID int, currency models.Currency) ([]models.Pocket, error) {
+	if currency != "" && !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	pockets := []models.Pocket{}
+	err := s.db.SelectContext(ctx, &pockets, `
+		SELECT * FROM pockets WHERE user_id = $1 AND status = $2 AND ($3 = '' OR currency = $3) ORDER BY currency, id`,
+		userID, models.PocketActive, string(currency))
+	if err != nil {
+		s.logger.Error(ctx, "ListPockets Failed select from pockets", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return pockets, nil
+}
+
+// pocketRef 请求中的 pocket id, 0 表示默认 pocket
+func pocketRef(id *int) int {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
+
+// MovePocketFunds 在同一用户同一币种的钱包和 pocket 之间划转, 不收手续费, 不占用限额
+func (s *walletService) MovePocketFunds(ctx context.Context, userID int, move models.PocketMove) (*models.TransactionResult, error) {
+	if err := validateMoney(move.Amount, move.Currency); err != nil {
+		return nil, err
+	}
+	from, to := pocketRef(move.FromPocketID), pocketRef(move.ToPocketID)
+	if from == to {
+		return nil, fmt.Errorf("%w: source and destination are the same", ErrInvalidPocket)
+	}
+
+	var result *models.TransactionResult
+	err := s.runInTx(ctx, "MovePocketFunds", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, userID, requestHash("pocket_transfer", userID, from, to, move.Amount, move.Currency))
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			result = replayed
+			return nil
+		}
+
+		pockets, err := s.lockPockets(ctx, tx, userID, move.Currency, from, to)
+		if err != nil {
+			return err
+		}
+		transactionID, err := s.movePocketFundsWithTx(ctx, tx, userID, move.Currency, pockets[from], pockets[to], move.Amount)
+		if err != nil {
+			return err
+		}
+
+		result = &models.TransactionResult{TransactionID: transactionID}
+		return s.saveIdempotencyResult(ctx, tx, userID, result)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "MovePocketFunds Failed", zap.Int("userID", userID), zap.Int("from", from), zap.Int("to", to), zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
+// lockPockets 按 id 顺序锁定划转涉及的 pocket, 默认 pocket(0) 不在返回值中; pocket 必须属于该用户、币种一致且未关闭
+func (s *walletService) lockPockets(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency, ids ...int) (map[int]*models.Pocket, error) {
+	var pocketIDs []int
+	for _, id := range ids {
+		if id != 0 {
+			pocketIDs = append(pocketIDs, id)
+		}
+	}
+	locked := make(map[int]*models.Pocket, len(pocketIDs))
+	if len(pocketIDs) == 0 {
+		return locked, nil
+	}
+
+	var pockets []models.Pocket
+	err := tx.Select(&pockets, "SELECT * FROM pockets WHERE id = ANY($1) AND user_id = $2 ORDER BY id FOR UPDATE", pq.Array(pocketIDs), userID)
+	if err != nil {
+		s.logger.Error(ctx, "lockPockets Failed to lock pockets", zap.Ints("pocketIDs", pocketIDs), zap.Error(err))
+		return nil, err
+	}
+	for i := range pockets {
+		locked[pockets[i].ID] = &pockets[i]
+	}
+	for _, id := range pocketIDs {
+		pocket, ok := locked[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrPocketNotFound, id)
+		}
+		if pocket.Status == models.PocketClosed {
+			return nil, fmt.Errorf("%w: %d", ErrPocketClosed, id)
+		}
+		if pocket.Currency != currency {
+			return nil, fmt.Errorf("%w: pocket %d is %s", ErrCurrencyMismatch, id, pocket.Currency)
+		}
+	}
+	return locked, nil
+}
+
+// movePocketFundsWithTx 在事务内划转, from / to 为 nil 表示钱包本身; 划入 pocket 只能使用自有资金, 不能动用授信额度
+func (s *walletService) movePocketFundsWithTx(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency,
+	from, to *models.Pocket, amount decimal.Decimal) (int, error) {
+	var postings []models.Posting
+	if from == nil {
+		var balance decimal.Decimal
+		err := tx.Get(&balance, "UPDATE wallets SET balance = balance - $1 WHERE user_id = $2 AND currency = $3 AND status = 'active' AND balance - held_balance >= $1 RETURNING balance",
+			amount, userID, currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, s.walletUnavailable(ctx, tx, userID, currency, true)
+		}
+		if err != nil {
+			s.logger.Error(ctx, "movePocketFundsWithTx Failed to update wallets", zap.Int("userID", userID), zap.Error(err))
+			return 0, err
+		}
+		s.walletChanged(tx, userID, currency)
+		postings = append(postings, walletPosting(userID, currency, amount.Neg()))
+	} else {
+		// 从 pocket 划出同样锁定钱包; 划入其他 pocket 要求钱包为 active, 划回钱包(包括关闭 pocket)在钱包冻结时也允许
+		var status models.WalletStatus
+		err := tx.Get(&status, "SELECT status FROM wallets WHERE user_id = $1 AND currency = $2 FOR UPDATE", userID, currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrWalletNotFound
+		}
+		if err != nil {
+			s.logger.Error(ctx, "movePocketFundsWithTx Failed to lock wallet", zap.Int("userID", userID), zap.Error(err))
+			return 0, err
+		}
+		if to != nil {
+			if err = walletStatusError(status); err != nil {
+				return 0, err
+			}
+		}
+
+		res, err := tx.Exec("UPDATE pockets SET balance = balance - $1 WHERE id = $2 AND balance >= $1", amount, from.ID)
+		if err != nil {
+			s.logger.Error(ctx, "movePocketFundsWithTx Failed to update pockets", zap.Int("pocketID", from.ID), zap.Error(err))
+			return 0, err
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil {
+			return 0, err
+		} else if rowsAffected == 0 {
+			return 0, ErrInsufficientFunds
+		}
+		postings = append(postings, pocketPosting(*from, amount.Neg()))
+	}
+
+	if to == nil {
+		if err := s.DepositWithTx(ctx, tx, userID, amount, currency); err != nil {
+			return 0, err
+		}
+		postings = append(postings, walletPosting(userID, currency, amount))
+	} else {
+		if _, err := tx.Exec("UPDATE pockets SET balance = balance + $1 WHERE id = $2", amount, to.ID); err != nil {
+			s.logger.Error(ctx, "movePocketFundsWithTx Failed to update pockets", zap.Int("pocketID", to.ID), zap.Error(err))
+			return 0, err
+		}
+		postings = append(postings, pocketPosting(*to, amount))
+	}
+
+	return s.recordTransaction(ctx, tx, models.Transaction{
+		SenderUserID:    userID,
+		ReceiverUserID:  userID,
+		TransactionType: models.PocketTransferTransactionType,
+		Amount:          amount,
+		Currency:        currency,
+	}, postings)
+}
+
+// ClosePocket 关闭 pocket, 剩余余额转回钱包
+func (s *walletService) ClosePocket(ctx context.Context, pocketID int) (*models.Pocket, error) {
+	var pocket models.Pocket
+	err := s.runInTx(ctx, "ClosePocket", func(tx *sqlx.Tx) error {
+		err := tx.Get(&pocket, "SELECT * FROM pockets WHERE id = $1 FOR UPDATE", pocketID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrPocketNotFound, pocketID)
+		}
+		if err != nil {
+			return err
+		}
+		if pocket.Status == models.PocketClosed {
+			return fmt.Errorf("%w: %d", ErrPocketClosed, pocketID)
+		}
+
+		if pocket.Balance.IsPositive() {
+			if _, err = s.movePocketFundsWithTx(ctx, tx, pocket.UserID, pocket.Currency, &pocket, nil, pocket.Balance); err != nil {
+				return err
+			}
+		}
+		return tx.Get(&pocket, "UPDATE pockets SET status = $1 WHERE id = $2 RETURNING *", models.PocketClosed, pocketID)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "ClosePocket Failed", zap.Int("pocketID", pocketID), zap.Error(err))
+		return nil, err
+	}
+	return &pocket, nil
+}
+
+// GetAggregateBalances 按币种汇总用户的钱包和所有未关闭 pocket 的余额
+func (s *walletService) GetAggregateBalances(ctx context.Context, userID int) ([]models.AggregateBalance, error) {
+	wallets, err := s.GetBalances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	pockets, err := s.ListPockets(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]models.AggregateBalance, 0, len(wallets))
+	for _, wallet := range wallets {
+		balance := models.AggregateBalance{
+			Currency: wallet.Currency,
+			Wallet:   *walletBalance(wallet),
+			Pockets:  []models.Pocket{},
+			Total:    wallet.Balance,
+		}
+		for _, pocket := range pockets {
+			if pocket.Currency == wallet.Currency {
+				balance.Pockets = append(balance.Pockets, pocket)
+				balance.Total = balance.Total.Add(pocket.Balance)
+			}
+		}
+		balances = append(balances, balance)
+	}
+	return balances, nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var pocketRowColumns = []string{"id", "user_id", "currency", "name", "balance", "status", "created_at", "updated_at"}
+
+func newTestPocketService(t *testing.T) (*walletService, sqlmock.Sqlmock, redismock.ClientMock) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}, mockDB, mockRedis
+}
+
+func TestWalletService_CreatePocket(t *testing.T) {
+	service, mockDB, _ := newTestPocketService(t)
+	now := time.Now()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT status FROM wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectQuery("INSERT INTO pockets").
+		WithArgs(1, models.USD, "Rent", models.PocketActive).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns).AddRow(5, 1, "USD", "Rent", "0", "active", now, now))
+	mockDB.ExpectCommit()
+
+	pocket, err := service.CreatePocket(context.Background(), 1, models.USD, " Rent ")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, pocket.ID)
+
+	// 同名(不区分大小写)的 pocket 已存在时 ON CONFLICT DO NOTHING 不返回行
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectQuery("INSERT INTO pockets").
+		WithArgs(1, models.USD, "rent", models.PocketActive).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns))
+	mockDB.ExpectRollback()
+
+	_, err = service.CreatePocket(context.Background(), 1, models.USD, "rent")
+	assert.ErrorIs(t, err, ErrPocketExists)
+
+	// 默认 pocket 的名称保留
+	_, err = service.CreatePocket(context.Background(), 1, models.USD, "Default")
+	assert.ErrorIs(t, err, ErrInvalidPocket)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_MovePocketFunds_WalletToPocket(t *testing.T) {
+	service, mockDB, mockRedis := newTestPocketService(t)
+	now := time.Now()
+	amount := decimal.NewFromInt(30)
+	pocketID := 5
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM pockets WHERE id = ANY\(\$1\) AND user_id = \$2 ORDER BY id FOR UPDATE`).
+		WithArgs(pq.Array([]int{pocketID}), 1).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns).AddRow(pocketID, 1, "USD", "Rent", "0", "active", now, now))
+	// 划入 pocket 只能使用扣除冻结金额后的自有资金
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2 AND currency = \$3 AND status = 'active' AND balance - held_balance >= \$1 RETURNING balance`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("70"))
+	mockDB.ExpectExec(`UPDATE pockets SET balance = balance \+ \$1 WHERE id = \$2`).
+		WithArgs(amount, pocketID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.PocketTransferTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(40))
+	expectJournalEntry(mockDB, walletPosting(1, models.USD, amount.Neg()),
+		pocketPosting(models.Pocket{ID: pocketID, UserID: 1, Currency: models.USD}, amount))
+	mockDB.ExpectCommit()
+
+	result, err := service.MovePocketFunds(context.Background(), 1, models.PocketMove{ToPocketID: &pocketID, Amount: amount, Currency: models.USD})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 40, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+// expectPocketWalletStatus 从 pocket 划出前锁定钱包并校验状态
+func expectPocketWalletStatus(mockDB sqlmock.Sqlmock, userID int, status models.WalletStatus) {
+	mockDB.ExpectQuery(`SELECT status FROM wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(userID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(status))
+}
+
+func TestWalletService_MovePocketFunds_Rejected(t *testing.T) {
+	service, mockDB, _ := newTestPocketService(t)
+	now := time.Now()
+	from, to := 5, 6
+
+	// pocket 余额不足
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM pockets WHERE id = ANY`).
+		WithArgs(pq.Array([]int{from, to}), 1).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns).
+			AddRow(from, 1, "USD", "Rent", "10", "active", now, now).AddRow(to, 1, "USD", "Holiday", "0", "active", now, now))
+	expectPocketWalletStatus(mockDB, 1, models.WalletActive)
+	mockDB.ExpectExec(`UPDATE pockets SET balance = balance - \$1 WHERE id = \$2 AND balance >= \$1`).
+		WithArgs(decimal.NewFromInt(30), from).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectRollback()
+
+	_, err := service.MovePocketFunds(context.Background(), 1, models.PocketMove{FromPocketID: &from, ToPocketID: &to, Amount: decimal.NewFromInt(30), Currency: models.USD})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	// 冻结的钱包不能在 pocket 之间划转
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM pockets WHERE id = ANY`).
+		WithArgs(pq.Array([]int{from, to}), 1).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns).
+			AddRow(from, 1, "USD", "Rent", "50", "active", now, now).AddRow(to, 1, "USD", "Holiday", "0", "active", now, now))
+	expectPocketWalletStatus(mockDB, 1, models.WalletFrozen)
+	mockDB.ExpectRollback()
+
+	_, err = service.MovePocketFunds(context.Background(), 1, models.PocketMove{FromPocketID: &from, ToPocketID: &to, Amount: decimal.NewFromInt(30), Currency: models.USD})
+	assert.ErrorIs(t, err, ErrWalletFrozen)
+
+	// 其他用户的 pocket 视为不存在
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM pockets WHERE id = ANY`).
+		WithArgs(pq.Array([]int{from}), 2).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns))
+	mockDB.ExpectRollback()
+
+	_, err = service.MovePocketFunds(context.Background(), 2, models.PocketMove{FromPocketID: &from, Amount: decimal.NewFromInt(30), Currency: models.USD})
+	assert.ErrorIs(t, err, ErrPocketNotFound)
+
+	_, err = service.MovePocketFunds(context.Background(), 1, models.PocketMove{Amount: decimal.NewFromInt(30), Currency: models.USD})
+	assert.ErrorIs(t, err, ErrInvalidPocket)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ClosePocket(t *testing.T) {
+	service, mockDB, mockRedis := newTestPocketService(t)
+	now := time.Now()
+	balance := decimal.NewFromInt(30)
+	pocket := models.Pocket{ID: 5, UserID: 1, Currency: models.USD}
+
+	// 剩余余额转回钱包后关闭
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM pockets WHERE id = \$1 FOR UPDATE`).
+		WithArgs(pocket.ID).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns).AddRow(pocket.ID, 1, "USD", "Rent", "30", "active", now, now))
+	// 钱包冻结时仍可以关闭 pocket, 余额转回钱包
+	expectPocketWalletStatus(mockDB, 1, models.WalletFrozen)
+	mockDB.ExpectExec(`UPDATE pockets SET balance = balance - \$1`).
+		WithArgs(balance, pocket.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(balance, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.PocketTransferTransactionType, balance, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(41))
+	expectJournalEntry(mockDB, pocketPosting(pocket, balance.Neg()), walletPosting(1, models.USD, balance))
+	mockDB.ExpectQuery(`UPDATE pockets SET status = \$1 WHERE id = \$2 RETURNING \*`).
+		WithArgs(models.PocketClosed, pocket.ID).
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns).AddRow(pocket.ID, 1, "USD", "Rent", "0", "closed", now, now))
+	mockDB.ExpectCommit()
+
+	closed, err := service.ClosePocket(context.Background(), pocket.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.PocketClosed, closed.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_GetAggregateBalances(t *testing.T) {
+	service, mockDB, mockRedis := newTestPocketService(t)
+	now := time.Now()
+
+	mockDB.ExpectQuery("FROM wallets WHERE user_id = \\$1 ORDER BY currency").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(append(walletRowColumns, "version")).
+			AddRow(1, "EUR", "20", "0", "0", "active", now, now, 1).AddRow(1, "USD", "100", "10", "0", "active", now, now, 3))
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.EUR), balanceCacheFields...).SetVal([]interface{}{"1", "20", "0", "0"})
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).SetVal([]interface{}{"3", "100", "10", "0"})
+	mockDB.ExpectQuery("FROM pockets WHERE user_id = \\$1").
+		WithArgs(1, models.PocketActive, "").
+		WillReturnRows(sqlmock.NewRows(pocketRowColumns).
+			AddRow(5, 1, "USD", "Rent", "30", "active", now, now).AddRow(6, 1, "USD", "Holiday", "12.5", "active", now, now))
+
+	balances, err := service.GetAggregateBalances(context.Background(), 1)
+
+	assert.NoError(t, err)
+	assert.Len(t, balances, 2)
+	assert.Equal(t, "20", balances[0].Total.String())
+	assert.Empty(t, balances[0].Pockets)
+	assert.Equal(t, "142.5", balances[1].Total.String())
+	assert.Equal(t, "90", balances[1].Wallet.Available.String())
+	assert.Len(t, balances[1].Pockets, 2)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_ChangeWalletStatus_PocketsNotEmpty(t *testing.T) {
+	service, mockDB, _ := newTestPocketService(t)
+	now := time.Now()
+
+	// pocket 中仍有余额时不能注销钱包
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT .* FROM wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns).AddRow(1, "USD", "0", "0", "0", "active", now, now))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(balance\), 0\) FROM pockets`).
+		WithArgs(1, models.USD, models.PocketActive).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("30"))
+	mockDB.ExpectRollback()
+
+	_, err := service.ChangeWalletStatus(context.Background(), 1, models.USD, models.WalletClosed, "user request")
+
+	assert.ErrorIs(t, err, ErrWalletNotEmpty)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const (
+	reconciliationBatchSize = 500
+	reconciliationListLimit = 50
+	// reconciliationRecheckDelay 缓存在事务提交后才更新, 发现不一致后等待片刻再确认, 避开刚提交、缓存尚未更新的事务
+	reconciliationRecheckDelay = time.Second
+)
+
+var ErrReconciliationNotFound = errors.New("reconciliation run not found")
+
+type ReconciliationService interface {
+	// Reconcile 同步执行一次对账, 对账失败时返回的记录中包含错误信息
+	Reconcile(ctx context.Context, trigger models.ReconciliationTrigger, repairCache bool) (*models.ReconciliationRun, error)
+	// StartReconciliation 创建对账记录后在后台执行, 通过 GetReconciliation 查询结果
+	StartReconciliation(ctx context.Context, trigger models.ReconciliationTrigger, repairCache bool) (*models.ReconciliationRun, error)
+	ListReconciliations(ctx context.Context) ([]models.ReconciliationRun, error)
+	GetReconciliation(ctx context.Context, runID int) (*models.ReconciliationRun, error)
+}
+
+type reconciliationService struct {
+	db           *sqlx.DB
+	redis        *redis.Client
+	logger       *wallet_logger.Logger
+	recheckDelay time.Duration
+}
+
+var _ ReconciliationService = &reconciliationService{}
+
+func (s *reconciliationService) balanceCache() balanceCache {
+	return balanceCache{redis: s.redis, logger: s.logger}
+}
+
+// NewReconciliationService service
+func NewReconciliationService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) ReconciliationService {
+	return newReconciliationService(logger, db, redis)
+}
+
+func newReconciliationService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *reconciliationService {
+	return &reconciliationService{
+		db:           db,
+		redis:        redis,
+		logger:       logger,
+		recheckDelay: reconciliationRecheckDelay,
+	}
+}
+
+// walletReconciliation 一个钱包的余额及按分录重新计算的余额
+type walletReconciliation struct {
+	UserID        int             `db:"user_id"`
+	Currency      models.Currency `db:"currency"`
+	Balance       decimal.Decimal `db:"balance"`
+	LedgerBalance decimal.Decimal `db:"ledger_balance"`
+	Expected      decimal.Decimal `db:"expected"`
+}
+
+func (s *reconciliationService) Reconcile(ctx context.Context, trigger models.ReconciliationTrigger, repairCache bool) (*models.ReconciliationRun, error) {
+	run, err := s.startRun(ctx, trigger, repairCache)
+	if err != nil {
+		return nil, err
+	}
+	return run, s.execute(ctx, run)
+}
+
+func (s *reconciliationService) StartReconciliation(ctx context.Context, trigger models.ReconciliationTrigger, repairCache bool) (*models.ReconciliationRun, error) {
+	run, err := s.startRun(ctx, trigger, repairCache)
+	if err != nil {
+		return nil, err
+	}
+	started := *run
+	// 对账可能耗时较长, 不随请求取消; 错误已记录在对账记录中
+	go func() {
+		_ = s.execute(context.WithoutCancel(ctx), run)
+	}()
+	return &started, nil
+}
+
+func (s *reconciliationService) startRun(ctx context.Context, trigger models.ReconciliationTrigger, repairCache bool) (*models.ReconciliationRun, error) {
+	var run models.ReconciliationRun
+	err := s.db.GetContext(ctx, &run, `
+		INSERT INTO reconciliation_runs (trigger, repair_cache, status, started_at)
+		VALUES ($1, $2, $3, $4) RETURNING *`,
+		trigger, repairCache, models.ReconciliationRunning, time.Now().UTC())
+	if err != nil {
+		s.logger.Error(ctx, "Reconcile Failed insert into reconciliation_runs", zap.String("trigger", string(trigger)), zap.Error(err))
+		return nil, err
+	}
+	return &run, nil
+}
+
+// execute 逐批核对所有钱包, 结束后更新对账记录; 单批失败时整次对账失败, 已发现的不一致保留
+func (s *reconciliationService) execute(ctx context.Context, run *models.ReconciliationRun) error {
+	err := s.checkWallets(ctx, run)
+	if err == nil {
+		err = s.recordSystemBalances(ctx, run)
+	}
+	run.Status = models.ReconciliationCompleted
+	if err != nil {
+		message := err.Error()
+		run.Status, run.Error = models.ReconciliationFailed, &message
+	}
+
+	finishedAt := time.Now().UTC()
+	run.FinishedAt = &finishedAt
+	_, updateErr := s.db.ExecContext(ctx, `
+		UPDATE reconciliation_runs SET status = $1, wallets_checked = $2, drifts = $3, cache_repaired = $4, error = $5, finished_at = $6
+		WHERE id = $7`,
+		run.Status, run.WalletsChecked, run.DriftCount, run.CacheRepaired, run.Error, finishedAt, run.ID)
+	if updateErr != nil {
+		s.logger.Error(ctx, "Reconcile Failed update reconciliation_runs", zap.Int("runID", run.ID), zap.Error(updateErr))
+		if err == nil {
+			err = updateErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{zap.Int("runID", run.ID), zap.Int("wallets", run.WalletsChecked),
+		zap.Int("drifts", run.DriftCount), zap.Int("cacheRepaired", run.CacheRepaired)}
+	if run.DriftCount > 0 {
+		s.logger.Error(ctx, "Reconcile found drifts", fields...)
+	} else {
+		s.logger.Info(ctx, "Reconcile completed", fields...)
+	}
+	return nil
+}
+
+// checkWallets 按 (user_id, currency) 顺序分批核对; 同一条语句读取的钱包余额和分录属于同一快照, 不受并发事务影响
+func (s *reconciliationService) checkWallets(ctx context.Context, run *models.ReconciliationRun) error {
+	lastUserID, lastCurrency := 0, models.Currency("")
+	for {
+		var wallets []walletReconciliation
+		err := s.db.SelectContext(ctx, &wallets, `
+			SELECT w.user_id, w.currency, w.balance, COALESCE(la.balance, 0) AS ledger_balance,
+			       COALESCE((SELECT SUM(p.amount) FROM postings p WHERE p.account_code = la.code), 0) AS expected
+			FROM wallets w
+			LEFT JOIN ledger_accounts la ON la.account_type = $1 AND la.user_id = w.user_id AND la.currency = w.currency
+			WHERE (w.user_id, w.currency) > ($2, $3)
+			ORDER BY w.user_id, w.currency LIMIT $4`,
+			models.WalletAccountType, lastUserID, lastCurrency, reconciliationBatchSize)
+		if err != nil {
+			s.logger.Error(ctx, "Reconcile Failed select from wallets", zap.Int("runID", run.ID), zap.Error(err))
+			return err
+		}
+		if len(wallets) == 0 {
+			return nil
+		}
+
+		for _, w := range wallets {
+			if !w.Balance.Equal(w.Expected) {
+				err = s.recordDrift(ctx, run, w.UserID, w.Currency, models.DriftWalletBalance, w.Expected, w.Balance, false)
+			}
+			if err == nil && !w.LedgerBalance.Equal(w.Expected) {
+				err = s.recordDrift(ctx, run, w.UserID, w.Currency, models.DriftLedgerAccount, w.Expected, w.LedgerBalance, false)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err = s.checkCache(ctx, run, wallets); err != nil {
+			return err
+		}
+
+		run.WalletsChecked += len(wallets)
+		last := wallets[len(wallets)-1]
+		lastUserID, lastCurrency = last.UserID, last.Currency
+	}
+}
+
+// checkCache 比较缓存的余额与 wallets.balance, 没有缓存的钱包跳过; 不一致的钱包等待片刻后重新读取确认
+func (s *reconciliationService) checkCache(ctx context.Context, run *models.ReconciliationRun, wallets []walletReconciliation) error {
+	keys := make([]walletKey, len(wallets))
+	for i, w := range wallets {
+		keys[i] = walletKey{UserID: w.UserID, Currency: w.Currency}
+	}
+	cached, err := s.balanceCache().getMany(ctx, keys)
+	if err != nil {
+		s.logger.Error(ctx, "Reconcile Failed get balances from cache", zap.Int("runID", run.ID), zap.Error(err))
+		return err
+	}
+
+	var suspects []walletReconciliation
+	for i, w := range wallets {
+		if cached[i] != nil && !cached[i].Balance.Equal(w.Balance) {
+			suspects = append(suspects, w)
+		}
+	}
+	if len(suspects) == 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.recheckDelay):
+	}
+	for _, w := range suspects {
+		if err = s.recheckCache(ctx, run, w.UserID, w.Currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recheckCache 重新读取数据库和缓存, 仍不一致时记录; repair_cache 时删除该钱包的缓存, 下次查询从数据库重新加载
+func (s *reconciliationService) recheckCache(ctx context.Context, run *models.ReconciliationRun, userID int, currency models.Currency) error {
+	var balance decimal.Decimal
+	err := s.db.GetContext(ctx, &balance, "SELECT balance FROM wallets WHERE user_id = $1 AND currency = $2", userID, currency)
+	if err != nil {
+		s.logger.Error(ctx, "Reconcile Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	cached, err := s.balanceCache().get(ctx, userID, currency)
+	if err != nil {
+		s.logger.Error(ctx, "Reconcile Failed get balance from cache", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	if cached == nil || cached.Balance.Equal(balance) {
+		return nil
+	}
+
+	repaired := false
+	if run.RepairCache {
+		if err = s.balanceCache().invalidate(ctx, walletKey{UserID: userID, Currency: currency}); err != nil {
+			s.logger.Warn(ctx, "Reconcile Failed to repair cache", zap.Int("userID", userID), zap.Error(err))
+		} else {
+			repaired = true
+			run.CacheRepaired++
+		}
+	}
+	return s.recordDrift(ctx, run, userID, currency, models.DriftCache, balance, cached.Balance, repaired)
+}
+
+func (s *reconciliationService) recordDrift(ctx context.Context, run *models.ReconciliationRun, userID int, currency models.Currency,
+	kind models.DriftKind, expected, actual decimal.Decimal, repaired bool) error {
+	var drift models.ReconciliationDrift
+	err := s.db.GetContext(ctx, &drift, `
+		INSERT INTO reconciliation_drifts (run_id, user_id, currency, kind, expected, actual, difference, repaired)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *`,
+		run.ID, userID, currency, kind, expected, actual, actual.Sub(expected), repaired)
+	if err != nil {
+		s.logger.Error(ctx, "Reconcile Failed insert into reconciliation_drifts", zap.Int("runID", run.ID), zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	s.logger.Error(ctx, "Reconcile found drift", zap.Int("runID", run.ID), zap.Int("userID", userID), zap.String("currency", string(currency)),
+		zap.String("kind", string(kind)), zap.String("expected", expected.String()), zap.String("actual", actual.String()), zap.Bool("repaired", repaired))
+	run.DriftCount++
+	run.Drifts = append(run.Drifts, drift)
+	return nil
+}
+
+// recordSystemBalances 按分录之和计算各系统账户的余额并随对账记录保存; 系统账户被所有存取款共用, 不在交易中维护累计余额
+func (s *reconciliationService) recordSystemBalances(ctx context.Context, run *models.ReconciliationRun) error {
+	err := s.db.SelectContext(ctx, &run.SystemBalances, `
+		INSERT INTO reconciliation_system_balances (run_id, account_code, currency, balance)
+		SELECT $1, la.code, la.currency, COALESCE(SUM(p.amount), 0)
+		FROM ledger_accounts la
+		LEFT JOIN postings p ON p.account_code = la.code
+		WHERE la.account_type = $2
+		GROUP BY la.code, la.currency
+		RETURNING *`,
+		run.ID, models.SystemAccountType)
+	if err != nil {
+		s.logger.Error(ctx, "Reconcile Failed insert into reconciliation_system_balances", zap.Int("runID", run.ID), zap.Error(err))
+	}
+	return err
+}
+
+// ListReconciliations 最近的对账记录, 不含明细
+func (s *reconciliationService) ListReconciliations(ctx context.Context) ([]models.ReconciliationRun, error) {
+	runs := []models.ReconciliationRun{}
+	err := s.db.SelectContext(ctx, &runs, "SELECT * FROM reconciliation_runs ORDER BY id DESC LIMIT $1", reconciliationListLimit)
+	if err != nil {
+		s.logger.Error(ctx, "ListReconciliations Failed select from reconciliation_runs", zap.Error(err))
+		return nil, err
+	}
+	return runs, nil
+}
+
+// GetReconciliation 查询对账记录及发现的不一致
+func (s *reconciliationService) GetReconciliation(ctx context.Context, runID int) (*models.ReconciliationRun, error) {
+	var run models.ReconciliationRun
+	err := s.db.GetContext(ctx, &run, "SELECT * FROM reconciliation_runs WHERE id = $1", runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrReconciliationNotFound, runID)
+	}
+	if err == nil {
+		err = s.db.SelectContext(ctx, &run.Drifts, "SELECT * FROM reconciliation_drifts WHERE run_id = $1 ORDER BY id", runID)
+	}
+	if err == nil {
+		err = s.db.SelectContext(ctx, &run.SystemBalances, "SELECT * FROM reconciliation_system_balances WHERE run_id = $1 ORDER BY account_code", runID)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "GetReconciliation Failed", zap.Int("runID", runID), zap.Error(err))
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Reconciler 每天定时对账一次
+type Reconciler struct {
+	service *reconciliationService
+}
+
+// NewReconciler new reconciler
+func NewReconciler(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *Reconciler {
+	return &Reconciler{service: newReconciliationService(logger, db, redis)}
+}
+
+// Run 今天(UTC)还没有定时对账时执行一次, 重启后不重复执行; 对账不修改数据库余额, 多实例偶尔重复执行也无影响
+func (r *Reconciler) Run(ctx context.Context) error {
+	s := r.service
+	day, _, _ := limitPeriods(time.Now())
+	var done bool
+	err := s.db.GetContext(ctx, &done, "SELECT EXISTS (SELECT 1 FROM reconciliation_runs WHERE trigger = $1 AND started_at >= $2)",
+		models.ReconciliationSchedule, day)
+	if err != nil {
+		s.logger.Error(ctx, "Reconciler Failed select from reconciliation_runs", zap.Error(err))
+		return err
+	}
+	if done {
+		return nil
+	}
+	_, err = s.Reconcile(ctx, models.ReconciliationSchedule, config.GetConfig().Reconciliation.RepairCache)
+	return err
+}
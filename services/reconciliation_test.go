@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var (
+	reconciliationRunColumns    = []string{"id", "trigger", "repair_cache", "status", "wallets_checked", "drifts", "cache_repaired", "error", "started_at", "finished_at"}
+	reconciliationDriftColumns  = []string{"id", "run_id", "user_id", "currency", "kind", "expected", "actual", "difference", "repaired", "created_at"}
+	walletReconciliationColumns = []string{"user_id", "currency", "balance", "ledger_balance", "expected"}
+	systemBalanceColumns        = []string{"run_id", "account_code", "currency", "balance"}
+)
+
+func newTestReconciliationService(t *testing.T) (*reconciliationService, sqlmock.Sqlmock, redismock.ClientMock) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return &reconciliationService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}, mockDB, mockRedis
+}
+
+func expectStartRun(mockDB sqlmock.Sqlmock, trigger models.ReconciliationTrigger, repairCache bool) {
+	mockDB.ExpectQuery("INSERT INTO reconciliation_runs").
+		WithArgs(trigger, repairCache, models.ReconciliationRunning, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(reconciliationRunColumns).
+			AddRow(7, trigger, repairCache, models.ReconciliationRunning, 0, 0, 0, nil, time.Now(), nil))
+}
+
+func expectDrift(mockDB sqlmock.Sqlmock, userID int, kind models.DriftKind, expected, actual, difference string, repaired bool) {
+	mockDB.ExpectQuery("INSERT INTO reconciliation_drifts").
+		WithArgs(7, userID, models.USD, kind, expected, actual, difference, repaired).
+		WillReturnRows(sqlmock.NewRows(reconciliationDriftColumns).
+			AddRow(userID, 7, userID, models.USD, kind, expected, actual, difference, repaired, time.Now()))
+}
+
+// expectSystemBalances 期望按分录之和计算并保存系统账户的余额
+func expectSystemBalances(mockDB sqlmock.Sqlmock) {
+	mockDB.ExpectQuery("INSERT INTO reconciliation_system_balances").
+		WithArgs(7, models.SystemAccountType).
+		WillReturnRows(sqlmock.NewRows(systemBalanceColumns).
+			AddRow(7, SystemAccountCode(ExternalCashInAccount, models.USD), models.USD, "-250").
+			AddRow(7, SystemAccountCode(ExternalCashOutAccount, models.USD), models.USD, "100"))
+}
+
+func TestReconciliationService_Reconcile(t *testing.T) {
+	service, mockDB, mockRedis := newTestReconciliationService(t)
+
+	expectStartRun(mockDB, models.ReconciliationCLI, true)
+	mockDB.ExpectQuery("FROM wallets w").
+		WithArgs(models.WalletAccountType, 0, models.Currency(""), reconciliationBatchSize).
+		WillReturnRows(sqlmock.NewRows(walletReconciliationColumns).
+			AddRow(1, models.USD, "100", "100", "100").
+			AddRow(2, models.USD, "90", "100", "100"). // 钱包余额与分录不符
+			AddRow(3, models.USD, "50", "50", "50"))
+	expectDrift(mockDB, 2, models.DriftWalletBalance, "100", "90", "-10", false)
+	// 钱包 2 没有缓存, 钱包 3 的缓存与数据库不符, 重新读取确认后删除
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).SetVal([]interface{}{"1", "100", "0", "0"})
+	mockRedis.ExpectHMGet(balanceCacheKey(2, models.USD), balanceCacheFields...).SetVal([]interface{}{nil, nil, nil, nil})
+	mockRedis.ExpectHMGet(balanceCacheKey(3, models.USD), balanceCacheFields...).SetVal([]interface{}{"2", "70", "0", "0"})
+	mockDB.ExpectQuery("SELECT balance FROM wallets").
+		WithArgs(3, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+	mockRedis.ExpectHMGet(balanceCacheKey(3, models.USD), balanceCacheFields...).SetVal([]interface{}{"2", "70", "0", "0"})
+	mockRedis.ExpectDel(balanceCacheKey(3, models.USD)).SetVal(1)
+	expectDrift(mockDB, 3, models.DriftCache, "50", "70", "20", true)
+	mockDB.ExpectQuery("FROM wallets w").
+		WithArgs(models.WalletAccountType, 3, models.USD, reconciliationBatchSize).
+		WillReturnRows(sqlmock.NewRows(walletReconciliationColumns))
+	expectSystemBalances(mockDB)
+	mockDB.ExpectExec("UPDATE reconciliation_runs SET status").
+		WithArgs(models.ReconciliationCompleted, 3, 2, 1, nil, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	run, err := service.Reconcile(context.Background(), models.ReconciliationCLI, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ReconciliationCompleted, run.Status)
+	assert.Equal(t, 3, run.WalletsChecked)
+	assert.Equal(t, 2, run.DriftCount)
+	assert.Equal(t, 1, run.CacheRepaired)
+	assert.Len(t, run.Drifts, 2)
+	assert.Equal(t, models.DriftCache, run.Drifts[1].Kind)
+	if assert.Len(t, run.SystemBalances, 2) {
+		assert.Equal(t, "-250", run.SystemBalances[0].Balance.String())
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestReconciliationService_Reconcile_CacheSettled(t *testing.T) {
+	service, mockDB, mockRedis := newTestReconciliationService(t)
+
+	// 读取后有事务提交并刷新了缓存, 重新读取时一致, 不算不一致; 账户余额不符单独记录
+	expectStartRun(mockDB, models.ReconciliationSchedule, false)
+	mockDB.ExpectQuery("FROM wallets w").
+		WithArgs(models.WalletAccountType, 0, models.Currency(""), reconciliationBatchSize).
+		WillReturnRows(sqlmock.NewRows(walletReconciliationColumns).AddRow(1, models.USD, "100", "95", "100"))
+	expectDrift(mockDB, 1, models.DriftLedgerAccount, "100", "95", "-5", false)
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).SetVal([]interface{}{"5", "130", "0", "0"})
+	mockDB.ExpectQuery("SELECT balance FROM wallets").
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("130"))
+	mockRedis.ExpectHMGet(balanceCacheKey(1, models.USD), balanceCacheFields...).SetVal([]interface{}{"5", "130", "0", "0"})
+	mockDB.ExpectQuery("FROM wallets w").
+		WithArgs(models.WalletAccountType, 1, models.USD, reconciliationBatchSize).
+		WillReturnRows(sqlmock.NewRows(walletReconciliationColumns))
+	expectSystemBalances(mockDB)
+	mockDB.ExpectExec("UPDATE reconciliation_runs SET status").
+		WithArgs(models.ReconciliationCompleted, 1, 1, 0, nil, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	run, err := service.Reconcile(context.Background(), models.ReconciliationSchedule, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, run.DriftCount)
+	assert.Equal(t, 0, run.CacheRepaired)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestReconciliationService_Reconcile_Failed(t *testing.T) {
+	service, mockDB, _ := newTestReconciliationService(t)
+
+	dbErr := errors.New("connection reset")
+	expectStartRun(mockDB, models.ReconciliationAdmin, false)
+	mockDB.ExpectQuery("FROM wallets w").WillReturnError(dbErr)
+	mockDB.ExpectExec("UPDATE reconciliation_runs SET status").
+		WithArgs(models.ReconciliationFailed, 0, 0, 0, dbErr.Error(), sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	run, err := service.Reconcile(context.Background(), models.ReconciliationAdmin, false)
+
+	assert.ErrorIs(t, err, dbErr)
+	assert.Equal(t, models.ReconciliationFailed, run.Status)
+	assert.Equal(t, dbErr.Error(), *run.Error)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestReconciliationService_GetReconciliation(t *testing.T) {
+	service, mockDB, _ := newTestReconciliationService(t)
+
+	mockDB.ExpectQuery("SELECT \\* FROM reconciliation_runs WHERE id = \\$1").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(reconciliationRunColumns).
+			AddRow(7, models.ReconciliationAdmin, false, models.ReconciliationCompleted, 3, 1, 0, nil, time.Now(), time.Now()))
+	mockDB.ExpectQuery("SELECT \\* FROM reconciliation_drifts WHERE run_id = \\$1").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(reconciliationDriftColumns).
+			AddRow(1, 7, 2, models.USD, models.DriftWalletBalance, "100", "90", "-10", false, time.Now()))
+	mockDB.ExpectQuery("SELECT \\* FROM reconciliation_system_balances WHERE run_id = \\$1").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(systemBalanceColumns).AddRow(7, SystemAccountCode(ExternalCashInAccount, models.USD), models.USD, "-250"))
+
+	run, err := service.GetReconciliation(context.Background(), 7)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, run.DriftCount)
+	assert.Len(t, run.Drifts, 1)
+	assert.Equal(t, "-10", run.Drifts[0].Difference.String())
+	assert.Len(t, run.SystemBalances, 1)
+
+	mockDB.ExpectQuery("SELECT \\* FROM reconciliation_runs WHERE id = \\$1").
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows(reconciliationRunColumns))
+	_, err = service.GetReconciliation(context.Background(), 8)
+	assert.ErrorIs(t, err, ErrReconciliationNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestReconciler_Run_AlreadyDone(t *testing.T) {
+	service, mockDB, _ := newTestReconciliationService(t)
+	reconciler := &Reconciler{service: service}
+
+	// 今天已经定时对账过
+	day, _, _ := limitPeriods(time.Now())
+	mockDB.ExpectQuery("SELECT EXISTS").
+		WithArgs(models.ReconciliationSchedule, day).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	assert.NoError(t, reconciler.Run(context.Background()))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const pendingReversalBatchSize = 100
+
+var (
+	ErrTransactionNotFound    = errors.New("transaction not found")
+	ErrNotReversible          = errors.New("transaction type cannot be reversed")
+	ErrRefundExceedsRemaining = errors.New("refund amount exceeds the remaining refundable amount")
+	ErrInvalidReversalPolicy  = errors.New("invalid reversal policy")
+)
+
+// reversalPolicy 未指定时使用配置的默认策略
+func reversalPolicy(policy models.ReversalPolicy) (models.ReversalPolicy, error) {
+	if policy == "" {
+		policy = models.ReversalPolicy(config.GetConfig().Reversal.InsufficientFundsPolicy)
+	}
+	switch policy {
+	case "":
+		return models.ReversalPolicyReject, nil
+	case models.ReversalPolicyReject, models.ReversalPolicyQueue:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidReversalPolicy, policy)
+	}
+}
+
+// Reverse 冲正/退款: 生成与原交易方向相反的流水和分录, amount 为零时退还剩余全部金额.
+// 对方余额不足时按 policy 拒绝或排队
+func (s *walletService) Reverse(ctx context.Context, transactionID int, amount decimal.Decimal, policy models.ReversalPolicy) (*models.TransactionResult, error) {
+	if amount.IsNegative() {
+		return nil, errors.New("amount must be greater than zero")
+	}
+	policy, err := reversalPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *models.TransactionResult
+	err = s.runInTx(ctx, "Reverse", func(tx *sqlx.Tx) error {
+		// 锁定原交易, 同一笔交易的并发退款串行执行
+		var original models.Transaction
+		err := tx.Get(&original, "SELECT * FROM transactions WHERE id = $1 FOR UPDATE", transactionID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTransactionNotFound
+		}
+		if err != nil {
+			s.logger.Error(ctx, "Reverse Failed select from transactions", zap.Int("transactionID", transactionID), zap.Error(err))
+			return err
+		}
+		// 幂等键按原交易的付款方区分, 在校验状态之前重放(全额冲正后原交易已是 reversed)
+		replayed, err := s.claimIdempotencyKey(ctx, tx, original.SenderUserID, requestHash("reverse", transactionID, amount, policy))
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			result = replayed
+			return nil
+		}
+		if _, _, err = reversalParties(original); err != nil {
+			return err
+		}
+		if original.Status != models.TransactionCompleted {
+			return fmt.Errorf("%w: transaction is %s", ErrNotReversible, original.Status)
+		}
+
+		// 已退款和排队中的金额都计入, 防止超额退款; 关联的手续费流水不计入
+		var refunded decimal.Decimal
+		err = tx.Get(&refunded, `
+			SELECT COALESCE(SUM(amount), 0) FROM (
+				SELECT amount FROM transactions WHERE original_transaction_id = $1 AND transaction_type = $3
+				UNION ALL
+				SELECT amount FROM pending_reversals WHERE original_transaction_id = $1 AND status = $2
+			) refunds`, transactionID, models.PendingReversalPending, models.ReversalTransactionType)
+		if err != nil {
+			s.logger.Error(ctx, "Reverse Failed to sum refunded amount", zap.Int("transactionID", transactionID), zap.Error(err))
+			return err
+		}
+		remaining := original.Amount.Sub(refunded)
+		if amount.IsZero() {
+			amount = remaining
+		}
+		if !remaining.IsPositive() || amount.GreaterThan(remaining) {
+			return fmt.Errorf("%w: %s %s remaining", ErrRefundExceedsRemaining, remaining.String(), original.Currency)
+		}
+		if err = validateMoney(amount, original.Currency); err != nil {
+			return err
+		}
+
+		reversalID, feeRefund, err := s.applyReversal(ctx, tx, original, amount)
+		if errors.Is(err, ErrInsufficientFunds) && policy == models.ReversalPolicyQueue {
+			// 条件扣款没有修改任何数据, 事务仍可继续使用
+			var pendingID int
+			err = tx.Get(&pendingID, "INSERT INTO pending_reversals (original_transaction_id, amount, status) VALUES ($1, $2, $3) RETURNING id",
+				transactionID, amount, models.PendingReversalPending)
+			if err != nil {
+				s.logger.Error(ctx, "Reverse Failed insert into pending_reversals", zap.Int("transactionID", transactionID), zap.Error(err))
+				return err
+			}
+			result = &models.TransactionResult{PendingReversalID: pendingID}
+			return s.saveIdempotencyResult(ctx, tx, original.SenderUserID, result)
+		}
+		if err != nil {
+			return err
+		}
+
+		result = &models.TransactionResult{TransactionID: reversalID, FeeRefund: feeRefund}
+		return s.saveIdempotencyResult(ctx, tx, original.SenderUserID, result)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "Reverse Failed", zap.Int("transactionID", transactionID), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// reversalParties 返回冲正时被扣款和入账的用户(0 表示外部账户), 与原交易方向相反
+func reversalParties(original models.Transaction) (debitUserID, creditUserID int, err error) {
+	switch original.TransactionType {
+	case models.TransferTransactionType:
+		// 收款方 -> 付款方
+		return original.ReceiverUserID, original.SenderUserID, nil
+	case models.DepositTransactionType:
+		// 用户钱包 -> 外部现金流入账户
+		return original.SenderUserID, 0, nil
+	case models.WithdrawTransactionType, models.CaptureTransactionType:
+		// 外部现金流出账户 -> 用户钱包
+		return 0, original.SenderUserID, nil
+	default:
+		return 0, 0, fmt.Errorf("%w: %s", ErrNotReversible, original.TransactionType)
+	}
+}
+
+// applyReversal 在事务内执行冲正的资金变动并记录关联原交易的流水, 按比例退还原交易收取的手续费, 不校验可退金额
+func (s *walletService) applyReversal(ctx context.Context, tx *sqlx.Tx, original models.Transaction, amount decimal.Decimal) (int, *models.FeeResult, error) {
+	debitUserID, creditUserID, err := reversalParties(original)
+	if err != nil {
+		return 0, nil, err
+	}
+	currency := original.Currency
+
+	var postings []models.Posting
+	switch {
+	case debitUserID != 0 && creditUserID != 0:
+		if err = s.lockWallets(ctx, tx, currency, debitUserID, creditUserID); err != nil {
+			return 0, nil, err
+		}
+		postings = []models.Posting{walletPosting(debitUserID, currency, amount.Neg()), walletPosting(creditUserID, currency, amount)}
+	case debitUserID != 0:
+		postings = []models.Posting{walletPosting(debitUserID, currency, amount.Neg()), systemPosting(ExternalCashInAccount, currency, amount)}
+	default:
+		postings = []models.Posting{systemPosting(ExternalCashOutAccount, currency, amount.Neg()), walletPosting(creditUserID, currency, amount)}
+	}
+
+	if debitUserID != 0 {
+		if err = s.WithdrawWithTx(ctx, tx, debitUserID, amount, currency); err != nil {
+			return 0, nil, err
+		}
+	}
+	if creditUserID != 0 {
+		if err = s.DepositWithTx(ctx, tx, creditUserID, amount, currency); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// 冲正流水的付款方为被扣款的一方
+	sender, receiver := debitUserID, creditUserID
+	if sender == 0 {
+		sender = creditUserID
+	}
+	if receiver == 0 {
+		receiver = debitUserID
+	}
+	reversalID, err := s.recordTransaction(ctx, tx, models.Transaction{
+		SenderUserID:          sender,
+		ReceiverUserID:        receiver,
+		TransactionType:       models.ReversalTransactionType,
+		Amount:                amount,
+		Currency:              currency,
+		OriginalTransactionID: &original.ID,
+	}, postings)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err = s.markFullyReversed(ctx, tx, original.ID); err != nil {
+		return 0, nil, err
+	}
+	feeRefund, err := s.refundFeesForReversal(ctx, tx, original)
+	if err != nil {
+		return 0, nil, err
+	}
+	return reversalID, feeRefund, nil
+}
+
+// markFullyReversed 累计冲正金额达到原交易金额时, 原交易迁移到 reversed
+func (s *walletService) markFullyReversed(ctx context.Context, tx *sqlx.Tx, transactionID int) error {
+	_, err := tx.Exec(`
+		UPDATE transactions SET status = $1, reversed_at = $2
+		WHERE id = $3 AND status = $4 AND amount <= (SELECT SUM(amount) FROM transactions WHERE original_transaction_id = $3 AND transaction_type = $5)`,
+		models.TransactionReversed, time.Now().UTC(), transactionID, models.TransactionCompleted, models.ReversalTransactionType)
+	if err != nil {
+		s.logger.Error(ctx, "markFullyReversed Failed to update original transaction status", zap.Int("transactionID", transactionID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ReversalWorker 定期重试排队中的冲正
+type ReversalWorker struct {
+	service *walletService
+}
+
+// NewReversalWorker new reversal worker
+func NewReversalWorker(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *ReversalWorker {
+	return &ReversalWorker{
+		service: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// ProcessPending 按排队顺序逐笔执行冲正, 对方余额仍不足的留待下次重试
+func (w *ReversalWorker) ProcessPending(ctx context.Context) error {
+	s := w.service
+	var ids []int
+	err := s.db.SelectContext(ctx, &ids, "SELECT id FROM pending_reversals WHERE status = $1 ORDER BY id LIMIT $2",
+		models.PendingReversalPending, pendingReversalBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = w.processOne(ctx, id); err != nil {
+			s.logger.Error(ctx, "ReversalWorker Failed to process pending reversal", zap.Int("pendingReversalID", id), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (w *ReversalWorker) processOne(ctx context.Context, id int) error {
+	s := w.service
+	return s.runInTx(ctx, "ProcessPendingReversal", func(tx *sqlx.Tx) error {
+		var pending models.PendingReversal
+		err := tx.Get(&pending, "SELECT * FROM pending_reversals WHERE id = $1 AND status = $2 FOR UPDATE SKIP LOCKED",
+			id, models.PendingReversalPending)
+		if errors.Is(err, sql.ErrNoRows) {
+			// 已被其他实例处理
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var original models.Transaction
+		err = tx.Get(&original, "SELECT * FROM transactions WHERE id = $1 FOR UPDATE", pending.OriginalTransactionID)
+		if err != nil {
+			return err
+		}
+
+		transactionID, _, err := s.applyReversal(ctx, tx, original, pending.Amount)
+		if errors.Is(err, ErrInsufficientFunds) {
+			_, err = tx.Exec("UPDATE pending_reversals SET attempts = attempts + 1 WHERE id = $1", id)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE pending_reversals SET status = $1, attempts = attempts + 1, transaction_id = $2 WHERE id = $3",
+			models.PendingReversalCompleted, transactionID, id)
+		if err != nil {
+			return err
+		}
+		s.logger.Info(ctx, "ReversalWorker completed pending reversal", zap.Int("pendingReversalID", id),
+			zap.Int("transactionID", transactionID))
+		return nil
+	})
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var transactionRowColumns = []string{"id", "sender_user_id", "receiver_user_id", "transaction_type", "amount", "currency", "fx_rate", "fx_quote_id", "original_transaction_id", "status", "created_at"}
+
+func TestWalletService_Reverse_PartialTransfer(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, mockRedis := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据: 原转账 100, 已退 30, 本次再退 50
+	senderID := 1
+	receiverID := 2
+	amount := decimal.NewFromInt(50)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, senderID, receiverID, "transfer", "100", "USD", nil, nil, nil, "completed", time.Now()))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\)`).
+		WithArgs(7, models.PendingReversalPending, models.ReversalTransactionType).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("30"))
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int{senderID, receiverID}), models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	// 收款方退回, 付款方入账
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, receiverID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("20"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(receiverID, senderID, models.ReversalTransactionType, amount, models.USD, nil, nil, 7, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	expectJournalEntry(mockDB, walletPosting(receiverID, models.USD, amount.Neg()), walletPosting(senderID, models.USD, amount))
+	// 部分退款, 原交易保持 completed
+	mockDB.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.TransactionReversed, sqlmock.AnyArg(), 7, models.TransactionCompleted, models.ReversalTransactionType).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// 原交易没有收取手续费
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE original_transaction_id = \$1 AND transaction_type = \$2`).
+		WithArgs(7, models.FeeTransactionType, models.TransactionCompleted).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns))
+	mockDB.ExpectCommit()
+
+	// 执行 Reverse 方法
+	result, err := service.Reverse(context.Background(), 7, amount, models.ReversalPolicyReject)
+
+	// 断言没有错误
+	assert.NoError(t, err)
+	assert.Equal(t, 12, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_Reverse_RefundsFeeProportionally(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 提现 100 收取手续费 1.5, 之前已退 30 并退还手续费 0.45, 本次再退 40: 累计应退 1.5 * 70 / 100 = 1.05, 本次退还 0.6
+	amount := decimal.NewFromInt(40)
+	fee := decimal.RequireFromString("0.6")
+	now := time.Now()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, 1, 1, "withdraw", "100", "USD", nil, nil, nil, "completed", now))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\)`).
+		WithArgs(7, models.PendingReversalPending, models.ReversalTransactionType).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("30"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.ReversalTransactionType, amount, models.USD, nil, nil, 7, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	expectJournalEntry(mockDB, systemPosting(ExternalCashOutAccount, models.USD, amount.Neg()), walletPosting(1, models.USD, amount))
+	mockDB.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.TransactionReversed, sqlmock.AnyArg(), 7, models.TransactionCompleted, models.ReversalTransactionType).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// 按累计冲正比例退还手续费
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE original_transaction_id = \$1 AND transaction_type = \$2`).
+		WithArgs(7, models.FeeTransactionType, models.TransactionCompleted).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(8, 1, 99, "fee", "1.5", "USD", nil, nil, 7, "completed", now))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\) FROM transactions WHERE original_transaction_id = \$1 AND transaction_type = \$2`).
+		WithArgs(7, models.ReversalTransactionType).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("70"))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\) FROM transactions WHERE original_transaction_id = \$1 AND transaction_type = \$2`).
+		WithArgs(8, models.ReversalTransactionType).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0.45"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(fee, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.ReversalTransactionType, fee, models.USD, nil, nil, 8, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(13))
+	expectJournalEntry(mockDB, systemPosting(FeeRevenueAccount, models.USD, fee.Neg()), walletPosting(1, models.USD, fee))
+	mockDB.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.TransactionReversed, sqlmock.AnyArg(), 8, models.TransactionCompleted, models.ReversalTransactionType).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectCommit()
+
+	result, err := service.Reverse(context.Background(), 7, amount, models.ReversalPolicyReject)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 12, result.TransactionID)
+	if assert.NotNil(t, result.FeeRefund) {
+		assert.Equal(t, 13, result.FeeRefund.TransactionID)
+		assert.True(t, fee.Equal(result.FeeRefund.Amount))
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_Reverse_ExceedsRemaining(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 原交易 100, 已退款和排队中的合计 80, 只能再退 20
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, 1, 2, "transfer", "100", "USD", nil, nil, nil, "completed", time.Now()))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\)`).
+		WithArgs(7, models.PendingReversalPending, models.ReversalTransactionType).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("80"))
+	mockDB.ExpectRollback()
+
+	result, err := service.Reverse(context.Background(), 7, decimal.NewFromInt(25), models.ReversalPolicyReject)
+
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrRefundExceedsRemaining)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Reverse_NotReversible(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 换汇的单边流水不能单独冲正
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, 1, 2, "exchange_in", "108.9", "USD", "1.089", "q-1", nil, "completed", time.Now()))
+	mockDB.ExpectRollback()
+
+	_, err = service.Reverse(context.Background(), 7, decimal.Zero, models.ReversalPolicyReject)
+
+	assert.ErrorIs(t, err, ErrNotReversible)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Reverse_QueueOnInsufficientFunds(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 存款全额冲正, 用户余额已不足: 排队等待后台任务执行
+	amount := decimal.NewFromInt(100)
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, 1, 1, "deposit", "100", "USD", nil, nil, nil, "completed", time.Now()))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\)`).
+		WithArgs(7, models.PendingReversalPending, models.ReversalTransactionType).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectQuery("INSERT INTO pending_reversals").
+		WithArgs(7, amount, models.PendingReversalPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mockDB.ExpectCommit()
+
+	result, err := service.Reverse(context.Background(), 7, decimal.Zero, models.ReversalPolicyQueue)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.PendingReversalID)
+	assert.Zero(t, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Reverse_RejectOnInsufficientFunds(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	amount := decimal.NewFromInt(100)
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, 1, 1, "deposit", "100", "USD", nil, nil, nil, "completed", time.Now()))
+	mockDB.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\)`).
+		WithArgs(7, models.PendingReversalPending, models.ReversalTransactionType).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectRollback()
+
+	_, err = service.Reverse(context.Background(), 7, decimal.Zero, models.ReversalPolicyReject)
+
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestReversalWorker_ProcessPending(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	worker := NewReversalWorker(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 排队的提现冲正: 退回用户钱包
+	amount := decimal.NewFromInt(40)
+	now := time.Now()
+
+	mockDB.ExpectQuery("SELECT id FROM pending_reversals").
+		WithArgs(models.PendingReversalPending, pendingReversalBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM pending_reversals WHERE id = \$1 AND status = \$2 FOR UPDATE SKIP LOCKED`).
+		WithArgs(3, models.PendingReversalPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "original_transaction_id", "amount", "status", "attempts", "transaction_id", "created_at", "updated_at"}).
+			AddRow(3, 7, "40", "pending", 1, nil, now, now))
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, 1, 1, "withdraw", "40", "USD", nil, nil, nil, "completed", now))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.ReversalTransactionType, amount, models.USD, nil, nil, 7, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	expectJournalEntry(mockDB, systemPosting(ExternalCashOutAccount, models.USD, amount.Neg()), walletPosting(1, models.USD, amount))
+	// 全额冲正后原交易迁移到 reversed
+	mockDB.ExpectExec("UPDATE transactions SET status").
+		WithArgs(models.TransactionReversed, sqlmock.AnyArg(), 7, models.TransactionCompleted, models.ReversalTransactionType).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE original_transaction_id = \$1 AND transaction_type = \$2`).
+		WithArgs(7, models.FeeTransactionType, models.TransactionCompleted).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns))
+	mockDB.ExpectExec("UPDATE pending_reversals SET status").
+		WithArgs(models.PendingReversalCompleted, 12, 3).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	err = worker.ProcessPending(context.Background())
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/config"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const (
+	defaultScheduleMaxRetries    = 3
+	defaultScheduleRetryInterval = time.Hour
+	scheduleBatchSize            = 100
+	// scheduleExecutionLease 执行中的租约, 到期未完成(如进程崩溃)时由其他实例接手
+	scheduleExecutionLease = 5 * time.Minute
+)
+
+var (
+	ErrScheduleNotFound  = errors.New("transfer schedule not found")
+	ErrInvalidSchedule   = errors.New("invalid transfer schedule")
+	ErrScheduleNotActive = errors.New("transfer schedule is not active")
+)
+
+type ScheduleService interface {
+	CreateSchedule(ctx context.Context, schedule models.TransferSchedule) (*models.TransferSchedule, error)
+	ListSchedules(ctx context.Context, userID int) ([]models.TransferSchedule, error)
+	CancelSchedule(ctx context.Context, scheduleID int) (*models.TransferSchedule, error)
+	ListExecutions(ctx context.Context, scheduleID int) ([]models.ScheduleExecution, error)
+}
+
+type scheduleService struct {
+	db     *sqlx.DB
+	logger *wallet_logger.Logger
+}
+
+var _ ScheduleService = &scheduleService{}
+
+// NewScheduleService service
+func NewScheduleService(logger *wallet_logger.Logger, db *sqlx.DB) ScheduleService {
+	return &scheduleService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func scheduleMaxRetries() int {
+	retries := config.GetConfig().Schedules.MaxRetries
+	if retries <= 0 {
+		return defaultScheduleMaxRetries
+	}
+	return retries
+}
+
+func scheduleRetryInterval() time.Duration {
+	seconds := config.GetConfig().Schedules.RetryIntervalSeconds
+	if seconds <= 0 {
+		return defaultScheduleRetryInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// CreateSchedule 创建定时转账, 未指定开始时间时立即执行第一次
+func (s *scheduleService) CreateSchedule(ctx context.Context, schedule models.TransferSchedule) (*models.TransferSchedule, error) {
+	if schedule.SenderUserID == schedule.ReceiverUserID {
+		return nil, fmt.Errorf("%w: sender and receiver are the same", ErrInvalidSchedule)
+	}
+	if !schedule.Amount.IsPositive() {
+		return nil, fmt.Errorf("%w: amount must be greater than zero", ErrInvalidSchedule)
+	}
+	if err := validateMoney(schedule.Amount, schedule.Currency); err != nil {
+		return nil, err
+	}
+	if !schedule.Frequency.Valid() {
+		return nil, fmt.Errorf("%w: unsupported frequency %q", ErrInvalidSchedule, schedule.Frequency)
+	}
+	if schedule.StartAt.IsZero() {
+		schedule.StartAt = time.Now()
+	}
+	if schedule.EndAt != nil && schedule.EndAt.Before(schedule.StartAt) {
+		return nil, fmt.Errorf("%w: end_at must not be before start_at", ErrInvalidSchedule)
+	}
+
+	err := s.db.GetContext(ctx, &schedule, `
+		INSERT INTO transfer_schedules (sender_user_id, receiver_user_id, amount, currency, frequency, start_at, end_at, next_run_at, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $6, $8) RETURNING *`,
+		schedule.SenderUserID, schedule.ReceiverUserID, schedule.Amount, schedule.Currency, schedule.Frequency,
+		schedule.StartAt, schedule.EndAt, models.ScheduleActive)
+	if err != nil {
+		s.logger.Error(ctx, "CreateSchedule Failed insert into transfer_schedules", zap.Int("senderID", schedule.SenderUserID), zap.Error(err))
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// ListSchedules 查询用户作为付款方的定时转账
+func (s *scheduleService) ListSchedules(ctx context.Context, userID int) ([]models.TransferSchedule, error) {
+	schedules := []models.TransferSchedule{}
+	err := s.db.SelectContext(ctx, &schedules, "SELECT * FROM transfer_schedules WHERE sender_user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		s.logger.Error(ctx, "ListSchedules Failed select from transfer_schedules", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// CancelSchedule 取消定时转账, 尚未执行的执行记录在同一条语句内一并取消
+func (s *scheduleService) CancelSchedule(ctx context.Context, scheduleID int) (*models.TransferSchedule, error) {
+	var schedule models.TransferSchedule
+	err := s.db.GetContext(ctx, &schedule, `
+		WITH cancelled AS (
+			UPDATE transfer_schedules SET status = $1, next_run_at = NULL WHERE id = $2 AND status = $3 RETURNING *
+		), executions AS (
+			UPDATE schedule_executions SET status = $4 WHERE schedule_id IN (SELECT id FROM cancelled) AND status = $5
+		)
+		SELECT * FROM cancelled`,
+		models.ScheduleCancelled, scheduleID, models.ScheduleActive, models.ExecutionCancelled, models.ExecutionPending)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 区分不存在和已结束
+		err = s.db.GetContext(ctx, &schedule, "SELECT * FROM transfer_schedules WHERE id = $1", scheduleID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScheduleNotFound
+		}
+		if err == nil {
+			err = fmt.Errorf("%w: schedule %d is %s", ErrScheduleNotActive, scheduleID, schedule.Status)
+		}
+	}
+	if err != nil {
+		s.logger.Error(ctx, "CancelSchedule Failed", zap.Int("scheduleID", scheduleID), zap.Error(err))
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// ListExecutions 查询定时转账的执行记录
+func (s *scheduleService) ListExecutions(ctx context.Context, scheduleID int) ([]models.ScheduleExecution, error) {
+	executions := []models.ScheduleExecution{}
+	err := s.db.SelectContext(ctx, &executions, "SELECT * FROM schedule_executions WHERE schedule_id = $1 ORDER BY scheduled_for DESC", scheduleID)
+	if err != nil {
+		s.logger.Error(ctx, "ListExecutions Failed select from schedule_executions", zap.Int("scheduleID", scheduleID), zap.Error(err))
+		return nil, err
+	}
+	return executions, nil
+}
+
+// ScheduleRunner 定期执行到期的定时转账
+type ScheduleRunner struct {
+	service *walletService
+}
+
+// NewScheduleRunner new schedule runner
+func NewScheduleRunner(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *ScheduleRunner {
+	return &ScheduleRunner{
+		service: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// RunDue 先为到期的定时转账生成执行记录, 再执行所有待执行的记录
+func (r *ScheduleRunner) RunDue(ctx context.Context) error {
+	if err := r.claimDueRuns(ctx); err != nil {
+		return err
+	}
+
+	s := r.service
+	var ids []int
+	err := s.db.SelectContext(ctx, &ids, "SELECT id FROM schedule_executions WHERE status = $1 AND next_attempt_at <= $2 ORDER BY id LIMIT $3",
+		models.ExecutionPending, time.Now(), scheduleBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = r.execute(ctx, id); err != nil {
+			s.logger.Error(ctx, "ScheduleRunner Failed to execute scheduled transfer", zap.Int("executionID", id), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// claimDueRuns 锁定到期的定时转账, 生成执行记录并推进下次执行时间; 多实例时 SKIP LOCKED 保证每次执行只生成一次
+func (r *ScheduleRunner) claimDueRuns(ctx context.Context) error {
+	s := r.service
+	return s.runInTx(ctx, "ClaimScheduledRuns", func(tx *sqlx.Tx) error {
+		var due []models.TransferSchedule
+		err := tx.Select(&due, "SELECT * FROM transfer_schedules WHERE status = $1 AND next_run_at <= $2 ORDER BY next_run_at LIMIT $3 FOR UPDATE SKIP LOCKED",
+			models.ScheduleActive, time.Now(), scheduleBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, schedule := range due {
+			_, err = tx.Exec(`
+				INSERT INTO schedule_executions (schedule_id, scheduled_for, status, next_attempt_at) VALUES ($1, $2, $3, $2)
+				ON CONFLICT (schedule_id, scheduled_for) DO NOTHING`,
+				schedule.ID, *schedule.NextRunAt, models.ExecutionPending)
+			if err != nil {
+				return err
+			}
+
+			// 停机期间错过的执行会在之后的轮次中逐次补上
+			var nextRunAt *time.Time
+			status := models.ScheduleCompleted
+			if next, ok := schedule.NextRun(); ok {
+				nextRunAt, status = &next, models.ScheduleActive
+			}
+			_, err = tx.Exec("UPDATE transfer_schedules SET runs = runs + 1, next_run_at = $1, status = $2 WHERE id = $3",
+				nextRunAt, status, schedule.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// execute 通过租约占用一条执行记录并调用 Transfer; 幂等键按执行记录生成, 租约过期后被重复执行也只会转账一次
+func (r *ScheduleRunner) execute(ctx context.Context, executionID int) error {
+	s := r.service
+	now := time.Now()
+	var execution models.ScheduleExecution
+	err := s.db.GetContext(ctx, &execution, `
+		UPDATE schedule_executions SET attempts = attempts + 1, next_attempt_at = $1
+		WHERE id = $2 AND status = $3 AND next_attempt_at <= $4 RETURNING *`,
+		now.Add(scheduleExecutionLease), executionID, models.ExecutionPending, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 已被其他实例占用
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var schedule models.TransferSchedule
+	if err = s.db.GetContext(ctx, &schedule, "SELECT * FROM transfer_schedules WHERE id = $1", execution.ScheduleID); err != nil {
+		return err
+	}
+
+	transferCtx := withInternalIdempotencyKey(ctx, fmt.Sprintf("schedule-execution:%d", execution.ID))
+	result, err := s.Transfer(transferCtx, schedule.SenderUserID, schedule.ReceiverUserID, schedule.Amount, schedule.Currency, models.TransactionDetails{})
+	switch {
+	case err == nil:
+		_, err = s.db.ExecContext(ctx, "UPDATE schedule_executions SET status = $1, transaction_id = $2, error = NULL WHERE id = $3",
+			models.ExecutionSucceeded, result.TransactionID, execution.ID)
+		return err
+	case errors.Is(err, ErrInsufficientFunds) && execution.Attempts <= scheduleMaxRetries():
+		// 余额不足按配置的间隔重试
+		_, err = s.db.ExecContext(ctx, "UPDATE schedule_executions SET error = $1, next_attempt_at = $2 WHERE id = $3",
+			err.Error(), time.Now().Add(scheduleRetryInterval()), execution.ID)
+		return err
+	case limitResetTime(err) != nil && execution.Attempts <= scheduleMaxRetries():
+		// 日/周/月限额超限时在用量重置后重试
+		_, err = s.db.ExecContext(ctx, "UPDATE schedule_executions SET error = $1, next_attempt_at = $2 WHERE id = $3",
+			err.Error(), *limitResetTime(err), execution.ID)
+		return err
+	case permanentTransferError(err):
+		_, err = s.db.ExecContext(ctx, "UPDATE schedule_executions SET status = $1, error = $2 WHERE id = $3",
+			models.ExecutionFailed, err.Error(), execution.ID)
+		return err
+	default:
+		// 其他错误视为临时故障, 租约到期后重试
+		return err
+	}
+}
+
+// limitResetTime 日/周/月限额超限时的用量重置时间; 其他错误及不会重置的单笔金额、余额上限返回 nil
+func limitResetTime(err error) *time.Time {
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		return limitErr.ResetsAt
+	}
+	return nil
+}
+
+// permanentTransferError 重试也不会成功的转账错误;
+// 内部幂等键不会与客户端的键冲突, ErrIdempotencyKeyConflict 不属于此类, 按临时故障重试
+func permanentTransferError(err error) bool {
+	return errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrWalletNotFound) || errors.Is(err, ErrUnsupportedCurrency) ||
+		errors.Is(err, ErrInvalidAmountPrecision) ||
+		errors.Is(err, ErrWalletFrozen) || errors.Is(err, ErrWalletClosed) || errors.Is(err, ErrLimitExceeded) ||
+		errors.Is(err, ErrApprovalRequired)
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var (
+	scheduleRowColumns  = []string{"id", "sender_user_id", "receiver_user_id", "amount", "currency", "frequency", "start_at", "end_at", "runs", "next_run_at", "status", "created_at", "updated_at"}
+	executionRowColumns = []string{"id", "schedule_id", "scheduled_for", "status", "attempts", "next_attempt_at", "transaction_id", "error", "created_at", "updated_at"}
+)
+
+func TestTransferSchedule_Occurrence(t *testing.T) {
+	// 每月 31 日: 小月取月末, 之后仍回到 31 日
+	start := time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)
+	monthly := models.TransferSchedule{Frequency: models.ScheduleMonthly, StartAt: start}
+	assert.Equal(t, time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC), monthly.Occurrence(1))
+	assert.Equal(t, time.Date(2026, time.March, 31, 9, 0, 0, 0, time.UTC), monthly.Occurrence(2))
+	assert.Equal(t, time.Date(2027, time.January, 31, 9, 0, 0, 0, time.UTC), monthly.Occurrence(12))
+
+	weekly := models.TransferSchedule{Frequency: models.ScheduleWeekly, StartAt: start}
+	assert.Equal(t, time.Date(2026, time.February, 14, 9, 0, 0, 0, time.UTC), weekly.Occurrence(2))
+
+	// 超过结束时间或一次性转账没有下一次
+	end := start.AddDate(0, 1, 0)
+	monthly.EndAt = &end
+	next, ok := monthly.NextRun()
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC), next)
+	monthly.Runs = 1
+	_, ok = monthly.NextRun()
+	assert.False(t, ok)
+	_, ok = models.TransferSchedule{Frequency: models.ScheduleOnce, StartAt: start}.NextRun()
+	assert.False(t, ok)
+}
+
+func TestScheduleService_CreateSchedule_Invalid(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewScheduleService(logger.NewLogger(), sqlx.NewDb(db, "postgres"))
+	ctx := context.Background()
+	valid := models.TransferSchedule{SenderUserID: 1, ReceiverUserID: 2, Amount: decimal.NewFromInt(100),
+		Currency: models.USD, Frequency: models.ScheduleMonthly}
+
+	// 付款方和收款方相同
+	schedule := valid
+	schedule.ReceiverUserID = 1
+	_, err = service.CreateSchedule(ctx, schedule)
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+
+	// 不支持的频率
+	schedule = valid
+	schedule.Frequency = "hourly"
+	_, err = service.CreateSchedule(ctx, schedule)
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+
+	// 结束时间早于开始时间
+	schedule = valid
+	schedule.StartAt = time.Now()
+	end := schedule.StartAt.Add(-time.Hour)
+	schedule.EndAt = &end
+	_, err = service.CreateSchedule(ctx, schedule)
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+
+	schedule = valid
+	schedule.Amount = decimal.RequireFromString("1.001")
+	_, err = service.CreateSchedule(ctx, schedule)
+	assert.ErrorIs(t, err, ErrInvalidAmountPrecision)
+}
+
+func TestScheduleService_CreateSchedule_Success(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewScheduleService(logger.NewLogger(), sqlx.NewDb(db, "postgres"))
+
+	start := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	amount := decimal.NewFromInt(100)
+	// 第一次执行时间即开始时间
+	mockDB.ExpectQuery("INSERT INTO transfer_schedules").
+		WithArgs(1, 42, amount, models.USD, models.ScheduleMonthly, start, nil, models.ScheduleActive).
+		WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+			AddRow(3, 1, 42, "100", "USD", "monthly", start, nil, 0, start, "active", start, start))
+
+	schedule, err := service.CreateSchedule(context.Background(), models.TransferSchedule{
+		SenderUserID:   1,
+		ReceiverUserID: 42,
+		Amount:         amount,
+		Currency:       models.USD,
+		Frequency:      models.ScheduleMonthly,
+		StartAt:        start,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, schedule.ID)
+	assert.Equal(t, start, *schedule.NextRunAt)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestScheduleService_CancelSchedule_NotActive(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewScheduleService(logger.NewLogger(), sqlx.NewDb(db, "postgres"))
+
+	now := time.Now()
+	mockDB.ExpectQuery(`WITH cancelled AS \(\s*UPDATE transfer_schedules SET status = \$1`).
+		WithArgs(models.ScheduleCancelled, 3, models.ScheduleActive, models.ExecutionCancelled, models.ExecutionPending).
+		WillReturnRows(sqlmock.NewRows(scheduleRowColumns))
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_schedules WHERE id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+			AddRow(3, 1, 42, "100", "USD", "once", now, nil, 1, nil, "completed", now, now))
+
+	_, err = service.CancelSchedule(context.Background(), 3)
+
+	assert.ErrorIs(t, err, ErrScheduleNotActive)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestScheduleRunner_RunDue_ClaimsAndExecutes(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, mockRedis := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	runner := NewScheduleRunner(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 准备测试数据: 每月 1 日向用户 42 转账 100
+	senderID := 1
+	receiverID := 42
+	amount := decimal.NewFromInt(100)
+	start := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	next := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+
+	// 生成执行记录并推进下次执行时间
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_schedules WHERE status = \$1 AND next_run_at <= \$2 (.+) FOR UPDATE SKIP LOCKED`).
+		WithArgs(models.ScheduleActive, sqlmock.AnyArg(), scheduleBatchSize).
+		WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+			AddRow(3, senderID, receiverID, "100", "USD", "monthly", start, nil, 0, start, "active", start, start))
+	mockDB.ExpectExec("INSERT INTO schedule_executions").
+		WithArgs(3, start, models.ExecutionPending).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec("UPDATE transfer_schedules SET runs = runs \\+ 1").
+		WithArgs(&next, models.ScheduleActive, 3).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	// 占用待执行记录
+	mockDB.ExpectQuery("SELECT id FROM schedule_executions").
+		WithArgs(models.ExecutionPending, sqlmock.AnyArg(), scheduleBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mockDB.ExpectQuery(`UPDATE schedule_executions SET attempts = attempts \+ 1`).
+		WithArgs(sqlmock.AnyArg(), 8, models.ExecutionPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(executionRowColumns).
+			AddRow(8, 3, start, "pending", 1, now.Add(scheduleExecutionLease), nil, nil, now, now))
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_schedules WHERE id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+			AddRow(3, senderID, receiverID, "100", "USD", "monthly", start, nil, 1, next, "active", start, now))
+
+	// 通过 Transfer 执行, 幂等键按执行记录生成
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs(internalIdempotencyScope, senderID, "schedule-execution:8", requestHash("transfer", senderID, receiverID, amount, models.USD), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int{senderID, receiverID}), models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("400"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, receiverID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	expectWalletLimits(mockDB, models.USD, receiverID)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(senderID, receiverID, models.TransferTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), walletPosting(receiverID, models.USD, amount))
+	mockDB.ExpectExec("UPDATE idempotency_keys SET status_code").
+		WithArgs(200, []byte(`{"transaction_id":20}`), internalIdempotencyScope, senderID, "schedule-execution:8").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	// 记录执行结果
+	mockDB.ExpectExec("UPDATE schedule_executions SET status").
+		WithArgs(models.ExecutionSucceeded, 20, 8).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = runner.RunDue(context.Background())
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+// expectInsufficientTransfer 期望一次因余额不足而回滚的转账
+func expectInsufficientTransfer(mockDB sqlmock.Sqlmock, senderID, receiverID int, amount decimal.Decimal) {
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectRollback()
+}
+
+func TestScheduleRunner_Execute_InsufficientFunds(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	runner := NewScheduleRunner(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	amount := decimal.NewFromInt(100)
+	now := time.Now()
+	scheduleRow := sqlmock.NewRows(scheduleRowColumns).
+		AddRow(3, 1, 42, "100", "USD", "monthly", now, nil, 1, now.AddDate(0, 1, 0), "active", now, now)
+
+	// 未超过重试次数: 保持 pending, 按重试间隔再次执行
+	mockDB.ExpectQuery(`UPDATE schedule_executions SET attempts = attempts \+ 1`).
+		WithArgs(sqlmock.AnyArg(), 8, models.ExecutionPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(executionRowColumns).
+			AddRow(8, 3, now, "pending", 1, now.Add(scheduleExecutionLease), nil, nil, now, now))
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_schedules WHERE id = \$1`).WithArgs(3).WillReturnRows(scheduleRow)
+	expectInsufficientTransfer(mockDB, 1, 42, amount)
+	mockDB.ExpectExec("UPDATE schedule_executions SET error = \\$1, next_attempt_at = \\$2").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 8).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = runner.execute(context.Background(), 8)
+	assert.NoError(t, err)
+
+	// 超过重试次数: 标记为失败
+	mockDB.ExpectQuery(`UPDATE schedule_executions SET attempts = attempts \+ 1`).
+		WithArgs(sqlmock.AnyArg(), 8, models.ExecutionPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(executionRowColumns).
+			AddRow(8, 3, now, "pending", defaultScheduleMaxRetries+1, now.Add(scheduleExecutionLease), nil, nil, now, now))
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_schedules WHERE id = \$1`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+			AddRow(3, 1, 42, "100", "USD", "monthly", now, nil, 1, now.AddDate(0, 1, 0), "active", now, now))
+	expectInsufficientTransfer(mockDB, 1, 42, amount)
+	mockDB.ExpectExec("UPDATE schedule_executions SET status = \\$1, error = \\$2").
+		WithArgs(models.ExecutionFailed, sqlmock.AnyArg(), 8).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = runner.execute(context.Background(), 8)
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestScheduleRunner_Execute_LimitExceeded(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	runner := NewScheduleRunner(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	amount := decimal.NewFromInt(100)
+	now := time.Now()
+	mockDB.ExpectQuery(`UPDATE schedule_executions SET attempts = attempts \+ 1`).
+		WithArgs(sqlmock.AnyArg(), 8, models.ExecutionPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(executionRowColumns).
+			AddRow(8, 3, now, "pending", 1, now.Add(scheduleExecutionLease), nil, nil, now, now))
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_schedules WHERE id = \$1`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+			AddRow(3, 1, 42, "100", "USD", "monthly", now, nil, 1, now.AddDate(0, 1, 0), "active", now, now))
+
+	// 累加后超出当日限额 150, 转账回滚
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1).AddRow(42))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("400"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, 42, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, 1, models.USD, "")
+	mockDB.ExpectQuery("FROM wallet_limits").
+		WillReturnRows(sqlmock.NewRows(walletLimitColumns).AddRow(1, "", nil, "150", nil, nil, nil, nil))
+	mockDB.ExpectQuery("INSERT INTO wallet_limit_usage").
+		WillReturnRows(sqlmock.NewRows([]string{"period", "outflow", "transfers"}).
+			AddRow("day", "200", 2).AddRow("week", "200", 2).AddRow("month", "200", 2))
+	mockDB.ExpectRollback()
+
+	// 保持 pending, 在当日用量重置后重试
+	mockDB.ExpectExec("UPDATE schedule_executions SET error = \\$1, next_attempt_at = \\$2").
+		WithArgs(sqlmock.AnyArg(), limitResets(now).Daily, 8).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = runner.execute(context.Background(), 8)
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestScheduleRunner_Execute_AlreadyClaimed(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	runner := NewScheduleRunner(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 其他实例已占用: 条件更新不返回行, 不执行转账
+	mockDB.ExpectQuery(`UPDATE schedule_executions SET attempts = attempts \+ 1`).
+		WithArgs(sqlmock.AnyArg(), 8, models.ExecutionPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(executionRowColumns))
+
+	err = runner.execute(context.Background(), 8)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,554 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const (
+	defaultApprovalTTL = 24 * time.Hour
+	approvalBatchSize  = 100
+)
+
+var (
+	ErrSharedWalletNotFound = errors.New("shared wallet not found")
+	ErrSharedWalletExists   = errors.New("shared wallet already exists")
+	ErrInvalidSharedWallet  = errors.New("invalid shared wallet")
+	ErrMemberNotFound       = errors.New("wallet member not found")
+	ErrNotPermitted         = errors.New("operation not permitted")
+	ErrApprovalNotFound     = errors.New("transfer approval not found")
+	ErrApprovalNotPending   = errors.New("transfer approval is not pending")
+	ErrAlreadyDecided       = errors.New("member has already decided")
+	ErrApprovalRequired     = errors.New("transfer requires approval")
+)
+
+type SharedWalletService interface {
+	CreateSharedWallet(ctx context.Context, wallet models.SharedWallet, ownerID int) (*models.SharedWallet, error)
+	ListMembers(ctx context.Context, userID int, currency models.Currency, actorID int) ([]models.WalletMember, error)
+	SetMember(ctx context.Context, userID int, currency models.Currency, actorID, memberID int, role models.MemberRole) (*models.WalletMember, error)
+	RemoveMember(ctx context.Context, userID int, currency models.Currency, actorID, memberID int) error
+	RequestTransfer(ctx context.Context, userID int, currency models.Currency, actorID, receiverID int, amount decimal.Decimal) (*models.SharedTransferResult, error)
+	ListApprovals(ctx context.Context, userID int, currency models.Currency, actorID int, status models.ApprovalStatus) ([]models.TransferApproval, error)
+	GetApproval(ctx context.Context, approvalID int) (*models.TransferApproval, error)
+	DecideApproval(ctx context.Context, approvalID, actorID int, decision models.Decision, reason string) (*models.TransferApproval, error)
+}
+
+type sharedWalletService struct {
+	db      *sqlx.DB
+	logger  *wallet_logger.Logger
+	wallets *walletService // 审批通过的转账走普通的 Transfer
+}
+
+var _ SharedWalletService = &sharedWalletService{}
+
+// NewSharedWalletService service
+func NewSharedWalletService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) SharedWalletService {
+	return newSharedWalletService(logger, db, redis)
+}
+
+func newSharedWalletService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *sharedWalletService {
+	return &sharedWalletService{
+		db:     db,
+		logger: logger,
+		wallets: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// CreateSharedWallet 把已有钱包设为共享钱包, ownerID 成为第一个 owner
+func (s *sharedWalletService) CreateSharedWallet(ctx context.Context, wallet models.SharedWallet, ownerID int) (*models.SharedWallet, error) {
+	if !wallet.Currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, wallet.Currency)
+	}
+	if wallet.RequiredApprovals < 1 {
+		return nil, fmt.Errorf("%w: required_approvals must be at least 1", ErrInvalidSharedWallet)
+	}
+	if wallet.ApprovalThreshold.IsNegative() {
+		return nil, fmt.Errorf("%w: approval_threshold must not be negative", ErrInvalidSharedWallet)
+	}
+	if !wallet.ApprovalThreshold.IsZero() {
+		if err := validateMoney(wallet.ApprovalThreshold, wallet.Currency); err != nil {
+			return nil, err
+		}
+	}
+	if wallet.ApprovalTTLSeconds < 0 {
+		return nil, fmt.Errorf("%w: approval_ttl_seconds must not be negative", ErrInvalidSharedWallet)
+	}
+	if wallet.ApprovalTTLSeconds == 0 {
+		wallet.ApprovalTTLSeconds = int(defaultApprovalTTL / time.Second)
+	}
+
+	err := s.wallets.runInTx(ctx, "CreateSharedWallet", func(tx *sqlx.Tx) error {
+		var status models.WalletStatus
+		err := tx.Get(&status, "SELECT status FROM wallets WHERE user_id = $1 AND currency = $2 FOR UPDATE", wallet.UserID, wallet.Currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status == models.WalletClosed {
+			return ErrWalletClosed
+		}
+
+		err = tx.Get(&wallet, `
+			INSERT INTO shared_wallets (user_id, currency, required_approvals, approval_threshold, approval_ttl_seconds)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id, currency) DO NOTHING RETURNING *`,
+			wallet.UserID, wallet.Currency, wallet.RequiredApprovals, wallet.ApprovalThreshold, wallet.ApprovalTTLSeconds)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSharedWalletExists
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO wallet_members (user_id, currency, member_user_id, role) VALUES ($1, $2, $3, $4)",
+			wallet.UserID, wallet.Currency, ownerID, models.MemberOwner)
+		return err
+	})
+	if err != nil {
+		s.logger.Error(ctx, "CreateSharedWallet Failed", zap.Int("userID", wallet.UserID), zap.Int("ownerID", ownerID), zap.Error(err))
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// memberRole 查询成员角色, 不是成员时返回 ErrNotPermitted
+func (s *sharedWalletService) memberRole(ctx context.Context, q sqlx.QueryerContext, userID int, currency models.Currency, memberID int) (models.MemberRole, error) {
+	var role models.MemberRole
+	err := sqlx.GetContext(ctx, q, &role, "SELECT role FROM wallet_members WHERE user_id = $1 AND currency = $2 AND member_user_id = $3",
+		userID, currency, memberID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: user %d is not a member", ErrNotPermitted, memberID)
+	}
+	return role, err
+}
+
+// lockSharedWallet 锁定共享钱包配置, 成员变更和审批在该锁下串行执行
+func (s *sharedWalletService) lockSharedWallet(tx *sqlx.Tx, userID int, currency models.Currency) (*models.SharedWallet, error) {
+	var wallet models.SharedWallet
+	err := tx.Get(&wallet, "SELECT * FROM shared_wallets WHERE user_id = $1 AND currency = $2 FOR UPDATE", userID, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSharedWalletNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// ListMembers 查询共享钱包的成员, 任何成员都可以查看
+func (s *sharedWalletService) ListMembers(ctx context.Context, userID int, currency models.Currency, actorID int) ([]models.WalletMember, error) {
+	if _, err := s.memberRole(ctx, s.db, userID, currency, actorID); err != nil {
+		return nil, err
+	}
+	members := []models.WalletMember{}
+	err := s.db.SelectContext(ctx, &members, "SELECT * FROM wallet_members WHERE user_id = $1 AND currency = $2 ORDER BY member_user_id",
+		userID, currency)
+	if err != nil {
+		s.logger.Error(ctx, "ListMembers Failed select from wallet_members", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return members, nil
+}
+
+// SetMember 添加成员或修改成员角色, 只有 owner 可以操作; 至少保留一个 owner
+func (s *sharedWalletService) SetMember(ctx context.Context, userID int, currency models.Currency, actorID, memberID int, role models.MemberRole) (*models.WalletMember, error) {
+	if !role.Valid() {
+		return nil, fmt.Errorf("%w: unsupported role %q", ErrInvalidSharedWallet, role)
+	}
+
+	var member models.WalletMember
+	err := s.wallets.runInTx(ctx, "SetMember", func(tx *sqlx.Tx) error {
+		if _, err := s.lockSharedWallet(tx, userID, currency); err != nil {
+			return err
+		}
+		if err := s.requireOwner(ctx, tx, userID, currency, actorID); err != nil {
+			return err
+		}
+		if role != models.MemberOwner {
+			if err := s.keepLastOwner(ctx, tx, userID, currency, memberID); err != nil {
+				return err
+			}
+		}
+
+		return tx.Get(&member, `
+			INSERT INTO wallet_members (user_id, currency, member_user_id, role) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, currency, member_user_id) DO UPDATE SET role = EXCLUDED.role RETURNING *`,
+			userID, currency, memberID, role)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "SetMember Failed", zap.Int("userID", userID), zap.Int("actorID", actorID), zap.Int("memberID", memberID), zap.Error(err))
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveMember 移除成员, 只有 owner 可以操作; 已记录的审批决定保留
+func (s *sharedWalletService) RemoveMember(ctx context.Context, userID int, currency models.Currency, actorID, memberID int) error {
+	err := s.wallets.runInTx(ctx, "RemoveMember", func(tx *sqlx.Tx) error {
+		if _, err := s.lockSharedWallet(tx, userID, currency); err != nil {
+			return err
+		}
+		if err := s.requireOwner(ctx, tx, userID, currency, actorID); err != nil {
+			return err
+		}
+		if err := s.keepLastOwner(ctx, tx, userID, currency, memberID); err != nil {
+			return err
+		}
+
+		res, err := tx.Exec("DELETE FROM wallet_members WHERE user_id = $1 AND currency = $2 AND member_user_id = $3", userID, currency, memberID)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if rowsAffected == 0 {
+			return fmt.Errorf("%w: %d", ErrMemberNotFound, memberID)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error(ctx, "RemoveMember Failed", zap.Int("userID", userID), zap.Int("actorID", actorID), zap.Int("memberID", memberID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *sharedWalletService) requireOwner(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency, actorID int) error {
+	role, err := s.memberRole(ctx, tx, userID, currency, actorID)
+	if err != nil {
+		return err
+	}
+	if role != models.MemberOwner {
+		return fmt.Errorf("%w: only owners can manage members", ErrNotPermitted)
+	}
+	return nil
+}
+
+// keepLastOwner memberID 是唯一的 owner 时不能移除或降级
+func (s *sharedWalletService) keepLastOwner(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency, memberID int) error {
+	var owners []int
+	err := tx.Select(&owners, "SELECT member_user_id FROM wallet_members WHERE user_id = $1 AND currency = $2 AND role = $3",
+		userID, currency, models.MemberOwner)
+	if err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0] == memberID {
+		return fmt.Errorf("%w: the last owner cannot be removed or demoted", ErrInvalidSharedWallet)
+	}
+	return nil
+}
+
+// RequestTransfer 成员从共享钱包发起转账: 不超过阈值时直接转账, 否则创建审批请求, 发起人的批准计入 M
+func (s *sharedWalletService) RequestTransfer(ctx context.Context, userID int, currency models.Currency, actorID, receiverID int, amount decimal.Decimal) (*models.SharedTransferResult, error) {
+	if err := validateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+	if receiverID == userID {
+		return nil, fmt.Errorf("%w: cannot transfer to the same wallet", ErrInvalidSharedWallet)
+	}
+
+	var approval *models.TransferApproval
+	err := s.wallets.runInTx(ctx, "RequestTransfer", func(tx *sqlx.Tx) error {
+		wallet, err := s.lockSharedWallet(tx, userID, currency)
+		if err != nil {
+			return err
+		}
+		role, err := s.memberRole(ctx, tx, userID, currency, actorID)
+		if err != nil {
+			return err
+		}
+		if !role.CanSpend() {
+			return fmt.Errorf("%w: %s cannot initiate transfers", ErrNotPermitted, role)
+		}
+		if amount.LessThanOrEqual(wallet.ApprovalThreshold) {
+			return nil
+		}
+
+		status := models.ApprovalPending
+		if wallet.RequiredApprovals <= 1 {
+			status = models.ApprovalApproved
+		}
+		approval = &models.TransferApproval{}
+		err = tx.Get(approval, `
+			INSERT INTO transfer_approvals (user_id, currency, initiator_user_id, receiver_user_id, amount, required_approvals, approvals, status, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, 1, $7, $8) RETURNING *`,
+			userID, currency, actorID, receiverID, amount, wallet.RequiredApprovals, status,
+			time.Now().Add(time.Duration(wallet.ApprovalTTLSeconds)*time.Second))
+		if err != nil {
+			return err
+		}
+		return s.recordDecision(tx, approval.ID, actorID, models.DecisionApprove, "")
+	})
+	if err != nil {
+		s.logger.Error(ctx, "RequestTransfer Failed", zap.Int("userID", userID), zap.Int("actorID", actorID), zap.Error(err))
+		return nil, err
+	}
+
+	if approval == nil {
+		result, err := s.wallets.Transfer(ctx, userID, receiverID, amount, currency, models.TransactionDetails{})
+		if err != nil {
+			return nil, err
+		}
+		return &models.SharedTransferResult{Transaction: result}, nil
+	}
+	if approval.Status == models.ApprovalApproved {
+		if approval, err = s.execute(ctx, approval.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &models.SharedTransferResult{Approval: approval}, nil
+}
+
+// recordDecision 记录成员的决定, 重复决定返回 ErrAlreadyDecided
+func (s *sharedWalletService) recordDecision(tx *sqlx.Tx, approvalID, memberID int, decision models.Decision, reason string) error {
+	var id int
+	err := tx.Get(&id, `
+		INSERT INTO approval_decisions (approval_id, member_user_id, decision, reason) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (approval_id, member_user_id) DO NOTHING RETURNING id`,
+		approvalID, memberID, decision, sql.NullString{String: reason, Valid: reason != ""})
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: user %d", ErrAlreadyDecided, memberID)
+	}
+	return err
+}
+
+// ListApprovals 查询共享钱包的审批请求, status 为空时返回所有状态
+func (s *sharedWalletService) ListApprovals(ctx context.Context, userID int, currency models.Currency, actorID int, status models.ApprovalStatus) ([]models.TransferApproval, error) {
+	if status != "" && !status.Valid() {
+		return nil, fmt.Errorf("%w: unsupported status %q", ErrInvalidSharedWallet, status)
+	}
+	if _, err := s.memberRole(ctx, s.db, userID, currency, actorID); err != nil {
+		return nil, err
+	}
+	approvals := []models.TransferApproval{}
+	err := s.db.SelectContext(ctx, &approvals, `
+		SELECT * FROM transfer_approvals WHERE user_id = $1 AND currency = $2 AND ($3 = '' OR status = $3) ORDER BY id DESC`,
+		userID, currency, string(status))
+	if err != nil {
+		s.logger.Error(ctx, "ListApprovals Failed select from transfer_approvals", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return approvals, nil
+}
+
+// GetApproval 查询审批请求及所有成员的决定
+func (s *sharedWalletService) GetApproval(ctx context.Context, approvalID int) (*models.TransferApproval, error) {
+	var approval models.TransferApproval
+	err := s.db.GetContext(ctx, &approval, "SELECT * FROM transfer_approvals WHERE id = $1", approvalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrApprovalNotFound, approvalID)
+	}
+	if err == nil {
+		err = s.db.SelectContext(ctx, &approval.Decisions, "SELECT * FROM approval_decisions WHERE approval_id = $1 ORDER BY id", approvalID)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "GetApproval Failed", zap.Int("approvalID", approvalID), zap.Error(err))
+		return nil, err
+	}
+	return &approval, nil
+}
+
+// DecideApproval 成员批准或拒绝审批请求: 批准数达到 M 时立即执行转账; 剩余成员全部批准也达不到 M 时请求被拒绝
+func (s *sharedWalletService) DecideApproval(ctx context.Context, approvalID, actorID int, decision models.Decision, reason string) (*models.TransferApproval, error) {
+	if decision != models.DecisionApprove && decision != models.DecisionReject {
+		return nil, fmt.Errorf("%w: unsupported decision %q", ErrInvalidSharedWallet, decision)
+	}
+
+	var approval models.TransferApproval
+	expired := false
+	err := s.wallets.runInTx(ctx, "DecideApproval", func(tx *sqlx.Tx) error {
+		err := tx.Get(&approval, "SELECT * FROM transfer_approvals WHERE id = $1", approvalID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrApprovalNotFound, approvalID)
+		}
+		if err != nil {
+			return err
+		}
+		// 先锁共享钱包再锁请求, 与成员变更的加锁顺序一致
+		if _, err = s.lockSharedWallet(tx, approval.UserID, approval.Currency); err != nil {
+			return err
+		}
+		if err = tx.Get(&approval, "SELECT * FROM transfer_approvals WHERE id = $1 FOR UPDATE", approvalID); err != nil {
+			return err
+		}
+		if approval.Status != models.ApprovalPending {
+			return fmt.Errorf("%w: approval %d is %s", ErrApprovalNotPending, approvalID, approval.Status)
+		}
+		if !time.Now().Before(approval.ExpiresAt) {
+			// 到期的请求在本次事务内标记为 expired, 提交后再返回错误
+			expired = true
+			return tx.Get(&approval, "UPDATE transfer_approvals SET status = $1 WHERE id = $2 RETURNING *", models.ApprovalExpired, approvalID)
+		}
+
+		role, err := s.memberRole(ctx, tx, approval.UserID, approval.Currency, actorID)
+		if err != nil {
+			return err
+		}
+		if !role.CanSpend() {
+			return fmt.Errorf("%w: %s cannot decide on transfers", ErrNotPermitted, role)
+		}
+		if err = s.recordDecision(tx, approvalID, actorID, decision, reason); err != nil {
+			return err
+		}
+
+		status := models.ApprovalPending
+		if decision == models.DecisionApprove {
+			approval.Approvals++
+			if approval.Approvals >= approval.RequiredApprovals {
+				status = models.ApprovalApproved
+			}
+		} else {
+			approval.Rejections++
+			// 可以审批的成员中, 还没拒绝的全部批准也达不到 M 时直接拒绝
+			var eligible int
+			err = tx.Get(&eligible, "SELECT COUNT(*) FROM wallet_members WHERE user_id = $1 AND currency = $2 AND role IN ($3, $4)",
+				approval.UserID, approval.Currency, models.MemberOwner, models.MemberSpender)
+			if err != nil {
+				return err
+			}
+			if eligible-approval.Rejections < approval.RequiredApprovals {
+				status = models.ApprovalRejected
+			}
+		}
+		return tx.Get(&approval, "UPDATE transfer_approvals SET approvals = $1, rejections = $2, status = $3 WHERE id = $4 RETURNING *",
+			approval.Approvals, approval.Rejections, status, approvalID)
+	})
+	if err == nil && expired {
+		err = fmt.Errorf("%w: approval %d expired at %s", ErrApprovalNotPending, approvalID, approval.ExpiresAt.Format(time.RFC3339))
+	}
+	if err != nil {
+		s.logger.Error(ctx, "DecideApproval Failed", zap.Int("approvalID", approvalID), zap.Int("actorID", actorID), zap.Error(err))
+		return nil, err
+	}
+
+	if approval.Status == models.ApprovalApproved {
+		return s.execute(ctx, approvalID)
+	}
+	return &approval, nil
+}
+
+// execute 执行已批准的转账: 锁定审批请求, 转账和状态更新在同一事务内提交, 重复执行也只会转账一次.
+// 余额不足等永久错误时标记为 failed; 临时故障时保持 approved, 由 ApprovalWorker 重试
+func (s *sharedWalletService) execute(ctx context.Context, approvalID int) (*models.TransferApproval, error) {
+	var approval models.TransferApproval
+	err := s.wallets.runInTx(ctx, "executeApproval", func(tx *sqlx.Tx) error {
+		if err := tx.Get(&approval, "SELECT * FROM transfer_approvals WHERE id = $1 FOR UPDATE", approvalID); err != nil {
+			return err
+		}
+		// 已被其他请求或 ApprovalWorker 执行
+		if approval.Status != models.ApprovalApproved {
+			return nil
+		}
+
+		result, err := s.wallets.transferWithTx(withApprovedTransfer(ctx), tx, approval.UserID, approval.ReceiverUserID, approval.Amount,
+			approval.Currency, models.TransactionDetails{})
+		if err != nil {
+			return err
+		}
+		return tx.Get(&approval, "UPDATE transfer_approvals SET status = $1, transaction_id = $2, error = NULL WHERE id = $3 RETURNING *",
+			models.ApprovalExecuted, result.TransactionID, approval.ID)
+	})
+	if permanentTransferError(err) {
+		// 转账已回滚, 只记录失败原因
+		err = s.db.GetContext(ctx, &approval, "UPDATE transfer_approvals SET status = $1, error = $2 WHERE id = $3 AND status = $4 RETURNING *",
+			models.ApprovalFailed, err.Error(), approvalID, models.ApprovalApproved)
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.GetApproval(ctx, approvalID)
+		}
+	}
+	if err != nil {
+		s.logger.Error(ctx, "execute Failed to execute approved transfer", zap.Int("approvalID", approvalID), zap.Error(err))
+		return nil, err
+	}
+	return &approval, nil
+}
+
+type approvedTransferKey struct{}
+
+// withApprovedTransfer 标记审批通过后执行的付款, 不再按共享钱包的阈值拦截
+func withApprovedTransfer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, approvedTransferKey{}, true)
+}
+
+// approvalThreshold 查询共享钱包的审批阈值, 不是共享钱包时返回 nil
+func (s *walletService) approvalThreshold(ctx context.Context, q sqlx.Queryer, userID int, currency models.Currency) (*decimal.Decimal, error) {
+	var threshold decimal.Decimal
+	err := sqlx.Get(q, &threshold, "SELECT approval_threshold FROM shared_wallets WHERE user_id = $1 AND currency = $2", userID, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		s.logger.Error(ctx, "approvalThreshold Failed select from shared_wallets", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return &threshold, nil
+}
+
+// checkApprovalThreshold 共享钱包超过阈值的付款必须通过 RequestTransfer 审批
+func checkApprovalThreshold(threshold *decimal.Decimal, amount decimal.Decimal) error {
+	if threshold != nil && amount.GreaterThan(*threshold) {
+		return fmt.Errorf("%w: amount %s exceeds the approval threshold %s", ErrApprovalRequired, amount, threshold)
+	}
+	return nil
+}
+
+// requireApproval 共享钱包的付款(转账、提现、换汇、托管、预授权扣款、出款)超过阈值且未经审批时返回 ErrApprovalRequired
+func (s *walletService) requireApproval(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency, amount decimal.Decimal) error {
+	if approved, _ := ctx.Value(approvedTransferKey{}).(bool); approved {
+		return nil
+	}
+	threshold, err := s.approvalThreshold(ctx, tx, userID, currency)
+	if err != nil {
+		return err
+	}
+	return checkApprovalThreshold(threshold, amount)
+}
+
+// ApprovalWorker 定期把到期未批准的请求标记为 expired, 并重试执行因临时故障未完成的已批准请求
+type ApprovalWorker struct {
+	service *sharedWalletService
+}
+
+// NewApprovalWorker new approval worker
+func NewApprovalWorker(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *ApprovalWorker {
+	return &ApprovalWorker{service: newSharedWalletService(logger, db, redis)}
+}
+
+// Run 处理到期和待执行的审批请求
+func (w *ApprovalWorker) Run(ctx context.Context) error {
+	s := w.service
+	res, err := s.db.ExecContext(ctx, "UPDATE transfer_approvals SET status = $1 WHERE status = $2 AND expires_at <= $3",
+		models.ApprovalExpired, models.ApprovalPending, time.Now())
+	if err != nil {
+		return err
+	}
+	if expired, _ := res.RowsAffected(); expired > 0 {
+		s.logger.Info(ctx, "ApprovalWorker expired transfer approvals", zap.Int64("approvals", expired))
+	}
+
+	var ids []int
+	err = s.db.SelectContext(ctx, &ids, "SELECT id FROM transfer_approvals WHERE status = $1 ORDER BY id LIMIT $2", models.ApprovalApproved, approvalBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err = s.execute(ctx, id); err != nil {
+			s.logger.Error(ctx, "ApprovalWorker Failed to execute approved transfer", zap.Int("approvalID", id), zap.Error(err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var (
+	sharedWalletRowColumns = []string{"user_id", "currency", "required_approvals", "approval_threshold", "approval_ttl_seconds", "created_at", "updated_at"}
+	approvalRowColumns     = []string{"id", "user_id", "currency", "initiator_user_id", "receiver_user_id", "amount", "required_approvals",
+		"approvals", "rejections", "status", "expires_at", "transaction_id", "error", "created_at", "updated_at"}
+)
+
+func newTestSharedWalletService(t *testing.T) (*sharedWalletService, sqlmock.Sqlmock, redismock.ClientMock) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return newSharedWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client), mockDB, mockRedis
+}
+
+// expectSharedWallet 期望锁定共享钱包 100: 需要 2 个批准, 超过 50 的转账需要审批
+func expectSharedWallet(mockDB sqlmock.Sqlmock) {
+	now := time.Now()
+	mockDB.ExpectQuery(`SELECT \* FROM shared_wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(100, models.USD).
+		WillReturnRows(sqlmock.NewRows(sharedWalletRowColumns).AddRow(100, "USD", 2, "50", 3600, now, now))
+}
+
+// expectApprovalThreshold 期望付款前查询共享钱包的审批阈值, threshold 为空表示不是共享钱包
+func expectApprovalThreshold(mockDB sqlmock.Sqlmock, userID int, currency models.Currency, threshold string) {
+	rows := sqlmock.NewRows([]string{"approval_threshold"})
+	if threshold != "" {
+		rows.AddRow(threshold)
+	}
+	mockDB.ExpectQuery(`SELECT approval_threshold FROM shared_wallets WHERE user_id = \$1 AND currency = \$2`).
+		WithArgs(userID, currency).
+		WillReturnRows(rows)
+}
+
+func expectMemberRole(mockDB sqlmock.Sqlmock, memberID int, role models.MemberRole) {
+	mockDB.ExpectQuery("SELECT role FROM wallet_members").
+		WithArgs(100, models.USD, memberID).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(role))
+}
+
+// expectSharedTransfer 期望在调用方的事务内从共享钱包 100 向 receiverID 转账一次; approved 为审批通过后的执行, 不再校验阈值
+func expectSharedTransfer(mockDB sqlmock.Sqlmock, approved bool, receiverID int, amount decimal.Decimal, transactionID int) {
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int{receiverID, 100}), models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(receiverID).AddRow(100))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, 100, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("400"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(amount, receiverID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if !approved {
+		expectApprovalThreshold(mockDB, 100, models.USD, "50")
+	}
+	expectWalletLimits(mockDB, models.USD, 100)
+	expectWalletLimits(mockDB, models.USD, receiverID)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(100, receiverID, models.TransferTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(transactionID))
+	expectJournalEntry(mockDB, walletPosting(100, models.USD, amount.Neg()), walletPosting(receiverID, models.USD, amount))
+}
+
+func TestSharedWalletService_RequestTransfer_BelowThreshold(t *testing.T) {
+	service, mockDB, mockRedis := newTestSharedWalletService(t)
+
+	// 不超过阈值的转账由 spender 直接发起
+	amount := decimal.NewFromInt(50)
+	mockDB.ExpectBegin()
+	expectSharedWallet(mockDB)
+	expectMemberRole(mockDB, 7, models.MemberSpender)
+	mockDB.ExpectCommit()
+	mockDB.ExpectBegin()
+	expectSharedTransfer(mockDB, false, 3, amount, 20)
+	mockDB.ExpectCommit()
+
+	result, err := service.RequestTransfer(context.Background(), 100, models.USD, 7, 3, amount)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 20, result.Transaction.TransactionID)
+	assert.Nil(t, result.Approval)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_RequestTransfer_NeedsApproval(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	// 超过阈值: 创建审批请求, 发起人的批准计入
+	amount := decimal.NewFromInt(80)
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	mockDB.ExpectBegin()
+	expectSharedWallet(mockDB)
+	expectMemberRole(mockDB, 7, models.MemberSpender)
+	mockDB.ExpectQuery("INSERT INTO transfer_approvals").
+		WithArgs(100, models.USD, 7, 3, amount, 2, models.ApprovalPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 1, 0, "pending", expiresAt, nil, nil, now, now))
+	mockDB.ExpectQuery("INSERT INTO approval_decisions").
+		WithArgs(9, 7, models.DecisionApprove, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mockDB.ExpectCommit()
+
+	result, err := service.RequestTransfer(context.Background(), 100, models.USD, 7, 3, amount)
+
+	assert.NoError(t, err)
+	assert.Nil(t, result.Transaction)
+	assert.Equal(t, models.ApprovalPending, result.Approval.Status)
+	assert.Equal(t, 1, result.Approval.Approvals)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_RequestTransfer_ViewerNotPermitted(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	mockDB.ExpectBegin()
+	expectSharedWallet(mockDB)
+	expectMemberRole(mockDB, 8, models.MemberViewer)
+	mockDB.ExpectRollback()
+
+	_, err := service.RequestTransfer(context.Background(), 100, models.USD, 8, 3, decimal.NewFromInt(10))
+
+	assert.ErrorIs(t, err, ErrNotPermitted)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// expectPendingApproval 期望读取并锁定待审批的请求 9
+func expectPendingApproval(mockDB sqlmock.Sqlmock, expiresAt time.Time) {
+	now := time.Now()
+	row := func() *sqlmock.Rows {
+		return sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 1, 0, "pending", expiresAt, nil, nil, now, now)
+	}
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_approvals WHERE id = \$1$`).WithArgs(9).WillReturnRows(row())
+	expectSharedWallet(mockDB)
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_approvals WHERE id = \$1 FOR UPDATE`).WithArgs(9).WillReturnRows(row())
+}
+
+func TestWalletService_Withdraw_SharedWalletNeedsApproval(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	// 直接调用钱包接口从共享钱包付款, 超过阈值 50 时回滚, 需通过 RequestTransfer 审批
+	amount := decimal.NewFromInt(80)
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, 100, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("420"))
+	expectApprovalThreshold(mockDB, 100, models.USD, "50")
+	mockDB.ExpectRollback()
+
+	_, err := service.wallets.Withdraw(context.Background(), 100, 0, amount, models.USD, "", models.TransactionDetails{})
+
+	assert.ErrorIs(t, err, ErrApprovalRequired)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_DecideApproval_ExecutesOnQuorum(t *testing.T) {
+	service, mockDB, mockRedis := newTestSharedWalletService(t)
+
+	amount := decimal.NewFromInt(80)
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	mockDB.ExpectBegin()
+	expectPendingApproval(mockDB, expiresAt)
+	expectMemberRole(mockDB, 5, models.MemberOwner)
+	mockDB.ExpectQuery("INSERT INTO approval_decisions").
+		WithArgs(9, 5, models.DecisionApprove, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mockDB.ExpectQuery("UPDATE transfer_approvals SET approvals = \\$1, rejections = \\$2, status = \\$3").
+		WithArgs(2, 0, models.ApprovalApproved, 9).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 2, 0, "approved", expiresAt, nil, nil, now, now))
+	mockDB.ExpectCommit()
+
+	// 达到 M 后锁定审批请求, 转账和标记为 executed 在同一事务内提交
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_approvals WHERE id = \$1 FOR UPDATE`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 2, 0, "approved", expiresAt, nil, nil, now, now))
+	expectSharedTransfer(mockDB, true, 3, amount, 20)
+	mockDB.ExpectQuery("UPDATE transfer_approvals SET status = \\$1, transaction_id = \\$2").
+		WithArgs(models.ApprovalExecuted, 20, 9).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 2, 0, "executed", expiresAt, 20, nil, now, now))
+	mockDB.ExpectCommit()
+
+	approval, err := service.DecideApproval(context.Background(), 9, 5, models.DecisionApprove, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ApprovalExecuted, approval.Status)
+	assert.Equal(t, 20, *approval.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_DecideApproval_RejectedWhenUnreachable(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	// 只有 2 个可以审批的成员, 一个拒绝后达不到 M = 2
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	mockDB.ExpectBegin()
+	expectPendingApproval(mockDB, expiresAt)
+	expectMemberRole(mockDB, 5, models.MemberOwner)
+	mockDB.ExpectQuery("INSERT INTO approval_decisions").
+		WithArgs(9, 5, models.DecisionReject, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mockDB.ExpectQuery(`SELECT COUNT\(\*\) FROM wallet_members`).
+		WithArgs(100, models.USD, models.MemberOwner, models.MemberSpender).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mockDB.ExpectQuery("UPDATE transfer_approvals SET approvals = \\$1, rejections = \\$2, status = \\$3").
+		WithArgs(1, 1, models.ApprovalRejected, 9).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 1, 1, "rejected", expiresAt, nil, nil, now, now))
+	mockDB.ExpectCommit()
+
+	approval, err := service.DecideApproval(context.Background(), 9, 5, models.DecisionReject, "too much")
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ApprovalRejected, approval.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_DecideApproval_Expired(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	// 到期的请求标记为 expired 并提交, 不记录决定
+	now := time.Now()
+	expiresAt := now.Add(-time.Minute)
+	mockDB.ExpectBegin()
+	expectPendingApproval(mockDB, expiresAt)
+	mockDB.ExpectQuery("UPDATE transfer_approvals SET status = \\$1 WHERE id = \\$2").
+		WithArgs(models.ApprovalExpired, 9).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 1, 0, "expired", expiresAt, nil, nil, now, now))
+	mockDB.ExpectCommit()
+
+	_, err := service.DecideApproval(context.Background(), 9, 5, models.DecisionApprove, "")
+
+	assert.ErrorIs(t, err, ErrApprovalNotPending)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_RemoveMember_LastOwner(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	mockDB.ExpectBegin()
+	expectSharedWallet(mockDB)
+	expectMemberRole(mockDB, 5, models.MemberOwner)
+	mockDB.ExpectQuery("SELECT member_user_id FROM wallet_members").
+		WithArgs(100, models.USD, models.MemberOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"member_user_id"}).AddRow(5))
+	mockDB.ExpectRollback()
+
+	err := service.RemoveMember(context.Background(), 100, models.USD, 5, 5)
+
+	assert.ErrorIs(t, err, ErrInvalidSharedWallet)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestApprovalWorker_Run_ExpiresPending(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	worker := NewApprovalWorker(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	mockDB.ExpectExec("UPDATE transfer_approvals SET status = \\$1 WHERE status = \\$2 AND expires_at <= \\$3").
+		WithArgs(models.ApprovalExpired, models.ApprovalPending, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectQuery("SELECT id FROM transfer_approvals WHERE status = \\$1").
+		WithArgs(models.ApprovalApproved, approvalBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	err = worker.Run(context.Background())
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_Execute_AlreadyExecuted(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	// 并发执行时后到者在审批请求的行锁上等待, 看到已执行后不再转账
+	now := time.Now()
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_approvals WHERE id = \$1 FOR UPDATE`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 2, 0, "executed", now.Add(time.Hour), 20, nil, now, now))
+	mockDB.ExpectCommit()
+
+	approval, err := service.execute(context.Background(), 9)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ApprovalExecuted, approval.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSharedWalletService_Execute_InsufficientFunds(t *testing.T) {
+	service, mockDB, _ := newTestSharedWalletService(t)
+
+	// 余额不足时转账回滚, 审批请求单独标记为 failed
+	amount := decimal.NewFromInt(80)
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transfer_approvals WHERE id = \$1 FOR UPDATE`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 2, 0, "approved", expiresAt, nil, nil, now, now))
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int{3, 100}), models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3).AddRow(100))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, 100, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(100, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectRollback()
+	mockDB.ExpectQuery(`UPDATE transfer_approvals SET status = \$1, error = \$2`).
+		WithArgs(models.ApprovalFailed, sqlmock.AnyArg(), 9, models.ApprovalApproved).
+		WillReturnRows(sqlmock.NewRows(approvalRowColumns).
+			AddRow(9, 100, "USD", 7, 3, "80", 2, 2, 0, "failed", expiresAt, nil, "insufficient funds", now, now))
+
+	approval, err := service.execute(context.Background(), 9)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ApprovalFailed, approval.Status)
+	assert.Nil(t, approval.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+	wallet_logger "wallet-service/pkg/logger"
+)
+
+const (
+	// statementMaxPeriod 单次生成对账单的最长期间
+	statementMaxPeriod = 366 * 24 * time.Hour
+	statementBatchSize = 100
+)
+
+var ErrInvalidStatement = errors.New("invalid statement period")
+
+// GetStatement 查询 [from, to) 期间的对账单: 期初余额、每笔交易及交易后的余额、期末余额; 整月的期间优先返回预生成的月结对账单
+func (s *walletService) GetStatement(ctx context.Context, userID int, currency models.Currency, from, to time.Time) (*models.Statement, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidStatement)
+	}
+	if to.After(time.Now()) {
+		return nil, fmt.Errorf("%w: to must not be in the future", ErrInvalidStatement)
+	}
+	if to.Sub(from) > statementMaxPeriod {
+		return nil, fmt.Errorf("%w: period must not exceed 366 days", ErrInvalidStatement)
+	}
+	// 时间字段不带时区, 统一按 UTC 比较
+	from, to = from.UTC(), to.UTC()
+
+	var exists bool
+	err := s.db.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM wallets WHERE user_id = $1 AND currency = $2)", userID, currency)
+	if err != nil {
+		s.logger.Error(ctx, "GetStatement Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWalletNotFound
+	}
+
+	var statement models.Statement
+	err = s.db.GetContext(ctx, &statement, "SELECT * FROM statements WHERE user_id = $1 AND currency = $2 AND period_start = $3 AND period_end = $4",
+		userID, currency, from, to)
+	if err == nil {
+		return &statement, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Error(ctx, "GetStatement Failed select from statements", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return s.buildStatement(ctx, userID, currency, from, to)
+}
+
+// buildStatement 按分录生成对账单, 每条影响钱包余额的分录为一行; 期初余额 + 各行金额 = 期末余额
+func (s *walletService) buildStatement(ctx context.Context, userID int, currency models.Currency, from, to time.Time) (*models.Statement, error) {
+	// 时间精度为微秒, from 之前的余额即 from 前一微秒的余额
+	opening, err := s.ledgerBalanceAt(ctx, userID, currency, from.Add(-time.Microsecond))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := models.StatementLines{}
+	err = s.db.SelectContext(ctx, &lines, `
+		SELECT MIN(p.created_at) AS time, je.transaction_id, COALESCE(t.transaction_type, '') AS transaction_type,
+		       CASE WHEN t.sender_user_id = $2 THEN NULLIF(t.receiver_user_id, $2) ELSE t.sender_user_id END AS counterparty_user_id,
+		       COALESCE(t.memo, je.description) AS description, t.external_reference, SUM(p.amount) AS amount
+		FROM postings p
+		JOIN journal_entries je ON je.id = p.journal_entry_id
+		LEFT JOIN transactions t ON t.id = je.transaction_id
+		WHERE p.account_code = $1 AND p.created_at >= $3 AND p.created_at < $4
+		GROUP BY je.id, t.id
+		HAVING SUM(p.amount) <> 0
+		ORDER BY MIN(p.created_at), je.id`,
+		WalletAccountCode(userID, currency), userID, from, to)
+	if err != nil {
+		s.logger.Error(ctx, "buildStatement Failed select from postings", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	statement := &models.Statement{
+		UserID:         userID,
+		Currency:       currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening,
+		ClosingBalance: opening,
+		Lines:          lines,
+		GeneratedAt:    time.Now().UTC(),
+	}
+	for i := range statement.Lines {
+		line := &statement.Lines[i]
+		statement.ClosingBalance = statement.ClosingBalance.Add(line.Amount)
+		line.Balance = statement.ClosingBalance
+		if line.Amount.IsPositive() {
+			statement.TotalIn = statement.TotalIn.Add(line.Amount)
+		} else {
+			statement.TotalOut = statement.TotalOut.Sub(line.Amount)
+		}
+	}
+	return statement, nil
+}
+
+// StatementGenerator 每月初为所有钱包预生成上个月的对账单
+type StatementGenerator struct {
+	service *walletService
+}
+
+// NewStatementGenerator new statement generator
+func NewStatementGenerator(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) *StatementGenerator {
+	return &StatementGenerator{
+		service: &walletService{
+			db:     db,
+			redis:  redis,
+			logger: logger,
+		},
+	}
+}
+
+// Run 生成上个月(UTC)的月结对账单; 已生成的钱包跳过, 中断或失败的钱包下次运行时继续
+func (g *StatementGenerator) Run(ctx context.Context) error {
+	now := time.Now()
+	_, _, month := limitPeriods(now)
+	// 与余额快照一样等待月初之前开始的事务提交
+	if now.Sub(month) < balanceSnapshotDelay {
+		return nil
+	}
+	return g.generateMonth(ctx, month.AddDate(0, -1, 0), month)
+}
+
+// generateMonth 按 (user_id, currency) 顺序分批生成, 单个钱包失败不影响其他钱包
+func (g *StatementGenerator) generateMonth(ctx context.Context, from, to time.Time) error {
+	s := g.service
+	lastUserID, lastCurrency := 0, models.Currency("")
+	generated := 0
+	for {
+		// 期间内已注销的钱包仍生成最后一个月的对账单
+		var wallets []struct {
+			UserID   int             `db:"user_id"`
+			Currency models.Currency `db:"currency"`
+		}
+		err := s.db.SelectContext(ctx, &wallets, `
+			SELECT w.user_id, w.currency FROM wallets w
+			WHERE w.created_at < $2 AND (w.status <> $3 OR w.updated_at >= $1) AND (w.user_id, w.currency) > ($4, $5)
+			  AND NOT EXISTS (
+			      SELECT 1 FROM statements st
+			      WHERE st.user_id = w.user_id AND st.currency = w.currency AND st.period_start = $1 AND st.period_end = $2)
+			ORDER BY w.user_id, w.currency LIMIT $6`,
+			from, to, models.WalletClosed, lastUserID, lastCurrency, statementBatchSize)
+		if err != nil {
+			s.logger.Error(ctx, "StatementGenerator Failed select from wallets", zap.Time("from", from), zap.Error(err))
+			return err
+		}
+		if len(wallets) == 0 {
+			break
+		}
+
+		for _, w := range wallets {
+			lastUserID, lastCurrency = w.UserID, w.Currency
+			statement, err := s.buildStatement(ctx, w.UserID, w.Currency, from, to)
+			if err == nil {
+				_, err = s.db.NamedExecContext(ctx, `
+					INSERT INTO statements (user_id, currency, period_start, period_end, opening_balance, closing_balance, total_in, total_out, lines, generated_at)
+					VALUES (:user_id, :currency, :period_start, :period_end, :opening_balance, :closing_balance, :total_in, :total_out, :lines, :generated_at)
+					ON CONFLICT DO NOTHING`, statement)
+			}
+			if err != nil {
+				s.logger.Error(ctx, "StatementGenerator Failed to generate statement", zap.Int("userID", w.UserID),
+					zap.String("currency", string(w.Currency)), zap.Time("from", from), zap.Error(err))
+				continue
+			}
+			generated++
+		}
+	}
+	if generated > 0 {
+		s.logger.Info(ctx, "StatementGenerator generated statements", zap.Time("from", from), zap.Int("wallets", generated))
+	}
+	return nil
+}
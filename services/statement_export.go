@@ -0,0 +1,105 @@
+package services
+
+import (
+	"encoding/csv"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"wallet-service/models"
+)
+
+// statementTimeLayout 对账单中的时间格式, 均为 UTC
+const statementTimeLayout = "2006-01-02 15:04:05"
+
+var statementCSVHeader = []string{"time", "transaction_id", "type", "counterparty_user_id", "description", "external_reference", "amount", "balance"}
+
+// WriteStatementCSV 导出 CSV: 首行为期初余额, 末行为期末余额, 中间每行一笔交易及交易后的余额
+func WriteStatementCSV(w io.Writer, statement *models.Statement) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{
+		statementCSVHeader,
+		{statement.From.Format(statementTimeLayout), "", "", "", "opening balance", "", "", statement.OpeningBalance.String()},
+	}
+	for _, line := range statement.Lines {
+		rows = append(rows, []string{
+			line.Time.Format(statementTimeLayout),
+			optionalInt(line.TransactionID),
+			string(line.TransactionType),
+			optionalInt(line.CounterpartyID),
+			csvText(line.Description),
+			csvText(optionalString(line.ExternalReference)),
+			line.Amount.String(),
+			line.Balance.String(),
+		})
+	}
+	rows = append(rows, []string{statement.To.Format(statementTimeLayout), "", "", "", "closing balance", "", "", statement.ClosingBalance.String()})
+	// WriteAll 写完后 Flush 并返回写入的错误
+	return cw.WriteAll(rows)
+}
+
+var statementHTMLTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"formatTime":     func(t time.Time) string { return t.Format(statementTimeLayout) },
+	"optionalInt":    optionalInt,
+	"optionalString": optionalString,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Statement {{.UserID}} {{.Currency}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.amount { text-align: right; font-family: monospace; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Account statement</h1>
+<p>User {{.UserID}}, {{.Currency}}<br>
+Period {{formatTime .From}} – {{formatTime .To}} (UTC, end exclusive)<br>
+Generated at {{formatTime .GeneratedAt}}</p>
+<table>
+<tr><th>Opening balance</th><td class="amount">{{.OpeningBalance}}</td></tr>
+<tr><th>Total in</th><td class="amount">{{.TotalIn}}</td></tr>
+<tr><th>Total out</th><td class="amount">{{.TotalOut}}</td></tr>
+<tr><th>Closing balance</th><td class="amount">{{.ClosingBalance}}</td></tr>
+</table>
+<h2>Transactions</h2>
+<table>
+<tr><th>Time</th><th>Transaction</th><th>Type</th><th>Counterparty</th><th>Description</th><th>External reference</th><th>Amount</th><th>Balance</th></tr>
+{{range .Lines}}<tr><td>{{formatTime .Time}}</td><td>{{optionalInt .TransactionID}}</td><td>{{.TransactionType}}</td><td>{{optionalInt .CounterpartyID}}</td><td>{{.Description}}</td><td>{{optionalString .ExternalReference}}</td><td class="amount">{{.Amount}}</td><td class="amount">{{.Balance}}</td></tr>
+{{else}}<tr><td colspan="8">No transactions in this period</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteStatementHTML 导出 HTML 对账单, 可在浏览器中打印为 PDF
+func WriteStatementHTML(w io.Writer, statement *models.Statement) error {
+	return statementHTMLTemplate.Execute(w, statement)
+}
+
+func optionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+// csvText 用户填写的文本以 = + - @ 制表符或回车开头时加 ' 前缀, 避免在电子表格中被当作公式执行
+func csvText(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func optionalString(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
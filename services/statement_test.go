@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var statementLineColumns = []string{"time", "transaction_id", "transaction_type", "counterparty_user_id", "description", "external_reference", "amount"}
+
+func newTestStatementGenerator(t *testing.T) (*StatementGenerator, sqlmock.Sqlmock, redismock.ClientMock) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	return &StatementGenerator{service: &walletService{db: sqlx.NewDb(db, "postgres"), redis: client, logger: logger.NewLogger()}}, mockDB, mockRedis
+}
+
+// expectBuildStatement 期望按分录生成钱包 1 在 3 月的对账单: 期初 500, 转入 200, 转出 120.5
+func expectBuildStatement(mockDB sqlmock.Sqlmock, from, to time.Time) {
+	snapshotAt := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	mockDB.ExpectQuery("FROM balance_snapshots").
+		WithArgs(1, models.USD, from.Add(-time.Microsecond)).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot_at", "balance"}).AddRow(snapshotAt, "450"))
+	mockDB.ExpectQuery("FROM postings").
+		WithArgs(WalletAccountCode(1, models.USD), snapshotAt, from.Add(-time.Microsecond)).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("50"))
+	mockDB.ExpectQuery("FROM postings p\\s+JOIN journal_entries").
+		WithArgs(WalletAccountCode(1, models.USD), 1, from, to).
+		WillReturnRows(sqlmock.NewRows(statementLineColumns).
+			AddRow(from.Add(time.Hour), 10, "transfer", 2, "rent", nil, "200").
+			AddRow(from.Add(48*time.Hour), 11, "withdraw", nil, "withdraw", "ref-1", "-120.5"))
+}
+
+func TestWalletService_GetStatement(t *testing.T) {
+	generator, mockDB, _ := newTestStatementGenerator(t)
+	service := generator.service
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	mockDB.ExpectQuery("SELECT EXISTS").
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	// 不是预生成的期间, 按分录生成
+	mockDB.ExpectQuery("SELECT \\* FROM statements").
+		WithArgs(1, models.USD, from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	expectBuildStatement(mockDB, from, to)
+
+	statement, err := service.GetStatement(context.Background(), 1, models.USD, from, to)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "500", statement.OpeningBalance.String())
+	assert.Equal(t, "579.5", statement.ClosingBalance.String())
+	assert.Equal(t, "200", statement.TotalIn.String())
+	assert.Equal(t, "120.5", statement.TotalOut.String())
+	assert.Len(t, statement.Lines, 2)
+	assert.Equal(t, "700", statement.Lines[0].Balance.String())
+	assert.Equal(t, 2, *statement.Lines[0].CounterpartyID)
+	assert.Equal(t, "579.5", statement.Lines[1].Balance.String())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_GetStatement_Invalid(t *testing.T) {
+	generator, mockDB, _ := newTestStatementGenerator(t)
+	service := generator.service
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := service.GetStatement(context.Background(), 1, models.USD, from, from)
+	assert.ErrorIs(t, err, ErrInvalidStatement)
+	_, err = service.GetStatement(context.Background(), 1, models.USD, from, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidStatement)
+	_, err = service.GetStatement(context.Background(), 1, models.USD, from, from.AddDate(2, 0, 0))
+	assert.ErrorIs(t, err, ErrInvalidStatement)
+
+	mockDB.ExpectQuery("SELECT EXISTS").
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	_, err = service.GetStatement(context.Background(), 1, models.USD, from, from.AddDate(0, 1, 0))
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestStatementGenerator_GenerateMonth(t *testing.T) {
+	generator, mockDB, _ := newTestStatementGenerator(t)
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	mockDB.ExpectQuery("SELECT w.user_id, w.currency FROM wallets w").
+		WithArgs(from, to, models.WalletClosed, 0, models.Currency(""), statementBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency"}).AddRow(1, "USD"))
+	expectBuildStatement(mockDB, from, to)
+	mockDB.ExpectExec("INSERT INTO statements").
+		WithArgs(1, models.USD, from, to, decimal.NewFromInt(500), decimal.RequireFromString("579.5"),
+			decimal.NewFromInt(200), decimal.RequireFromString("120.5"), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// 下一批从上一批最后的钱包之后开始
+	mockDB.ExpectQuery("SELECT w.user_id, w.currency FROM wallets w").
+		WithArgs(from, to, models.WalletClosed, 1, models.USD, statementBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency"}))
+
+	err := generator.generateMonth(context.Background(), from, to)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWriteStatementCSV(t *testing.T) {
+	transactionID, reference := 11, "ref-1"
+	statement := &models.Statement{
+		UserID:         1,
+		Currency:       models.USD,
+		From:           time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:             time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		OpeningBalance: decimal.NewFromInt(500),
+		ClosingBalance: decimal.RequireFromString("379.5"),
+		Lines: models.StatementLines{{
+			Time:              time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC),
+			TransactionID:     &transactionID,
+			TransactionType:   models.WithdrawTransactionType,
+			Description:       "cash, ATM",
+			ExternalReference: &reference,
+			Amount:            decimal.RequireFromString("-120.5"),
+			Balance:           decimal.RequireFromString("379.5"),
+		}},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteStatementCSV(&buf, statement))
+	assert.Equal(t, "time,transaction_id,type,counterparty_user_id,description,external_reference,amount,balance\n"+
+		"2024-03-01 00:00:00,,,,opening balance,,,500\n"+
+		"2024-03-03 10:00:00,11,withdraw,,\"cash, ATM\",ref-1,-120.5,379.5\n"+
+		"2024-04-01 00:00:00,,,,closing balance,,,379.5\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, WriteStatementHTML(&buf, statement))
+	assert.Contains(t, buf.String(), "<td>cash, ATM</td>")
+	assert.Contains(t, buf.String(), `<td class="amount">379.5</td>`)
+}
+
+func TestWriteStatementCSV_FormulaInjection(t *testing.T) {
+	// 以公式字符开头的备注和外部单号加 ' 前缀, 金额等数值列不受影响
+	transactionID, reference := 11, "@SUM(A1:A2)"
+	statement := &models.Statement{
+		From:           time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:             time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		OpeningBalance: decimal.NewFromInt(500),
+		ClosingBalance: decimal.RequireFromString("379.5"),
+		Lines: models.StatementLines{{
+			Time:              time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC),
+			TransactionID:     &transactionID,
+			TransactionType:   models.WithdrawTransactionType,
+			Description:       "=HYPERLINK(\"http://x\")",
+			ExternalReference: &reference,
+			Amount:            decimal.RequireFromString("-120.5"),
+			Balance:           decimal.RequireFromString("379.5"),
+		}},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteStatementCSV(&buf, statement))
+	assert.Contains(t, buf.String(), "2024-03-03 10:00:00,11,withdraw,,\"'=HYPERLINK(\"\"http://x\"\")\",'@SUM(A1:A2),-120.5,379.5\n")
+
+	for _, v := range []string{"+1", "-1", "\tcmd", "\rcmd"} {
+		assert.Equal(t, "'"+v, csvText(v))
+	}
+	assert.Equal(t, "cash", csvText("cash"))
+	assert.Equal(t, "", csvText(""))
+}
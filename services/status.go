@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+	"wallet-service/models"
+)
+
+var ErrInvalidStatusTransition = errors.New("invalid transaction status transition")
+
+// GetTransaction 查询单笔交易及其状态
+func (s *walletService) GetTransaction(ctx context.Context, transactionID int) (*models.Transaction, error) {
+	var transaction models.Transaction
+	err := s.db.GetContext(ctx, &transaction, "SELECT * FROM transactions WHERE id = $1", transactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		s.logger.Error(ctx, "GetTransaction Failed select from transactions", zap.Int("transactionID", transactionID), zap.Error(err))
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// RequestPayout 发起外部出款: 冻结可用余额并生成 pending 状态的取款流水, 账面余额和分录在完成时才变动
+func (s *walletService) RequestPayout(ctx context.Context, userID int, amount decimal.Decimal, currency models.Currency) (*models.TransactionResult, error) {
+	if !amount.IsPositive() {
+		return nil, errors.New("amount must be greater than zero")
+	}
+	if err := validateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+
+	var result *models.TransactionResult
+	err := s.runInTx(ctx, "RequestPayout", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, userID, requestHash("payout", userID, amount, currency))
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			result = replayed
+			return nil
+		}
+
+		res, err := tx.Exec("UPDATE wallets SET held_balance = held_balance + $1 WHERE user_id = $2 AND currency = $3 AND status = 'active' AND balance + credit_limit - held_balance >= $1",
+			amount, userID, currency)
+		if err != nil {
+			s.logger.Error(ctx, "RequestPayout Failed to update wallets", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return s.walletUnavailable(ctx, tx, userID, currency, true)
+		}
+		s.walletChanged(tx, userID, currency)
+		// 出款在发起时计入限额, 失败后不退回用量
+		if err = s.chargeOutflow(ctx, tx, userID, currency, amount, 0); err != nil {
+			return err
+		}
+
+		transactionID, err := s.insertTransaction(ctx, tx, models.Transaction{
+			SenderUserID:    userID,
+			ReceiverUserID:  userID,
+			TransactionType: models.WithdrawTransactionType,
+			Amount:          amount,
+			Currency:        currency,
+			Status:          models.TransactionPending,
+		})
+		if err != nil {
+			return err
+		}
+
+		// 出款按 withdraw 规则在发起时收取手续费, 出款失败时退还
+		fee, err := s.chargeFee(ctx, tx, transactionID, models.WithdrawTransactionType, userID, amount, currency)
+		if err != nil {
+			return err
+		}
+
+		result = &models.TransactionResult{TransactionID: transactionID, Fee: fee}
+		return s.saveIdempotencyResult(ctx, tx, userID, result)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "RequestPayout Failed", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
+// CompleteTransaction pending -> completed: 释放冻结并扣减账面余额, 同时写入分录
+func (s *walletService) CompleteTransaction(ctx context.Context, transactionID int) (*models.Transaction, error) {
+	var transaction *models.Transaction
+	err := s.runInTx(ctx, "CompleteTransaction", func(tx *sqlx.Tx) error {
+		var err error
+		transaction, err = s.lockTransactionForTransition(ctx, tx, transactionID, models.TransactionCompleted)
+		if err != nil {
+			return err
+		}
+		if transaction.TransactionType != models.WithdrawTransactionType {
+			return fmt.Errorf("%w: pending %s transactions are not supported", ErrInvalidStatusTransition, transaction.TransactionType)
+		}
+
+		// 冻结时已校验过可用余额, 约束 held_balance <= balance + credit_limit 保证不会超出授信额度; 钱包被冻结后只能将出款置为失败
+		res, err := tx.Exec("UPDATE wallets SET balance = balance - $1, held_balance = held_balance - $1 WHERE user_id = $2 AND currency = $3 AND status = 'active'",
+			transaction.Amount, transaction.SenderUserID, transaction.Currency)
+		if err != nil {
+			s.logger.Error(ctx, "CompleteTransaction Failed to update wallets", zap.Int("transactionID", transactionID), zap.Error(err))
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return s.walletUnavailable(ctx, tx, transaction.SenderUserID, transaction.Currency, true)
+		}
+		s.walletChanged(tx, transaction.SenderUserID, transaction.Currency)
+
+		// 用户钱包 -> 外部现金流出
+		err = s.postJournalEntry(ctx, tx, models.JournalEntry{
+			TransactionID: &transaction.ID,
+			Description:   string(transaction.TransactionType),
+			Postings: []models.Posting{
+				walletPosting(transaction.SenderUserID, transaction.Currency, transaction.Amount.Neg()),
+				systemPosting(ExternalCashOutAccount, transaction.Currency, transaction.Amount),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return s.transitionTransaction(ctx, tx, transaction, models.TransactionCompleted, "")
+	})
+	if err != nil {
+		s.logger.Error(ctx, "CompleteTransaction Failed", zap.Int("transactionID", transactionID), zap.Error(err))
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// FailTransaction pending -> failed: 释放冻结并退还发起时收取的手续费, 出款金额对应的账面余额不变
+func (s *walletService) FailTransaction(ctx context.Context, transactionID int, reason string) (*models.Transaction, error) {
+	var transaction *models.Transaction
+	err := s.runInTx(ctx, "FailTransaction", func(tx *sqlx.Tx) error {
+		var err error
+		transaction, err = s.lockTransactionForTransition(ctx, tx, transactionID, models.TransactionFailed)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE wallets SET held_balance = held_balance - $1 WHERE user_id = $2 AND currency = $3",
+			transaction.Amount, transaction.SenderUserID, transaction.Currency)
+		if err != nil {
+			s.logger.Error(ctx, "FailTransaction Failed to update wallets", zap.Int("transactionID", transactionID), zap.Error(err))
+			return err
+		}
+		s.walletChanged(tx, transaction.SenderUserID, transaction.Currency)
+		if _, err = s.refundFees(ctx, tx, transaction.ID); err != nil {
+			return err
+		}
+		return s.transitionTransaction(ctx, tx, transaction, models.TransactionFailed, reason)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "FailTransaction Failed", zap.Int("transactionID", transactionID), zap.Error(err))
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// lockTransactionForTransition 锁定交易并校验是否允许迁移到 next
+func (s *walletService) lockTransactionForTransition(ctx context.Context, tx *sqlx.Tx, transactionID int, next models.TransactionStatus) (*models.Transaction, error) {
+	var transaction models.Transaction
+	err := tx.Get(&transaction, "SELECT * FROM transactions WHERE id = $1 FOR UPDATE", transactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		s.logger.Error(ctx, "lockTransactionForTransition Failed select from transactions", zap.Int("transactionID", transactionID), zap.Error(err))
+		return nil, err
+	}
+	if !transaction.Status.CanTransitionTo(next) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, transaction.Status, next)
+	}
+	return &transaction, nil
+}
+
+// transitionTransaction 更新状态并记录迁移时间, 调用方需已通过 lockTransactionForTransition 锁定交易
+func (s *walletService) transitionTransaction(ctx context.Context, tx *sqlx.Tx, transaction *models.Transaction, next models.TransactionStatus, reason string) error {
+	now := time.Now().UTC()
+	var column string
+	switch next {
+	case models.TransactionCompleted:
+		column, transaction.CompletedAt = "completed_at", &now
+	case models.TransactionFailed:
+		column, transaction.FailedAt = "failed_at", &now
+		transaction.FailureReason = &reason
+	case models.TransactionReversed:
+		column, transaction.ReversedAt = "reversed_at", &now
+	default:
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, transaction.Status, next)
+	}
+
+	_, err := tx.Exec(fmt.Sprintf("UPDATE transactions SET status = $1, %s = $2, failure_reason = $3 WHERE id = $4", column),
+		next, now, transaction.FailureReason, transaction.ID)
+	if err != nil {
+		s.logger.Error(ctx, "transitionTransaction Failed to update transactions", zap.Int("transactionID", transaction.ID), zap.Error(err))
+		return err
+	}
+	transaction.Status = next
+	return nil
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+func TestTransactionStatus_CanTransitionTo(t *testing.T) {
+	assert.True(t, models.TransactionPending.CanTransitionTo(models.TransactionCompleted))
+	assert.True(t, models.TransactionPending.CanTransitionTo(models.TransactionFailed))
+	assert.True(t, models.TransactionCompleted.CanTransitionTo(models.TransactionReversed))
+	assert.False(t, models.TransactionPending.CanTransitionTo(models.TransactionReversed))
+	assert.False(t, models.TransactionCompleted.CanTransitionTo(models.TransactionFailed))
+	assert.False(t, models.TransactionFailed.CanTransitionTo(models.TransactionCompleted))
+	assert.False(t, models.TransactionReversed.CanTransitionTo(models.TransactionCompleted))
+}
+
+func TestWalletService_RequestPayout(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, mockRedis := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 出款只冻结金额, 不变动账面余额, 不写分录
+	userID := 1
+	amount := decimal.NewFromInt(40)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`UPDATE wallets SET held_balance = held_balance \+ \$1`).
+		WithArgs(amount, userID, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, userID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, userID)
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(userID, userID, models.WithdrawTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionPending, nil, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(15))
+	mockDB.ExpectCommit()
+
+	// 执行 RequestPayout 方法
+	result, err := service.RequestPayout(context.Background(), userID, amount, models.USD)
+
+	// 断言没有错误
+	assert.NoError(t, err)
+	assert.Equal(t, 15, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_CompleteTransaction(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 完成时释放冻结并扣减账面余额, 写入分录
+	amount := decimal.NewFromInt(40)
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(15).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(15, 1, 1, "withdraw", "40", "USD", nil, nil, nil, "pending", time.Now()))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1, held_balance = held_balance - \$1`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectJournalEntry(mockDB, walletPosting(1, models.USD, amount.Neg()), systemPosting(ExternalCashOutAccount, models.USD, amount))
+	mockDB.ExpectExec(`UPDATE transactions SET status = \$1, completed_at = \$2`).
+		WithArgs(models.TransactionCompleted, sqlmock.AnyArg(), nil, 15).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	transaction, err := service.CompleteTransaction(context.Background(), 15)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.TransactionCompleted, transaction.Status)
+	assert.NotNil(t, transaction.CompletedAt)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_FailTransaction(t *testing.T) {
+	client, mockRedis := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 失败时只释放冻结, 账面余额不变; 没有收取手续费时无需退还
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(15).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(15, 1, 1, "withdraw", "40", "USD", nil, nil, nil, "pending", time.Now()))
+	mockDB.ExpectExec(`UPDATE wallets SET held_balance = held_balance - \$1`).
+		WithArgs(decimal.NewFromInt(40), 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE original_transaction_id = \$1 AND transaction_type = \$2`).
+		WithArgs(15, models.FeeTransactionType, models.TransactionCompleted).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns))
+	mockDB.ExpectExec(`UPDATE transactions SET status = \$1, failed_at = \$2`).
+		WithArgs(models.TransactionFailed, sqlmock.AnyArg(), "bank rejected", 15).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	transaction, err := service.FailTransaction(context.Background(), 15, "bank rejected")
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.TransactionFailed, transaction.Status)
+	assert.Equal(t, "bank rejected", *transaction.FailureReason)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
+}
+
+func TestWalletService_FailTransaction_RefundsFee(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	fee := decimal.NewFromInt(2)
+
+	// 出款失败时从手续费收入账户全额退还发起时收取的手续费, 手续费流水迁移到 reversed
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(15).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(15, 1, 1, "withdraw", "40", "USD", nil, nil, nil, "pending", time.Now()))
+	mockDB.ExpectExec(`UPDATE wallets SET held_balance = held_balance - \$1`).
+		WithArgs(decimal.NewFromInt(40), 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE original_transaction_id = \$1 AND transaction_type = \$2`).
+		WithArgs(15, models.FeeTransactionType, models.TransactionCompleted).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(16, 1, 99, "fee", "2", "USD", nil, nil, 15, "completed", time.Now()))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1`).
+		WithArgs(fee, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.ReversalTransactionType, fee, models.USD, nil, nil, 16, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(17))
+	expectJournalEntry(mockDB, systemPosting(FeeRevenueAccount, models.USD, fee.Neg()), walletPosting(1, models.USD, fee))
+	mockDB.ExpectExec(`UPDATE transactions SET status = \$1, reversed_at = \$2`).
+		WithArgs(models.TransactionReversed, sqlmock.AnyArg(), 16, models.TransactionCompleted, models.ReversalTransactionType).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(`UPDATE transactions SET status = \$1, failed_at = \$2`).
+		WithArgs(models.TransactionFailed, sqlmock.AnyArg(), "bank rejected", 15).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	transaction, err := service.FailTransaction(context.Background(), 15, "bank rejected")
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.TransactionFailed, transaction.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_CompleteTransaction_InvalidTransition(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+
+	// 已失败的交易不能再完成, 余额不做任何变动
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE id = \$1 FOR UPDATE`).
+		WithArgs(15).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(15, 1, 1, "withdraw", "40", "USD", nil, nil, nil, "failed", time.Now()))
+	mockDB.ExpectRollback()
+
+	_, err = service.CompleteTransaction(context.Background(), 15)
+
+	assert.ErrorIs(t, err, ErrInvalidStatusTransition)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_TransactionTimestamps_UTC(t *testing.T) {
+	// 本地时区不是 UTC 时, 流水的创建、完成和状态迁移时间仍按 UTC 写入
+	local := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*60*60)
+	defer func() { time.Local = local }()
+
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	service := &walletService{db: sqlxDB, redis: client, logger: logger.NewLogger()}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("INSERT INTO transactions").
+		WithArgs(1, 1, models.WithdrawTransactionType, decimal.NewFromInt(40), models.USD, nil, nil, nil, utcTime{}, models.TransactionCompleted, utcTime{}, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(15))
+	mockDB.ExpectExec(`UPDATE transactions SET status = \$1, reversed_at = \$2, failure_reason = \$3`).
+		WithArgs(models.TransactionReversed, utcTime{}, nil, 15).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// 部分冲正累计达到原交易金额时同样按 UTC 记录冲正时间
+	mockDB.ExpectExec(`UPDATE transactions SET status = \$1, reversed_at = \$2`).
+		WithArgs(models.TransactionReversed, utcTime{}, 16, models.TransactionCompleted, models.ReversalTransactionType).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+	transaction := models.Transaction{SenderUserID: 1, ReceiverUserID: 1, TransactionType: models.WithdrawTransactionType,
+		Amount: decimal.NewFromInt(40), Currency: models.USD}
+	transaction.ID, err = service.insertTransaction(context.Background(), tx, transaction)
+	assert.NoError(t, err)
+	transaction.Status = models.TransactionCompleted
+	assert.NoError(t, service.transitionTransaction(context.Background(), tx, &transaction, models.TransactionReversed, ""))
+	assert.NoError(t, service.markFullyReversed(context.Background(), tx, 16))
+	assert.NoError(t, tx.Commit())
+
+	assert.Equal(t, time.UTC, transaction.ReversedAt.Location())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-redis/redismock/v9"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -34,28 +35,76 @@ func TestWalletService_Transfer_Success(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", 0).SetVal("OK")
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", receiverID)).SetVal("200")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", receiverID), "250", 0).SetVal("OK")
-
-	// 设置 mock DB 的期望行为
+	// 设置 mock DB 的期望行为: 先按顺序锁定双方钱包, 再扣款入账
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\) AND currency = \$2 ORDER BY user_id FOR UPDATE`).
+		WithArgs(pq.Array([]int{senderID, receiverID}), models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
 	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
-		WithArgs(amount, receiverID).
+		WithArgs(amount, receiverID, models.USD).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec("INSERT INTO transactions").WithArgs(receiverID, senderID, models.TransferTransactionType, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	expectWalletLimits(mockDB, models.USD, receiverID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WithArgs(senderID, receiverID, models.TransferTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), walletPosting(receiverID, models.USD, amount))
 	mockDB.ExpectCommit()
+	// 提交后双方钱包的缓存一起刷新
+	expectBalanceCacheRefresh(mockDB, mockRedis,
+		cachedBalance{UserID: senderID, Currency: models.USD, Balance: decimal.RequireFromString("50"), Version: 3},
+		cachedBalance{UserID: receiverID, Currency: models.USD, Balance: decimal.RequireFromString("250"), Version: 7})
 
 	// 执行 Transfer 方法
-	err = service.Transfer(context.Background(), senderID, receiverID, amount)
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
 
 	// 断言没有错误
 	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Transfer_LockOrder(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, _ := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	mockLogger := logger.NewLogger()
+
+	// 创建 WalletService
+	service := NewWalletService(mockLogger, sqlxDB, client)
+
+	// 准备测试数据: 反方向转账
+	senderID := 2
+	receiverID := 1
+	amount := decimal.NewFromFloat(50.0)
+
+	// 设置 mock DB 的期望行为: 无论转账方向, 加锁顺序都按 user_id 升序
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\) AND currency = \$2 ORDER BY user_id FOR UPDATE`).
+		WithArgs(pq.Array([]int{receiverID, senderID}), models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(receiverID).AddRow(senderID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectRollback()
+
+	// 执行 Transfer 方法
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
+
+	// 断言余额不足
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
 func TestWalletService_Transfer_NegativeAmount(t *testing.T) {
@@ -80,12 +129,32 @@ func TestWalletService_Transfer_NegativeAmount(t *testing.T) {
 	amount := decimal.NewFromFloat(-50.0)
 
 	// 执行 Transfer 方法
-	err = service.Transfer(context.Background(), senderID, receiverID, amount)
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
 
 	// 断言返回错误
 	assert.EqualError(t, err, "amount must be greater than zero")
 }
 
+func TestWalletService_Transfer_SameWallet(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, _ := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	service := NewWalletService(logger.NewLogger(), sqlxDB, client)
+
+	// 执行 Transfer 方法
+	_, err = service.Transfer(context.Background(), 1, 1, decimal.NewFromFloat(50.0), models.USD, models.TransactionDetails{})
+
+	// 断言返回错误
+	assert.EqualError(t, err, "cannot transfer to the same wallet")
+}
+
 func TestWalletService_Transfer_BeginTransactionError(t *testing.T) {
 	// 创建 mock Redis 客户端
 	client, _ := redismock.NewClientMock()
@@ -111,7 +180,7 @@ func TestWalletService_Transfer_BeginTransactionError(t *testing.T) {
 	mockDB.ExpectBegin().WillReturnError(fmt.Errorf("begin transaction error"))
 
 	// 执行 Transfer 方法
-	err = service.Transfer(context.Background(), senderID, receiverID, amount)
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
 
 	// 断言返回错误
 	assert.Error(t, err)
@@ -119,7 +188,7 @@ func TestWalletService_Transfer_BeginTransactionError(t *testing.T) {
 
 func TestWalletService_Transfer_WithdrawError(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -138,26 +207,26 @@ func TestWalletService_Transfer_WithdrawError(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
 		WillReturnError(fmt.Errorf("withdraw error"))
 	mockDB.ExpectRollback()
 
 	// 执行 Transfer 方法
-	err = service.Transfer(context.Background(), senderID, receiverID, amount)
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.EqualError(t, err, "withdraw error")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
 func TestWalletService_Transfer_DepositError(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -176,31 +245,29 @@ func TestWalletService_Transfer_DepositError(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", 0).SetVal("OK")
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", receiverID)).SetVal("200")
-
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance + \$1 WHERE user_id = \$2`).
-		WithArgs(amount, receiverID).
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
+		WithArgs(amount, receiverID, models.USD).
 		WillReturnError(fmt.Errorf("deposit error"))
 	mockDB.ExpectRollback()
 
 	// 执行 Transfer 方法
-	err = service.Transfer(context.Background(), senderID, receiverID, amount)
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.EqualError(t, err, "deposit error")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
 func TestWalletService_Transfer_CommitError(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -219,26 +286,26 @@ func TestWalletService_Transfer_CommitError(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", 0).SetVal("OK")
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", receiverID)).SetVal("200")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", receiverID), "250", 0).SetVal("OK")
-
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance + \$1 WHERE user_id = \$2`).
-		WithArgs(amount, receiverID).
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
+		WithArgs(amount, receiverID, models.USD).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	expectWalletLimits(mockDB, models.USD, receiverID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), walletPosting(receiverID, models.USD, amount))
 	mockDB.ExpectCommit().WillReturnError(fmt.Errorf("commit error"))
-	mockDB.ExpectRollback()
 
 	// 执行 Transfer 方法
-	err = service.Transfer(context.Background(), senderID, receiverID, amount)
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.EqualError(t, err, "commit error")
 }
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	maxTxAttempts = 3
+	txRetryDelay  = 20 * time.Millisecond
+)
+
+// Postgres 可重试的错误码
+const (
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+const (
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+)
+
+// isRetryableTxError 序列化冲突和死锁可以安全地整体重试
+func isRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+}
+
+// isUniqueViolation 是否违反了指定的唯一约束(索引)
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation && pqErr.Constraint == constraint
+}
+
+// isForeignKeyViolation 是否违反了外键约束, 通常表示引用的钱包不存在
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation
+}
+
+// runInTx 在一个数据库事务中执行 fn, fn 返回错误时回滚;
+// 遇到序列化冲突或死锁时整体重试, fn 必须可以安全地重复执行
+func (s *walletService) runInTx(ctx context.Context, name string, fn func(tx *sqlx.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = s.execTx(ctx, name, fn)
+		if err == nil || !isRetryableTxError(err) || attempt == maxTxAttempts {
+			return err
+		}
+
+		s.logger.Warn(ctx, name+" retry transaction", zap.Int("attempt", attempt), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		}
+	}
+	return err
+}
+
+func (s *walletService) execTx(ctx context.Context, name string, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		s.logger.Error(ctx, name+" Failed to begin transaction", zap.Error(err))
+		return err
+	}
+
+	err = fn(tx)
+	// 余额有变化的钱包只在提交成功后刷新缓存
+	changed := s.changes.take(tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			s.logger.Error(ctx, name+" Failed Rollback transaction", zap.Error(rbErr))
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		s.logger.Error(ctx, name+" Failed to commit transaction", zap.Error(err))
+		return err
+	}
+	if len(changed) > 0 {
+		// 事务已提交, 请求取消时仍然刷新缓存
+		s.refreshBalanceCache(context.WithoutCancel(ctx), changed)
+	}
+	return nil
+}
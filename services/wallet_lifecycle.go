@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"wallet-service/models"
+)
+
+const walletColumns = "user_id, currency, balance, held_balance, credit_limit, status, created_at, updated_at"
+
+var (
+	ErrWalletAlreadyExists           = errors.New("wallet already exists")
+	ErrWalletFrozen                  = errors.New("wallet is frozen")
+	ErrWalletClosed                  = errors.New("wallet is closed")
+	ErrWalletNotEmpty                = errors.New("wallet balance must be zero before closing")
+	ErrInvalidWalletStatusTransition = errors.New("invalid wallet status transition")
+)
+
+// CreateWallet 显式创建钱包, 每个用户每个币种只能有一个钱包
+func (s *walletService) CreateWallet(ctx context.Context, userID int, currency models.Currency) (*models.Wallet, error) {
+	if userID <= 0 {
+		return nil, errors.New("invalid user id")
+	}
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+
+	// 显式按 UTC 写入创建时间, 不依赖数据库会话的时区; 查询历史余额时与分录时间比较
+	now := time.Now().UTC()
+	var wallet models.Wallet
+	err := s.db.GetContext(ctx, &wallet, `
+		INSERT INTO wallets (user_id, currency, balance, status, created_at, updated_at) VALUES ($1, $2, 0, $3, $4, $4)
+		ON CONFLICT (user_id, currency) DO NOTHING RETURNING `+walletColumns,
+		userID, currency, models.WalletActive, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d %s", ErrWalletAlreadyExists, userID, currency)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "CreateWallet Failed insert into wallets", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// ChangeWalletStatus 冻结、解冻或注销钱包, 并记录变更原因; 注销前账面余额、冻结金额和 pocket 的余额必须为零, 且没有未结算的托管
+func (s *walletService) ChangeWalletStatus(ctx context.Context, userID int, currency models.Currency, status models.WalletStatus, reason string) (*models.Wallet, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, errors.New("reason is required")
+	}
+
+	var wallet models.Wallet
+	err := s.runInTx(ctx, "ChangeWalletStatus", func(tx *sqlx.Tx) error {
+		err := tx.Get(&wallet, "SELECT "+walletColumns+" FROM wallets WHERE user_id = $1 AND currency = $2 FOR UPDATE", userID, currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			s.logger.Error(ctx, "ChangeWalletStatus Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+		if !wallet.Status.CanTransitionTo(status) {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidWalletStatusTransition, wallet.Status, status)
+		}
+		if status == models.WalletClosed && (!wallet.Balance.IsZero() || !wallet.HeldBalance.IsZero()) {
+			return fmt.Errorf("%w: balance %s, held %s", ErrWalletNotEmpty, wallet.Balance.String(), wallet.HeldBalance.String())
+		}
+		if status == models.WalletClosed {
+			// pocket 的余额需先转回钱包, 空的 pocket 随钱包一起关闭
+			var pocketBalance decimal.Decimal
+			err = tx.Get(&pocketBalance, "SELECT COALESCE(SUM(balance), 0) FROM pockets WHERE user_id = $1 AND currency = $2 AND status = $3",
+				userID, currency, models.PocketActive)
+			if err != nil {
+				return err
+			}
+			if !pocketBalance.IsZero() {
+				return fmt.Errorf("%w: pockets hold %s", ErrWalletNotEmpty, pocketBalance.String())
+			}
+			// 作为买方或卖方的托管未结算时不能注销, 否则无法放款或退款
+			var openEscrows int
+			err = tx.Get(&openEscrows, "SELECT COUNT(*) FROM escrows WHERE (buyer_user_id = $1 OR seller_user_id = $1) AND currency = $2 AND status = $3",
+				userID, currency, models.EscrowFunded)
+			if err != nil {
+				return err
+			}
+			if openEscrows > 0 {
+				return fmt.Errorf("%w: %d open escrows", ErrWalletNotEmpty, openEscrows)
+			}
+			_, err = tx.Exec("UPDATE pockets SET status = $1 WHERE user_id = $2 AND currency = $3 AND status = $4",
+				models.PocketClosed, userID, currency, models.PocketActive)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Get(&wallet.UpdatedAt, "UPDATE wallets SET status = $1 WHERE user_id = $2 AND currency = $3 RETURNING updated_at",
+			status, userID, currency)
+		if err != nil {
+			s.logger.Error(ctx, "ChangeWalletStatus Failed to update wallets", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO wallet_status_changes (user_id, currency, from_status, to_status, reason, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			userID, currency, wallet.Status, status, reason, time.Now().UTC())
+		if err != nil {
+			s.logger.Error(ctx, "ChangeWalletStatus Failed insert into wallet_status_changes", zap.Int("userID", userID), zap.Error(err))
+			return err
+		}
+		wallet.Status = status
+		return nil
+	})
+	if err != nil {
+		s.logger.Error(ctx, "ChangeWalletStatus Failed", zap.Int("userID", userID), zap.String("status", string(status)), zap.Error(err))
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// ListWalletStatusChanges 查询钱包的状态变更记录, 按时间倒序
+func (s *walletService) ListWalletStatusChanges(ctx context.Context, userID int, currency models.Currency) ([]models.WalletStatusChange, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	changes := []models.WalletStatusChange{}
+	err := s.db.SelectContext(ctx, &changes, "SELECT * FROM wallet_status_changes WHERE user_id = $1 AND currency = $2 ORDER BY created_at DESC, id DESC",
+		userID, currency)
+	if err != nil {
+		s.logger.Error(ctx, "ListWalletStatusChanges Failed select from wallet_status_changes", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return changes, nil
+}
+
+// walletStatusError 钱包状态不允许付款或收款时的错误
+func walletStatusError(status models.WalletStatus) error {
+	switch status {
+	case models.WalletFrozen:
+		return ErrWalletFrozen
+	case models.WalletClosed:
+		return ErrWalletClosed
+	default:
+		return nil
+	}
+}
+
+// walletUnavailable 带状态条件的余额更新未命中时查明原因: 钱包不存在、状态不允许, 扣款时还可能是可用余额不足
+func (s *walletService) walletUnavailable(ctx context.Context, tx *sqlx.Tx, userID int, currency models.Currency, debit bool) error {
+	var status models.WalletStatus
+	err := tx.Get(&status, "SELECT status FROM wallets WHERE user_id = $1 AND currency = $2", userID, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWalletNotFound
+	}
+	if err != nil {
+		s.logger.Error(ctx, "walletUnavailable Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	if debit {
+		if err = walletStatusError(status); err != nil {
+			return err
+		}
+		return ErrInsufficientFunds
+	}
+	if status == models.WalletClosed {
+		return ErrWalletClosed
+	}
+	return ErrWalletNotFound
+}
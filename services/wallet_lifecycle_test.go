@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wallet-service/models"
+	"wallet-service/pkg/logger"
+)
+
+var walletRowColumns = []string{"user_id", "currency", "balance", "held_balance", "credit_limit", "status", "created_at", "updated_at"}
+
+func TestWalletService_CreateWallet(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+	now := time.Now()
+
+	mockDB.ExpectQuery("INSERT INTO wallets").
+		WithArgs(1, models.EUR, models.WalletActive, utcTime{}).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns).AddRow(1, "EUR", "0", "0", "0", "active", now, now))
+	// 已存在时 ON CONFLICT DO NOTHING 不返回行
+	mockDB.ExpectQuery("INSERT INTO wallets").
+		WithArgs(1, models.EUR, models.WalletActive, utcTime{}).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns))
+
+	wallet, err := service.CreateWallet(context.Background(), 1, models.EUR)
+	assert.NoError(t, err)
+	assert.Equal(t, models.WalletActive, wallet.Status)
+	assert.True(t, wallet.Balance.IsZero())
+
+	_, err = service.CreateWallet(context.Background(), 1, models.EUR)
+	assert.ErrorIs(t, err, ErrWalletAlreadyExists)
+
+	_, err = service.CreateWallet(context.Background(), 1, "XXX")
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ChangeWalletStatus_Freeze(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+	now := time.Now()
+
+	// 状态变更与变更记录在同一事务内写入
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT .* FROM wallets WHERE user_id = \$1 AND currency = \$2 FOR UPDATE`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns).AddRow(1, "USD", "100", "0", "0", "active", now, now))
+	mockDB.ExpectQuery(`UPDATE wallets SET status = \$1`).
+		WithArgs(models.WalletFrozen, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(now))
+	mockDB.ExpectExec("INSERT INTO wallet_status_changes").
+		WithArgs(1, models.USD, models.WalletActive, models.WalletFrozen, "chargeback investigation", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	wallet, err := service.ChangeWalletStatus(context.Background(), 1, models.USD, models.WalletFrozen, "chargeback investigation")
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.WalletFrozen, wallet.Status)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_ChangeWalletStatus_Rejected(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+	now := time.Now()
+
+	// 有余额的钱包不能注销
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT .* FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns).AddRow(1, "USD", "100", "0", "0", "frozen", now, now))
+	mockDB.ExpectRollback()
+	_, err = service.ChangeWalletStatus(context.Background(), 1, models.USD, models.WalletClosed, "user request")
+	assert.ErrorIs(t, err, ErrWalletNotEmpty)
+
+	// 已注销为终态
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`SELECT .* FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows(walletRowColumns).AddRow(1, "USD", "0", "0", "0", "closed", now, now))
+	mockDB.ExpectRollback()
+	_, err = service.ChangeWalletStatus(context.Background(), 1, models.USD, models.WalletActive, "reopen")
+	assert.ErrorIs(t, err, ErrInvalidWalletStatusTransition)
+
+	// 必须填写原因
+	_, err = service.ChangeWalletStatus(context.Background(), 1, models.USD, models.WalletFrozen, " ")
+	assert.Error(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Withdraw_WalletFrozen(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+	amount := decimal.NewFromInt(10)
+
+	// 冻结的钱包不能付款
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1 .* AND status = 'active'`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletFrozen))
+	mockDB.ExpectRollback()
+
+	_, err = service.Withdraw(context.Background(), 1, 1, amount, models.USD, models.WithdrawTransactionType, models.TransactionDetails{})
+
+	assert.ErrorIs(t, err, ErrWalletFrozen)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWalletService_Deposit_WalletClosed(t *testing.T) {
+	client, _ := redismock.NewClientMock()
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	service := NewWalletService(logger.NewLogger(), sqlx.NewDb(db, "postgres"), client)
+	amount := decimal.NewFromInt(10)
+
+	// 已注销的钱包拒绝收款, 冻结的钱包仍可收款
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 .* AND status <> 'closed'`).
+		WithArgs(amount, 1, models.USD).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletClosed))
+	mockDB.ExpectRollback()
+
+	_, err = service.Deposit(context.Background(), 1, 1, amount, models.USD, models.DepositTransactionType, models.TransactionDetails{})
+
+	assert.ErrorIs(t, err, ErrWalletClosed)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
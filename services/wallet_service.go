@@ -6,30 +6,64 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
+	"sort"
 	"time"
 	"wallet-service/models"
 	wallet_logger "wallet-service/pkg/logger"
 )
 
 type WalletService interface {
-	Deposit(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, transactionType models.TransactionType) error
-	Withdraw(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, transactionType models.TransactionType) error
-	Transfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal) error
-	GetBalance(ctx context.Context, userID int) (decimal.Decimal, error)
-	GetTransactionHistory(userID int) ([]models.Transaction, error)
+	CreateWallet(ctx context.Context, userID int, currency models.Currency) (*models.Wallet, error)
+	ChangeWalletStatus(ctx context.Context, userID int, currency models.Currency, status models.WalletStatus, reason string) (*models.Wallet, error)
+	ListWalletStatusChanges(ctx context.Context, userID int, currency models.Currency) ([]models.WalletStatusChange, error)
+	GetWalletLimits(ctx context.Context, userID int, currency models.Currency) (*models.WalletLimits, error)
+	SetWalletLimits(ctx context.Context, userID int, currency models.Currency, limits models.SpendingLimits) (*models.WalletLimits, error)
+	GetInterest(ctx context.Context, userID int, currency models.Currency) (*models.InterestSummary, error)
+	SetCreditLimit(ctx context.Context, userID int, currency models.Currency, creditLimit decimal.Decimal) (*models.Wallet, error)
+	CreatePocket(ctx context.Context, userID int, currency models.Currency, name string) (*models.Pocket, error)
+	ListPockets(ctx context.Context, userID int, currency models.Currency) ([]models.Pocket, error)
+	MovePocketFunds(ctx context.Context, userID int, move models.PocketMove) (*models.TransactionResult, error)
+	ClosePocket(ctx context.Context, pocketID int) (*models.Pocket, error)
+	GetAggregateBalances(ctx context.Context, userID int) ([]models.AggregateBalance, error)
+	Deposit(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, transactionType models.TransactionType, details models.TransactionDetails) (*models.TransactionResult, error)
+	Withdraw(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, transactionType models.TransactionType, details models.TransactionDetails) (*models.TransactionResult, error)
+	Transfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, details models.TransactionDetails) (*models.TransactionResult, error)
+	ExchangeTransfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, quoteID string, details models.TransactionDetails) (*models.TransactionResult, error)
+	Reserve(ctx context.Context, userID int, amount decimal.Decimal, currency models.Currency, ttl time.Duration) (*models.Hold, error)
+	Capture(ctx context.Context, holdID int, amount decimal.Decimal) (*models.TransactionResult, error)
+	Void(ctx context.Context, holdID int) (*models.Hold, error)
+	Reverse(ctx context.Context, transactionID int, amount decimal.Decimal, policy models.ReversalPolicy) (*models.TransactionResult, error)
+	RequestPayout(ctx context.Context, userID int, amount decimal.Decimal, currency models.Currency) (*models.TransactionResult, error)
+	CompleteTransaction(ctx context.Context, transactionID int) (*models.Transaction, error)
+	FailTransaction(ctx context.Context, transactionID int, reason string) (*models.Transaction, error)
+	GetTransaction(ctx context.Context, transactionID int) (*models.Transaction, error)
+	BatchTransfer(ctx context.Context, senderID int, currency models.Currency, items []models.BatchTransferItem, mode models.BatchMode) (*models.TransactionResult, error)
+	ListHolds(ctx context.Context, userID int, currency models.Currency) ([]models.Hold, error)
+	GetBalance(ctx context.Context, userID int, currency models.Currency) (*models.Balance, error)
+	GetBalances(ctx context.Context, userID int) ([]models.Wallet, error)
+	GetBalanceAt(ctx context.Context, userID int, currency models.Currency, at time.Time) (*models.HistoricalBalance, error)
+	GetStatement(ctx context.Context, userID int, currency models.Currency, from, to time.Time) (*models.Statement, error)
+	GetTransactionHistory(ctx context.Context, userID int, filter models.TransactionFilter) (*models.TransactionPage, error)
 }
 
 type walletService struct {
-	db     *sqlx.DB
-	redis  *redis.Client
-	logger *wallet_logger.Logger
+	db      *sqlx.DB
+	redis   *redis.Client
+	logger  *wallet_logger.Logger
+	changes walletChanges // 事务内余额有变化的钱包
 }
 
 var _ WalletService = &walletService{}
 
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds balance")
+	ErrWalletNotFound    = errors.New("wallet not found")
+)
+
 // NewWalletService service
 func NewWalletService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Client) WalletService {
 	return &walletService{
@@ -40,389 +74,562 @@ func NewWalletService(logger *wallet_logger.Logger, db *sqlx.DB, redis *redis.Cl
 }
 
 // Deposit 存款
-func (s *walletService) Deposit(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, transactionType models.TransactionType) error {
-	if amount.LessThan(decimal.Zero) {
-		return errors.New("amount must be greater than zero")
+func (s *walletService) Deposit(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, transactionType models.TransactionType, details models.TransactionDetails) (*models.TransactionResult, error) {
+	if err := validateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+	if err := validateTransactionDetails(details); err != nil {
+		return nil, err
 	}
 
-	tx, err := s.db.Beginx()
-	if err != nil {
-		s.logger.Error(ctx, "Deposit Failed to begin transaction", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+	if len(transactionType) == 0 {
+		transactionType = models.DepositTransactionType
 	}
 
-	defer func() {
+	var result *models.TransactionResult
+	err := s.runInTx(ctx, "Deposit", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, senderID, detailsRequestHash(requestHash("deposit", senderID, receiverID, amount, currency, transactionType), details))
 		if err != nil {
-			err = tx.Rollback()
-			if err != nil {
-				s.logger.Error(ctx, "Deposit Failed Rollback transaction", zap.Int("senderID", senderID),
-					zap.Int("receiverID", receiverID), zap.Error(err))
-			}
+			return err
+		}
+		if replayed != nil {
+			result = replayed
+			return nil
 		}
-	}()
-
-	res, err := tx.Exec("UPDATE wallets SET balance = balance + $1 WHERE user_id = $2", amount, senderID)
-	if err != nil {
-		s.logger.Error(ctx, "Deposit Failed to Exec transaction", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
-	}
 
-	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
-		query := `
-			INSERT INTO wallets (user_id, balance)
-			VALUES ($1, $2)
-			ON CONFLICT (user_id)
-			DO UPDATE SET balance = wallets.balance + EXCLUDED.balance
-	   `
-		_, err = tx.Exec(query, senderID, amount)
+		err = s.DepositWithTx(ctx, tx, senderID, amount, currency)
 		if err != nil {
-			s.logger.Error(ctx, "Deposit Failed to Exec transaction", zap.Int("senderID", senderID),
-				zap.Int("receiverID", receiverID), zap.Error(err))
 			return err
 		}
-	}
+		if err = s.checkCreditLimit(ctx, tx, senderID, currency); err != nil {
+			return err
+		}
 
-	if len(transactionType) == 0 {
-		transactionType = models.DepositTransactionType
-	}
+		// 外部现金流入 -> 用户钱包
+		transactionID, err := s.recordTransaction(ctx, tx, withDetails(models.Transaction{
+			SenderUserID:    senderID,
+			ReceiverUserID:  receiverID,
+			TransactionType: transactionType,
+			Amount:          amount,
+			Currency:        currency,
+		}, details), []models.Posting{
+			systemPosting(ExternalCashInAccount, currency, amount.Neg()),
+			walletPosting(senderID, currency, amount),
+		})
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.Exec("INSERT INTO transactions (sender_user_id, receiver_user_id, transaction_type, amount, created_at) VALUES ($1, $2, $3, $4, $5)",
-		senderID, receiverID, transactionType, amount, time.Now())
+		result = &models.TransactionResult{TransactionID: transactionID}
+		return s.saveIdempotencyResult(ctx, tx, senderID, result)
+	})
 	if err != nil {
-		s.logger.Error(ctx, "Deposit Failed insert into  transactions ", zap.Int("senderID", senderID),
+		s.logger.Error(ctx, "Deposit Failed", zap.Int("senderID", senderID),
 			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+		return nil, err
 	}
 
-	// 更新缓存中的余额
+	return result, nil
+}
+
+// DepositWithTx 在事务内给钱包入账, 交易流水和分录由调用方记录
+func (s *walletService) DepositWithTx(ctx context.Context, tx *sqlx.Tx, userID int, amount decimal.Decimal, currency models.Currency) error {
 
-	err = s.redis.IncrByFloat(ctx, fmt.Sprintf("wallet:balance:%d", senderID), amount.InexactFloat64()).Err()
+	if !amount.IsPositive() {
+		return errors.New("amount must be greater than zero")
+	}
+
+	// 冻结的钱包可以收款, 已注销的钱包拒绝; 钱包需通过 CreateWallet 显式创建
+	res, err := tx.Exec("UPDATE wallets SET balance = balance + $1 WHERE user_id = $2 AND currency = $3 AND status <> 'closed'", amount, userID, currency)
 	if err != nil {
-		// 记录日志，不影响事务
-		s.logger.Warn(ctx, "Deposit Failed to update Redis cache:", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
+		s.logger.Error(ctx, "depositWithTx Failed to update wallets", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	if rowsAffected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return s.walletUnavailable(ctx, tx, userID, currency, false)
 	}
 
-	return tx.Commit()
+	s.walletChanged(tx, userID, currency)
+	return nil
+
 }
 
-func (s *walletService) DepositWithTx(ctx context.Context, tx *sqlx.Tx, senderID, receiverID int, amount decimal.Decimal, transactionType models.TransactionType) error {
+// Withdraw 取款
+func (s *walletService) Withdraw(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, transactionType models.TransactionType, details models.TransactionDetails) (*models.TransactionResult, error) {
 
-	if amount.LessThan(decimal.Zero) {
-		return errors.New("amount must be greater than zero")
+	if err := validateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+	if err := validateTransactionDetails(details); err != nil {
+		return nil, err
 	}
 
-	res, err := tx.Exec("UPDATE wallets SET balance = balance + $1 WHERE user_id = $2", amount, senderID)
-	if err != nil {
-		s.logger.Warn(ctx, "depositWithTx Failed to update Redis cache:", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+	if len(transactionType) == 0 {
+		transactionType = models.WithdrawTransactionType
 	}
 
-	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
-		query := `
-			INSERT INTO wallets (user_id, balance)
-			VALUES ($1, $2)
-			ON CONFLICT (user_id) 
-			DO UPDATE SET balance = wallets.balance + EXCLUDED.balance
-        `
-		_, err = tx.Exec(query, senderID, amount)
+	var result *models.TransactionResult
+	err := s.runInTx(ctx, "Withdraw", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, senderID, detailsRequestHash(requestHash("withdraw", senderID, receiverID, amount, currency, transactionType), details))
 		if err != nil {
-			s.logger.Warn(ctx, "depositWithTx Failed to update Redis cache:", zap.Int("senderID", senderID),
-				zap.Int("receiverID", receiverID), zap.Error(err))
 			return err
 		}
-	}
+		if replayed != nil {
+			result = replayed
+			return nil
+		}
 
-	if len(transactionType) == 0 {
-		transactionType = models.DepositTransactionType
-	}
+		// 余额检查与扣款在同一条语句内完成
+		err = s.WithdrawWithTx(ctx, tx, senderID, amount, currency)
+		if err != nil {
+			return err
+		}
+		if err = s.chargeOutflow(ctx, tx, senderID, currency, amount, 0); err != nil {
+			return err
+		}
 
-	if tx == nil {
-		tx, err = s.db.Beginx()
+		// 用户钱包 -> 外部现金流出
+		transactionID, err := s.recordTransaction(ctx, tx, withDetails(models.Transaction{
+			SenderUserID:    senderID,
+			ReceiverUserID:  receiverID,
+			TransactionType: transactionType,
+			Amount:          amount,
+			Currency:        currency,
+		}, details), []models.Posting{
+			walletPosting(senderID, currency, amount.Neg()),
+			systemPosting(ExternalCashOutAccount, currency, amount),
+		})
 		if err != nil {
 			return err
 		}
-	}
 
-	_, err = tx.Exec("INSERT INTO transactions (receiver_user_id,sender_user_id, transaction_type, amount, created_at) VALUES ($1, $2, $3, $4, $5)",
-		senderID, receiverID, transactionType, amount, time.Now())
-	if err != nil {
-		s.logger.Warn(ctx, "depositWithTx Failed to update Redis cache:", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
-	}
+		fee, err := s.chargeFee(ctx, tx, transactionID, transactionType, senderID, amount, currency)
+		if err != nil {
+			return err
+		}
 
-	// 更新缓存中的余额
-	err = s.redis.IncrByFloat(ctx, fmt.Sprintf("wallet:balance:%d", senderID), amount.InexactFloat64()).Err()
+		result = &models.TransactionResult{TransactionID: transactionID, Fee: fee}
+		return s.saveIdempotencyResult(ctx, tx, senderID, result)
+	})
 	if err != nil {
-		// 记录日志，不影响事务
-		s.logger.Warn(ctx, "depositWithTx Failed to update Redis cache:", zap.Int("senderID", senderID),
+		s.logger.Error(ctx, "Withdraw Failed", zap.Int("senderID", senderID),
 			zap.Int("receiverID", receiverID), zap.Error(err))
+		return nil, err
 	}
 
-	return nil
-
+	return result, nil
 }
 
-// Withdraw 取款
-func (s *walletService) Withdraw(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, transactionType models.TransactionType) error {
+// WithdrawWithTx 在事务内扣款, 余额不足时不做任何修改并返回 ErrInsufficientFunds
+func (s *walletService) WithdrawWithTx(ctx context.Context, tx *sqlx.Tx, senderID int, amount decimal.Decimal, currency models.Currency) error {
 
-	if amount.LessThan(decimal.Zero) {
+	if !amount.IsPositive() {
 		return errors.New("amount must be greater than zero")
 	}
 
+	// 条件更新: 只有钱包正常且可用余额(加上授信额度、扣除冻结金额)足够时才扣款, 并发扣款由行锁串行化
 	var balance decimal.Decimal
-	// 从 Redis 缓存中查询余额
-	cacheBalance, err := s.redis.Get(ctx, fmt.Sprintf("wallet:balance:%d", senderID)).Result()
+	err := tx.Get(&balance, "UPDATE wallets SET balance = balance - $1 WHERE user_id = $2 AND currency = $3 AND status = 'active' AND balance + credit_limit - held_balance >= $1 RETURNING balance", amount, senderID, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.walletUnavailable(ctx, tx, senderID, currency, true)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "Withdraw Failed to Exec transaction: UPDATE wallets ", zap.Int("senderID", senderID),
+			zap.Error(err))
+		return err
+	}
 
-	switch {
-	case err == redis.Nil:
-		// 缓存不存在，从数据库查询余额
-		err = s.db.Get(&balance, "SELECT balance FROM wallets WHERE user_id = $1", senderID)
-		if err != nil {
-			s.logger.Error(ctx, "Withdraw Failed to select from wallets pg", zap.Int("senderID", senderID),
-				zap.Int("receiverID", receiverID), zap.Error(err))
-			return errors.New("insufficient funds")
-		}
+	s.walletChanged(tx, senderID, currency)
+	return nil
+}
 
-	case err != nil:
-		// Redis 获取余额失败
-		s.logger.Error(ctx, "Withdraw Failed to get Balance from redis", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
+// lockWallets 按 user_id 升序对同一币种的钱包加行锁, 保证并发转账的加锁顺序一致, 避免死锁
+func (s *walletService) lockWallets(ctx context.Context, tx *sqlx.Tx, currency models.Currency, userIDs ...int) error {
+	ids := append([]int(nil), userIDs...)
+	sort.Ints(ids)
+	var locked []int
+	err := tx.Select(&locked, "SELECT user_id FROM wallets WHERE user_id = ANY($1) AND currency = $2 ORDER BY user_id FOR UPDATE", pq.Array(ids), currency)
+	if err != nil {
+		s.logger.Error(ctx, "lockWallets Failed to lock wallets", zap.Ints("userIDs", ids), zap.Error(err))
 		return err
+	}
+	return nil
+}
 
-	default:
-		// 从缓存中读取余额
-		balance, err = decimal.NewFromString(cacheBalance)
+// Transfer 转账
+func (s *walletService) Transfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, details models.TransactionDetails) (*models.TransactionResult, error) {
+
+	if err := validateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+	if err := validateTransactionDetails(details); err != nil {
+		return nil, err
+	}
+	if senderID == receiverID {
+		return nil, errors.New("cannot transfer to the same wallet")
+	}
+
+	var result *models.TransactionResult
+	err := s.runInTx(ctx, "Transfer", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, senderID, detailsRequestHash(requestHash("transfer", senderID, receiverID, amount, currency), details))
 		if err != nil {
-			s.logger.Error(ctx, "Withdraw Failed decimal.NewFromString", zap.Int("senderID", senderID),
-				zap.Int("receiverID", receiverID), zap.Error(err))
 			return err
 		}
-		// 如果缓存的余额为零，重新从数据库查询
-		if balance.IsZero() {
-			err = s.db.Get(&balance, "SELECT balance FROM wallets WHERE user_id = $1", senderID)
-			if err != nil {
-				s.logger.Error(ctx, "Withdraw Failed to select from wallets pg", zap.Int("senderID", senderID),
-					zap.Int("receiverID", receiverID), zap.Error(err))
-				return errors.New("insufficient funds")
-			}
+		if replayed != nil {
+			result = replayed
+			return nil
 		}
-	}
 
-	// 检查余额是否足够
-	if balance.LessThan(amount) {
-		return errors.New("insufficient funds balance")
+		result, err = s.transferWithTx(ctx, tx, senderID, receiverID, amount, currency, details)
+		if err != nil {
+			return err
+		}
+		return s.saveIdempotencyResult(ctx, tx, senderID, result)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "Transfer Failed", zap.Int("senderID", senderID),
+			zap.Int("receiverID", receiverID), zap.Error(err))
+		return nil, err
 	}
 
-	tx, err := s.db.Beginx()
+	return result, nil
+}
+
+// transferWithTx 在调用方的事务内转账并收取手续费, 不处理幂等键
+func (s *walletService) transferWithTx(ctx context.Context, tx *sqlx.Tx, senderID, receiverID int, amount decimal.Decimal,
+	currency models.Currency, details models.TransactionDetails) (*models.TransactionResult, error) {
+	// 双方使用同一币种的钱包, 跨币种转账需要先换汇
+	err := s.lockWallets(ctx, tx, currency, senderID, receiverID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer func() {
-		if err != nil {
-			err = tx.Rollback()
-			if err != nil {
-				s.logger.Error(ctx, "Withdraw Failed Rollback transaction", zap.Int("senderID", senderID),
-					zap.Int("receiverID", receiverID), zap.Error(err))
-			}
-		}
-	}()
+	err = s.WithdrawWithTx(ctx, tx, senderID, amount, currency)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = tx.Exec("UPDATE wallets SET balance = balance - $1 WHERE user_id = $2", amount, senderID)
+	err = s.DepositWithTx(ctx, tx, receiverID, amount, currency)
 	if err != nil {
-		s.logger.Error(ctx, "Withdraw Failed to Exec transaction update wallets", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+		return nil, err
 	}
 
-	if len(transactionType) == 0 {
-		transactionType = models.WithdrawTransactionType
+	if err = s.chargeOutflow(ctx, tx, senderID, currency, amount, 1); err != nil {
+		return nil, err
+	}
+	if err = s.checkCreditLimit(ctx, tx, receiverID, currency); err != nil {
+		return nil, err
 	}
 
-	_, err = tx.Exec("INSERT INTO transactions (sender_user_id, receiver_user_id, transaction_type, amount, created_at) VALUES ($1, $2, $3, $4, $5)",
-		senderID, receiverID, transactionType, amount, time.Now())
+	transactionID, err := s.recordTransaction(ctx, tx, withDetails(models.Transaction{
+		SenderUserID:    senderID,
+		ReceiverUserID:  receiverID,
+		TransactionType: models.TransferTransactionType,
+		Amount:          amount,
+		Currency:        currency,
+	}, details), []models.Posting{
+		walletPosting(senderID, currency, amount.Neg()),
+		walletPosting(receiverID, currency, amount),
+	})
 	if err != nil {
-		s.logger.Error(ctx, "Withdraw Failed to Exec transaction: insert into pg transactions", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+		return nil, err
 	}
 
-	// 更新 Redis 缓存
-	err = s.redis.Set(ctx, fmt.Sprintf("wallet:balance:%d", senderID), balance.Sub(amount).String(), 0).Err()
+	// 手续费由付款方另行支付, 收款方全额到账
+	fee, err := s.chargeFee(ctx, tx, transactionID, models.TransferTransactionType, senderID, amount, currency)
 	if err != nil {
-		// 记录日志，不影响事务
-		s.logger.Warn(ctx, "Withdraw Failed to update Redis cache:", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
+		return nil, err
 	}
 
-	return tx.Commit()
+	return &models.TransactionResult{TransactionID: transactionID, Fee: fee}, nil
 }
 
-func (s *walletService) WithdrawWithTx(ctx context.Context, tx *sqlx.Tx, senderID int, amount decimal.Decimal) error {
+// ExchangeTransfer 换汇转账: 按报价锁定的汇率从付款方扣除 currency, 给收款方入账报价的目标币种, 点差计入 FXHouseAccount.
+// 附加信息随两笔流水保存, external_reference 在同一付款方下唯一, 只记在付款方币种的流水上
+func (s *walletService) ExchangeTransfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal, currency models.Currency, quoteID string, details models.TransactionDetails) (*models.TransactionResult, error) {
 
-	if amount.LessThan(decimal.Zero) {
-		return errors.New("amount must be greater than zero")
+	if err := validateMoney(amount, currency); err != nil {
+		return nil, err
+	}
+	if err := validateTransactionDetails(details); err != nil {
+		return nil, err
+	}
+	if quoteID == "" {
+		return nil, ErrFXQuoteUnavailable
 	}
 
-	var balance decimal.Decimal
-	// 从 Redis 缓存中查询余额
-	cacheBalance, err := s.redis.Get(ctx, fmt.Sprintf("wallet:balance:%d", senderID)).Result()
-	switch {
-	case err == redis.Nil:
-		// 缓存不存在，从数据库查询余额
-		err = s.db.Get(&balance, "SELECT balance FROM wallets WHERE user_id = $1", senderID)
+	var result *models.TransactionResult
+	err := s.runInTx(ctx, "ExchangeTransfer", func(tx *sqlx.Tx) error {
+		replayed, err := s.claimIdempotencyKey(ctx, tx, senderID, detailsRequestHash(requestHash("exchange", senderID, receiverID, amount, currency, quoteID), details))
 		if err != nil {
-			s.logger.Error(ctx, "Withdraw Failed get balance from pg", zap.Int("senderID", senderID),
-				zap.Error(err))
-			return errors.New("insufficient funds")
+			return err
+		}
+		if replayed != nil {
+			result = replayed
+			return nil
 		}
 
-	case err != nil:
-		// 如果获取 Redis 时出现错误，返回错误
-		return err
-
-	default:
-		// 从缓存中读取余额
-		balance, err = decimal.NewFromString(cacheBalance)
+		quote, err := s.useFXQuote(ctx, tx, quoteID)
 		if err != nil {
 			return err
 		}
-		if balance.IsZero() {
-			err = s.db.Get(&balance, "SELECT balance FROM wallets WHERE user_id = $1", senderID)
-			if err != nil {
-				s.logger.Error(ctx, "Withdraw Failed get balance from pg ", zap.Int("senderID", senderID),
-					zap.Error(err))
-				return errors.New("insufficient funds")
-			}
+		if quote.BaseCurrency != currency {
+			return fmt.Errorf("%w: quote %s sells %s", ErrCurrencyMismatch, quote.ID, quote.BaseCurrency)
+		}
+
+		// 收款金额按目标币种的最小单位向下取整, 中间价金额与成交金额之差为点差
+		units, _ := quote.QuoteCurrency.MinorUnits()
+		clientRate := quote.ClientRate()
+		gross := amount.Mul(quote.Rate).Truncate(units)
+		credited := amount.Mul(clientRate).Truncate(units)
+		spread := gross.Sub(credited)
+		if !credited.IsPositive() {
+			return ErrExchangeTooSmall
 		}
-	}
 
-	if tx == nil {
-		tx, err = s.db.Beginx()
+		// 按 (币种, user_id) 的固定顺序加锁, 与同币种转账的加锁顺序一致
+		if quote.BaseCurrency < quote.QuoteCurrency {
+			err = s.lockWallets(ctx, tx, quote.BaseCurrency, senderID)
+			if err == nil {
+				err = s.lockWallets(ctx, tx, quote.QuoteCurrency, receiverID)
+			}
+		} else {
+			err = s.lockWallets(ctx, tx, quote.QuoteCurrency, receiverID)
+			if err == nil {
+				err = s.lockWallets(ctx, tx, quote.BaseCurrency, senderID)
+			}
+		}
 		if err != nil {
 			return err
 		}
-	}
 
-	// 检查余额是否足够
-	if balance.LessThan(amount) {
-		return errors.New("insufficient funds balance")
-	}
+		err = s.WithdrawWithTx(ctx, tx, senderID, amount, quote.BaseCurrency)
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.Exec("UPDATE wallets SET balance = balance - $1 WHERE user_id = $2", amount, senderID)
-	if err != nil {
-		s.logger.Error(ctx, "Withdraw Failed to Exec transaction: UPDATE wallets ", zap.Int("senderID", senderID),
-			zap.Error(err))
-		return err
-	}
+		err = s.DepositWithTx(ctx, tx, receiverID, credited, quote.QuoteCurrency)
+		if err != nil {
+			return err
+		}
 
-	// 更新 Redis 缓存
-	err = s.redis.Set(ctx, fmt.Sprintf("wallet:balance:%d", senderID), balance.Sub(amount).String(), 0).Err()
-	if err != nil {
-		// 记录日志，不影响事务
-		s.logger.Warn(ctx, "Withdraw Failed to update Redis cache:", zap.Int("senderID", senderID),
-			zap.Error(err))
-	}
+		if err = s.chargeOutflow(ctx, tx, senderID, quote.BaseCurrency, amount, 1); err != nil {
+			return err
+		}
+		if err = s.checkCreditLimit(ctx, tx, receiverID, quote.QuoteCurrency); err != nil {
+			return err
+		}
 
-	return nil
-}
+		// 付款方币种: 用户钱包 -> 平台外汇头寸
+		outID, err := s.recordTransaction(ctx, tx, withDetails(models.Transaction{
+			SenderUserID:    senderID,
+			ReceiverUserID:  receiverID,
+			TransactionType: models.ExchangeOutTransactionType,
+			Amount:          amount,
+			Currency:        quote.BaseCurrency,
+			FXRate:          &clientRate,
+			FXQuoteID:       &quote.ID,
+		}, details), []models.Posting{
+			walletPosting(senderID, quote.BaseCurrency, amount.Neg()),
+			systemPosting(FXPositionAccount, quote.BaseCurrency, amount),
+		})
+		if err != nil {
+			return err
+		}
 
-// Transfer 转账
-func (s *walletService) Transfer(ctx context.Context, senderID, receiverID int, amount decimal.Decimal) error {
+		// 收款方币种: 平台外汇头寸 -> 收款方钱包 + 点差收入
+		postings := []models.Posting{
+			systemPosting(FXPositionAccount, quote.QuoteCurrency, gross.Neg()),
+			walletPosting(receiverID, quote.QuoteCurrency, credited),
+		}
+		if spread.IsPositive() {
+			postings = append(postings, systemPosting(FXHouseAccount, quote.QuoteCurrency, spread))
+		}
+		inDetails := details
+		inDetails.ExternalReference = ""
+		inID, err := s.recordTransaction(ctx, tx, withDetails(models.Transaction{
+			SenderUserID:    senderID,
+			ReceiverUserID:  receiverID,
+			TransactionType: models.ExchangeInTransactionType,
+			Amount:          credited,
+			Currency:        quote.QuoteCurrency,
+			FXRate:          &clientRate,
+			FXQuoteID:       &quote.ID,
+		}, inDetails), postings)
+		if err != nil {
+			return err
+		}
 
-	if amount.LessThan(decimal.Zero) {
-		return errors.New("amount must be greater than zero")
-	}
+		// 手续费按付款方币种收取
+		fee, err := s.chargeFee(ctx, tx, outID, models.ExchangeOutTransactionType, senderID, amount, quote.BaseCurrency)
+		if err != nil {
+			return err
+		}
 
-	tx, err := s.db.Beginx()
+		result = &models.TransactionResult{
+			TransactionID: outID,
+			Fee:           fee,
+			Exchange: &models.ExchangeResult{
+				TransactionID: inID,
+				Currency:      quote.QuoteCurrency,
+				Amount:        credited,
+				Rate:          clientRate,
+			},
+		}
+		return s.saveIdempotencyResult(ctx, tx, senderID, result)
+	})
 	if err != nil {
-		s.logger.Error(ctx, "Transfer Failed to begin transaction:", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+		s.logger.Error(ctx, "ExchangeTransfer Failed", zap.Int("senderID", senderID),
+			zap.Int("receiverID", receiverID), zap.String("quoteID", quoteID), zap.Error(err))
+		return nil, err
 	}
-	// 确保事务回滚
-	defer func() {
-		if err != nil {
-			err = tx.Rollback()
-			if err != nil {
-				s.logger.Error(ctx, "Transfer Failed Rollback transaction", zap.Int("senderID", senderID),
-					zap.Int("receiverID", receiverID), zap.Error(err))
-			}
-		}
-	}()
 
-	err = s.WithdrawWithTx(ctx, tx, senderID, amount)
+	return result, nil
+}
+
+// recordTransaction 写入交易流水及其复式记账分录, 返回交易ID
+func (s *walletService) recordTransaction(ctx context.Context, tx *sqlx.Tx, transaction models.Transaction, postings []models.Posting) (int, error) {
+	transaction.Status = models.TransactionCompleted
+	transactionID, err := s.insertTransaction(ctx, tx, transaction)
 	if err != nil {
-		s.logger.Error(ctx, "Transfer Failed withdrawWithTx:", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+		return 0, err
 	}
 
-	err = s.DepositWithTx(ctx, tx, receiverID, senderID, amount, models.TransferTransactionType)
+	err = s.postJournalEntry(ctx, tx, models.JournalEntry{
+		TransactionID: &transactionID,
+		Description:   string(transaction.TransactionType),
+		Postings:      postings,
+	})
 	if err != nil {
-		s.logger.Error(ctx, "Transfer Failed depositWithTx:", zap.Int("senderID", senderID),
-			zap.Int("receiverID", receiverID), zap.Error(err))
-		return err
+		return 0, err
 	}
 
-	return tx.Commit()
+	return transactionID, nil
 }
 
-// GetBalance 查询余额
-func (s *walletService) GetBalance(ctx context.Context, userID int) (decimal.Decimal, error) {
-	var balance decimal.Decimal
-	// 尝试从 Redis 获取缓存中的余额
-	cacheBalance, err := s.redis.Get(ctx, fmt.Sprintf("wallet:balance:%d", userID)).Result()
+// insertTransaction 写入流水, 不产生分录; 状态为空时视为已完成
+func (s *walletService) insertTransaction(ctx context.Context, tx *sqlx.Tx, transaction models.Transaction) (int, error) {
+	// 时间字段不带时区, 与分录一样按 UTC 写入
+	now := time.Now().UTC()
+	if transaction.Status == "" {
+		transaction.Status = models.TransactionCompleted
+	}
+	var completedAt *time.Time
+	if transaction.Status == models.TransactionCompleted {
+		completedAt = &now
+	}
 
-	switch {
-	case err == redis.Nil:
-		// 缓存不存在，从数据库查询余额
-		err = s.db.Get(&balance, "SELECT balance FROM wallets WHERE user_id = $1", userID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return balance, errors.New("wallet not found")
-			}
-			s.logger.Error(ctx, "GetBalance Failed get balance from pg:", zap.Int("userID", userID),
-				zap.Error(err))
-			return balance, err
-		}
-		// 查询成功后，将数据缓存到 Redis
-		err = s.redis.Set(ctx, fmt.Sprintf("wallet:balance:%d", userID), balance.String(), 0).Err()
-		if err != nil {
-			// 记录日志，不影响主流程
-			s.logger.Warn(ctx, "GetBalance Failed to cache balance:", zap.Error(err))
-		}
+	var transactionID int
+	err := tx.QueryRowx("INSERT INTO transactions (sender_user_id, receiver_user_id, transaction_type, amount, currency, fx_rate, fx_quote_id, original_transaction_id, created_at, status, completed_at, memo, external_reference, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id",
+		transaction.SenderUserID, transaction.ReceiverUserID, transaction.TransactionType, transaction.Amount, transaction.Currency,
+		transaction.FXRate, transaction.FXQuoteID, transaction.OriginalTransactionID, now, transaction.Status, completedAt,
+		transaction.Memo, transaction.ExternalReference, transaction.Metadata).Scan(&transactionID)
+	if isUniqueViolation(err, externalReferenceIndex) {
+		return 0, fmt.Errorf("%w: %s", ErrDuplicateExternalReference, *transaction.ExternalReference)
+	}
+	if err != nil {
+		s.logger.Error(ctx, "recordTransaction Failed insert into transactions", zap.Int("senderID", transaction.SenderUserID),
+			zap.Int("receiverID", transaction.ReceiverUserID), zap.Error(err))
+		return 0, err
+	}
+	return transactionID, nil
+}
 
-	case err != nil:
+// GetBalance 查询余额, 同时返回账面余额、可用余额和授信额度的使用情况.
+// 缓存只在事务提交后按版本号写入, 返回的总是某个已提交的余额, 旧版本不会覆盖缓存中的新版本;
+// 提交后更新缓存失败时最多在一个缓存有效期内返回旧值. 扣款、冻结等操作始终在数据库中校验余额, 不依赖缓存
+func (s *walletService) GetBalance(ctx context.Context, userID int, currency models.Currency) (*models.Balance, error) {
+	if !currency.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	// 尝试从 Redis 获取缓存中的账面余额、冻结金额和授信额度
+	cached, err := s.balanceCache().get(ctx, userID, currency)
+	if err != nil {
 		// Redis 查询失败，记录日志并返回错误
 		s.logger.Error(ctx, "GetBalance Failed get balance from cache:", zap.Int("userID", userID),
 			zap.Error(err))
-		return balance, err
+		return nil, err
+	}
+	if cached != nil {
+		return walletBalance(models.Wallet{Currency: currency, Balance: cached.Balance, HeldBalance: cached.HeldBalance, CreditLimit: cached.CreditLimit}), nil
+	}
 
-	default:
-		// 从缓存中读取余额
-		balance, err = decimal.NewFromString(cacheBalance)
-		if err != nil {
-			s.logger.Error(ctx, "GetBalance Failed to decimal.NewFromString from redis ", zap.Int("userID", userID),
-				zap.Error(err))
-			return balance, err
+	// 缓存不存在，从数据库查询余额
+	entry := cachedBalance{UserID: userID, Currency: currency}
+	err = s.db.QueryRowx("SELECT balance, held_balance, credit_limit, version FROM wallets WHERE user_id = $1 AND currency = $2", userID, currency).
+		Scan(&entry.Balance, &entry.HeldBalance, &entry.CreditLimit, &entry.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWalletNotFound
 		}
+		s.logger.Error(ctx, "GetBalance Failed get balance from pg:", zap.Int("userID", userID),
+			zap.Error(err))
+		return nil, err
+	}
+	// 查询成功后写入缓存; 期间有事务提交时缓存中已是更新的版本, 不会被覆盖
+	if err = s.balanceCache().set(ctx, entry); err != nil {
+		// 记录日志，不影响主流程
+		s.logger.Warn(ctx, "GetBalance Failed to cache balance:", zap.Error(err))
 	}
 
-	return balance, nil
+	return walletBalance(models.Wallet{Currency: currency, Balance: entry.Balance, HeldBalance: entry.HeldBalance, CreditLimit: entry.CreditLimit}), nil
 }
 
-// GetTransactionHistory 获取交易历史
-func (s *walletService) GetTransactionHistory(userID int) ([]models.Transaction, error) {
-	var transactions []models.Transaction
-	err := s.db.Select(&transactions, "SELECT * FROM transactions WHERE sender_user_id = $1", userID)
+func walletBalance(wallet models.Wallet) *models.Balance {
+	return &models.Balance{
+		Currency:  wallet.Currency,
+		Ledger:    wallet.Balance,
+		Held:      wallet.HeldBalance,
+		Available: wallet.Available(),
+		Credit:    wallet.Credit(),
+	}
+}
+
+// GetBalances 查询用户所有币种的余额. 钱包列表和状态只在数据库中, 余额与缓存按版本号合并:
+// 缓存的版本更新(查询后又有事务提交)时使用缓存, 缓存缺失或更旧时使用数据库并写回缓存, 与 GetBalance 返回的余额一致
+func (s *walletService) GetBalances(ctx context.Context, userID int) ([]models.Wallet, error) {
+	var rows []struct {
+		models.Wallet
+		Version int64 `db:"version"`
+	}
+	err := s.db.Select(&rows, "SELECT "+walletColumns+", version FROM wallets WHERE user_id = $1 ORDER BY currency", userID)
+	if err != nil {
+		s.logger.Error(ctx, "GetBalances Failed select from wallets", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrWalletNotFound
+	}
+
+	wallets := make([]models.Wallet, len(rows))
+	keys := make([]walletKey, len(rows))
+	for i, row := range rows {
+		wallets[i] = row.Wallet
+		keys[i] = walletKey{UserID: userID, Currency: row.Currency}
+	}
+	cached, err := s.balanceCache().getMany(ctx, keys)
 	if err != nil {
-		return transactions, err
+		// 数据库中已是已提交的余额, 缓存不可用时直接返回
+		s.logger.Warn(ctx, "GetBalances Failed get balances from cache:", zap.Int("userID", userID), zap.Error(err))
+		return wallets, nil
+	}
+	var stale []cachedBalance
+	for i, row := range rows {
+		entry := cached[i]
+		switch {
+		case entry != nil && entry.Version > row.Version:
+			wallets[i].Balance, wallets[i].HeldBalance, wallets[i].CreditLimit = entry.Balance, entry.HeldBalance, entry.CreditLimit
+		case entry == nil || entry.Version < row.Version:
+			stale = append(stale, cachedBalance{UserID: userID, Currency: row.Currency, Balance: row.Balance,
+				HeldBalance: row.HeldBalance, CreditLimit: row.CreditLimit, Version: row.Version})
+		}
+	}
+	if len(stale) > 0 {
+		if err = s.balanceCache().set(ctx, stale...); err != nil {
+			s.logger.Warn(ctx, "GetBalances Failed to cache balances:", zap.Error(err))
+		}
 	}
-	return transactions, nil
+	return wallets, nil
 }
@@ -3,14 +3,12 @@ package services
 import (
 	"context"
 	"errors"
-	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-redis/redismock/v9"
 	"github.com/jmoiron/sqlx"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"testing"
-	"time"
 	"wallet-service/models"
 	"wallet-service/pkg/logger"
 )
@@ -40,16 +38,17 @@ func TestWalletService_Deposit(t *testing.T) {
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin() // 开始事务
 	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
+		WithArgs(amount, senderID, models.USD).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec("INSERT INTO transactions").WithArgs(senderID, receiverID, transactionType, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1)) // 执行插入操作
-	mockDB.ExpectCommit()                                                                                                                                             // 提交事务
-	// ExpectRollback()
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectIncrByFloat(fmt.Sprintf("wallet:balance:%d", senderID), amount.InexactFloat64()).SetVal(amount.InexactFloat64())
+	expectWalletLimits(mockDB, models.USD, senderID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WithArgs(senderID, receiverID, transactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1)) // 执行插入操作
+	expectJournalEntry(mockDB, systemPosting(ExternalCashInAccount, models.USD, amount.Neg()), walletPosting(senderID, models.USD, amount))
+	mockDB.ExpectCommit() // 提交事务
+	// 设置提交后刷新缓存的期望行为
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: senderID, Currency: models.USD, Balance: amount, Version: 1})
 
 	// 执行 Deposit 方法
-	err = service.Deposit(context.Background(), senderID, receiverID, amount, transactionType)
+	_, err = service.Deposit(context.Background(), senderID, receiverID, amount, models.USD, transactionType, models.TransactionDetails{})
 
 	// 断言没有错误
 	assert.NoError(t, err)
@@ -76,7 +75,7 @@ func TestWalletService_Deposit_TransactionBeginError(t *testing.T) {
 	mockDB.ExpectBegin().WillReturnError(errors.New("failed to begin transaction"))
 
 	// Call the method
-	err = service.Deposit(context.Background(), 1, 2, decimal.NewFromFloat(100.0), models.DepositTransactionType)
+	_, err = service.Deposit(context.Background(), 1, 2, decimal.NewFromFloat(100.0), models.USD, models.DepositTransactionType, models.TransactionDetails{})
 
 	// Assert error was returned
 	assert.Error(t, err)
@@ -104,14 +103,14 @@ func TestWalletService_Deposit_ExecError(t *testing.T) {
 
 	// Exec update mock (simulate an error)
 	mockDB.ExpectExec("UPDATE wallets SET balance = balance + $1 WHERE user_id = $2").
-		WithArgs(decimal.NewFromFloat(100.0), 1).
+		WithArgs(decimal.NewFromFloat(100.0), 1, models.USD).
 		WillReturnError(errors.New("failed to execute update"))
 
 	// Commit transaction mock (no need since Exec fails)
 	mockDB.ExpectCommit()
 
 	// Call the method
-	err = service.Deposit(context.Background(), 1, 2, decimal.NewFromFloat(100.0), models.DepositTransactionType)
+	_, err = service.Deposit(context.Background(), 1, 2, decimal.NewFromFloat(100.0), models.USD, models.DepositTransactionType, models.TransactionDetails{})
 
 	// Assert error was returned
 	assert.Error(t, err)
@@ -134,30 +133,22 @@ func TestWalletService_Deposit_NoRowsAffected(t *testing.T) {
 	// 创建 WalletService
 	service := NewWalletService(mockLogger, sqlxDB, client)
 	// Begin transaction mock
-	transactionType := models.DepositTransactionType
 	mock.ExpectBegin()
 
 	// Exec update mock (simulate no rows affected)
 	mock.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
-		WithArgs(decimal.NewFromFloat(100.0), 1).
+		WithArgs(decimal.NewFromFloat(100.0), 1, models.USD).
 		WillReturnResult(sqlmock.NewResult(0, 0)) // No rows affected
 
-	// Simulate insert operation for wallets
-	mock.ExpectExec("INSERT INTO wallets").
-		WithArgs(1, decimal.NewFromFloat(100.0).Round(0)).
-		WillReturnResult(sqlmock.NewResult(1, 1)) // Insert successfully
-
-	// Insert into transactions mock
-	mock.ExpectExec("INSERT INTO transactions").
-		WithArgs(1, 1, transactionType, decimal.NewFromFloat(100.0).Round(0), sqlmock.AnyArg()). // Matching the arguments
-		WillReturnResult(sqlmock.NewResult(1, 1))                                                // Insert transaction successfully
-
-	// Commit transaction mock
-	mock.ExpectCommit()
+	// 钱包需显式创建, 不存在时回滚
+	mock.ExpectQuery(`SELECT status FROM wallets WHERE user_id = \$1 AND currency = \$2`).
+		WithArgs(1, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}))
+	mock.ExpectRollback()
 
 	// Call the method
-	err = service.Deposit(context.Background(), 1, 1, decimal.NewFromFloat(100.0), models.DepositTransactionType)
-	assert.NoError(t, err)
+	_, err = service.Deposit(context.Background(), 1, 1, decimal.NewFromFloat(100.0), models.USD, models.DepositTransactionType, models.TransactionDetails{})
+	assert.ErrorIs(t, err, ErrWalletNotFound)
 
 	// Assert expectations
 	err = mock.ExpectationsWereMet()
@@ -185,14 +176,14 @@ func TestWalletService_Deposit_RollbackOnError(t *testing.T) {
 
 	// Exec update mock (simulate success)
 	mock.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
-		WithArgs(decimal.NewFromFloat(100.0), 1).
+		WithArgs(decimal.NewFromFloat(100.0), 1, models.USD).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Simulate error that requires rollback
 	mock.ExpectRollback()
 
 	// Call the method
-	err = s.Deposit(context.Background(), 1, 1, decimal.NewFromFloat(100.0), models.DepositTransactionType)
+	_, err = s.Deposit(context.Background(), 1, 1, decimal.NewFromFloat(100.0), models.USD, models.DepositTransactionType, models.TransactionDetails{})
 	assert.Error(t, err)
 
 	// Assert expectations
@@ -202,7 +193,7 @@ func TestWalletService_Deposit_RollbackOnError(t *testing.T) {
 
 func TestWalletService_Withdraw(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -224,21 +215,17 @@ func TestWalletService_Withdraw(t *testing.T) {
 
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec("INSERT INTO transactions").WithArgs(senderID, receiverID, transactionType, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2 AND currency = \$3 AND status = 'active' AND balance \+ credit_limit - held_balance >= \$1 RETURNING balance`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WithArgs(senderID, receiverID, transactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), systemPosting(ExternalCashOutAccount, models.USD, amount))
 	mockDB.ExpectCommit()
 
-	// 设置 mock Redis 的期望行为
-	// 首先 GET 操作（查询余额）
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-
-	// 然后 SET 操作（更新余额）
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", time.Duration(0)).SetVal("OK")
-
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, transactionType)
+	_, err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, transactionType, models.TransactionDetails{})
 
 	// 断言没有错误
 	assert.NoError(t, err)
@@ -246,7 +233,7 @@ func TestWalletService_Withdraw(t *testing.T) {
 
 func TestWalletService_WithdrawWithTx(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -265,35 +252,29 @@ func TestWalletService_WithdrawWithTx(t *testing.T) {
 	}
 	// 准备测试数据
 	senderID := 1
-	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
-	transactionType := models.WithdrawTransactionType
 
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec("INSERT INTO transactions").WithArgs(senderID, receiverID, transactionType, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2 AND currency = \$3 AND status = 'active' AND balance \+ credit_limit - held_balance >= \$1 RETURNING balance`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
 	mockDB.ExpectCommit()
 
-	// 设置 mock Redis 的期望行为
-	// 首先 GET 操作（查询余额）
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-
-	// 然后 SET 操作（更新余额）
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", time.Duration(0)).SetVal("OK")
-
 	// 执行 Withdraw 方法
-	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount)
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+	err = service.WithdrawWithTx(context.Background(), tx, senderID, amount, models.USD)
 
 	// 断言没有错误
 	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
 func TestWalletService_Transfer(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -314,22 +295,23 @@ func TestWalletService_Transfer(t *testing.T) {
 
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`SELECT user_id FROM wallets WHERE user_id = ANY\(\$1\) AND currency = \$2 ORDER BY user_id FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(senderID).AddRow(receiverID))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2 AND currency = \$3 AND status = 'active' AND balance \+ credit_limit - held_balance >= \$1 RETURNING balance`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("0"))
 	mockDB.ExpectExec(`UPDATE wallets SET balance = balance \+ \$1 WHERE user_id = \$2`).
-		WithArgs(amount, receiverID).
+		WithArgs(amount, receiverID, models.USD).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec("INSERT INTO transactions").WithArgs(receiverID, senderID, models.TransferTransactionType, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	expectWalletLimits(mockDB, models.USD, receiverID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WithArgs(senderID, receiverID, models.TransferTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), walletPosting(receiverID, models.USD, amount))
 	mockDB.ExpectCommit()
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal(amount.String())
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), amount.String(), time.Duration(0)).SetVal("OK")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", receiverID), amount.String(), time.Duration(0)).SetVal("OK")
-
 	// 执行 Transfer 方法
-	err = service.Transfer(context.Background(), senderID, receiverID, amount)
+	_, err = service.Transfer(context.Background(), senderID, receiverID, amount, models.USD, models.TransactionDetails{})
 
 	// 断言没有错误
 	assert.NoError(t, err)
@@ -356,19 +338,20 @@ func TestWalletService_GetBalance(t *testing.T) {
 	expectedBalance := decimal.NewFromFloat(100.0).Round(0)
 
 	// 设置 mock DB 的期望行为
-	mockDB.ExpectQuery(`SELECT balance FROM wallets WHERE user_id = \$1`).
-		WithArgs(userID).
-		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(expectedBalance))
+	mockDB.ExpectQuery(`SELECT balance, held_balance, credit_limit, version FROM wallets WHERE user_id = \$1`).
+		WithArgs(userID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "held_balance", "credit_limit", "version"}).AddRow(expectedBalance, "0", "0", 1))
 
 	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", userID)).SetVal(expectedBalance.String())
+	mockRedis.ExpectHMGet(balanceCacheKey(userID, models.USD), balanceCacheFields...).
+		SetVal([]interface{}{"1", expectedBalance.String(), "0", "0"})
 
 	// 执行 GetBalance 方法
-	balance, err := service.GetBalance(context.Background(), userID)
+	balance, err := service.GetBalance(context.Background(), userID, models.USD)
 
 	// 断言没有错误，并且余额正确
 	assert.NoError(t, err)
-	assert.Equal(t, expectedBalance, balance)
+	assert.Equal(t, expectedBalance, balance.Ledger)
 }
 
 func TestWalletService_GetTransactionHistory(t *testing.T) {
@@ -392,16 +375,17 @@ func TestWalletService_GetTransactionHistory(t *testing.T) {
 		{SenderUserID: 1, ReceiverUserID: 2, Amount: decimal.NewFromFloat(100.00).Round(2), TransactionType: models.DepositTransactionType},
 	}
 
-	// 设置 mock DB 的期望行为
-	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE sender_user_id = \$1`).
-		WithArgs(userID).
+	// 设置 mock DB 的期望行为: 同时包含转出和转入的交易
+	mockDB.ExpectQuery(`SELECT \* FROM transactions WHERE \(sender_user_id = \$1 OR receiver_user_id = \$1\) ORDER BY created_at DESC, id DESC LIMIT \$2`).
+		WithArgs(userID, defaultHistoryPageSize+1).
 		WillReturnRows(sqlmock.NewRows([]string{"sender_user_id", "receiver_user_id", "amount", "transaction_type"}).
 			AddRow(1, 2, "100.00", models.DepositTransactionType))
 
 	// 执行 GetTransactionHistory 方法
-	transactions, err := service.GetTransactionHistory(userID)
+	page, err := service.GetTransactionHistory(context.Background(), userID, models.TransactionFilter{})
 
 	// 断言没有错误，并且交易记录正确
 	assert.NoError(t, err)
-	assert.Equal(t, expectedTransactions, transactions)
+	assert.Equal(t, expectedTransactions, page.Transactions)
+	assert.Empty(t, page.NextPageToken)
 }
@@ -6,19 +6,19 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-redis/redismock/v9"
 	"github.com/jmoiron/sqlx"
-	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"wallet-service/models"
 	"wallet-service/pkg/logger"
 )
 
 func TestWalletService_WithdrawWithTx_InsufficientFunds(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
-	db, _, err := sqlmock.New()
+	db, mockDB, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to create mock DB: %v", err)
 	}
@@ -37,23 +37,33 @@ func TestWalletService_WithdrawWithTx_InsufficientFunds(t *testing.T) {
 	senderID := 1
 	amount := decimal.NewFromFloat(150.0)
 
+	// 设置 mock DB 的期望行为: 余额不足时条件更新不返回行
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2 AND currency = \$3 AND status = 'active' AND balance \+ credit_limit - held_balance >= \$1 RETURNING balance`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets WHERE user_id = \$1 AND currency = \$2`).
+		WithArgs(senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+
 	// 开始一个事务
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
 	// 执行 WithdrawWithTx 方法
-	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount)
+	err = service.WithdrawWithTx(context.Background(), tx, senderID, amount, models.USD)
 
 	// 断言返回错误
-	assert.EqualError(t, err, "all expectations were already fulfilled, call to database transaction Begin was not expected")
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
-func TestWalletService_WithdrawWithTx_RedisError(t *testing.T) {
+func TestWalletService_WithdrawWithTx_WalletNotFound(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
-	db, _, err := sqlmock.New()
+	db, mockDB, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to create mock DB: %v", err)
 	}
@@ -72,19 +82,29 @@ func TestWalletService_WithdrawWithTx_RedisError(t *testing.T) {
 	senderID := 1
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetErr(fmt.Errorf("redis error"))
+	// 设置 mock DB 的期望行为
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}))
+
+	// 开始一个事务
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
 
 	// 执行 WithdrawWithTx 方法
-	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount)
+	err = service.WithdrawWithTx(context.Background(), tx, senderID, amount, models.USD)
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrWalletNotFound)
 }
 
 func TestWalletService_WithdrawWithTx_DBError(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -106,22 +126,24 @@ func TestWalletService_WithdrawWithTx_DBError(t *testing.T) {
 	senderID := 1
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-
 	// 设置 mock DB 的期望行为
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
 		WillReturnError(fmt.Errorf("database error"))
 
+	// 开始一个事务
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+
 	// 执行 WithdrawWithTx 方法
-	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount)
+	err = service.WithdrawWithTx(context.Background(), tx, senderID, amount, models.USD)
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.EqualError(t, err, "database error")
 }
 
-func TestWalletService_WithdrawWithTx_NoTxProvided(t *testing.T) {
+func TestWalletService_WithdrawWithTx_Success(t *testing.T) {
 	// 创建 mock Redis 客户端
 	client, mockRedis := redismock.NewClientMock()
 
@@ -145,22 +167,22 @@ func TestWalletService_WithdrawWithTx_NoTxProvided(t *testing.T) {
 	senderID := 1
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", 0).SetVal("OK")
-
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectCommit()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+
+	// 开始一个事务
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
 
 	// 执行 WithdrawWithTx 方法
-	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount)
+	err = service.WithdrawWithTx(context.Background(), tx, senderID, amount, models.USD)
 
 	// 断言没有错误
 	assert.NoError(t, err)
+	assert.NoError(t, mockRedis.ExpectationsWereMet())
 }
 
 func TestWalletService_WithdrawWithTx_InvalidParams(t *testing.T) {
@@ -188,7 +210,7 @@ func TestWalletService_WithdrawWithTx_InvalidParams(t *testing.T) {
 	amount := decimal.NewFromFloat(-50.0)
 
 	// 执行 WithdrawWithTx 方法
-	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount)
+	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount, models.USD)
 
 	// 断言返回错误
 	assert.EqualError(t, err, "amount must be greater than zero")
@@ -196,7 +218,7 @@ func TestWalletService_WithdrawWithTx_InvalidParams(t *testing.T) {
 
 func TestWalletService_WithdrawWithTx_DBSelectError(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -218,17 +240,22 @@ func TestWalletService_WithdrawWithTx_DBSelectError(t *testing.T) {
 	senderID := 1
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetErr(redis.Nil)
-
 	// 设置 mock DB 的期望行为
-	mockDB.ExpectQuery(`SELECT balance FROM wallets WHERE user_id = \$1`).
-		WithArgs(senderID).
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(senderID, models.USD).
 		WillReturnError(fmt.Errorf("database select error"))
 
+	// 开始一个事务
+	tx, err := sqlxDB.Beginx()
+	assert.NoError(t, err)
+
 	// 执行 WithdrawWithTx 方法
-	err = service.WithdrawWithTx(context.Background(), nil, senderID, amount)
+	err = service.WithdrawWithTx(context.Background(), tx, senderID, amount, models.USD)
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.EqualError(t, err, "database select error")
 }
@@ -6,11 +6,10 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-redis/redismock/v9"
 	"github.com/jmoiron/sqlx"
-	"github.com/redis/go-redis/v9"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"testing"
-	"time"
 	"wallet-service/models"
 	"wallet-service/pkg/logger"
 )
@@ -39,32 +38,33 @@ func TestWalletService_Withdraw_Success(t *testing.T) {
 
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec("INSERT INTO transactions").WithArgs(senderID, receiverID, transactionType, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2 AND currency = \$3 AND status = 'active' AND balance \+ credit_limit - held_balance >= \$1 RETURNING balance`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WithArgs(senderID, receiverID, transactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), systemPosting(ExternalCashOutAccount, models.USD, amount))
 	mockDB.ExpectCommit()
 
-	// 设置 mock Redis 的期望行为
-	// 首先 GET 操作（查询余额）
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-
-	// 然后 SET 操作（更新余额）
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", time.Duration(0)).SetVal("OK")
+	// 设置 mock Redis 的期望行为: 提交后缓存写入数据库中的新余额和版本号
+	expectBalanceCacheRefresh(mockDB, mockRedis, cachedBalance{UserID: senderID, Currency: models.USD, Balance: decimal.RequireFromString("50"), Version: 2})
 
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, transactionType)
+	result, err := service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, transactionType, models.TransactionDetails{})
 
 	// 断言没有错误
 	assert.NoError(t, err)
+	assert.Equal(t, 1, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
 func TestWalletService_Withdraw_InsufficientFunds(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
-	db, _, err := sqlmock.New()
+	db, mockDB, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to create mock DB: %v", err)
 	}
@@ -80,22 +80,31 @@ func TestWalletService_Withdraw_InsufficientFunds(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(150.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
+	// 设置 mock DB 的期望行为: 条件更新未命中, 钱包存在
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.WalletActive))
+	mockDB.ExpectRollback()
 
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, "")
+	_, err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, "", models.TransactionDetails{})
 
 	// 断言返回错误
 	assert.EqualError(t, err, "insufficient funds balance")
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
-func TestWalletService_Withdraw_RedisError(t *testing.T) {
+func TestWalletService_Withdraw_WalletNotFound(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
-	db, _, err := sqlmock.New()
+	db, mockDB, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to create mock DB: %v", err)
 	}
@@ -111,19 +120,27 @@ func TestWalletService_Withdraw_RedisError(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetErr(fmt.Errorf("redis error"))
+	// 设置 mock DB 的期望行为: 条件更新未命中, 钱包不存在
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}))
+	mockDB.ExpectRollback()
 
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, "")
+	_, err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, "", models.TransactionDetails{})
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
 func TestWalletService_Withdraw_DBError(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -142,21 +159,19 @@ func TestWalletService_Withdraw_DBError(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
 		WillReturnError(fmt.Errorf("database error"))
 	mockDB.ExpectRollback()
 
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, "")
+	_, err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, "", models.TransactionDetails{})
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.EqualError(t, err, "database error")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
 func TestWalletService_Withdraw_RedisUpdateError(t *testing.T) {
@@ -180,20 +195,25 @@ func TestWalletService_Withdraw_RedisUpdateError(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetVal("100")
-	mockRedis.ExpectSet(fmt.Sprintf("wallet:balance:%d", senderID), "50", time.Duration(0)).SetErr(fmt.Errorf("redis update error"))
-
 	// 设置 mock DB 的期望行为
 	mockDB.ExpectBegin()
-	mockDB.ExpectExec(`UPDATE wallets SET balance = balance - \$1 WHERE user_id = \$2`).
-		WithArgs(amount, senderID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectExec("INSERT INTO transactions").WithArgs(senderID, receiverID, models.WithdrawTransactionType, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WithArgs(senderID, receiverID, models.WithdrawTransactionType, amount, models.USD, nil, nil, nil, sqlmock.AnyArg(), models.TransactionCompleted, sqlmock.AnyArg(), nil, nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), systemPosting(ExternalCashOutAccount, models.USD, amount))
 	mockDB.ExpectCommit()
 
+	// 设置 mock Redis 的期望行为: 写入缓存失败时删除缓存, 不影响已提交的提现
+	entry := cachedBalance{UserID: senderID, Currency: models.USD, Balance: decimal.RequireFromString("50"), Version: 2}
+	mockDB.ExpectQuery(`JOIN unnest`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency", "balance", "held_balance", "credit_limit", "version"}).AddRow(senderID, "USD", "50", "0", "0", 2))
+	expectBalanceCacheSet(mockRedis, entry).SetErr(fmt.Errorf("redis update error"))
+
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, "")
+	_, err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, "", models.TransactionDetails{})
 
 	// 断言没有错误
 	assert.NoError(t, err)
@@ -221,7 +241,7 @@ func TestWalletService_Withdraw_InvalidParams(t *testing.T) {
 	amount := decimal.NewFromFloat(-50.0)
 
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.WithdrawTransactionType)
+	_, err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, models.WithdrawTransactionType, models.TransactionDetails{})
 
 	// 断言返回错误
 	assert.EqualError(t, err, "amount must be greater than zero")
@@ -229,7 +249,7 @@ func TestWalletService_Withdraw_InvalidParams(t *testing.T) {
 
 func TestWalletService_Withdraw_DBSelectError(t *testing.T) {
 	// 创建 mock Redis 客户端
-	client, mockRedis := redismock.NewClientMock()
+	client, _ := redismock.NewClientMock()
 
 	// 创建 mock DB 和 mock Logger
 	db, mockDB, err := sqlmock.New()
@@ -248,17 +268,67 @@ func TestWalletService_Withdraw_DBSelectError(t *testing.T) {
 	receiverID := 2
 	amount := decimal.NewFromFloat(50.0)
 
-	// 设置 mock Redis 的期望行为
-	mockRedis.ExpectGet(fmt.Sprintf("wallet:balance:%d", senderID)).SetErr(redis.Nil)
-
 	// 设置 mock DB 的期望行为
-	mockDB.ExpectQuery(`SELECT balance FROM wallets WHERE user_id = \$1`).
-		WithArgs(senderID).
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mockDB.ExpectQuery(`SELECT status FROM wallets`).
+		WithArgs(senderID, models.USD).
 		WillReturnError(fmt.Errorf("database select error"))
+	mockDB.ExpectRollback()
 
 	// 执行 Withdraw 方法
-	err = service.Withdraw(context.Background(), senderID, receiverID, amount, "")
+	_, err = service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, "", models.TransactionDetails{})
 
 	// 断言返回错误
-	assert.Error(t, err)
+	assert.EqualError(t, err, "database select error")
+}
+
+func TestWalletService_Withdraw_RetryOnSerializationFailure(t *testing.T) {
+	// 创建 mock Redis 客户端
+	client, _ := redismock.NewClientMock()
+
+	// 创建 mock DB 和 mock Logger
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	mockLogger := logger.NewLogger()
+
+	// 创建 WalletService
+	service := NewWalletService(mockLogger, sqlxDB, client)
+
+	// 准备测试数据
+	senderID := 1
+	receiverID := 2
+	amount := decimal.NewFromFloat(50.0)
+
+	// 第一次尝试遇到序列化冲突
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnError(&pq.Error{Code: "40001", Message: "could not serialize access"})
+	mockDB.ExpectRollback()
+
+	// 第二次尝试成功
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(`UPDATE wallets SET balance = balance - \$1`).
+		WithArgs(amount, senderID, models.USD).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50"))
+	expectApprovalThreshold(mockDB, senderID, models.USD, "")
+	expectWalletLimits(mockDB, models.USD, senderID)
+	mockDB.ExpectQuery("INSERT INTO transactions").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	expectJournalEntry(mockDB, walletPosting(senderID, models.USD, amount.Neg()), systemPosting(ExternalCashOutAccount, models.USD, amount))
+	mockDB.ExpectCommit()
+
+	// 执行 Withdraw 方法
+	result, err := service.Withdraw(context.Background(), senderID, receiverID, amount, models.USD, "", models.TransactionDetails{})
+
+	// 断言重试后成功
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.TransactionID)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }